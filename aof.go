@@ -0,0 +1,434 @@
+package localcache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrAOFDisabled is returned by RewriteAOF when the cache has no append only file
+	ErrAOFDisabled = errors.New("append only file is not enabled")
+	// errAOFRecordCorrupted is returned when a record fails its checksum or is truncated
+	errAOFRecordCorrupted = errors.New("append only file record corrupted")
+)
+
+// FsyncPolicy decides how often the append only file is flushed to stable storage
+type FsyncPolicy int
+
+const (
+	// FsyncEverySecond fsyncs once per second, losing at most a second of writes on a crash
+	FsyncEverySecond FsyncPolicy = iota
+	// FsyncAlways fsyncs after every record, the write returns once it is durable
+	FsyncAlways
+	// FsyncNever leaves flushing to the operating system
+	FsyncNever
+)
+
+const (
+	aofOpSet byte = iota + 1
+	aofOpDelete
+	aofOpExpire
+	aofOpClear
+	// aofOpSealedSet is a set whose value is sealed by the cache key provider
+	aofOpSealedSet
+	// aofOpCostSet and aofOpSealedCostSet are the sets carrying the cost of the entry.
+	// The plain sets of older logs are weighed again on replay.
+	aofOpCostSet
+	aofOpSealedCostSet
+)
+
+const (
+	// aofRecordHeaderSize is the checksum and the body length of a record
+	aofRecordHeaderSize = 8
+	// aofFlushInterval is how often buffered records are handed to the operating system
+	aofFlushInterval = time.Second
+	// aofRewriteDrainSize is the amount of buffered records a rewrite drains without
+	// blocking writers before it takes the final lock
+	aofRewriteDrainSize = 64 * 1024
+)
+
+var aofCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// aofRecord is a single logged mutation
+type aofRecord struct {
+	op       byte
+	expireAt uint64
+	key      string
+	value    []byte
+	// cost is the cost of a set, negative when the record does not carry it
+	cost int64
+}
+
+// encodeAOFRecord renders a record as
+// crc32(body) | len(body) | op | expireAt | uvarint(len(key)) | key | value
+func encodeAOFRecord(op byte, key string, value []byte, expireAt uint64) []byte {
+	return encodeAOFBody(op, key, value, expireAt, -1)
+}
+
+// encodeAOFCostRecord renders a set record carrying cost as
+// crc32(body) | len(body) | op | expireAt | uvarint(len(key)) | key | uvarint(cost) | value
+func encodeAOFCostRecord(op byte, key string, value []byte, expireAt uint64, cost int64) []byte {
+	return encodeAOFBody(op, key, value, expireAt, cost)
+}
+
+// encodeAOFBody renders a record, with cost in front of the value unless it is negative
+func encodeAOFBody(op byte, key string, value []byte, expireAt uint64, cost int64) []byte {
+	bodyLength := 1 + timestampSizeInBytes + 2*binary.MaxVarintLen64 + len(key) + len(value)
+	record := make([]byte, aofRecordHeaderSize+bodyLength)
+	body := record[aofRecordHeaderSize:]
+	body[0] = op
+	binary.LittleEndian.PutUint64(body[1:], expireAt)
+	n := 1 + timestampSizeInBytes
+	n += binary.PutUvarint(body[n:], uint64(len(key)))
+	n += copy(body[n:], key)
+	if cost >= 0 {
+		n += binary.PutUvarint(body[n:], uint64(cost))
+	}
+	n += copy(body[n:], value)
+	body = body[:n]
+	binary.LittleEndian.PutUint32(record, crc32.Checksum(body, aofCRCTable))
+	binary.LittleEndian.PutUint32(record[4:], uint32(n))
+	return record[:aofRecordHeaderSize+n]
+}
+
+// readAOFRecord reads the next record. It returns io.EOF at a clean end of the log
+// and errAOFRecordCorrupted for a torn or damaged record.
+func readAOFRecord(r *bufio.Reader) (aofRecord, int, error) {
+	header := make([]byte, aofRecordHeaderSize)
+	if n, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF && n == 0 {
+			return aofRecord{}, 0, io.EOF
+		}
+		return aofRecord{}, 0, errAOFRecordCorrupted
+	}
+	checksum := binary.LittleEndian.Uint32(header)
+	length := binary.LittleEndian.Uint32(header[4:])
+	if length < 1+timestampSizeInBytes+1 {
+		return aofRecord{}, 0, errAOFRecordCorrupted
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return aofRecord{}, 0, errAOFRecordCorrupted
+	}
+	if crc32.Checksum(body, aofCRCTable) != checksum {
+		return aofRecord{}, 0, errAOFRecordCorrupted
+	}
+	record, err := decodeAOFBody(body)
+	if err != nil {
+		return aofRecord{}, 0, err
+	}
+	return record, aofRecordHeaderSize + int(length), nil
+}
+
+// decodeAOFBody decodes the body of a record whose checksum was verified
+func decodeAOFBody(body []byte) (aofRecord, error) {
+	if len(body) < 1+timestampSizeInBytes+1 {
+		return aofRecord{}, errAOFRecordCorrupted
+	}
+	record := aofRecord{
+		op:       body[0],
+		expireAt: binary.LittleEndian.Uint64(body[1:]),
+		cost:     -1,
+	}
+	rest := body[1+timestampSizeInBytes:]
+	keyLength, n := binary.Uvarint(rest)
+	if n <= 0 || uint64(len(rest)-n) < keyLength {
+		return aofRecord{}, errAOFRecordCorrupted
+	}
+	record.key = string(rest[n : n+int(keyLength)])
+	record.value = rest[n+int(keyLength):]
+	// the sets carrying a cost are read back as the plain sets with their cost
+	switch record.op {
+	case aofOpCostSet, aofOpSealedCostSet:
+		cost, n := binary.Uvarint(record.value)
+		if n <= 0 || cost > math.MaxInt64 {
+			return aofRecord{}, errAOFRecordCorrupted
+		}
+		record.cost, record.value = int64(cost), record.value[n:]
+		if record.op == aofOpCostSet {
+			record.op = aofOpSet
+		} else {
+			record.op = aofOpSealedSet
+		}
+	}
+	return record, nil
+}
+
+// aof is an append only log of the cache mutations
+type aof struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	writer *bufio.Writer
+	policy FsyncPolicy
+	// rewriteBuf collects the records appended while a rewrite is running, nil otherwise
+	rewriteBuf *bytes.Buffer
+	// rewriteMu serializes rewrites
+	rewriteMu sync.Mutex
+	close     chan struct{}
+	done      chan struct{}
+}
+
+// openAOF opens or creates the log at path and replays it through apply. A torn or
+// corrupted tail, as left by a crash in the middle of a write, is truncated away.
+func openAOF(path string, policy FsyncPolicy, apply func(record aofRecord)) (*aof, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	valid := int64(0)
+	reader := bufio.NewReader(file)
+	for {
+		record, n, err := readAOFRecord(reader)
+		if err != nil {
+			break
+		}
+		apply(record)
+		valid += int64(n)
+	}
+	if err := file.Truncate(valid); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(valid, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	a := &aof{
+		path:   path,
+		file:   file,
+		writer: bufio.NewWriter(file),
+		policy: policy,
+		close:  make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go a.flushLoop()
+	return a, nil
+}
+
+// append logs a record. It is called under the segment write lock, so records of
+// the same key are logged in the order they are applied.
+func (a *aof) append(record []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.writer.Write(record); err != nil {
+		return err
+	}
+	if a.rewriteBuf != nil {
+		a.rewriteBuf.Write(record)
+	}
+	if a.policy != FsyncAlways {
+		return nil
+	}
+	if err := a.writer.Flush(); err != nil {
+		return err
+	}
+	return a.file.Sync()
+}
+
+func (a *aof) flushLoop() {
+	defer close(a.done)
+	ticker := time.NewTicker(aofFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.mu.Lock()
+			if a.writer.Buffered() > 0 {
+				if err := a.writer.Flush(); err == nil && a.policy == FsyncEverySecond {
+					_ = a.file.Sync()
+				}
+			}
+			a.mu.Unlock()
+		case <-a.close:
+			return
+		}
+	}
+}
+
+// rewrite replaces the log with the records produced by dump, which should describe the
+// live contents of the cache. Writers keep appending to the current log meanwhile; the
+// records they append are also buffered and copied after the dump, so the new log never
+// misses a mutation. Replaying a record that is already part of the dump is harmless as
+// every record carries an absolute state.
+func (a *aof) rewrite(dump func(emit func(record []byte) error) error) error {
+	a.rewriteMu.Lock()
+	defer a.rewriteMu.Unlock()
+
+	tmpPath := a.path + ".rewrite"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	abort := func(err error) error {
+		a.mu.Lock()
+		a.rewriteBuf = nil
+		a.mu.Unlock()
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	a.mu.Lock()
+	a.rewriteBuf = &bytes.Buffer{}
+	a.mu.Unlock()
+
+	writer := bufio.NewWriter(tmp)
+	if err := dump(func(record []byte) error {
+		_, err := writer.Write(record)
+		return err
+	}); err != nil {
+		return abort(err)
+	}
+
+	// drain what writers appended during the dump without holding them up
+	for {
+		a.mu.Lock()
+		if a.rewriteBuf.Len() <= aofRewriteDrainSize {
+			break
+		}
+		pending := a.rewriteBuf
+		a.rewriteBuf = &bytes.Buffer{}
+		a.mu.Unlock()
+		if _, err := pending.WriteTo(writer); err != nil {
+			return abort(err)
+		}
+	}
+	// a.mu is held from here on, writers wait for the final drain and the swap
+	defer a.mu.Unlock()
+	fail := func(err error) error {
+		a.rewriteBuf = nil
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if _, err := a.rewriteBuf.WriteTo(writer); err != nil {
+		return fail(err)
+	}
+	if err := writer.Flush(); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := a.writer.Flush(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmpPath, a.path); err != nil {
+		return fail(err)
+	}
+	syncDir(filepath.Dir(a.path))
+	a.file.Close()
+	a.file = tmp
+	a.writer = bufio.NewWriter(tmp)
+	a.rewriteBuf = nil
+	return nil
+}
+
+// closeAOF flushes and syncs the pending records and closes the file
+func (a *aof) closeAOF() error {
+	close(a.close)
+	<-a.done
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.writer.Flush(); err != nil {
+		a.file.Close()
+		return err
+	}
+	if err := a.file.Sync(); err != nil {
+		a.file.Close()
+		return err
+	}
+	return a.file.Close()
+}
+
+// syncDir makes a rename in dir durable, errors are ignored as not every platform supports it
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+}
+
+// replayAOF applies a logged record to the segments. Expired entries are dropped.
+func (c *cache) replayAOF(record aofRecord) {
+	hashKey := c.hashFunc.Sum64(record.key)
+	segment := c.segments[hashKey&c.bucketMask]
+	now := uint64(segment.clock.TimeStamp())
+	if record.op == aofOpSealedSet {
+		if c.sealer == nil {
+			segment.removeKey(record.key, hashKey)
+			return
+		}
+		value, err := c.sealer.open(record.key, record.value)
+		if err != nil {
+			segment.removeKey(record.key, hashKey)
+			return
+		}
+		record.op, record.value = aofOpSet, value
+	}
+	switch record.op {
+	case aofOpSet:
+		if record.expireAt <= now {
+			segment.removeKey(record.key, hashKey)
+			return
+		}
+		cost := record.cost
+		if cost < 0 {
+			cost = c.weigh(record.key, record.value)
+		}
+		_, _, _ = segment.store(record.key, hashKey, record.value, record.expireAt, cost)
+	case aofOpDelete:
+		segment.removeKey(record.key, hashKey)
+	case aofOpExpire:
+		if record.expireAt <= now {
+			segment.removeKey(record.key, hashKey)
+			return
+		}
+		_ = segment.expire(record.key, hashKey, record.expireAt)
+	case aofOpClear:
+		for _, segment := range c.segments {
+			segment.clear()
+		}
+	}
+}
+
+// dumpAOF emits a set record for every live entry
+func (c *cache) dumpAOF(emit func(record []byte) error) error {
+	return c.dump(func(key string, value []byte, expireAt uint64, cost int64) error {
+		record, err := c.aofSetRecord(key, value, expireAt, cost)
+		if err != nil {
+			return err
+		}
+		return emit(record)
+	})
+}
+
+// aofSetRecord renders the set record of an entry with its cost, sealing the value when
+// the cache encrypts its values
+func (c *cache) aofSetRecord(key string, value []byte, expireAt uint64, cost int64) ([]byte, error) {
+	if c.sealer == nil {
+		return encodeAOFCostRecord(aofOpCostSet, key, value, expireAt, cost), nil
+	}
+	sealed, err := c.sealer.seal(key, value)
+	if err != nil {
+		return nil, err
+	}
+	return encodeAOFCostRecord(aofOpSealedCostSet, key, sealed, expireAt, cost), nil
+}
+
+func (c *cache) RewriteAOF() error {
+	if c.aof == nil {
+		return ErrAOFDisabled
+	}
+	return c.aof.rewrite(c.dumpAOF)
+}
@@ -0,0 +1,198 @@
+package localcache
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type aofTestSuite struct {
+	suite.Suite
+}
+
+func TestAOFTestSuite(t *testing.T) {
+	suite.Run(t, new(aofTestSuite))
+}
+
+func (a *aofTestSuite) SetupSuite() {}
+
+func (a *aofTestSuite) TestReplay() {
+	path := filepath.Join(a.T().TempDir(), "cache.aof")
+	c, err := NewCache(SetAOF(path))
+	assert.Equal(a.T(), nil, err)
+
+	value := []byte("公众号：Golang梦工厂")
+	assert.Equal(a.T(), nil, c.Set("asong", value))
+	assert.Equal(a.T(), nil, c.SetWithTime("ttl", value, time.Hour))
+	assert.Equal(a.T(), nil, c.SetWithTime("expired", value, time.Second))
+	assert.Equal(a.T(), nil, c.Set("deleted", value))
+	assert.Equal(a.T(), nil, c.Delete("deleted"))
+	assert.Equal(a.T(), nil, c.Expire("asong", 2*time.Hour))
+	assert.Equal(a.T(), nil, c.Close())
+
+	time.Sleep(2 * time.Second)
+	c, err = NewCache(SetAOF(path))
+	assert.Equal(a.T(), nil, err)
+	defer c.Close()
+
+	res, err := c.Get("asong")
+	assert.Equal(a.T(), nil, err)
+	assert.Equal(a.T(), value, res)
+	ttl, err := c.TTL("asong")
+	assert.Equal(a.T(), nil, err)
+	assert.True(a.T(), ttl > time.Hour)
+
+	res, err = c.Get("ttl")
+	assert.Equal(a.T(), nil, err)
+	assert.Equal(a.T(), value, res)
+
+	_, err = c.Get("expired")
+	assert.Equal(a.T(), ErrEntryNotFound, err)
+	_, err = c.Get("deleted")
+	assert.Equal(a.T(), ErrEntryNotFound, err)
+	assert.Equal(a.T(), 2, c.Len())
+}
+
+func (a *aofTestSuite) TestTornTailIsTruncated() {
+	path := filepath.Join(a.T().TempDir(), "cache.aof")
+	c, err := NewCache(SetAOF(path), SetAOFFsync(FsyncAlways))
+	assert.Equal(a.T(), nil, err)
+	assert.Equal(a.T(), nil, c.Set("asong", []byte("公众号：Golang梦工厂")))
+	info, err := os.Stat(path)
+	assert.Equal(a.T(), nil, err)
+	valid := info.Size()
+	assert.True(a.T(), valid > 0)
+	assert.Equal(a.T(), nil, c.Close())
+
+	// simulate a crash in the middle of writing the next record
+	record := encodeAOFRecord(aofOpSet, "torn", []byte("value"), uint64(time.Now().Add(time.Hour).Unix()))
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Equal(a.T(), nil, err)
+	_, err = file.Write(record[:len(record)-2])
+	assert.Equal(a.T(), nil, err)
+	assert.Equal(a.T(), nil, file.Close())
+
+	c, err = NewCache(SetAOF(path))
+	assert.Equal(a.T(), nil, err)
+	defer c.Close()
+	_, err = c.Get("asong")
+	assert.Equal(a.T(), nil, err)
+	_, err = c.Get("torn")
+	assert.Equal(a.T(), ErrEntryNotFound, err)
+	info, err = os.Stat(path)
+	assert.Equal(a.T(), nil, err)
+	assert.Equal(a.T(), valid, info.Size())
+}
+
+func (a *aofTestSuite) TestChecksumMismatch() {
+	path := filepath.Join(a.T().TempDir(), "cache.aof")
+	first := encodeAOFRecord(aofOpSet, "first", []byte("value"), uint64(time.Now().Add(time.Hour).Unix()))
+	second := encodeAOFRecord(aofOpSet, "second", []byte("value"), uint64(time.Now().Add(time.Hour).Unix()))
+	second[len(second)-1] ^= 0xff
+	err := os.WriteFile(path, append(first, second...), 0644)
+	assert.Equal(a.T(), nil, err)
+
+	c, err := NewCache(SetAOF(path))
+	assert.Equal(a.T(), nil, err)
+	defer c.Close()
+	_, err = c.Get("first")
+	assert.Equal(a.T(), nil, err)
+	_, err = c.Get("second")
+	assert.Equal(a.T(), ErrEntryNotFound, err)
+}
+
+func (a *aofTestSuite) TestRewrite() {
+	path := filepath.Join(a.T().TempDir(), "cache.aof")
+	c, err := NewCache(SetAOF(path))
+	assert.Equal(a.T(), nil, err)
+
+	for round := 0; round < 10; round++ {
+		for index := 0; index < 100; index++ {
+			value := []byte(fmt.Sprintf("value%02d", round))
+			assert.Equal(a.T(), nil, c.Set(fmt.Sprintf("asong%03d", index), value))
+		}
+	}
+
+	// writers keep going while the log is rewritten
+	var wg sync.WaitGroup
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for index := 0; index < 100; index++ {
+				_ = c.Set(fmt.Sprintf("worker%d-%03d", worker, index), []byte("公众号：Golang梦工厂"))
+			}
+		}(worker)
+	}
+	assert.Equal(a.T(), nil, c.RewriteAOF())
+	wg.Wait()
+	assert.Equal(a.T(), nil, c.Delete("asong000"))
+	length := c.Len()
+	assert.Equal(a.T(), nil, c.Close())
+
+	c, err = NewCache(SetAOF(path))
+	assert.Equal(a.T(), nil, err)
+	defer c.Close()
+	assert.Equal(a.T(), length, c.Len())
+	res, err := c.Get("asong001")
+	assert.Equal(a.T(), nil, err)
+	assert.Equal(a.T(), []byte("value09"), res)
+	_, err = c.Get("asong000")
+	assert.Equal(a.T(), ErrEntryNotFound, err)
+	for worker := 0; worker < 4; worker++ {
+		_, err = c.Get(fmt.Sprintf("worker%d-099", worker))
+		assert.Equal(a.T(), nil, err)
+	}
+
+	c2, err := NewCache()
+	assert.Equal(a.T(), nil, err)
+	assert.Equal(a.T(), ErrAOFDisabled, c2.RewriteAOF())
+}
+
+func (a *aofTestSuite) TestReplayClear() {
+	path := filepath.Join(a.T().TempDir(), "cache.aof")
+	c, err := NewCache(SetAOF(path))
+	assert.Equal(a.T(), nil, err)
+
+	assert.Equal(a.T(), nil, c.Set("cleared", []byte("value")))
+	assert.Equal(a.T(), nil, c.Clear())
+	assert.Equal(a.T(), nil, c.Set("asong", []byte("value")))
+	assert.Equal(a.T(), nil, c.Close())
+
+	c, err = NewCache(SetAOF(path))
+	assert.Equal(a.T(), nil, err)
+	defer c.Close()
+	_, err = c.Get("cleared")
+	assert.Equal(a.T(), ErrEntryNotFound, err)
+	assert.Equal(a.T(), 1, c.Len())
+}
+
+func (a *aofTestSuite) TestAppendFailureLeavesMemory() {
+	c, err := NewCache(SetAOF(filepath.Join(a.T().TempDir(), "cache.aof")), SetAOFFsync(FsyncAlways))
+	assert.Equal(a.T(), nil, err)
+	defer c.Close()
+	assert.Equal(a.T(), nil, c.SetWithTime("asong", []byte("value"), time.Hour))
+
+	// every later append fails to reach the file
+	assert.Equal(a.T(), nil, c.(*cache).aof.file.Close())
+	assert.NotEqual(a.T(), nil, c.Set("asong", []byte("changed")))
+	assert.NotEqual(a.T(), nil, c.Set("other", []byte("value")))
+	assert.NotEqual(a.T(), nil, c.Expire("asong", time.Minute))
+	assert.NotEqual(a.T(), nil, c.Delete("asong"))
+	assert.NotEqual(a.T(), nil, c.Clear())
+
+	res, err := c.Get("asong")
+	assert.Equal(a.T(), nil, err)
+	assert.Equal(a.T(), []byte("value"), res)
+	ttl, err := c.TTL("asong")
+	assert.Equal(a.T(), nil, err)
+	assert.True(a.T(), ttl > time.Minute)
+	_, err = c.Get("other")
+	assert.Equal(a.T(), ErrEntryNotFound, err)
+	assert.Equal(a.T(), 1, c.Len())
+}
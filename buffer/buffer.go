@@ -96,6 +96,14 @@ func (b *Buffer) GetPlaceholderCount() int {
 	return len(b.placeholder)
 }
 
+func (b *Buffer) GetAvailableSpaceCount() int {
+	unused := b.capacity - b.index
+	if unused < 0 {
+		unused = 0
+	}
+	return len(b.availableSpace) + unused
+}
+
 func (b *Buffer) GetPlaceholderIndex() []int {
 	res := make([]int, 0, len(b.placeholder))
 	for index := range b.placeholder{
@@ -183,5 +183,21 @@ func (b *bufferTestSuite) TestGetPlaceholderIndex() {
 
 	expected := []int{0, 1, 2}
 	indexs := buffer.GetPlaceholderIndex()
-	assert.Equal(b.T(), expected, indexs)
-}
\ No newline at end of file
+	assert.ElementsMatch(b.T(), expected, indexs)
+}
+
+func (b *bufferTestSuite) TestGetAvailableSpaceCount() {
+	b.T().Parallel()
+	buffer := NewBuffer(3)
+	assert.Equal(b.T(), 3, buffer.GetAvailableSpaceCount())
+
+	for i := 0; i < 3; i++ {
+		_, err := buffer.Push([]byte(fmt.Sprintf("asong%02d", i)))
+		assert.Equal(b.T(), nil, err)
+	}
+	assert.Equal(b.T(), 0, buffer.GetAvailableSpaceCount())
+
+	err := buffer.Remove(1)
+	assert.Equal(b.T(), nil, err)
+	assert.Equal(b.T(), 1, buffer.GetAvailableSpaceCount())
+}
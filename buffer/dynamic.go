@@ -0,0 +1,97 @@
+package buffer
+
+// DynamicBuffer is an IBuffer that allocates its slots as they are used, up to capacity.
+// It suits buffers whose capacity is large next to the number of entries they usually hold.
+type DynamicBuffer struct {
+	array    [][]byte
+	capacity int
+	count    int
+	// free holds the released slots
+	free []int
+}
+
+func NewDynamicBuffer(capacity int) IBuffer {
+	return &DynamicBuffer{capacity: capacity}
+}
+
+func (b *DynamicBuffer) Push(data []byte) (int, error) {
+	index := 0
+	if len(b.free) > 0 {
+		index = b.free[len(b.free)-1]
+		b.free = b.free[:len(b.free)-1]
+	} else if len(b.array) < b.capacity {
+		index = len(b.array)
+		b.array = append(b.array, nil)
+	} else {
+		return 0, ErrBufferFull
+	}
+	b.array[index] = make([]byte, len(data))
+	copy(b.array[index], data)
+	b.count++
+	return index, nil
+}
+
+func (b *DynamicBuffer) Get(index int) ([]byte, error) {
+	if err := b.checkIndex(index); err != nil {
+		return nil, err
+	}
+	if index >= len(b.array) {
+		return nil, nil
+	}
+	return b.array[index], nil
+}
+
+func (b *DynamicBuffer) Remove(index int) error {
+	if err := b.checkIndex(index); err != nil {
+		return err
+	}
+	if index >= len(b.array) || b.array[index] == nil {
+		return nil
+	}
+	b.array[index] = nil
+	b.free = append(b.free, index)
+	b.count--
+	return nil
+}
+
+func (b *DynamicBuffer) checkIndex(index int) error {
+	if index < 0 {
+		return ErrInvalidIndex
+	}
+	if index >= b.capacity {
+		return ErrIndexOutOFBounds
+	}
+	return nil
+}
+
+func (b *DynamicBuffer) Reset() {
+	b.array = nil
+	b.free = nil
+	b.count = 0
+}
+
+func (b *DynamicBuffer) Len() int {
+	return b.count
+}
+
+func (b *DynamicBuffer) Capacity() int {
+	return b.capacity
+}
+
+func (b *DynamicBuffer) GetPlaceholderCount() int {
+	return b.count
+}
+
+func (b *DynamicBuffer) GetAvailableSpaceCount() int {
+	return b.capacity - b.count
+}
+
+func (b *DynamicBuffer) GetPlaceholderIndex() []int {
+	res := make([]int, 0, b.count)
+	for index, data := range b.array {
+		if data != nil {
+			res = append(res, index)
+		}
+	}
+	return res
+}
@@ -0,0 +1,75 @@
+package buffer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type dynamicBufferTestSuite struct {
+	suite.Suite
+}
+
+func TestDynamicBufferTestSuite(t *testing.T) {
+	suite.Run(t, new(dynamicBufferTestSuite))
+}
+
+func (d *dynamicBufferTestSuite) SetupSuite() {}
+
+func (d *dynamicBufferTestSuite) TestPushGetRemove() {
+	buffer := NewDynamicBuffer(2)
+	assert.Equal(d.T(), 2, buffer.GetAvailableSpaceCount())
+
+	index, err := buffer.Push([]byte("Hello"))
+	assert.Equal(d.T(), nil, err)
+	assert.Equal(d.T(), 0, index)
+	index, err = buffer.Push([]byte{})
+	assert.Equal(d.T(), nil, err)
+	assert.Equal(d.T(), 1, index)
+	_, err = buffer.Push([]byte("full"))
+	assert.Equal(d.T(), ErrBufferFull, err)
+
+	res, err := buffer.Get(0)
+	assert.Equal(d.T(), nil, err)
+	assert.Equal(d.T(), []byte("Hello"), res)
+	res, err = buffer.Get(1)
+	assert.Equal(d.T(), nil, err)
+	assert.Equal(d.T(), []byte{}, res)
+
+	assert.Equal(d.T(), nil, buffer.Remove(0))
+	assert.Equal(d.T(), nil, buffer.Remove(0))
+	res, err = buffer.Get(0)
+	assert.Equal(d.T(), nil, err)
+	assert.Nil(d.T(), res)
+	assert.Equal(d.T(), 1, buffer.Len())
+	assert.Equal(d.T(), 1, buffer.GetAvailableSpaceCount())
+	assert.Equal(d.T(), []int{1}, buffer.GetPlaceholderIndex())
+
+	index, err = buffer.Push([]byte("World"))
+	assert.Equal(d.T(), nil, err)
+	assert.Equal(d.T(), 0, index)
+
+	_, err = buffer.Get(2)
+	assert.Equal(d.T(), ErrIndexOutOFBounds, err)
+	_, err = buffer.Get(-1)
+	assert.Equal(d.T(), ErrInvalidIndex, err)
+}
+
+func (d *dynamicBufferTestSuite) TestGrowsOnDemand() {
+	buffer := NewDynamicBuffer(1 << 30).(*DynamicBuffer)
+	for i := 0; i < 3; i++ {
+		_, err := buffer.Push([]byte("Hello"))
+		assert.Equal(d.T(), nil, err)
+	}
+	assert.Equal(d.T(), 3, len(buffer.array))
+	res, err := buffer.Get(1000)
+	assert.Equal(d.T(), nil, err)
+	assert.Nil(d.T(), res)
+
+	buffer.Reset()
+	assert.Equal(d.T(), 0, buffer.Len())
+	assert.Equal(d.T(), []int{}, buffer.GetPlaceholderIndex())
+	index, err := buffer.Push([]byte("Hello"))
+	assert.Equal(d.T(), nil, err)
+	assert.Equal(d.T(), 0, index)
+}
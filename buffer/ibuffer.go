@@ -15,6 +15,14 @@ type IBuffer interface {
 	Remove(index int) error
 	// GetPlaceholderCount buffer store entry count
 	GetPlaceholderCount() int
+	// GetAvailableSpaceCount number of free slots, both released and never used
+	GetAvailableSpaceCount() int
 	// GetPlaceholderIndex get all index
 	GetPlaceholderIndex() []int
-}
\ No newline at end of file
+}
+
+// Viewer is implemented by buffers whose Get copies the data. View returns the stored data
+// itself, which stays valid until the slot is removed or the buffer is reset or closed.
+type Viewer interface {
+	View(index int) ([]byte, error)
+}
@@ -0,0 +1,299 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package buffer
+
+import (
+	"encoding/binary"
+	"os"
+	"sort"
+	"syscall"
+	"unsafe"
+)
+
+// MmapBuffer is an IBuffer whose slots live in an mmap region outside the Go heap,
+// so the garbage collector neither scans nor accounts for the stored data.
+// Every slot has a fixed size: a 4 byte length header followed by the data.
+//
+// A file backed buffer keeps its contents when it is closed and opened again with the
+// same geometry, which allows warm restarts. An anonymous buffer is gone once closed.
+type MmapBuffer struct {
+	data     []byte
+	file     *os.File
+	capacity int
+	slotSize int
+	// next is the first slot that has never been used
+	next int
+	// free holds the released slots below next
+	free []int
+	// placeholder record the index that buffer has stored.
+	placeholder map[int]struct{}
+}
+
+// NewMmapBuffer maps an anonymous region of capacity slots of slotSize bytes each
+func NewMmapBuffer(capacity int, slotSize int) (*MmapBuffer, error) {
+	if err := checkMmapGeometry(capacity, slotSize); err != nil {
+		return nil, err
+	}
+	data, err := syscall.Mmap(-1, 0, mmapHeaderSize+capacity*slotSize,
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, err
+	}
+	b := newMmapBuffer(data, nil, capacity, slotSize)
+	b.writeHeader()
+	return b, nil
+}
+
+// OpenMmapBuffer maps the file at path, creating it when needed. When the file was
+// written by a buffer of the same capacity and slot size, its entries are kept;
+// otherwise it is reset.
+func OpenMmapBuffer(path string, capacity int, slotSize int) (*MmapBuffer, error) {
+	if err := checkMmapGeometry(capacity, slotSize); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	size := mmapHeaderSize + capacity*slotSize
+	if err := file.Truncate(int64(size)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	data, err := syscall.Mmap(int(file.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	b := newMmapBuffer(data, file, capacity, slotSize)
+	if b.validHeader() {
+		b.recover()
+	} else {
+		b.Reset()
+	}
+	return b, nil
+}
+
+const (
+	// mmapMagic identifies the file format, including the layout of the entries the cache
+	// stores in the slots. It must change with that layout, so files written by an older
+	// build are reset instead of being misread.
+	mmapMagic = "LCMMAP02"
+	// mmapHeaderSize is the magic, the capacity and the slot size
+	mmapHeaderSize = 16
+	// slotHeaderSize is the length of the data stored in a slot, plus one. 0 marks a free slot.
+	slotHeaderSize = 4
+)
+
+var (
+	ErrDataTooLarge    = &bufferError{"Data larger than the buffer slot."}
+	ErrInvalidGeometry = &bufferError{"Capacity and slot size must be greater than zero."}
+	// ErrBufferClosed is returned by the buffer once its region is unmapped
+	ErrBufferClosed = &bufferError{"Buffer closed."}
+)
+
+func checkMmapGeometry(capacity int, slotSize int) error {
+	if capacity <= 0 || slotSize <= slotHeaderSize {
+		return ErrInvalidGeometry
+	}
+	return nil
+}
+
+func newMmapBuffer(data []byte, file *os.File, capacity int, slotSize int) *MmapBuffer {
+	return &MmapBuffer{
+		data:        data,
+		file:        file,
+		capacity:    capacity,
+		slotSize:    slotSize,
+		placeholder: make(map[int]struct{}, capacity),
+	}
+}
+
+func (b *MmapBuffer) writeHeader() {
+	copy(b.data, mmapMagic)
+	binary.LittleEndian.PutUint32(b.data[8:], uint32(b.capacity))
+	binary.LittleEndian.PutUint32(b.data[12:], uint32(b.slotSize))
+}
+
+func (b *MmapBuffer) validHeader() bool {
+	return string(b.data[:8]) == mmapMagic &&
+		binary.LittleEndian.Uint32(b.data[8:]) == uint32(b.capacity) &&
+		binary.LittleEndian.Uint32(b.data[12:]) == uint32(b.slotSize)
+}
+
+// recover rebuilds the slot bookkeeping from the slot headers of a reopened file
+func (b *MmapBuffer) recover() {
+	for index := 0; index < b.capacity; index++ {
+		if b.slotLength(index) > 0 {
+			b.placeholder[index] = struct{}{}
+			b.next = index + 1
+		}
+	}
+	for index := 0; index < b.next; index++ {
+		if _, ok := b.placeholder[index]; !ok {
+			b.free = append(b.free, index)
+		}
+	}
+}
+
+func (b *MmapBuffer) slot(index int) []byte {
+	offset := mmapHeaderSize + index*b.slotSize
+	return b.data[offset : offset+b.slotSize]
+}
+
+// slotLength returns the stored length plus one, 0 for a free slot
+func (b *MmapBuffer) slotLength(index int) uint32 {
+	return binary.LittleEndian.Uint32(b.slot(index))
+}
+
+func (b *MmapBuffer) Push(data []byte) (int, error) {
+	if b.data == nil {
+		return 0, ErrBufferClosed
+	}
+	if len(data) > b.slotSize-slotHeaderSize {
+		return 0, ErrDataTooLarge
+	}
+	index := 0
+	if len(b.free) > 0 {
+		index = b.free[len(b.free)-1]
+		b.free = b.free[:len(b.free)-1]
+	} else if b.next < b.capacity {
+		index = b.next
+		b.next++
+	} else {
+		return 0, ErrBufferFull
+	}
+	slot := b.slot(index)
+	copy(slot[slotHeaderSize:], data)
+	binary.LittleEndian.PutUint32(slot, uint32(len(data))+1)
+	b.placeholder[index] = struct{}{}
+	return index, nil
+}
+
+// Get returns a copy of the data stored at index. The mapped memory is reused by later
+// pushes and unmapped by Close, so it is never handed out directly.
+func (b *MmapBuffer) Get(index int) ([]byte, error) {
+	if err := b.checkIndex(index); err != nil {
+		return nil, err
+	}
+	length := b.slotLength(index)
+	if length == 0 {
+		return nil, nil
+	}
+	res := make([]byte, length-1)
+	copy(res, b.slot(index)[slotHeaderSize:])
+	return res, nil
+}
+
+// View returns the data stored at index without copying it. The data must not be used
+// once the slot is removed or the buffer is reset or closed.
+func (b *MmapBuffer) View(index int) ([]byte, error) {
+	if err := b.checkIndex(index); err != nil {
+		return nil, err
+	}
+	length := b.slotLength(index)
+	if length == 0 {
+		return nil, nil
+	}
+	return b.slot(index)[slotHeaderSize : slotHeaderSize+length-1], nil
+}
+
+func (b *MmapBuffer) Remove(index int) error {
+	if err := b.checkIndex(index); err != nil {
+		return err
+	}
+	if b.slotLength(index) == 0 {
+		return nil
+	}
+	binary.LittleEndian.PutUint32(b.slot(index), 0)
+	delete(b.placeholder, index)
+	b.free = append(b.free, index)
+	return nil
+}
+
+func (b *MmapBuffer) checkIndex(index int) error {
+	if b.data == nil {
+		return ErrBufferClosed
+	}
+	if index < 0 {
+		return ErrInvalidIndex
+	}
+	if index >= b.capacity {
+		return ErrIndexOutOFBounds
+	}
+	return nil
+}
+
+func (b *MmapBuffer) Reset() {
+	if b.data == nil {
+		return
+	}
+	for index := 0; index < b.capacity; index++ {
+		binary.LittleEndian.PutUint32(b.slot(index), 0)
+	}
+	b.writeHeader()
+	b.next = 0
+	b.free = nil
+	b.placeholder = make(map[int]struct{}, b.capacity)
+}
+
+func (b *MmapBuffer) Len() int {
+	return len(b.placeholder)
+}
+
+func (b *MmapBuffer) Capacity() int {
+	return b.capacity
+}
+
+func (b *MmapBuffer) GetPlaceholderCount() int {
+	return len(b.placeholder)
+}
+
+func (b *MmapBuffer) GetAvailableSpaceCount() int {
+	return b.capacity - len(b.placeholder)
+}
+
+func (b *MmapBuffer) GetPlaceholderIndex() []int {
+	res := make([]int, 0, len(b.placeholder))
+	for index := range b.placeholder {
+		res = append(res, index)
+	}
+	sort.Ints(res)
+	return res
+}
+
+// Sync flushes the mapped region of a file backed buffer to disk
+func (b *MmapBuffer) Sync() error {
+	if b.file == nil {
+		return nil
+	}
+	if b.data == nil {
+		return ErrBufferClosed
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(&b.data[0])),
+		uintptr(len(b.data)), syscall.MS_SYNC)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Close unmaps the region. A file backed buffer is synced first so it can be reopened.
+// Once closed, the buffer returns ErrBufferClosed.
+func (b *MmapBuffer) Close() error {
+	if b.data == nil {
+		return nil
+	}
+	err := b.Sync()
+	if unmapErr := syscall.Munmap(b.data); err == nil {
+		err = unmapErr
+	}
+	b.data = nil
+	if b.file != nil {
+		if closeErr := b.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
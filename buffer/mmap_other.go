@@ -0,0 +1,30 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package buffer
+
+import "io"
+
+var (
+	ErrDataTooLarge    = &bufferError{"Data larger than the buffer slot."}
+	ErrInvalidGeometry = &bufferError{"Capacity and slot size must be greater than zero."}
+	ErrBufferClosed    = &bufferError{"Buffer closed."}
+	// ErrMmapUnsupported is returned on platforms without mmap support
+	ErrMmapUnsupported = &bufferError{"Mmap buffers are not supported on this platform."}
+)
+
+// MmapBuffer is not available on this platform
+type MmapBuffer struct {
+	IBuffer
+	io.Closer
+}
+
+// NewMmapBuffer returns ErrMmapUnsupported on this platform
+func NewMmapBuffer(capacity int, slotSize int) (*MmapBuffer, error) {
+	return nil, ErrMmapUnsupported
+}
+
+// OpenMmapBuffer returns ErrMmapUnsupported on this platform
+func OpenMmapBuffer(path string, capacity int, slotSize int) (*MmapBuffer, error) {
+	return nil, ErrMmapUnsupported
+}
@@ -0,0 +1,190 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package buffer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"github.com/stretchr/testify/suite"
+	"path/filepath"
+	"testing"
+)
+
+type mmapBufferTestSuite struct {
+	suite.Suite
+}
+
+func TestMmapBufferTestSuite(t *testing.T) {
+	suite.Run(t, new(mmapBufferTestSuite))
+}
+
+func (m *mmapBufferTestSuite) SetupSuite() {}
+
+func (m *mmapBufferTestSuite) TestPushGetRemove() {
+	buffer, err := NewMmapBuffer(2, 64)
+	assert.Equal(m.T(), nil, err)
+	defer buffer.Close()
+
+	index, err := buffer.Push([]byte("Hello"))
+	assert.Equal(m.T(), nil, err)
+	assert.Equal(m.T(), 0, index)
+	index, err = buffer.Push([]byte{})
+	assert.Equal(m.T(), nil, err)
+	assert.Equal(m.T(), 1, index)
+	_, err = buffer.Push([]byte("full"))
+	assert.Equal(m.T(), ErrBufferFull, err)
+
+	res, err := buffer.Get(0)
+	assert.Equal(m.T(), nil, err)
+	assert.Equal(m.T(), []byte("Hello"), res)
+	res, err = buffer.Get(1)
+	assert.Equal(m.T(), nil, err)
+	assert.Equal(m.T(), []byte{}, res)
+
+	assert.Equal(m.T(), nil, buffer.Remove(0))
+	res, err = buffer.Get(0)
+	assert.Equal(m.T(), nil, err)
+	assert.Nil(m.T(), res)
+	assert.Equal(m.T(), 1, buffer.Len())
+	assert.Equal(m.T(), 1, buffer.GetAvailableSpaceCount())
+	assert.Equal(m.T(), []int{1}, buffer.GetPlaceholderIndex())
+
+	index, err = buffer.Push([]byte("World"))
+	assert.Equal(m.T(), nil, err)
+	assert.Equal(m.T(), 0, index)
+
+	_, err = buffer.Get(2)
+	assert.Equal(m.T(), ErrIndexOutOFBounds, err)
+	_, err = buffer.Get(-1)
+	assert.Equal(m.T(), ErrInvalidIndex, err)
+}
+
+func (m *mmapBufferTestSuite) TestGetCopiesOut() {
+	buffer, err := NewMmapBuffer(1, 64)
+	assert.Equal(m.T(), nil, err)
+
+	index, err := buffer.Push([]byte("Hello"))
+	assert.Equal(m.T(), nil, err)
+	res, err := buffer.Get(index)
+	assert.Equal(m.T(), nil, err)
+	res[0] = 'J'
+
+	assert.Equal(m.T(), nil, buffer.Remove(index))
+	_, err = buffer.Push([]byte("World"))
+	assert.Equal(m.T(), nil, err)
+	assert.Equal(m.T(), nil, buffer.Close())
+	assert.Equal(m.T(), []byte("Jello"), res)
+}
+
+func (m *mmapBufferTestSuite) TestView() {
+	buffer, err := NewMmapBuffer(2, 64)
+	assert.Equal(m.T(), nil, err)
+	defer buffer.Close()
+
+	index, err := buffer.Push([]byte("Hello"))
+	assert.Equal(m.T(), nil, err)
+	res, err := buffer.View(index)
+	assert.Equal(m.T(), nil, err)
+	assert.Equal(m.T(), []byte("Hello"), res)
+
+	// the view is the mapped memory, a later push into the slot shows through
+	assert.Equal(m.T(), nil, buffer.Remove(index))
+	res, err = buffer.View(index)
+	assert.Equal(m.T(), nil, err)
+	assert.Nil(m.T(), res)
+	_, err = buffer.Push([]byte("World"))
+	assert.Equal(m.T(), nil, err)
+	res, err = buffer.View(index)
+	assert.Equal(m.T(), nil, err)
+	res[0] = 'J'
+	got, _ := buffer.Get(index)
+	assert.Equal(m.T(), []byte("Jorld"), got)
+
+	_, err = buffer.View(2)
+	assert.Equal(m.T(), ErrIndexOutOFBounds, err)
+}
+
+func (m *mmapBufferTestSuite) TestDataTooLarge() {
+	buffer, err := NewMmapBuffer(1, 8)
+	assert.Equal(m.T(), nil, err)
+	defer buffer.Close()
+
+	_, err = buffer.Push([]byte("Hello"))
+	assert.Equal(m.T(), ErrDataTooLarge, err)
+	_, err = buffer.Push([]byte("Hell"))
+	assert.Equal(m.T(), nil, err)
+
+	_, err = NewMmapBuffer(0, 8)
+	assert.Equal(m.T(), ErrInvalidGeometry, err)
+}
+
+func (m *mmapBufferTestSuite) TestReopen() {
+	path := filepath.Join(m.T().TempDir(), "buffer.mmap")
+	buffer, err := OpenMmapBuffer(path, 4, 64)
+	assert.Equal(m.T(), nil, err)
+	for _, data := range []string{"a", "b", "c"} {
+		_, err := buffer.Push([]byte(data))
+		assert.Equal(m.T(), nil, err)
+	}
+	assert.Equal(m.T(), nil, buffer.Remove(1))
+	assert.Equal(m.T(), nil, buffer.Close())
+
+	buffer, err = OpenMmapBuffer(path, 4, 64)
+	assert.Equal(m.T(), nil, err)
+	assert.Equal(m.T(), []int{0, 2}, buffer.GetPlaceholderIndex())
+	res, err := buffer.Get(2)
+	assert.Equal(m.T(), nil, err)
+	assert.Equal(m.T(), []byte("c"), res)
+	index, err := buffer.Push([]byte("d"))
+	assert.Equal(m.T(), nil, err)
+	assert.Equal(m.T(), 1, index)
+	index, err = buffer.Push([]byte("e"))
+	assert.Equal(m.T(), nil, err)
+	assert.Equal(m.T(), 3, index)
+	assert.Equal(m.T(), nil, buffer.Close())
+
+	// a different geometry starts empty
+	buffer, err = OpenMmapBuffer(path, 8, 64)
+	assert.Equal(m.T(), nil, err)
+	assert.Equal(m.T(), 0, buffer.Len())
+	assert.Equal(m.T(), nil, buffer.Close())
+}
+
+func (m *mmapBufferTestSuite) TestReopenOtherFormat() {
+	path := filepath.Join(m.T().TempDir(), "buffer.mmap")
+	buffer, err := OpenMmapBuffer(path, 4, 64)
+	assert.Equal(m.T(), nil, err)
+	_, err = buffer.Push([]byte("a"))
+	assert.Equal(m.T(), nil, err)
+	assert.Equal(m.T(), nil, buffer.Close())
+
+	// a file written with the previous entry layout starts empty
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	assert.Equal(m.T(), nil, err)
+	_, err = file.WriteAt([]byte("LCMMAP01"), 0)
+	assert.Equal(m.T(), nil, err)
+	assert.Equal(m.T(), nil, file.Close())
+	buffer, err = OpenMmapBuffer(path, 4, 64)
+	assert.Equal(m.T(), nil, err)
+	assert.Equal(m.T(), 0, buffer.Len())
+	assert.Equal(m.T(), nil, buffer.Close())
+}
+
+func (m *mmapBufferTestSuite) TestClosed() {
+	buffer, err := NewMmapBuffer(2, 64)
+	assert.Equal(m.T(), nil, err)
+	index, err := buffer.Push([]byte("Hello"))
+	assert.Equal(m.T(), nil, err)
+	assert.Equal(m.T(), nil, buffer.Close())
+
+	_, err = buffer.Push([]byte("Hello"))
+	assert.Equal(m.T(), ErrBufferClosed, err)
+	_, err = buffer.Get(index)
+	assert.Equal(m.T(), ErrBufferClosed, err)
+	_, err = buffer.View(index)
+	assert.Equal(m.T(), ErrBufferClosed, err)
+	assert.Equal(m.T(), ErrBufferClosed, buffer.Remove(index))
+	buffer.Reset()
+	assert.Equal(m.T(), nil, buffer.Close())
+}
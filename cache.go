@@ -2,6 +2,7 @@ package localcache
 
 import (
 	"errors"
+	"math"
 	"sync"
 	"time"
 )
@@ -9,6 +10,10 @@ import (
 var (
 	ErrShardCount = errors.New("shard count must be power of two")
 	ErrBytes = errors.New("maxBytes must be greater than 0")
+	ErrCleanupBatchSize = errors.New("cleanup batch size must be greater than 0")
+	ErrCleanupTimeBudget = errors.New("cleanup time budget must be greater than 0")
+	ErrSkewInterval = errors.New("shard skew check interval must be greater than 0")
+	ErrCostInvalid = errors.New("cost must not be negative")
 )
 
 const (
@@ -17,6 +22,14 @@ const (
 	defaultCleanTIme = time.Minute * 10
 	defaultStatsEnabled = false
 	defaultCleanupEnabled = false
+	defaultCleanupBatchSize = 20
+	defaultCleanupTimeBudget = 25 * time.Millisecond
+	defaultScanCount = 10
+	// activeExpireRatio is the share of expired entries above which a cleanup pass keeps
+	// working on the same segment and the next pass is scheduled sooner.
+	activeExpireRatio = 0.25
+	// minCleanTimeDivisor bounds how much more often than cleanTime cleanup may run.
+	minCleanTimeDivisor = 16
 )
 
 type cache struct {
@@ -32,11 +45,127 @@ type cache struct {
 	locks    []sync.RWMutex
 	// close cache
 	close chan struct{}
+	// cleanupBatchSize is the number of buffer slots a segment inspects per cleanup pass
+	cleanupBatchSize int
+	// cleanupTimeBudget bounds the time spent in a single cleanup cycle
+	cleanupTimeBudget time.Duration
+	// cleanupSegment is the segment the next cleanup cycle resumes from
+	cleanupSegment uint64
+	// statsEnabled enables lock wait accounting
+	statsEnabled bool
+	// latency records operation latencies, nil unless enabled
+	latency *latencyRecorder
+	// aof logs every mutation, nil unless enabled
+	aof *aof
+	// l2 holds the entries evicted from memory, nil unless enabled
+	l2 *diskStore
+	// store is the system of record behind the cache, nil unless set
+	store Store
+	// storeLocks order the store calls of a key with its cache updates
+	storeLocks []sync.Mutex
+	// writeBehind queues the writes to store in WriteBehind mode, nil otherwise
+	writeBehind *writeBehind
+	// sealer encrypts the values written to memory and to disk, nil unless enabled
+	sealer *sealer
+	// maxKeySize is the longest key a set accepts
+	maxKeySize uint64
+	// weigher computes the cost of the entries set without one, nil gives them defaultCost
+	weigher Weigher
 }
 
 
 // NewCache constructor cache instance
 func NewCache(opts ...Opt) (ICache, error) {
+	options, err := newOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var codec *valueCodec
+	if options.compressor != nil || options.keyProvider != nil {
+		codec = &valueCodec{}
+	}
+	if options.compressor != nil {
+		if err := registerCompressor(options.compressor); err != nil {
+			return nil, err
+		}
+		codec.compression = newCompression(options.compressor, options.compressionThreshold)
+	}
+	if options.keyProvider != nil {
+		if _, key, err := options.keyProvider.CurrentKey(); err != nil {
+			return nil, err
+		} else if !validKeySize(key) {
+			return nil, ErrKeySize
+		}
+		codec.sealer = newSealer(options.keyProvider)
+	}
+
+	segments := make([]*segment, options.bucketCount)
+	maxSegmentBytes := options.segmentBytes()
+	if options.mmapEnabled {
+		var err error
+		segments, err = newMmapSegments(options.bucketCount, maxSegmentBytes, options.mmapDir, options.statsEnabled)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		for index := range segments{
+			segments[index] = newSegment(maxSegmentBytes, options.statsEnabled)
+		}
+	}
+	for _, segment := range segments {
+		segment.codec = codec
+	}
+
+	c := newCacheWithSegments(options, segments)
+	if options.mmapEnabled {
+		c.maxKeySize = maxChunkedKeySize
+	}
+	if codec != nil {
+		c.sealer = codec.sealer
+	}
+	if options.mmapDir != "" {
+		c.rebuildSegments()
+	}
+	if options.l2Dir != "" {
+		l2, err := openDiskStore(options.l2Dir, options.l2MaxBytes)
+		if err != nil {
+			closeSegments(segments)
+			return nil, err
+		}
+		l2.sealer = c.sealer
+		c.l2 = l2
+		for _, segment := range segments {
+			segment.onEvict = c.demote
+		}
+	}
+	if options.aofPath != "" {
+		aof, err := openAOF(options.aofPath, options.aofFsync, c.replayAOF)
+		if err != nil {
+			if c.l2 != nil {
+				c.l2.close()
+			}
+			closeSegments(segments)
+			return nil, err
+		}
+		c.aof = aof
+		c.flushL2()
+	}
+	if options.store != nil {
+		c.store = options.store
+		c.storeLocks = make([]sync.Mutex, storeLockStripes)
+		if options.writeMode == WriteBehind {
+			c.writeBehind = newWriteBehind(options.store, options.writeBehindBatchSize,
+				options.writeBehindInterval, options.writeBehindMaxDirty)
+		}
+	}
+	c.start(options)
+
+	return c, nil
+}
+
+// newOptions applies opts over the defaults and validates the result
+func newOptions(opts []Opt) (*options, error) {
 	options := &options{
 		hashFunc: NewDefaultHashFunc(),
 		bucketCount: defaultBucketCount,
@@ -44,6 +173,11 @@ func NewCache(opts ...Opt) (ICache, error) {
 		cleanTime: defaultCleanTIme,
 		statsEnabled: defaultStatsEnabled,
 		cleanupEnabled: defaultCleanupEnabled,
+		cleanupBatchSize: defaultCleanupBatchSize,
+		cleanupTimeBudget: defaultCleanupTimeBudget,
+		writeBehindBatchSize: defaultWriteBehindBatchSize,
+		writeBehindInterval: defaultWriteBehindInterval,
+		writeBehindMaxDirty: defaultWriteBehindMaxDirty,
 	}
 	for _, each := range opts{
 		each(options)
@@ -57,68 +191,459 @@ func NewCache(opts ...Opt) (ICache, error) {
 		return nil, ErrBytes
 	}
 
-	segments := make([]*segment, options.bucketCount)
-	locks := make([]sync.RWMutex, options.bucketCount)
+	if options.cleanupBatchSize <= 0 {
+		return nil, ErrCleanupBatchSize
+	}
+
+	if options.cleanupTimeBudget <= 0 {
+		return nil, ErrCleanupTimeBudget
+	}
+
+	if options.skewWarning != nil && options.skewInterval <= 0 {
+		return nil, ErrSkewInterval
+	}
 
-	maxSegmentBytes := (options.maxBytes + options.bucketCount - 1) / options.bucketCount
-	for index := range segments{
-		segments[index] = newSegment(maxSegmentBytes, options.statsEnabled)
+	if options.maxCost < 0 {
+		return nil, ErrCostInvalid
 	}
 
+	if options.store != nil {
+		if options.writeMode != WriteThrough && options.writeMode != WriteBehind {
+			return nil, ErrWriteMode
+		}
+		if options.writeMode == WriteBehind && (options.writeBehindBatchSize <= 0 ||
+			options.writeBehindInterval <= 0 || options.writeBehindMaxDirty <= 0) {
+			return nil, ErrWriteBehind
+		}
+	}
+	return options, nil
+}
+
+// segmentBytes returns the share of maxBytes held by every segment
+func (o *options) segmentBytes() uint64 {
+	return (o.maxBytes + o.bucketCount - 1) / o.bucketCount
+}
+
+// newCacheWithSegments creates a cache over segments, without starting it
+func newCacheWithSegments(options *options, segments []*segment) *cache {
 	c := &cache{
 		hashFunc: options.hashFunc,
 		bucketCount: options.bucketCount,
 		bucketMask: options.bucketCount - 1,
 		segments: segments,
-		locks: locks,
+		locks: make([]sync.RWMutex, options.bucketCount),
 		close: make(chan struct{}),
+		cleanupBatchSize: options.cleanupBatchSize,
+		cleanupTimeBudget: options.cleanupTimeBudget,
+		statsEnabled: options.statsEnabled,
+		maxKeySize: maxKeySize,
+		weigher: options.weigher,
+	}
+	if options.latencyEnabled {
+		c.latency = &latencyRecorder{}
+	}
+	maxSegmentCost := (options.maxCost + int64(options.bucketCount) - 1) / int64(options.bucketCount)
+	for _, segment := range segments {
+		segment.maxCost = maxSegmentCost
+		if options.maxCost > 0 || options.weigher != nil {
+			segment.costs = make(map[uint64]int64)
+		}
 	}
+	return c
+}
+
+// start runs the background cleanup and the skew monitor
+func (c *cache) start(options *options) {
     if options.cleanupEnabled {
 		go c.cleanup(options.cleanTime)
 	}
-
-	return c, nil
+	if options.skewWarning != nil {
+		go c.monitorSkew(options.skewInterval, options.skewThreshold, options.skewWarning)
+	}
 }
 
 func (c *cache) Set(key string, value []byte) error  {
-	hashKey := c.hashFunc.Sum64(key)
-	bucketIndex := hashKey&c.bucketMask
-	c.locks[bucketIndex].Lock()
-	defer c.locks[bucketIndex].Unlock()
-	err := c.segments[bucketIndex].set(key, hashKey, value, defaultExpireTime)
-	return err
+	return c.set(key, value, defaultExpireTime, c.weigh(key, value))
 }
 
 func (c *cache) Get(key string) ([]byte, error)  {
 	hashKey := c.hashFunc.Sum64(key)
 	bucketIndex := hashKey&c.bucketMask
-	c.locks[bucketIndex].RLock()
-	defer c.locks[hashKey&c.bucketMask].RUnlock()
+	if c.latency != nil {
+		defer c.latency.get.since(bucketIndex, time.Now())
+	}
+	c.rlock(bucketIndex)
 	entry, err := c.segments[bucketIndex].get(key, hashKey)
-	if err != nil{
-		return nil, err
+	c.locks[bucketIndex].RUnlock()
+	if err != nil {
+		return c.getMissing(key, hashKey, bucketIndex, err)
+	}
+	return entry, nil
+}
+
+// View calls fn with the value of key while holding the segment read lock. Raw values are
+// passed as stored, without copying, so fn must neither keep nor modify value and must not
+// call the cache. Values that are compressed, sealed or split across slots are decoded
+// into a temporary buffer first. The error returned by fn is returned as is.
+func (c *cache) View(key string, fn func(value []byte) error) error {
+	hashKey := c.hashFunc.Sum64(key)
+	bucketIndex := hashKey&c.bucketMask
+	if c.latency != nil {
+		defer c.latency.get.since(bucketIndex, time.Now())
+	}
+	c.rlock(bucketIndex)
+	segment := c.segments[bucketIndex]
+	entry, err := segment.lookup(key, hashKey)
+	if err == nil {
+		err = segment.view(key, hashKey, entry, fn)
+		c.locks[bucketIndex].RUnlock()
+		return err
+	}
+	c.locks[bucketIndex].RUnlock()
+	value, err := c.getMissing(key, hashKey, bucketIndex, err)
+	if err != nil {
+		return err
+	}
+	return fn(value)
+}
+
+// AppendTo appends the value of key to dst and returns the extended slice, which lets
+// callers reuse their buffers across reads. dst is returned unchanged on error.
+func (c *cache) AppendTo(dst []byte, key string) ([]byte, error) {
+	hashKey := c.hashFunc.Sum64(key)
+	bucketIndex := hashKey&c.bucketMask
+	if c.latency != nil {
+		defer c.latency.get.since(bucketIndex, time.Now())
+	}
+	c.rlock(bucketIndex)
+	segment := c.segments[bucketIndex]
+	entry, err := segment.lookup(key, hashKey)
+	if err == nil {
+		res, err := segment.appendValue(dst, key, hashKey, entry)
+		c.locks[bucketIndex].RUnlock()
+		if err != nil {
+			return dst, err
+		}
+		return res, nil
 	}
-	return entry,nil
+	c.locks[bucketIndex].RUnlock()
+	value, err := c.getMissing(key, hashKey, bucketIndex, err)
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, value...), nil
+}
+
+// getMissing completes a read that did not find a live entry in memory: expired entries
+// are removed, then the key is looked up in the disk tier and in the store.
+func (c *cache) getMissing(key string, hashKey uint64, bucketIndex uint64, err error) ([]byte, error) {
+	if err == errEntryExpired {
+		// expired entries are removed lazily, which needs the write lock
+		c.lock(bucketIndex)
+		c.segments[bucketIndex].removeExpired(key, hashKey)
+		c.locks[bucketIndex].Unlock()
+		if c.store != nil {
+			return c.load(key, hashKey, bucketIndex)
+		}
+		return nil, ErrEntryNotFound
+	}
+	if err == ErrEntryNotFound && c.l2 != nil {
+		if value, ok := c.promote(key, hashKey, bucketIndex); ok {
+			return value, nil
+		}
+	}
+	if err == ErrEntryNotFound && c.store != nil {
+		return c.load(key, hashKey, bucketIndex)
+	}
+	return nil, err
+}
+
+// demote moves an entry evicted from memory to the disk tier. It is called under the
+// segment write lock, so the entry is only queued; flushL2 writes it once the lock is
+// released. Entries that do not fit the disk tier budget are dropped.
+func (c *cache) demote(key string, value []byte, expireAt uint64, cost int64) {
+	c.l2.queue(key, value, expireAt, cost)
+}
+
+// flushL2 writes the demotions queued under a segment lock. It must be called without
+// holding any segment lock.
+func (c *cache) flushL2() {
+	if c.l2 != nil {
+		c.l2.flush()
+	}
+}
+
+// promote moves key from the disk tier back into memory. The key is taken from the disk
+// tier under the segment write lock, so a concurrent Delete or Set of the key either
+// finds it there or waits until it is back in memory.
+func (c *cache) promote(key string, hashKey uint64, bucketIndex uint64) ([]byte, bool) {
+	defer c.flushL2()
+	segment := c.segments[bucketIndex]
+	c.lock(bucketIndex)
+	defer c.locks[bucketIndex].Unlock()
+	if entry, err := segment.peek(key, hashKey); err == nil {
+		// a concurrent set stored a newer value meanwhile
+		if value, err := segment.readValue(entry); err == nil {
+			return value, true
+		}
+	}
+	value, expireAt, cost, ok := c.l2.take(key, segment.clock.TimeStamp())
+	if !ok {
+		segment.stats.l2Miss()
+		return nil, false
+	}
+	segment.stats.l2Hit()
+	if _, _, err := segment.store(key, hashKey, value, expireAt, cost); err != nil {
+		return value, true
+	}
+	// promotions are logged so that an AOF rewrite dumping segments and then the
+	// disk tier cannot miss an entry moving back into an already dumped segment
+	if c.aof != nil {
+		if record, err := c.aofSetRecord(key, value, expireAt, cost); err == nil {
+			_ = c.aof.append(record)
+		}
+	}
+	return value, true
 }
 
 func (c *cache) SetWithTime(key string, value []byte, expired time.Duration) error{
+	return c.set(key, value, expired, c.weigh(key, value))
+}
+
+// SetWithCost stores value with an explicit cost, in place of the one given by the weigher.
+// The append only file and the disk tier keep the cost. Entries read back from the mmap
+// files count for the default cost, those loaded from the store are weighed again.
+func (c *cache) SetWithCost(key string, value []byte, expired time.Duration, cost int64) error {
+	if cost < 0 {
+		return ErrCostInvalid
+	}
+	return c.set(key, value, expired, cost)
+}
+
+// weigh returns the cost of an entry set without one
+func (c *cache) weigh(key string, value []byte) int64 {
+	if c.weigher == nil {
+		return defaultCost
+	}
+	if cost := c.weigher(key, value); cost > 0 {
+		return cost
+	}
+	return 0
+}
+
+func (c *cache) set(key string, value []byte, expired time.Duration, cost int64) error {
+	if expired <= 0 {
+		return ErrExpireTimeInvalid
+	}
+	if uint64(len(key)) > c.maxKeySize {
+		return ErrKeyTooLarge
+	}
 	hashKey := c.hashFunc.Sum64(key)
+	if c.store == nil {
+		return c.setLocal(key, hashKey, value, expired, cost)
+	}
+	defer c.lockStore(hashKey)()
+	if c.writeBehind == nil {
+		if err := c.store.Store(key, value); err != nil {
+			return err
+		}
+		return c.setLocal(key, hashKey, value, expired, cost)
+	}
+	if err := c.setLocal(key, hashKey, value, expired, cost); err != nil {
+		return err
+	}
+	return c.writeBehind.put(StoreWrite{Key: key, Value: append([]byte(nil), value...)})
+}
+
+// setLocal stores the entry in memory, without going through the store
+func (c *cache) setLocal(key string, hashKey uint64, value []byte, expired time.Duration, cost int64) error {
 	bucketIndex := hashKey&c.bucketMask
-	c.locks[bucketIndex].Lock()
+	if c.latency != nil {
+		defer c.latency.set.since(bucketIndex, time.Now())
+	}
+	defer c.flushL2()
+	c.lock(bucketIndex)
 	defer c.locks[bucketIndex].Unlock()
-	err := c.segments[bucketIndex].set(key, hashKey, value, expired)
-	return err
+	segment := c.segments[bucketIndex]
+	expireAt := uint64(segment.clock.Epoch(expired))
+	// the mutations are logged before memory changes, so a failed append leaves nothing
+	// behind. A set failing in memory fails the same way when it is replayed.
+	if c.aof != nil {
+		record, err := c.aofSetRecord(key, value, expireAt, cost)
+		if err != nil {
+			return err
+		}
+		if err := c.aof.append(record); err != nil {
+			return err
+		}
+	}
+	if err := segment.setAt(key, hashKey, value, expireAt, cost); err != nil {
+		return err
+	}
+	if c.l2 != nil {
+		c.l2.delete(key)
+	}
+	return nil
 }
 
 func (c *cache) Delete(key string) error{
 	hashKey := c.hashFunc.Sum64(key)
 	bucketIndex := hashKey&c.bucketMask
-	c.locks[bucketIndex].Lock()
+	if c.store != nil {
+		defer c.lockStore(hashKey)()
+		if c.writeBehind == nil {
+			if err := c.store.Delete(key); err != nil {
+				return err
+			}
+		} else if err := c.writeBehind.put(StoreWrite{Key: key, Deleted: true}); err != nil {
+			return err
+		}
+	}
+	if c.latency != nil {
+		defer c.latency.delete.since(bucketIndex, time.Now())
+	}
+	defer c.flushL2()
+	c.lock(bucketIndex)
+	defer c.locks[bucketIndex].Unlock()
+	// replaying the delete of a missing key is harmless
+	if c.aof != nil {
+		if err := c.aof.append(encodeAOFRecord(aofOpDelete, key, nil, 0)); err != nil {
+			return err
+		}
+	}
+	err := c.segments[bucketIndex].delete(hashKey)
+	if c.l2 != nil && c.l2.delete(key) && err == ErrEntryNotFound {
+		err = nil
+	}
+	return err
+}
+
+func (c *cache) Evict(key string) error {
+	hashKey := c.hashFunc.Sum64(key)
+	bucketIndex := hashKey&c.bucketMask
+	defer c.flushL2()
+	c.lock(bucketIndex)
 	defer c.locks[bucketIndex].Unlock()
 	err := c.segments[bucketIndex].delete(hashKey)
+	if c.l2 != nil && c.l2.delete(key) && err == ErrEntryNotFound {
+		err = nil
+	}
 	return err
 }
 
+func (c *cache) Expire(key string, expired time.Duration) error {
+	if expired <= 0 {
+		return ErrExpireTimeInvalid
+	}
+	hashKey := c.hashFunc.Sum64(key)
+	bucketIndex := hashKey&c.bucketMask
+	if c.latency != nil {
+		defer c.latency.expire.since(bucketIndex, time.Now())
+	}
+	c.lock(bucketIndex)
+	defer c.locks[bucketIndex].Unlock()
+	segment := c.segments[bucketIndex]
+	if c.l2 != nil {
+		if _, err := segment.peek(key, hashKey); err == ErrEntryNotFound {
+			// bring the entry back to memory so its new expire time sticks
+			c.locks[bucketIndex].Unlock()
+			c.promote(key, hashKey, bucketIndex)
+			c.lock(bucketIndex)
+		}
+	}
+	expireAt := uint64(segment.clock.Epoch(expired))
+	// replaying the expire of a missing key is harmless
+	if c.aof != nil {
+		if err := c.aof.append(encodeAOFRecord(aofOpExpire, key, nil, expireAt)); err != nil {
+			return err
+		}
+	}
+	return segment.expire(key, hashKey, expireAt)
+}
+
+func (c *cache) TTL(key string) (time.Duration, error) {
+	hashKey := c.hashFunc.Sum64(key)
+	bucketIndex := hashKey&c.bucketMask
+	c.rlock(bucketIndex)
+	segment := c.segments[bucketIndex]
+	ttl, err := segment.ttl(key, hashKey)
+	c.locks[bucketIndex].RUnlock()
+	if err == ErrEntryNotFound && c.l2 != nil {
+		now := segment.clock.TimeStamp()
+		if expireAt, ok := c.l2.expireAt(key, now); ok {
+			return time.Duration(int64(expireAt)-now) * time.Second, nil
+		}
+	}
+	return ttl, err
+}
+
+// dump calls fn with every live entry, holding one segment read lock at a time, followed
+// by the entries of the disk tier
+func (c *cache) dump(fn func(key string, value []byte, expireAt uint64, cost int64) error) error {
+	for index := range c.segments {
+		c.locks[index].RLock()
+		err := c.segments[index].dump(fn)
+		c.locks[index].RUnlock()
+		if err != nil {
+			return err
+		}
+	}
+	if c.l2 != nil {
+		return c.l2.dump(time.Now().Unix(), fn)
+	}
+	return nil
+}
+
+func (c *cache) Snapshot(fn func(key string, value []byte, expireAt time.Time) error) error {
+	return c.dump(func(key string, value []byte, expireAt uint64, cost int64) error {
+		return fn(key, value, time.Unix(int64(expireAt), 0))
+	})
+}
+
+// Clear holds every segment lock while clearing, so no Set lands in between and the
+// append only file records the clear at a single point
+func (c *cache) Clear() error {
+	for index := range c.locks {
+		c.locks[index].Lock()
+	}
+	defer func() {
+		for index := range c.locks {
+			c.locks[index].Unlock()
+		}
+	}()
+	if c.aof != nil {
+		if err := c.aof.append(encodeAOFRecord(aofOpClear, "", nil, 0)); err != nil {
+			return err
+		}
+	}
+	for _, segment := range c.segments {
+		segment.clear()
+	}
+	if c.l2 != nil {
+		return c.l2.clear()
+	}
+	return nil
+}
+
+// Scan cursors hold the segment index in the high 32 bits and the buffer slot in the low 32 bits
+func (c *cache) Scan(cursor uint64, count int) ([]string, uint64) {
+	if count <= 0 {
+		count = defaultScanCount
+	}
+	keys := make([]string, 0, count)
+	bucketIndex, slot := cursor>>32, int(cursor&math.MaxUint32)
+	for ; bucketIndex < c.bucketCount; bucketIndex, slot = bucketIndex+1, 0 {
+		c.locks[bucketIndex].RLock()
+		var next int
+		keys, next = c.segments[bucketIndex].scan(keys, slot, count)
+		c.locks[bucketIndex].RUnlock()
+		if len(keys) >= count && next < c.segments[bucketIndex].capacity() {
+			return keys, bucketIndex<<32 | uint64(next)
+		}
+	}
+	return keys, 0
+}
+
 func (c *cache) Len() int {
 	length := 0
 	for index :=0; index < int(c.bucketCount); index++{
@@ -141,44 +666,221 @@ func (c *cache) Capacity() int {
 
 func (c *cache) Close() error {
 	close(c.close)
-	return nil
+	var err error
+	if c.writeBehind != nil {
+		err = c.writeBehind.closeWriteBehind()
+	}
+	if c.aof != nil {
+		if aofErr := c.aof.closeAOF(); err == nil {
+			err = aofErr
+		}
+	}
+	if c.l2 != nil {
+		if l2Err := c.l2.close(); err == nil {
+			err = l2Err
+		}
+	}
+	// the cleanup goroutine may still be inside a segment, unmapping waits for it
+	for index := range c.segments {
+		c.locks[index].Lock()
+		if segmentErr := closeSegments(c.segments[index:index+1]); err == nil {
+			err = segmentErr
+		}
+		c.locks[index].Unlock()
+	}
+	return err
 }
 
 func (c *cache) cleanup(cleanTime time.Duration)  {
-	ticker := time.NewTicker(cleanTime)
-	defer ticker.Stop()
+	interval := cleanTime
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
 	for {
 		select {
-		case t := <- ticker.C:
-			for index := 0; index < int(c.bucketCount); index++{
-				c.locks[index].Lock()
-				c.segments[index].cleanup(t.Unix())
-				c.locks[index].Unlock()
-			}
+		case t := <- timer.C:
+			ratio := c.activeExpire(t)
+			interval = nextCleanInterval(interval, cleanTime, ratio)
+			timer.Reset(interval)
 		case <- c.close:
 			return
 		}
 	}
 }
 
+// activeExpire runs one bounded cleanup cycle, resuming from the segment the previous
+// cycle stopped at. Every segment pass inspects at most cleanupBatchSize slots under the
+// segment lock, and the cycle stops once cleanupTimeBudget is spent. It returns the ratio
+// of expired entries to checked entries.
+func (c *cache) activeExpire(now time.Time) float64 {
+	deadline := now.Add(c.cleanupTimeBudget)
+	checked, expired := 0, 0
+	for visited := uint64(0); visited < c.bucketCount; visited++ {
+		index := c.cleanupSegment
+		c.cleanupSegment = (index + 1) & c.bucketMask
+		for {
+			c.lock(index)
+			n, e := c.segments[index].cleanup(now.Unix(), c.cleanupBatchSize)
+			c.locks[index].Unlock()
+			checked += n
+			expired += e
+			// like redis active expiry, keep sweeping a segment while it is dense in expired entries
+			if n == 0 || float64(e)/float64(n) <= activeExpireRatio || time.Now().After(deadline) {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+	}
+	if checked == 0 {
+		return 0
+	}
+	return float64(expired) / float64(checked)
+}
+
+// nextCleanInterval halves the interval while cleanup keeps finding many expired entries
+// and backs off towards cleanTime once they become rare.
+func nextCleanInterval(interval, cleanTime time.Duration, ratio float64) time.Duration {
+	if ratio > activeExpireRatio {
+		interval /= 2
+		if floor := cleanTime / minCleanTimeDivisor; interval < floor {
+			interval = floor
+		}
+		return interval
+	}
+	interval *= 2
+	if interval > cleanTime {
+		interval = cleanTime
+	}
+	return interval
+}
+
 func (c *cache) Stats() Stats {
 	s := Stats{}
-	for _, shard := range c.segments {
+	for index, shard := range c.segments {
+		c.locks[index].RLock()
+		s.LiveBytes += int64(shard.size())
+		s.LiveCost += shard.totalCost()
+		c.locks[index].RUnlock()
 		tmp := shard.getStats()
 		s.Hits += tmp.Hits
 		s.Misses += tmp.Misses
 		s.DelHits += tmp.DelHits
 		s.DelMisses += tmp.DelMisses
 		s.Collisions += tmp.Collisions
+		s.Evictions += tmp.Evictions
+		s.Expirations += tmp.Expirations
+		s.ExpiredLazy += tmp.ExpiredLazy
+		s.ExpiredCleanup += tmp.ExpiredCleanup
+		s.Sets += tmp.Sets
+		s.Overwrites += tmp.Overwrites
+		s.BytesIn += tmp.BytesIn
+		s.RawValueBytes += tmp.RawValueBytes
+		s.StoredValueBytes += tmp.StoredValueBytes
+		s.L2Hits += tmp.L2Hits
+		s.L2Misses += tmp.L2Misses
+		s.LockWait += tmp.LockWait
+	}
+	if c.latency != nil {
+		s.Latency = c.latency.snapshot()
 	}
 	return s
 }
 
+func (c *cache) ResetStats() {
+	for _, shard := range c.segments {
+		shard.stats.reset()
+	}
+	if c.latency != nil {
+		c.latency.reset()
+	}
+}
+
+// entryInfo describes a stored entry without copying its value
+type entryInfo struct {
+	ttl time.Duration
+	size int
+}
+
+// inspector is implemented by caches that can look up entries without touching the statistics
+type inspector interface {
+	inspect(key string) (entryInfo, error)
+}
+
+func (c *cache) ShardStats() []ShardStat {
+	res := make([]ShardStat, c.bucketCount)
+	for index := range c.segments {
+		c.locks[index].RLock()
+		res[index] = c.segments[index].getShardStat()
+		c.locks[index].RUnlock()
+	}
+	return res
+}
+
+// lock acquires the write lock of a segment, recording the wait when stats or latency are enabled
+func (c *cache) lock(bucketIndex uint64) {
+	if !c.statsEnabled && c.latency == nil {
+		c.locks[bucketIndex].Lock()
+		return
+	}
+	start := time.Now()
+	c.locks[bucketIndex].Lock()
+	c.recordLockWait(bucketIndex, time.Since(start))
+}
+
+// rlock acquires the read lock of a segment, recording the wait when stats or latency are enabled
+func (c *cache) rlock(bucketIndex uint64) {
+	if !c.statsEnabled && c.latency == nil {
+		c.locks[bucketIndex].RLock()
+		return
+	}
+	start := time.Now()
+	c.locks[bucketIndex].RLock()
+	c.recordLockWait(bucketIndex, time.Since(start))
+}
+
+func (c *cache) recordLockWait(bucketIndex uint64, wait time.Duration) {
+	c.segments[bucketIndex].stats.lockWait(wait)
+	if c.latency != nil {
+		c.latency.lockWait.record(bucketIndex, wait)
+	}
+}
+
+func (c *cache) inspect(key string) (entryInfo, error) {
+	hashKey := c.hashFunc.Sum64(key)
+	bucketIndex := hashKey&c.bucketMask
+	c.rlock(bucketIndex)
+	defer c.locks[bucketIndex].RUnlock()
+	segment := c.segments[bucketIndex]
+	entry, err := segment.peek(key, hashKey)
+	if err != nil {
+		return entryInfo{}, err
+	}
+	ttl, err := segment.ttl(key, hashKey)
+	if err != nil {
+		return entryInfo{}, err
+	}
+	return entryInfo{ttl: ttl, size: readValueSizeFromEntry(entry)}, nil
+}
+
 func (c *cache) GetKeyHit(key string) int64 {
 	hashKey := c.hashFunc.Sum64(key)
 	bucketIndex := hashKey&c.bucketMask
-	c.locks[bucketIndex].Lock()
-	defer c.locks[bucketIndex].Unlock()
-	hit := c.segments[bucketIndex].getKeyHit(key)
+	hit := c.segments[bucketIndex].getKeyHit(hashKey)
 	return hit
+}
+
+func (c *cache) HotKeys(n int) []KeyCount {
+	if n <= 0 {
+		return nil
+	}
+	res := make([]KeyCount, 0, n)
+	for _, shard := range c.segments {
+		res = append(res, shard.getHotKeys()...)
+	}
+	sortKeyCounts(res)
+	if len(res) > n {
+		res = res[:n]
+	}
+	return res
 }
\ No newline at end of file
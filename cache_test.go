@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
+	"sync"
 	"testing"
 	"time"
 )
@@ -143,4 +144,186 @@ func (h *cacheTestSuite) TestStats() {
 	assert.Equal(h.T(), int64(10), stats.Misses)
 	assert.Equal(h.T(), int64(10), stats.DelHits)
 	assert.Equal(h.T(), int64(10), stats.DelMisses)
-}
\ No newline at end of file
+}
+func (h *cacheTestSuite) TestActiveExpire() {
+	c, err := NewCache(SetShardCount(4), SetCleanupBatchSize(8), SetCleanupTimeBudget(time.Second))
+	assert.Equal(h.T(), nil, err)
+
+	value := []byte("公众号：Golang梦工厂")
+	for index := 0; index < 100; index++ {
+		key := fmt.Sprintf("asong%03d", index)
+		err = c.SetWithTime(key, value, time.Second)
+		assert.Equal(h.T(), nil, err)
+	}
+
+	ratio := c.(*cache).activeExpire(time.Now().Add(time.Minute))
+	assert.Equal(h.T(), float64(1), ratio)
+	assert.Equal(h.T(), 0, c.Len())
+
+	_, err = NewCache(SetCleanupTimeBudget(0))
+	assert.Equal(h.T(), ErrCleanupTimeBudget, err)
+	_, err = NewCache(SetCleanupTimeBudget(-time.Second))
+	assert.Equal(h.T(), ErrCleanupTimeBudget, err)
+}
+
+func (h *cacheTestSuite) TestHotKeys() {
+	c, err := NewCache(SetStatsEnabled(true))
+	assert.Equal(h.T(), nil, err)
+
+	value := []byte("公众号：Golang梦工厂")
+	for index := 0; index < 10; index++ {
+		key := fmt.Sprintf("asong%03d", index)
+		err = c.Set(key, value)
+		assert.Equal(h.T(), nil, err)
+	}
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := 0; index < 10; index++ {
+				for i := 0; i <= index; i++ {
+					_, _ = c.Get(fmt.Sprintf("asong%03d", index))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	hot := c.HotKeys(3)
+	assert.Equal(h.T(), 3, len(hot))
+	assert.Equal(h.T(), "asong009", hot[0].Key)
+	assert.Equal(h.T(), "asong008", hot[1].Key)
+	assert.Equal(h.T(), "asong007", hot[2].Key)
+	assert.GreaterOrEqual(h.T(), c.GetKeyHit("asong009"), int64(40))
+}
+
+func (h *cacheTestSuite) TestTTL() {
+	c, err := NewCache()
+	assert.Equal(h.T(), nil, err)
+
+	err = c.SetWithTime("asong", []byte("公众号：Golang梦工厂"), time.Hour)
+	assert.Equal(h.T(), nil, err)
+
+	ttl, err := c.TTL("asong")
+	assert.Equal(h.T(), nil, err)
+	assert.InDelta(h.T(), float64(time.Hour), float64(ttl), float64(2*time.Second))
+
+	_, err = c.TTL("missing")
+	assert.Equal(h.T(), ErrEntryNotFound, err)
+}
+
+func (h *cacheTestSuite) TestStatsCounters() {
+	c, err := NewCache(SetStatsEnabled(true), SetShardCount(1))
+	assert.Equal(h.T(), nil, err)
+
+	value := []byte("公众号：Golang梦工厂")
+	for index := 0; index < 10; index++ {
+		err = c.Set(fmt.Sprintf("asong%03d", index), value)
+		assert.Equal(h.T(), nil, err)
+	}
+	err = c.Set("asong000", value)
+	assert.Equal(h.T(), nil, err)
+	err = c.SetWithTime("lazy", value, time.Second)
+	assert.Equal(h.T(), nil, err)
+	err = c.SetWithTime("cleanup", value, time.Second)
+	assert.Equal(h.T(), nil, err)
+	_, _ = c.Get("asong001")
+	_, _ = c.Get("asong001")
+	_, _ = c.Get("missing")
+
+	time.Sleep(2 * time.Second)
+	_, err = c.Get("lazy")
+	assert.Equal(h.T(), ErrEntryNotFound, err)
+	c.(*cache).activeExpire(time.Now())
+
+	stats := c.Stats()
+	entrySize := int64(entryHeaderSize(len("asong000")) + len("asong000") + len(value))
+	assert.Equal(h.T(), int64(13), stats.Sets)
+	assert.Equal(h.T(), int64(1), stats.Overwrites)
+	assert.Equal(h.T(), int64(1), stats.ExpiredLazy)
+	assert.Equal(h.T(), int64(1), stats.ExpiredCleanup)
+	assert.Equal(h.T(), int64(2), stats.Expirations)
+	assert.Equal(h.T(), 11*entrySize+int64(entryHeaderSize(len("lazy"))+len("lazy")+entryHeaderSize(len("cleanup"))+len("cleanup")+2*len(value)), stats.BytesIn)
+	assert.Equal(h.T(), 10*entrySize, stats.LiveBytes)
+	assert.Equal(h.T(), 0.5, stats.HitRatio())
+
+	_, _ = c.Get("asong002")
+	delta := c.Stats().Delta(stats)
+	assert.Equal(h.T(), int64(1), delta.Hits)
+	assert.Equal(h.T(), int64(0), delta.Sets)
+	assert.Equal(h.T(), 10*entrySize, delta.LiveBytes)
+	assert.Equal(h.T(), float64(1), delta.HitRatio())
+
+	c.ResetStats()
+	stats = c.Stats()
+	assert.Equal(h.T(), int64(0), stats.Hits)
+	assert.Equal(h.T(), int64(0), stats.Sets)
+	assert.Equal(h.T(), 10*entrySize, stats.LiveBytes)
+	assert.Equal(h.T(), 0, len(c.HotKeys(10)))
+	assert.Equal(h.T(), int64(0), c.GetKeyHit("asong001"))
+}
+
+func (h *cacheTestSuite) TestExpire() {
+	c, err := NewCache()
+	assert.Equal(h.T(), nil, err)
+
+	value := []byte("公众号：Golang梦工厂")
+	err = c.SetWithTime("asong", value, time.Second)
+	assert.Equal(h.T(), nil, err)
+	err = c.Expire("asong", time.Hour)
+	assert.Equal(h.T(), nil, err)
+
+	time.Sleep(2 * time.Second)
+	res, err := c.Get("asong")
+	assert.Equal(h.T(), nil, err)
+	assert.Equal(h.T(), value, res)
+
+	assert.Equal(h.T(), ErrEntryNotFound, c.Expire("missing", time.Hour))
+	assert.Equal(h.T(), ErrExpireTimeInvalid, c.Expire("asong", 0))
+}
+
+func (h *cacheTestSuite) TestScan() {
+	c, err := NewCache(SetShardCount(4))
+	assert.Equal(h.T(), nil, err)
+
+	for index := 0; index < 25; index++ {
+		err = c.Set(fmt.Sprintf("asong%d", index), []byte("value"))
+		assert.Equal(h.T(), nil, err)
+	}
+	seen := make(map[string]bool)
+	cursor, calls := uint64(0), 0
+	for {
+		var keys []string
+		keys, cursor = c.Scan(cursor, 4)
+		assert.True(h.T(), len(keys) <= 4)
+		for _, key := range keys {
+			assert.False(h.T(), seen[key])
+			seen[key] = true
+		}
+		calls++
+		if cursor == 0 {
+			break
+		}
+	}
+	assert.Equal(h.T(), 25, len(seen))
+	assert.True(h.T(), calls >= 7)
+}
+
+func (h *cacheTestSuite) TestSnapshot() {
+	c, err := NewCache(SetShardCount(4))
+	assert.Equal(h.T(), nil, err)
+
+	assert.Equal(h.T(), nil, c.SetWithTime("asong", []byte("公众号：Golang梦工厂"), time.Hour))
+	assert.Equal(h.T(), nil, c.SetWithTime("golang", []byte("value"), 2*time.Hour))
+	entries := make(map[string]time.Time)
+	err = c.Snapshot(func(key string, value []byte, expireAt time.Time) error {
+		entries[key] = expireAt
+		return nil
+	})
+	assert.Equal(h.T(), nil, err)
+	assert.Equal(h.T(), 2, len(entries))
+	assert.InDelta(h.T(), float64(time.Hour), float64(time.Until(entries["asong"])), float64(2*time.Second))
+	assert.InDelta(h.T(), float64(2*time.Hour), float64(time.Until(entries["golang"])), float64(2*time.Second))
+}
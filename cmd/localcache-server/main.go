@@ -0,0 +1,96 @@
+// Command localcache-server serves a cache over the redis protocol, and optionally over
+// the memcached text protocol and a REST API.
+//
+//	localcache-server -addr :6379 -memcache-addr :11211 -http-addr :8080 -max-bytes 536870912
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	localcache "github.com/asong2020/go-localcache"
+	"github.com/asong2020/go-localcache/server/memcache"
+	"github.com/asong2020/go-localcache/server/resp"
+	"github.com/asong2020/go-localcache/server/rest"
+)
+
+func main() {
+	addr := flag.String("addr", ":6379", "address the redis protocol is served on")
+	memcacheAddr := flag.String("memcache-addr", "", "address the memcached protocol is served on, empty to disable")
+	httpAddr := flag.String("http-addr", "", "address the REST API is served on, empty to disable")
+	shards := flag.Uint64("shards", 256, "number of cache shards, a power of two")
+	maxBytes := flag.Uint64("max-bytes", 512*1024*1024, "maximum size of the cache in bytes")
+	cleanup := flag.Duration("cleanup", time.Minute, "interval of the expired entries cleanup, 0 to disable")
+	aof := flag.String("aof", "", "append only file logging every write, empty to disable")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "time given to the connections to finish on shutdown")
+	flag.Parse()
+
+	opts := []localcache.Opt{localcache.SetShardCount(*shards), localcache.SetMaxBytes(*maxBytes),
+		localcache.SetStatsEnabled(true)}
+	if *cleanup > 0 {
+		opts = append(opts, localcache.SetCleanupEnabled(true), localcache.SetCleanTime(*cleanup))
+	}
+	if *aof != "" {
+		opts = append(opts, localcache.SetAOF(*aof))
+	}
+	cache, err := localcache.NewCache(opts...)
+	if err != nil {
+		log.Fatalf("localcache-server: %v", err)
+	}
+
+	server := resp.NewServer(cache)
+	errs := make(chan error, 3)
+	go func() {
+		errs <- server.ListenAndServe(*addr)
+	}()
+	log.Printf("localcache-server: serving redis protocol on %s", *addr)
+	var memcacheServer *memcache.Server
+	if *memcacheAddr != "" {
+		memcacheServer = memcache.NewServer(cache)
+		go func() {
+			errs <- memcacheServer.ListenAndServe(*memcacheAddr)
+		}()
+		log.Printf("localcache-server: serving memcached protocol on %s", *memcacheAddr)
+	}
+	var httpServer *http.Server
+	if *httpAddr != "" {
+		httpServer = &http.Server{Addr: *httpAddr, Handler: rest.NewHandler(cache)}
+		go func() {
+			errs <- httpServer.ListenAndServe()
+		}()
+		log.Printf("localcache-server: serving REST API on %s", *httpAddr)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-errs:
+		log.Printf("localcache-server: %v", err)
+	case sig := <-signals:
+		log.Printf("localcache-server: %v received, shutting down", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("localcache-server: shutdown: %v", err)
+		}
+		if memcacheServer != nil {
+			if err := memcacheServer.Shutdown(ctx); err != nil {
+				log.Printf("localcache-server: shutdown: %v", err)
+			}
+		}
+		if httpServer != nil {
+			if err := httpServer.Shutdown(ctx); err != nil {
+				log.Printf("localcache-server: shutdown: %v", err)
+			}
+		}
+		cancel()
+	}
+	if err := cache.Close(); err != nil {
+		log.Fatalf("localcache-server: close: %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package localcache
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sync"
+)
+
+// Compressor compresses the values stored by a cache set up with SetCompression
+type Compressor interface {
+	// ID identifies the codec in the entry header, from 1 to 127. 0 marks a raw value,
+	// 1 to 3 are taken by the flate, gzip and zlib compressors.
+	ID() byte
+	Compress(src []byte) ([]byte, error)
+	Decompress(src []byte) ([]byte, error)
+}
+
+// codec ids of the built in compressors
+const (
+	codecRaw byte = iota
+	CodecFlate
+	CodecGzip
+	CodecZlib
+)
+
+var (
+	// ErrCompressorID is returned by NewCache for a compressor whose id is not within 1 to 127
+	// or already used by a compressor of another type
+	ErrCompressorID = errors.New("compressor id is out of range or taken by another compressor")
+	// errUnknownCodec is returned when an entry was encoded by an unregistered codec, or
+	// sealed while the cache has no key provider
+	errUnknownCodec = errors.New("entry encoded by an unknown codec")
+)
+
+// compressors holds every codec entries may be compressed with, by id. A compressor is
+// registered when a cache using it is created and stays registered, so entries read back
+// from an mmap file can always be decompressed.
+var compressors = struct {
+	sync.RWMutex
+	byID [256]Compressor
+}{}
+
+func init() {
+	for _, level := range []func(int) (Compressor, error){NewFlateCompressor, NewGzipCompressor, NewZlibCompressor} {
+		compressor, _ := level(flate.DefaultCompression)
+		compressors.byID[compressor.ID()] = compressor
+	}
+}
+
+func registerCompressor(compressor Compressor) error {
+	id := compressor.ID()
+	if id == codecRaw || id&flagSealed != 0 {
+		return ErrCompressorID
+	}
+	compressors.Lock()
+	defer compressors.Unlock()
+	if registered := compressors.byID[id]; registered != nil {
+		if reflect.TypeOf(registered) != reflect.TypeOf(compressor) {
+			return ErrCompressorID
+		}
+		return nil
+	}
+	compressors.byID[id] = compressor
+	return nil
+}
+
+// compression decides how the values of a cache are stored
+type compression struct {
+	compressor Compressor
+	// threshold is the size below which values are stored raw
+	threshold int
+}
+
+func newCompression(compressor Compressor, threshold int) *compression {
+	return &compression{compressor: compressor, threshold: threshold}
+}
+
+// encode returns the codec and the payload to store for value. Values below the threshold,
+// and values that do not shrink, are stored raw.
+func (c *compression) encode(value []byte) (byte, []byte) {
+	if c == nil || len(value) < c.threshold {
+		return codecRaw, value
+	}
+	compressed, err := c.compressor.Compress(value)
+	if err != nil || len(compressed) >= len(value) {
+		return codecRaw, value
+	}
+	return c.compressor.ID(), compressed
+}
+
+// decodeValue returns the value stored as payload with codec
+func decodeValue(codec byte, payload []byte) ([]byte, error) {
+	if codec == codecRaw {
+		return payload, nil
+	}
+	compressors.RLock()
+	compressor := compressors.byID[codec]
+	compressors.RUnlock()
+	if compressor == nil {
+		return nil, errUnknownCodec
+	}
+	return compressor.Decompress(payload)
+}
+
+// streamWriter is the writer side of the stdlib compression formats
+type streamWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// streamCompressor adapts a stdlib compression format. Writers allocate large tables,
+// so they are pooled.
+type streamCompressor struct {
+	id        byte
+	level     int
+	newWriter func(w io.Writer, level int) (streamWriter, error)
+	newReader func(r io.Reader) (io.ReadCloser, error)
+	writers   sync.Pool
+}
+
+func newStreamCompressor(id byte, level int, newWriter func(w io.Writer, level int) (streamWriter, error),
+	newReader func(r io.Reader) (io.ReadCloser, error)) (Compressor, error) {
+	if _, err := newWriter(ioutil.Discard, level); err != nil {
+		return nil, err
+	}
+	return &streamCompressor{id: id, level: level, newWriter: newWriter, newReader: newReader}, nil
+}
+
+// NewFlateCompressor compresses with raw deflate at level, from flate.HuffmanOnly to
+// flate.BestCompression
+func NewFlateCompressor(level int) (Compressor, error) {
+	return newStreamCompressor(CodecFlate, level, func(w io.Writer, level int) (streamWriter, error) {
+		return flate.NewWriter(w, level)
+	}, func(r io.Reader) (io.ReadCloser, error) {
+		return flate.NewReader(r), nil
+	})
+}
+
+// NewGzipCompressor compresses with gzip at level
+func NewGzipCompressor(level int) (Compressor, error) {
+	return newStreamCompressor(CodecGzip, level, func(w io.Writer, level int) (streamWriter, error) {
+		return gzip.NewWriterLevel(w, level)
+	}, func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	})
+}
+
+// NewZlibCompressor compresses with zlib at level
+func NewZlibCompressor(level int) (Compressor, error) {
+	return newStreamCompressor(CodecZlib, level, func(w io.Writer, level int) (streamWriter, error) {
+		return zlib.NewWriterLevel(w, level)
+	}, func(r io.Reader) (io.ReadCloser, error) {
+		return zlib.NewReader(r)
+	})
+}
+
+func (s *streamCompressor) ID() byte {
+	return s.id
+}
+
+func (s *streamCompressor) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, ok := s.writers.Get().(streamWriter)
+	if ok {
+		w.Reset(&buf)
+	} else {
+		var err error
+		if w, err = s.newWriter(&buf, s.level); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	s.writers.Put(w)
+	return buf.Bytes(), nil
+}
+
+func (s *streamCompressor) Decompress(src []byte) ([]byte, error) {
+	r, err := s.newReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
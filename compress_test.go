@@ -0,0 +1,167 @@
+package localcache
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+// runLengthCompressor is a custom codec counting its compressions
+type runLengthCompressor struct {
+	compressed int
+}
+
+func (r *runLengthCompressor) ID() byte {
+	return 100
+}
+
+func (r *runLengthCompressor) Compress(src []byte) ([]byte, error) {
+	r.compressed++
+	var dst []byte
+	for i := 0; i < len(src); {
+		j := i
+		for j < len(src) && src[j] == src[i] && j-i < 255 {
+			j++
+		}
+		dst = append(dst, byte(j-i), src[i])
+		i = j
+	}
+	return dst, nil
+}
+
+func (r *runLengthCompressor) Decompress(src []byte) ([]byte, error) {
+	if len(src)%2 != 0 {
+		return nil, errors.New("odd run length encoding")
+	}
+	var dst []byte
+	for i := 0; i < len(src); i += 2 {
+		dst = append(dst, bytes.Repeat([]byte{src[i+1]}, int(src[i]))...)
+	}
+	return dst, nil
+}
+
+// conflictingCompressor claims the id of the gzip codec
+type conflictingCompressor struct {
+	runLengthCompressor
+}
+
+func (c *conflictingCompressor) ID() byte {
+	return CodecGzip
+}
+
+type compressTestSuite struct {
+	suite.Suite
+}
+
+func TestCompressTestSuite(t *testing.T) {
+	suite.Run(t, new(compressTestSuite))
+}
+
+func (h *compressTestSuite) SetupSuite() {}
+
+func jsonBlob(size int) []byte {
+	blob := bytes.Repeat([]byte(`{"id":1234,"name":"asong","tags":["cache","local"]},`), size/52+1)
+	return blob[:size]
+}
+
+func (h *compressTestSuite) TestCodecs() {
+	value := jsonBlob(4096)
+	for _, newCompressor := range []func(int) (Compressor, error){NewFlateCompressor, NewGzipCompressor, NewZlibCompressor} {
+		compressor, err := newCompressor(flate.BestSpeed)
+		assert.Equal(h.T(), nil, err)
+		c, err := NewCache(SetShardCount(4), SetCompression(compressor, 64))
+		assert.Equal(h.T(), nil, err)
+
+		assert.Equal(h.T(), nil, c.Set("asong", value))
+		res, err := c.Get("asong")
+		assert.Equal(h.T(), nil, err)
+		assert.Equal(h.T(), value, res)
+
+		assert.Equal(h.T(), compressor.ID(), h.codecOf(c, "asong"))
+		info, err := c.(inspector).inspect("asong")
+		assert.Equal(h.T(), nil, err)
+		assert.Less(h.T(), info.size, len(value)/5)
+		assert.Equal(h.T(), nil, c.Close())
+	}
+
+	_, err := NewFlateCompressor(42)
+	assert.NotEqual(h.T(), nil, err)
+}
+
+func (h *compressTestSuite) codecOf(c ICache, key string) byte {
+	cache := c.(*cache)
+	hashKey := cache.hashFunc.Sum64(key)
+	entry, err := cache.segments[hashKey&cache.bucketMask].peek(key, hashKey)
+	assert.Equal(h.T(), nil, err)
+	return readCodecFromEntry(entry)
+}
+
+func (h *compressTestSuite) TestThresholdAndIncompressible() {
+	compressor, _ := NewGzipCompressor(flate.DefaultCompression)
+	c, err := NewCache(SetShardCount(4), SetCompression(compressor, 256))
+	assert.Equal(h.T(), nil, err)
+	defer c.Close()
+
+	small := jsonBlob(255)
+	random := make([]byte, 4096)
+	_, _ = rand.Read(random)
+	assert.Equal(h.T(), nil, c.Set("small", small))
+	assert.Equal(h.T(), nil, c.Set("random", random))
+	assert.Equal(h.T(), nil, c.Set("large", jsonBlob(256)))
+	assert.Equal(h.T(), codecRaw, h.codecOf(c, "small"))
+	assert.Equal(h.T(), codecRaw, h.codecOf(c, "random"))
+	assert.Equal(h.T(), CodecGzip, h.codecOf(c, "large"))
+
+	for key, value := range map[string][]byte{"small": small, "random": random, "large": jsonBlob(256)} {
+		res, err := c.Get(key)
+		assert.Equal(h.T(), nil, err)
+		assert.Equal(h.T(), value, res)
+	}
+}
+
+func (h *compressTestSuite) TestCustomCompressor() {
+	compressor := &runLengthCompressor{}
+	c, err := NewCache(SetShardCount(4), SetCompression(compressor, 1))
+	assert.Equal(h.T(), nil, err)
+	defer c.Close()
+
+	value := bytes.Repeat([]byte("a"), 1000)
+	assert.Equal(h.T(), nil, c.Set("asong", value))
+	assert.Equal(h.T(), 1, compressor.compressed)
+	assert.Equal(h.T(), byte(100), h.codecOf(c, "asong"))
+	res, err := c.Get("asong")
+	assert.Equal(h.T(), nil, err)
+	assert.Equal(h.T(), value, res)
+
+	// the expire timestamp is patched without compressing the value again
+	assert.Equal(h.T(), nil, c.Expire("asong", time.Hour))
+	assert.Equal(h.T(), 1, compressor.compressed)
+	res, err = c.Get("asong")
+	assert.Equal(h.T(), nil, err)
+	assert.Equal(h.T(), value, res)
+
+	_, err = NewCache(SetCompression(&conflictingCompressor{}, 1))
+	assert.Equal(h.T(), ErrCompressorID, err)
+}
+
+func (h *compressTestSuite) TestStats() {
+	compressor, _ := NewZlibCompressor(flate.DefaultCompression)
+	c, err := NewCache(SetShardCount(4), SetStatsEnabled(true), SetCompression(compressor, 64))
+	assert.Equal(h.T(), nil, err)
+	defer c.Close()
+
+	assert.Equal(h.T(), nil, c.Set("raw", []byte("value")))
+	assert.Equal(h.T(), nil, c.Set("compressed", jsonBlob(8192)))
+	stats := c.Stats()
+	assert.Equal(h.T(), int64(5+8192), stats.RawValueBytes)
+	assert.Less(h.T(), stats.StoredValueBytes, int64(8192/5))
+	assert.Greater(h.T(), stats.CompressionRatio(), 5.0)
+
+	c.ResetStats()
+	assert.Equal(h.T(), int64(0), c.Stats().RawValueBytes)
+}
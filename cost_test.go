@@ -0,0 +1,187 @@
+package localcache
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type costTestSuite struct {
+	suite.Suite
+}
+
+func TestCostTestSuite(t *testing.T) {
+	suite.Run(t, new(costTestSuite))
+}
+
+func (c *costTestSuite) SetupSuite() {}
+
+func (c *costTestSuite) TestMaxCost() {
+	cache, err := NewCache(SetShardCount(1), SetMaxCost(10), SetStatsEnabled(true))
+	assert.Equal(c.T(), nil, err)
+	defer cache.Close()
+
+	for index := 0; index < 4; index++ {
+		err := cache.SetWithCost(fmt.Sprintf("asong%d", index), []byte("value"), time.Minute, 3)
+		assert.Equal(c.T(), nil, err)
+	}
+	assert.Equal(c.T(), 3, cache.Len())
+	stats := cache.Stats()
+	assert.Equal(c.T(), int64(9), stats.LiveCost)
+	assert.Equal(c.T(), int64(1), stats.Evictions)
+	assert.Equal(c.T(), int64(9), stats.Delta(stats).LiveCost)
+	_, err = cache.Get("asong0")
+	assert.Equal(c.T(), ErrEntryNotFound, err)
+
+	// overwriting releases the cost of the previous entry
+	assert.Equal(c.T(), nil, cache.SetWithCost("asong1", []byte("value"), time.Minute, 4))
+	assert.Equal(c.T(), int64(10), cache.Stats().LiveCost)
+	assert.Equal(c.T(), nil, cache.Delete("asong1"))
+	assert.Equal(c.T(), int64(6), cache.Stats().LiveCost)
+	// entries set without a cost count for one
+	assert.Equal(c.T(), nil, cache.Set("asong", []byte("value")))
+	assert.Equal(c.T(), int64(7), cache.Stats().LiveCost)
+
+	assert.Equal(c.T(), ErrEntryTooLarge, cache.SetWithCost("asong", []byte("value"), time.Minute, 11))
+	assert.Equal(c.T(), ErrCostInvalid, cache.SetWithCost("asong", []byte("value"), time.Minute, -1))
+	assert.Equal(c.T(), nil, cache.SetWithCost("free", []byte("value"), time.Minute, 0))
+
+	assert.Equal(c.T(), nil, cache.Clear())
+	assert.Equal(c.T(), int64(0), cache.Stats().LiveCost)
+
+	_, err = NewCache(SetMaxCost(-1))
+	assert.Equal(c.T(), ErrCostInvalid, err)
+}
+
+func (c *costTestSuite) TestEvictsCheapLargeEntriesFirst() {
+	cache, err := NewCache(SetShardCount(1), SetMaxCost(9))
+	assert.Equal(c.T(), nil, err)
+	defer cache.Close()
+
+	assert.Equal(c.T(), nil, cache.SetWithCost("costly", bytes.Repeat([]byte("a"), 1024), time.Minute, 5))
+	assert.Equal(c.T(), nil, cache.SetWithCost("cheap", bytes.Repeat([]byte("b"), 16*1024), time.Minute, 1))
+	assert.Equal(c.T(), nil, cache.SetWithCost("asong", []byte("value"), time.Minute, 2))
+	assert.Equal(c.T(), nil, cache.SetWithCost("song", []byte("value"), time.Minute, 2))
+
+	// costly is the least recently stored, but cheap holds the fewest cost per byte
+	_, err = cache.Get("cheap")
+	assert.Equal(c.T(), ErrEntryNotFound, err)
+	for _, key := range []string{"costly", "asong", "song"} {
+		_, err := cache.Get(key)
+		assert.Equal(c.T(), nil, err, key)
+	}
+}
+
+func (c *costTestSuite) TestEvictsBySlotsWithCosts() {
+	cache, err := NewCache(SetShardCount(1), SetMaxBytes(2*segmentSize))
+	assert.Equal(c.T(), nil, err)
+	defer cache.Close()
+
+	assert.Equal(c.T(), nil, cache.SetWithCost("costly", []byte("value"), time.Minute, 100))
+	assert.Equal(c.T(), nil, cache.Set("cheap", []byte("value")))
+	assert.Equal(c.T(), nil, cache.Set("asong", []byte("value")))
+	assert.Equal(c.T(), 2, cache.Len())
+	_, err = cache.Get("costly")
+	assert.Equal(c.T(), nil, err)
+	_, err = cache.Get("cheap")
+	assert.Equal(c.T(), ErrEntryNotFound, err)
+}
+
+func (c *costTestSuite) TestWeigher() {
+	path := filepath.Join(c.T().TempDir(), "cache.aof")
+	weigher := func(key string, value []byte) int64 {
+		if key == "negative" {
+			return -1
+		}
+		return int64(len(value))
+	}
+	cache, err := NewCache(SetShardCount(1), SetMaxCost(100), SetWeigher(weigher), SetAOF(path))
+	assert.Equal(c.T(), nil, err)
+
+	assert.Equal(c.T(), nil, cache.Set("asong", bytes.Repeat([]byte("a"), 60)))
+	assert.Equal(c.T(), nil, cache.SetWithTime("song", bytes.Repeat([]byte("b"), 30), time.Minute))
+	assert.Equal(c.T(), nil, cache.Set("negative", []byte("value")))
+	assert.Equal(c.T(), int64(90), cache.Stats().LiveCost)
+	// an explicit cost wins over the weigher
+	assert.Equal(c.T(), nil, cache.SetWithCost("song", bytes.Repeat([]byte("b"), 30), time.Minute, 10))
+	assert.Equal(c.T(), int64(70), cache.Stats().LiveCost)
+	// the free entry and then song, the cheapest per byte, make room
+	assert.Equal(c.T(), nil, cache.Set("golang", bytes.Repeat([]byte("c"), 40)))
+	assert.Equal(c.T(), 2, cache.Len())
+	_, err = cache.Get("song")
+	assert.Equal(c.T(), ErrEntryNotFound, err)
+	_, err = cache.Get("asong")
+	assert.Equal(c.T(), nil, err)
+	assert.Equal(c.T(), int64(100), cache.Stats().LiveCost)
+	assert.Equal(c.T(), nil, cache.SetWithCost("asong", bytes.Repeat([]byte("a"), 60), time.Minute, 5))
+	assert.Equal(c.T(), int64(45), cache.Stats().LiveCost)
+	assert.Equal(c.T(), nil, cache.Close())
+
+	// costs are logged, an explicit one survives the replay
+	cache, err = NewCache(SetShardCount(1), SetMaxCost(100), SetWeigher(weigher), SetAOF(path))
+	assert.Equal(c.T(), nil, err)
+	assert.Equal(c.T(), 2, cache.Len())
+	assert.Equal(c.T(), int64(45), cache.Stats().LiveCost)
+	assert.Equal(c.T(), nil, cache.RewriteAOF())
+	assert.Equal(c.T(), nil, cache.Close())
+
+	cache, err = NewCache(SetShardCount(1), SetMaxCost(100), SetWeigher(weigher), SetAOF(path))
+	assert.Equal(c.T(), nil, err)
+	defer cache.Close()
+	assert.Equal(c.T(), 2, cache.Len())
+	assert.Equal(c.T(), int64(45), cache.Stats().LiveCost)
+}
+
+func (c *costTestSuite) TestReplayRecordWithoutCost() {
+	path := filepath.Join(c.T().TempDir(), "cache.aof")
+	// a set logged before the records carried costs is weighed again
+	record := encodeAOFRecord(aofOpSet, "asong", []byte("value"), uint64(time.Now().Add(time.Hour).Unix()))
+	assert.Equal(c.T(), nil, ioutil.WriteFile(path, record, 0644))
+	weigher := func(key string, value []byte) int64 {
+		return int64(len(value))
+	}
+	cache, err := NewCache(SetShardCount(1), SetWeigher(weigher), SetAOF(path))
+	assert.Equal(c.T(), nil, err)
+	defer cache.Close()
+	assert.Equal(c.T(), 1, cache.Len())
+	assert.Equal(c.T(), int64(5), cache.Stats().LiveCost)
+}
+
+func (c *costTestSuite) TestL2KeepsCost() {
+	local, err := NewCache(SetShardCount(1), SetMaxCost(10), SetL2(c.T().TempDir(), 0))
+	assert.Equal(c.T(), nil, err)
+	defer local.Close()
+
+	assert.Equal(c.T(), nil, local.SetWithCost("asong", []byte("value"), time.Hour, 7))
+	assert.Equal(c.T(), nil, local.SetWithCost("song", []byte("value"), time.Hour, 5))
+	assert.Equal(c.T(), int64(5), local.Stats().LiveCost)
+	// asong comes back from the disk tier with its cost and demotes song in turn
+	res, err := local.Get("asong")
+	assert.Equal(c.T(), nil, err)
+	assert.Equal(c.T(), []byte("value"), res)
+	assert.Equal(c.T(), int64(7), local.Stats().LiveCost)
+	segment := local.(*cache).segments[0]
+	assert.Equal(c.T(), int64(7), segment.costOf(local.(*cache).hashFunc.Sum64("asong")))
+}
+
+func (c *costTestSuite) TestObjectCache() {
+	cache, err := NewObjectCache(SetShardCount(1), SetMaxCost(10))
+	assert.Equal(c.T(), nil, err)
+	defer cache.Close()
+
+	assert.Equal(c.T(), nil, cache.SetWithCost("asong", &user{name: "asong"}, time.Minute, 8))
+	assert.Equal(c.T(), nil, cache.Set("song", &user{name: "song"}))
+	assert.Equal(c.T(), nil, cache.SetWithCost("golang", &user{name: "golang"}, time.Minute, 2))
+	_, err = cache.Get("song")
+	assert.Equal(c.T(), ErrEntryNotFound, err)
+	assert.Equal(c.T(), int64(10), cache.Stats().LiveCost)
+	assert.Equal(c.T(), ErrCostInvalid, cache.SetWithCost("asong", 1, time.Minute, -1))
+
+	_, err = NewObjectCache(SetWeigher(func(key string, value []byte) int64 { return 1 }))
+	assert.Equal(c.T(), ErrObjectOption, err)
+}
@@ -0,0 +1,176 @@
+package localcache
+
+import (
+	"encoding/json"
+	"expvar"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+const (
+	// debugPathPrefix is the path the debug handlers are registered under
+	debugPathPrefix = "/debug/localcache/"
+	// debugHotKeys is the number of hot keys shown by the inspector
+	debugHotKeys = 20
+)
+
+// DebugInfo is the snapshot rendered by the debug handler and published to expvar
+type DebugInfo struct {
+	Name     string       `json:"name"`
+	Entries  int          `json:"entries"`
+	Capacity int          `json:"capacity"`
+	Stats    Stats        `json:"stats"`
+	Shards   []DebugShard `json:"shards,omitempty"`
+	Skew     float64      `json:"skew"`
+	HotKeys  []KeyCount   `json:"hot_keys"`
+	Lookup   *DebugLookup `json:"lookup,omitempty"`
+}
+
+// DebugShard describes the occupancy of a single segment
+type DebugShard struct {
+	Index    int `json:"index"`
+	Len      int `json:"len"`
+	Bytes    int `json:"bytes"`
+	Capacity int `json:"capacity"`
+	Free     int `json:"free"`
+}
+
+// DebugLookup is the result of looking up a single key
+type DebugLookup struct {
+	Key   string `json:"key"`
+	Found bool   `json:"found"`
+	TTL   string `json:"ttl,omitempty"`
+	Size  int    `json:"size"`
+}
+
+func newDebugInfo(name string, cache ICache) DebugInfo {
+	info := DebugInfo{
+		Name:     name,
+		Entries:  cache.Len(),
+		Capacity: cache.Capacity(),
+		Stats:    cache.Stats(),
+		HotKeys:  cache.HotKeys(debugHotKeys),
+	}
+	shards := cache.ShardStats()
+	for index, shard := range shards {
+		info.Shards = append(info.Shards, DebugShard{
+			Index:    index,
+			Len:      shard.Entries,
+			Bytes:    shard.Bytes,
+			Capacity: shard.Capacity,
+			Free:     shard.Free,
+		})
+	}
+	info.Skew = ShardSkew(shards)
+	return info
+}
+
+func lookupDebugKey(cache ICache, key string) *DebugLookup {
+	lookup := &DebugLookup{Key: key}
+	if inspector, ok := cache.(inspector); ok {
+		info, err := inspector.inspect(key)
+		if err != nil {
+			return lookup
+		}
+		lookup.Found = true
+		lookup.TTL = info.ttl.String()
+		lookup.Size = info.size
+		return lookup
+	}
+	ttl, err := cache.TTL(key)
+	if err != nil {
+		return lookup
+	}
+	lookup.Found = true
+	lookup.TTL = ttl.String()
+	return lookup
+}
+
+// RegisterDebugHandler registers an inspector page for cache on mux at /debug/localcache/{name}.
+// The page is rendered as HTML, or as JSON when requested with ?format=json or an
+// Accept: application/json header. A key can be looked up with ?key=.
+func RegisterDebugHandler(mux *http.ServeMux, name string, cache ICache) {
+	mux.Handle(debugPathPrefix+name, &debugHandler{name: name, cache: cache})
+}
+
+// PublishExpvar publishes the same snapshot as the debug handler as the expvar variable
+// localcache.{name}. Like expvar.Publish it panics if the name is already registered.
+func PublishExpvar(name string, cache ICache) {
+	expvar.Publish("localcache."+name, expvar.Func(func() interface{} {
+		return newDebugInfo(name, cache)
+	}))
+}
+
+type debugHandler struct {
+	name  string
+	cache ICache
+}
+
+func (h *debugHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	info := newDebugInfo(h.name, h.cache)
+	if key := r.URL.Query().Get("key"); key != "" {
+		info.Lookup = lookupDebugKey(h.cache, key)
+	}
+
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(info)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := debugTemplate.Execute(w, info); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+var debugTemplate = template.Must(template.New("debug").Parse(`<!DOCTYPE html>
+<html>
+<head><title>localcache: {{.Name}}</title></head>
+<body>
+<h1>localcache: {{.Name}}</h1>
+<p>{{.Entries}} entries, capacity {{.Capacity}}</p>
+
+<h2>Lookup</h2>
+<form method="get">
+<input type="text" name="key" value="{{with .Lookup}}{{.Key}}{{end}}">
+<input type="submit" value="Lookup">
+</form>
+{{with .Lookup}}{{if .Found}}<p><b>{{.Key}}</b>: ttl {{.TTL}}, size {{.Size}} bytes</p>{{else}}<p><b>{{.Key}}</b>: not found</p>{{end}}{{end}}
+
+<h2>Stats</h2>
+<table>
+<tr><td>hits</td><td>{{.Stats.Hits}}</td></tr>
+<tr><td>misses</td><td>{{.Stats.Misses}}</td></tr>
+<tr><td>delete hits</td><td>{{.Stats.DelHits}}</td></tr>
+<tr><td>delete misses</td><td>{{.Stats.DelMisses}}</td></tr>
+<tr><td>collisions</td><td>{{.Stats.Collisions}}</td></tr>
+<tr><td>evictions</td><td>{{.Stats.Evictions}}</td></tr>
+<tr><td>hit ratio</td><td>{{printf "%.4f" .Stats.HitRatio}}</td></tr>
+<tr><td>expirations</td><td>{{.Stats.Expirations}} ({{.Stats.ExpiredLazy}} lazy, {{.Stats.ExpiredCleanup}} cleanup)</td></tr>
+<tr><td>sets</td><td>{{.Stats.Sets}}</td></tr>
+<tr><td>overwrites</td><td>{{.Stats.Overwrites}}</td></tr>
+<tr><td>bytes in</td><td>{{.Stats.BytesIn}}</td></tr>
+<tr><td>value bytes</td><td>{{.Stats.StoredValueBytes}} stored of {{.Stats.RawValueBytes}} written</td></tr>
+<tr><td>live bytes</td><td>{{.Stats.LiveBytes}}</td></tr>
+<tr><td>live cost</td><td>{{.Stats.LiveCost}}</td></tr>
+<tr><td>l2 hits</td><td>{{.Stats.L2Hits}}</td></tr>
+<tr><td>l2 misses</td><td>{{.Stats.L2Misses}}</td></tr>
+<tr><td>lock wait</td><td>{{.Stats.LockWait}}</td></tr>
+</table>
+
+<h2>Hot keys</h2>
+<table>
+<tr><th>key</th><th>hits</th></tr>
+{{range .HotKeys}}<tr><td>{{.Key}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+
+<h2>Shards</h2>
+<p>skew (max/mean entries): {{printf "%.2f" .Skew}}</p>
+<table>
+<tr><th>shard</th><th>len</th><th>bytes</th><th>capacity</th><th>free</th></tr>
+{{range .Shards}}<tr><td>{{.Index}}</td><td>{{.Len}}</td><td>{{.Bytes}}</td><td>{{.Capacity}}</td><td>{{.Free}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
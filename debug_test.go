@@ -0,0 +1,88 @@
+package localcache
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type debugTestSuite struct {
+	suite.Suite
+}
+
+func TestDebugTestSuite(t *testing.T) {
+	suite.Run(t, new(debugTestSuite))
+}
+
+func (d *debugTestSuite) SetupSuite() {}
+
+func (d *debugTestSuite) newCache() ICache {
+	c, err := NewCache(SetStatsEnabled(true), SetShardCount(2))
+	assert.Equal(d.T(), nil, err)
+	err = c.SetWithTime("asong", []byte("公众号：Golang梦工厂"), time.Hour)
+	assert.Equal(d.T(), nil, err)
+	_, err = c.Get("asong")
+	assert.Equal(d.T(), nil, err)
+	return c
+}
+
+func (d *debugTestSuite) get(url string) (*http.Response, string) {
+	resp, err := http.Get(url)
+	assert.Equal(d.T(), nil, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.Equal(d.T(), nil, err)
+	return resp, string(body)
+}
+
+func (d *debugTestSuite) TestJSON() {
+	mux := http.NewServeMux()
+	RegisterDebugHandler(mux, "users", d.newCache())
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, body := d.get(server.URL + "/debug/localcache/users?format=json&key=asong")
+	assert.Equal(d.T(), "application/json", resp.Header.Get("Content-Type"))
+
+	info := DebugInfo{}
+	err := json.Unmarshal([]byte(body), &info)
+	assert.Equal(d.T(), nil, err)
+	assert.Equal(d.T(), "users", info.Name)
+	assert.Equal(d.T(), 1, info.Entries)
+	assert.Equal(d.T(), int64(1), info.Stats.Hits)
+	assert.Equal(d.T(), 2, len(info.Shards))
+	assert.Equal(d.T(), info.Shards[0].Capacity+info.Shards[1].Capacity-1, info.Shards[0].Free+info.Shards[1].Free)
+	assert.Equal(d.T(), []KeyCount{{Key: "asong", Count: 1}}, info.HotKeys)
+	assert.Equal(d.T(), true, info.Lookup.Found)
+	assert.Equal(d.T(), len("公众号：Golang梦工厂"), info.Lookup.Size)
+}
+
+func (d *debugTestSuite) TestHTML() {
+	mux := http.NewServeMux()
+	RegisterDebugHandler(mux, "users", d.newCache())
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, body := d.get(server.URL + "/debug/localcache/users?key=missing")
+	assert.Equal(d.T(), "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Contains(d.T(), body, "<h1>localcache: users</h1>")
+	assert.Contains(d.T(), body, "<b>missing</b>: not found")
+}
+
+func (d *debugTestSuite) TestPublishExpvar() {
+	// expvar names can only be published once, so every run needs its own
+	name := fmt.Sprintf("debug-test-%d", time.Now().UnixNano())
+	PublishExpvar(name, d.newCache())
+	info := DebugInfo{}
+	err := json.Unmarshal([]byte(expvar.Get("localcache."+name).String()), &info)
+	assert.Equal(d.T(), nil, err)
+	assert.Equal(d.T(), name, info.Name)
+	assert.Equal(d.T(), 1, info.Entries)
+}
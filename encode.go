@@ -2,56 +2,288 @@ package localcache
 
 import (
 	"encoding/binary"
+	"errors"
+	"math"
 	"unsafe"
 )
 
 const (
-	timestampSizeInBytes = 8                                                       // Number of bytes used for timestamp
-	hashSizeInBytes      = 8                                                       // Number of bytes used for hash
-	keySizeInBytes       = 2                                                       // Number of bytes used for size of entry key
-	headersSizeInBytes   = timestampSizeInBytes + hashSizeInBytes + keySizeInBytes                // Number of bytes used for all headers
+	kindSizeInBytes      = 1 // Number of bytes used for the kind of slot
+	timestampSizeInBytes = 8 // Number of bytes used for timestamp
+	hashSizeInBytes      = 8 // Number of bytes used for hash
+	codecSizeInBytes     = 1 // Number of bytes used for the codec of the value
+	chunkIndexSizeInBytes = 4 // Number of bytes used for the buffer index of a chunk
+	fixedHeadersSizeInBytes = kindSizeInBytes + timestampSizeInBytes + hashSizeInBytes + codecSizeInBytes // Number of bytes used for the headers before the key length
+
+	// maxSlotSize is the largest slot an entry or a chunk takes, it leaves room for the
+	// slot header of mmap buffers
+	maxSlotSize = segmentSize - 16
+	// chunkDataSize is the part of a chunked value held by a chunk slot
+	chunkDataSize = maxSlotSize - kindSizeInBytes
+	// maxChunkedKeySize is the longest key of an entry whose value can be chunked, the
+	// rest of the slot is left for the chunk list
+	maxChunkedKeySize = maxSlotSize / 2
+	// maxKeySize is the largest key the entry format holds
+	maxKeySize = math.MaxUint32
+)
+
+// kinds of buffer slots
+const (
+	// kindValue is an entry holding its value
+	kindValue byte = iota + 1
+	// kindChunked is an entry whose value is split across chunk slots. Its value is
+	// uvarint(len(value)) followed by the buffer index of every chunk.
+	kindChunked
+	// kindChunk is a slot holding a part of a chunked value
+	kindChunk
+	// kindObject is an entry whose value is an object held by the segment. Its value is
+	// uvarint(size of the object).
+	kindObject
+)
+
+var (
+	// ErrKeyTooLarge is returned for a key longer than the entry format or the buffer allows
+	ErrKeyTooLarge = errors.New("key too large")
+	// errChunkMissing is returned when a chunk of a value cannot be found
+	errChunkMissing = errors.New("chunk of a value missing")
 )
 
+// flagSealed marks in the codec byte a value sealed by a sealer
+const flagSealed byte = 0x80
+
+// valueCodec compresses and seals the values of a cache, a nil codec stores them raw
+type valueCodec struct {
+	compression *compression
+	sealer      *sealer
+}
+
+// encode returns the codec byte and the payload to store for the value of key.
+// Values are compressed first, ciphertext does not compress.
+func (v *valueCodec) encode(key string, value []byte) (byte, []byte, error) {
+	if v == nil {
+		return codecRaw, value, nil
+	}
+	codec, payload := v.compression.encode(value)
+	if v.sealer == nil {
+		return codec, payload, nil
+	}
+	sealed, err := v.sealer.seal(key, payload)
+	if err != nil {
+		return 0, nil, err
+	}
+	return codec | flagSealed, sealed, nil
+}
+
+// decode returns the value of key stored as payload with codec
+func (v *valueCodec) decode(key string, codec byte, payload []byte) ([]byte, error) {
+	if codec&flagSealed != 0 {
+		if v == nil || v.sealer == nil {
+			return nil, errUnknownCodec
+		}
+		opened, err := v.sealer.open(key, payload)
+		if err != nil {
+			return nil, err
+		}
+		return decodeValue(codec&^flagSealed, opened)
+	}
+	if codec == codecRaw {
+		dst := make([]byte, len(payload))
+		copy(dst, payload)
+		return dst, nil
+	}
+	return decodeValue(codec, payload)
+}
+
+// wrapEntry renders an entry as kind | expireAt | hash | codec | uvarint(keyLen) | key | value.
+// The value is encoded by codec, nil stores it raw. With chunked, a value that does not
+// fit a single slot is returned as chunks, kind | data each, whose buffer indexes must be
+// set in the entry with setChunkIndex once they are pushed. Entries with a key longer than
+// maxChunkedKeySize are never chunked, they do not fit a slot anyway.
+func wrapEntry(timestamp uint64, key string, hash uint64, entry []byte, codec *valueCodec, chunked bool) ([]byte, [][]byte, error) {
+	if uint64(len(key)) > maxKeySize {
+		return nil, nil, ErrKeyTooLarge
+	}
+	flags, entry, err := codec.encode(key, entry)
+	if err != nil {
+		return nil, nil, err
+	}
+	headerLength := entryHeaderSize(len(key))
+	if !chunked || headerLength+len(key)+len(entry) <= maxSlotSize || len(key) > maxChunkedKeySize {
+		blob := make([]byte, headerLength+len(key)+len(entry))
+		n := putEntryHeader(blob, kindValue, timestamp, key, hash, flags)
+		copy(blob[n:], entry)
+		return blob, nil, nil
+	}
+
+	count := (len(entry) + chunkDataSize - 1) / chunkDataSize
+	blob := make([]byte, headerLength+len(key)+binary.MaxVarintLen64+count*chunkIndexSizeInBytes)
+	n := putEntryHeader(blob, kindChunked, timestamp, key, hash, flags)
+	n += binary.PutUvarint(blob[n:], uint64(len(entry)))
+	chunks := make([][]byte, count)
+	for index := range chunks {
+		data := entry[index*chunkDataSize:]
+		if len(data) > chunkDataSize {
+			data = data[:chunkDataSize]
+		}
+		chunk := make([]byte, kindSizeInBytes+len(data))
+		chunk[0] = kindChunk
+		copy(chunk[kindSizeInBytes:], data)
+		chunks[index] = chunk
+	}
+	return blob[:n+count*chunkIndexSizeInBytes], chunks, nil
+}
+
+// wrapObjectEntry returns the entry of an object of size bytes held outside the buffer
+func wrapObjectEntry(timestamp uint64, key string, hash uint64, size int) []byte {
+	blob := make([]byte, entryHeaderSize(len(key))+len(key)+uvarintSize(uint64(size)))
+	n := putEntryHeader(blob, kindObject, timestamp, key, hash, codecRaw)
+	binary.PutUvarint(blob[n:], uint64(size))
+	return blob
+}
+
+// entryHeaderSize returns the size of the headers of an entry whose key is keyLength long
+func entryHeaderSize(keyLength int) int {
+	return fixedHeadersSizeInBytes + uvarintSize(uint64(keyLength))
+}
 
-func wrapEntry(timestamp uint64, key string, hash uint64, entry []byte) []byte {
-	keyLength := len(key)
-	blobLength := len(entry) + keyLength + headersSizeInBytes
-	blob := make([]byte, blobLength)
+func uvarintSize(x uint64) int {
+	n := 1
+	for ; x >= 0x80; x >>= 7 {
+		n++
+	}
+	return n
+}
 
-	binary.LittleEndian.PutUint64(blob, timestamp)
-	binary.LittleEndian.PutUint64(blob[timestampSizeInBytes:], hash)
-	binary.LittleEndian.PutUint16(blob[timestampSizeInBytes+hashSizeInBytes:], uint16(keyLength))
-	copy(blob[headersSizeInBytes:], key)
-	copy(blob[headersSizeInBytes+keyLength:], entry)
+// putEntryHeader writes the headers and the key of an entry and returns their size
+func putEntryHeader(blob []byte, kind byte, timestamp uint64, key string, hash uint64, codec byte) int {
+	blob[0] = kind
+	binary.LittleEndian.PutUint64(blob[kindSizeInBytes:], timestamp)
+	binary.LittleEndian.PutUint64(blob[kindSizeInBytes+timestampSizeInBytes:], hash)
+	blob[fixedHeadersSizeInBytes-codecSizeInBytes] = codec
+	n := fixedHeadersSizeInBytes
+	n += binary.PutUvarint(blob[n:], uint64(len(key)))
+	n += copy(blob[n:], key)
+	return n
+}
 
-	return blob[:blobLength]
+// keyBoundsFromEntry returns where the key of an entry starts and ends
+func keyBoundsFromEntry(data []byte) (int, int) {
+	length, n := binary.Uvarint(data[fixedHeadersSizeInBytes:])
+	start := fixedHeadersSizeInBytes + n
+	return start, start + int(length)
 }
 
 func readKeyFromEntry(data []byte) string {
-	length := binary.LittleEndian.Uint16(data[timestampSizeInBytes+hashSizeInBytes:])
+	start, end := keyBoundsFromEntry(data)
 
-	dst := make([]byte, length)
-	copy(dst, data[headersSizeInBytes:headersSizeInBytes+length])
+	dst := make([]byte, end-start)
+	copy(dst, data[start:end])
 	return bytesToString(dst)
 }
 
-func readEntry(data []byte) []byte {
-	length := binary.LittleEndian.Uint16(data[timestampSizeInBytes+hashSizeInBytes:])
+// entryHasKey reports whether the entry holds key, without copying the key out
+func entryHasKey(data []byte, key string) bool {
+	start, end := keyBoundsFromEntry(data)
+	return bytesToString(data[start:end]) == key
+}
+
+// readEntry returns the value of an entry holding its value, decoded by codec
+func readEntry(data []byte, codec *valueCodec) ([]byte, error) {
+	start, end := keyBoundsFromEntry(data)
+	return codec.decode(bytesToString(data[start:end]), readCodecFromEntry(data), data[end:])
+}
+
+// readValueSizeFromEntry returns the size of the value as stored, compressed or not, or
+// the size of the object of an object entry
+func readValueSizeFromEntry(data []byte) int {
+	_, end := keyBoundsFromEntry(data)
+	if kind := readKindFromEntry(data); kind == kindChunked || kind == kindObject {
+		length, _ := binary.Uvarint(data[end:])
+		return int(length)
+	}
+	return len(data) - end
+}
 
-	dst := make([]byte, len(data) - int(length + headersSizeInBytes))
-	copy(dst, data[headersSizeInBytes+length:])
+// readChunksFromEntry returns the size of a chunked value and the buffer indexes of its chunks
+func readChunksFromEntry(data []byte) (int, []uint32) {
+	_, end := keyBoundsFromEntry(data)
+	length, n := binary.Uvarint(data[end:])
+	list := data[end+n:]
+	indexes := make([]uint32, len(list)/chunkIndexSizeInBytes)
+	for i := range indexes {
+		indexes[i] = binary.LittleEndian.Uint32(list[i*chunkIndexSizeInBytes:])
+	}
+	return int(length), indexes
+}
+
+// setChunkIndex records in a chunked entry the buffer index of its i-th chunk
+func setChunkIndex(data []byte, i int, index uint32) {
+	_, end := keyBoundsFromEntry(data)
+	_, n := binary.Uvarint(data[end:])
+	binary.LittleEndian.PutUint32(data[end+n+i*chunkIndexSizeInBytes:], index)
+}
 
+// storedSizeOfEntry returns the bytes held by an entry, its chunks or its object included
+func storedSizeOfEntry(data []byte) int {
+	switch readKindFromEntry(data) {
+	case kindChunked:
+		length, indexes := readChunksFromEntry(data)
+		return len(data) + length + len(indexes)*kindSizeInBytes
+	case kindObject:
+		return len(data) + readValueSizeFromEntry(data)
+	}
+	return len(data)
+}
+
+func readKindFromEntry(data []byte) byte {
+	return data[0]
+}
+
+func readCodecFromEntry(data []byte) byte {
+	return data[fixedHeadersSizeInBytes-codecSizeInBytes]
+}
+
+// validEntry reports whether data is a well formed entry, as opposed to a chunk or garbage
+func validEntry(data []byte) bool {
+	if len(data) <= fixedHeadersSizeInBytes {
+		return false
+	}
+	kind := readKindFromEntry(data)
+	if kind != kindValue && kind != kindChunked {
+		return false
+	}
+	length, n := binary.Uvarint(data[fixedHeadersSizeInBytes:])
+	if n <= 0 || uint64(len(data)-fixedHeadersSizeInBytes-n) < length {
+		return false
+	}
+	if kind == kindValue {
+		return true
+	}
+	end := fixedHeadersSizeInBytes + n + int(length)
+	valueLength, n := binary.Uvarint(data[end:])
+	if n <= 0 {
+		return false
+	}
+	count := (valueLength + chunkDataSize - 1) / chunkDataSize
+	return uint64(len(data)-end-n) == count*chunkIndexSizeInBytes
+}
+
+// withExpireAt returns a copy of entry with its expire timestamp replaced
+func withExpireAt(entry []byte, expireAt uint64) []byte {
+	dst := make([]byte, len(entry))
+	copy(dst, entry)
+	binary.LittleEndian.PutUint64(dst[kindSizeInBytes:], expireAt)
 	return dst
 }
 
 func readExpireAtFromEntry(data []byte) uint64 {
-	return binary.LittleEndian.Uint64(data)
+	return binary.LittleEndian.Uint64(data[kindSizeInBytes:])
 }
 
 func readHashFromEntry(data []byte) uint64 {
-	return binary.LittleEndian.Uint64(data[timestampSizeInBytes:])
+	return binary.LittleEndian.Uint64(data[kindSizeInBytes+timestampSizeInBytes:])
 }
 
 func bytesToString(b []byte) string {
 	return *(*string)(unsafe.Pointer(&b))
-}
\ No newline at end of file
+}
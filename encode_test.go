@@ -0,0 +1,190 @@
+package localcache
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"strings"
+	"testing"
+	"time"
+)
+
+type encodeTestSuite struct {
+	suite.Suite
+}
+
+func TestEncodeTestSuite(t *testing.T) {
+	suite.Run(t, new(encodeTestSuite))
+}
+
+func (e *encodeTestSuite) SetupSuite() {}
+
+func (e *encodeTestSuite) TestEntryFormat() {
+	for _, key := range []string{"", "asong", strings.Repeat("k", 127), strings.Repeat("k", 128), strings.Repeat("k", 70000)} {
+		entry, chunks, err := wrapEntry(42, key, 7, []byte("value"), nil, true)
+		assert.Equal(e.T(), nil, err)
+		assert.Equal(e.T(), 0, len(chunks))
+		assert.True(e.T(), validEntry(entry))
+		assert.Equal(e.T(), key, readKeyFromEntry(entry))
+		assert.Equal(e.T(), uint64(42), readExpireAtFromEntry(entry))
+		assert.Equal(e.T(), uint64(7), readHashFromEntry(entry))
+		assert.Equal(e.T(), entryHeaderSize(len(key))+len(key)+5, len(entry))
+		value, err := readEntry(entry, nil)
+		assert.Equal(e.T(), nil, err)
+		assert.Equal(e.T(), []byte("value"), value)
+	}
+
+	// only segments with fixed size slots chunk their values
+	entry, chunks, err := wrapEntry(42, "asong", 7, make([]byte, 3*chunkDataSize+1), nil, false)
+	assert.Equal(e.T(), nil, err)
+	assert.Equal(e.T(), 0, len(chunks))
+	assert.Equal(e.T(), kindValue, readKindFromEntry(entry))
+
+	entry, chunks, err = wrapEntry(42, "asong", 7, make([]byte, 3*chunkDataSize+1), nil, true)
+	assert.Equal(e.T(), nil, err)
+	assert.Equal(e.T(), 4, len(chunks))
+	assert.Equal(e.T(), kindChunked, readKindFromEntry(entry))
+	assert.True(e.T(), validEntry(entry))
+	assert.False(e.T(), validEntry(chunks[0]))
+	for i := range chunks {
+		setChunkIndex(entry, i, uint32(10+i))
+	}
+	length, indexes := readChunksFromEntry(entry)
+	assert.Equal(e.T(), 3*chunkDataSize+1, length)
+	assert.Equal(e.T(), []uint32{10, 11, 12, 13}, indexes)
+	assert.Equal(e.T(), len(entry)+length+4, storedSizeOfEntry(entry))
+}
+
+func (e *encodeTestSuite) TestLongKeys() {
+	c, err := NewCache(SetShardCount(4))
+	assert.Equal(e.T(), nil, err)
+	defer c.Close()
+
+	key := strings.Repeat("k", 100*1024)
+	other := strings.Repeat("k", 100*1024-1) + "j"
+	assert.Equal(e.T(), nil, c.Set(key, []byte("long")))
+	assert.Equal(e.T(), nil, c.Set(other, []byte("other")))
+	res, err := c.Get(key)
+	assert.Equal(e.T(), nil, err)
+	assert.Equal(e.T(), []byte("long"), res)
+	res, err = c.Get(other)
+	assert.Equal(e.T(), nil, err)
+	assert.Equal(e.T(), []byte("other"), res)
+}
+
+func (e *encodeTestSuite) TestValuesLargerThanSegment() {
+	c, err := NewCache()
+	assert.Equal(e.T(), nil, err)
+	defer c.Close()
+	value := make([]byte, 4<<20)
+	assert.True(e.T(), len(value) > segmentSize*c.(*cache).segments[0].capacity())
+	assert.Equal(e.T(), nil, c.Set("large", value))
+	res, err := c.Get("large")
+	assert.Equal(e.T(), nil, err)
+	assert.Equal(e.T(), value, res)
+	assert.Equal(e.T(), kindValue, readKindFromEntry(e.entry(c, "large")))
+
+	c, err = NewCache(SetMaxBytes(1 << 20))
+	assert.Equal(e.T(), nil, err)
+	defer c.Close()
+	assert.Equal(e.T(), nil, c.Set("large", value[:100*1024]))
+	res, err = c.Get("large")
+	assert.Equal(e.T(), nil, err)
+	assert.Equal(e.T(), value[:100*1024], res)
+}
+
+// chunked makes the heap segments of c chunk their values like the mmap segments do
+func (e *encodeTestSuite) chunked(c ICache) {
+	for _, segment := range c.(*cache).segments {
+		segment.chunked = true
+	}
+}
+
+func (e *encodeTestSuite) TestChunkedValues() {
+	c, err := NewCache(SetShardCount(1), SetMaxBytes(64*segmentSize), SetStatsEnabled(true))
+	assert.Equal(e.T(), nil, err)
+	defer c.Close()
+	e.chunked(c)
+	segment := c.(*cache).segments[0]
+
+	value := make([]byte, 10*segmentSize)
+	for i := range value {
+		value[i] = byte(i * 7)
+	}
+	assert.Equal(e.T(), nil, c.Set("large", value))
+	assert.Equal(e.T(), 1, c.Len())
+	assert.Equal(e.T(), 12, segment.entries.GetPlaceholderCount())
+	res, err := c.Get("large")
+	assert.Equal(e.T(), nil, err)
+	assert.Equal(e.T(), value, res)
+	info, err := c.(inspector).inspect("large")
+	assert.Equal(e.T(), nil, err)
+	assert.Equal(e.T(), len(value), info.size)
+
+	// expire keeps the chunks, an overwrite releases them
+	assert.Equal(e.T(), nil, c.Expire("large", time.Hour))
+	res, err = c.Get("large")
+	assert.Equal(e.T(), nil, err)
+	assert.Equal(e.T(), value, res)
+	assert.Equal(e.T(), 12, segment.entries.GetPlaceholderCount())
+	assert.Equal(e.T(), nil, c.Set("large", []byte("small")))
+	assert.Equal(e.T(), 1, segment.entries.GetPlaceholderCount())
+	assert.Equal(e.T(), storedSizeOfEntry(e.entry(c, "large")), segment.size())
+
+	// a chunked value evicts as many entries as it needs slots
+	for index := 0; index < 64; index++ {
+		assert.Equal(e.T(), nil, c.Set(fmt.Sprintf("asong%02d", index), []byte("value")))
+	}
+	c.ResetStats()
+	assert.Equal(e.T(), nil, c.Set("large", value))
+	assert.Equal(e.T(), 64-12+1, c.Len())
+	assert.Equal(e.T(), int64(12), c.Stats().Evictions)
+	res, err = c.Get("large")
+	assert.Equal(e.T(), nil, err)
+	assert.Equal(e.T(), value, res)
+
+	// a value larger than the segment fails without evicting or leaking chunks
+	length, placeholders := c.Len(), segment.entries.GetPlaceholderCount()
+	assert.Equal(e.T(), ErrEntryTooLarge, c.Set("huge", make([]byte, 65*segmentSize)))
+	assert.Equal(e.T(), length, c.Len())
+	assert.Equal(e.T(), c.Len(), segment.len())
+	assert.Equal(e.T(), placeholders, segment.entries.GetPlaceholderCount())
+	assert.Equal(e.T(), int64(12), c.Stats().Evictions)
+}
+
+func (e *encodeTestSuite) entry(c ICache, key string) []byte {
+	cache := c.(*cache)
+	hashKey := cache.hashFunc.Sum64(key)
+	entry, err := cache.segments[hashKey&cache.bucketMask].peek(key, hashKey)
+	assert.Equal(e.T(), nil, err)
+	return entry
+}
+
+func (e *encodeTestSuite) TestChunkedCleanupAndCompression() {
+	compressor, _ := NewFlateCompressor(flate.BestSpeed)
+	c, err := NewCache(SetShardCount(1), SetMaxBytes(64*segmentSize), SetCompression(compressor, 1024))
+	assert.Equal(e.T(), nil, err)
+	defer c.Close()
+	e.chunked(c)
+	segment := c.(*cache).segments[0]
+
+	// random data stays raw and chunked, repetitive data shrinks into one slot
+	random := make([]byte, 4*segmentSize)
+	_, _ = rand.Read(random)
+	repetitive := bytes.Repeat([]byte("asong"), 4*segmentSize/5)
+	assert.Equal(e.T(), nil, c.SetWithTime("random", random, time.Second))
+	assert.Equal(e.T(), nil, c.Set("repetitive", repetitive))
+	assert.Equal(e.T(), kindValue, readKindFromEntry(e.entry(c, "repetitive")))
+	res, err := c.Get("repetitive")
+	assert.Equal(e.T(), nil, err)
+	assert.Equal(e.T(), repetitive, res)
+
+	_, expired := segment.cleanup(time.Now().Add(time.Minute).Unix(), segment.capacity())
+	assert.Equal(e.T(), 1, expired)
+	assert.Equal(e.T(), 1, segment.entries.GetPlaceholderCount())
+	keys, _ := c.Scan(0, 10)
+	assert.Equal(e.T(), []string{"repetitive"}, keys)
+}
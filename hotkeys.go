@@ -0,0 +1,172 @@
+package localcache
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// sketchDepth is the number of hash rows of the count-min sketch
+	sketchDepth = 4
+	// sketchWidth is the number of counters per row. value must be a power of two.
+	sketchWidth = 256
+	// defaultTopK is the number of hot keys every segment keeps track of
+	defaultTopK = 16
+)
+
+// KeyCount is a key with its estimated number of hits
+type KeyCount struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+}
+
+// countMinSketch estimates key frequencies in a fixed amount of memory.
+// Counters are updated atomically, so it is safe to use under a read lock.
+type countMinSketch struct {
+	counters [sketchDepth * sketchWidth]int64
+}
+
+// add counts one more occurrence of hashKey and returns its new estimate.
+func (s *countMinSketch) add(hashKey uint64) int64 {
+	estimate := int64(math.MaxInt64)
+	h1, h2 := sketchHashes(hashKey)
+	for row := uint32(0); row < sketchDepth; row++ {
+		index := row*sketchWidth + (h1+row*h2)&(sketchWidth-1)
+		if count := atomic.AddInt64(&s.counters[index], 1); count < estimate {
+			estimate = count
+		}
+	}
+	return estimate
+}
+
+// estimate returns how many times hashKey has been counted. It never underestimates.
+func (s *countMinSketch) estimate(hashKey uint64) int64 {
+	estimate := int64(math.MaxInt64)
+	h1, h2 := sketchHashes(hashKey)
+	for row := uint32(0); row < sketchDepth; row++ {
+		index := row*sketchWidth + (h1+row*h2)&(sketchWidth-1)
+		if count := atomic.LoadInt64(&s.counters[index]); count < estimate {
+			estimate = count
+		}
+	}
+	return estimate
+}
+
+func (s *countMinSketch) reset() {
+	for index := range s.counters {
+		atomic.StoreInt64(&s.counters[index], 0)
+	}
+}
+
+// sketchHashes derives the two hashes used for double hashing. The low bits of hashKey
+// pick the segment, so they are mixed in again before being used as row offsets.
+func sketchHashes(hashKey uint64) (uint32, uint32) {
+	hashKey ^= hashKey >> 33
+	hashKey *= 0xff51afd7ed558ccd
+	hashKey ^= hashKey >> 33
+	hashKey *= 0xc4ceb9fe1a85ec53
+	hashKey ^= hashKey >> 33
+	return uint32(hashKey), uint32(hashKey>>32) | 1
+}
+
+// topK keeps the k keys with the highest estimated counts in a min-heap.
+type topK struct {
+	mu   sync.Mutex
+	k    int
+	heap keyCountHeap
+	// floor is the smallest tracked count once the heap is full. Estimates that do not
+	// exceed it can neither update a tracked key nor replace one, so they skip the lock.
+	floor int64
+}
+
+func newTopK(k int) *topK {
+	return &topK{
+		k: k,
+		heap: keyCountHeap{
+			positions: make(map[string]int, k),
+		},
+	}
+}
+
+// offer records the latest estimate for key.
+func (t *topK) offer(key string, count int64) {
+	if count <= atomic.LoadInt64(&t.floor) {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if pos, ok := t.heap.positions[key]; ok {
+		t.heap.items[pos].Count = count
+		heap.Fix(&t.heap, pos)
+	} else if t.heap.Len() < t.k {
+		heap.Push(&t.heap, KeyCount{Key: key, Count: count})
+	} else if count > t.heap.items[0].Count {
+		delete(t.heap.positions, t.heap.items[0].Key)
+		t.heap.items[0] = KeyCount{Key: key, Count: count}
+		t.heap.positions[key] = 0
+		heap.Fix(&t.heap, 0)
+	}
+	if t.heap.Len() == t.k {
+		atomic.StoreInt64(&t.floor, t.heap.items[0].Count)
+	}
+}
+
+func (t *topK) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.heap.items = t.heap.items[:0]
+	t.heap.positions = make(map[string]int, t.k)
+	atomic.StoreInt64(&t.floor, 0)
+}
+
+// list returns the tracked keys ordered by count, highest first.
+func (t *topK) list() []KeyCount {
+	t.mu.Lock()
+	res := make([]KeyCount, len(t.heap.items))
+	copy(res, t.heap.items)
+	t.mu.Unlock()
+	sortKeyCounts(res)
+	return res
+}
+
+func sortKeyCounts(counts []KeyCount) {
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Key < counts[j].Key
+	})
+}
+
+// keyCountHeap is a min-heap of KeyCount that remembers the position of every key
+type keyCountHeap struct {
+	items     []KeyCount
+	positions map[string]int
+}
+
+func (h *keyCountHeap) Len() int { return len(h.items) }
+
+func (h *keyCountHeap) Less(i, j int) bool { return h.items[i].Count < h.items[j].Count }
+
+func (h *keyCountHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.positions[h.items[i].Key] = i
+	h.positions[h.items[j].Key] = j
+}
+
+func (h *keyCountHeap) Push(x interface{}) {
+	item := x.(KeyCount)
+	h.positions[item.Key] = len(h.items)
+	h.items = append(h.items, item)
+}
+
+func (h *keyCountHeap) Pop() interface{} {
+	last := len(h.items) - 1
+	item := h.items[last]
+	h.items = h.items[:last]
+	delete(h.positions, item.Key)
+	return item
+}
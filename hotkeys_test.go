@@ -0,0 +1,48 @@
+package localcache
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type hotKeysTestSuite struct {
+	suite.Suite
+}
+
+func TestHotKeysTestSuite(t *testing.T) {
+	suite.Run(t, new(hotKeysTestSuite))
+}
+
+func (h *hotKeysTestSuite) SetupSuite() {}
+
+func (h *hotKeysTestSuite) TestSketchNeverUnderestimates() {
+	sketch := &countMinSketch{}
+	fnv := NewDefaultHashFunc()
+	for index := 0; index < 1000; index++ {
+		key := fmt.Sprintf("asong%04d", index)
+		for i := 0; i <= index%10; i++ {
+			sketch.add(fnv.Sum64(key))
+		}
+	}
+	for index := 0; index < 1000; index++ {
+		key := fmt.Sprintf("asong%04d", index)
+		assert.GreaterOrEqual(h.T(), sketch.estimate(fnv.Sum64(key)), int64(index%10+1))
+	}
+}
+
+func (h *hotKeysTestSuite) TestTopKKeepsHighestCounts() {
+	top := newTopK(3)
+	for index := 1; index <= 10; index++ {
+		top.offer(fmt.Sprintf("asong%02d", index), int64(index))
+	}
+	top.offer("asong01", 20)
+
+	expected := []KeyCount{
+		{Key: "asong01", Count: 20},
+		{Key: "asong10", Count: 10},
+		{Key: "asong09", Count: 9},
+	}
+	assert.Equal(h.T(), expected, top.list())
+}
@@ -0,0 +1,199 @@
+package httpcache
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// entry kinds. A response whose Vary lists request headers is stored under a key derived
+// from the values of those headers, and the URL key holds a vary entry with their names
+// and the generation of the variants, so dropping the vary entry orphans all of them.
+const (
+	kindResponse byte = iota + 1
+	kindVary
+)
+
+var errInvalidEntry = errors.New("httpcache: invalid entry")
+
+// entry is a stored response
+type entry struct {
+	status int
+	header http.Header
+	body   []byte
+	// responseTime is when the response was received or last revalidated
+	responseTime time.Time
+	// initialAge is the age the response had when it was received, from its Age header
+	initialAge time.Duration
+}
+
+// encode renders the entry as
+// kind | status | responseTime | initialAge | uvarint(len(fields)) | fields | body
+// where every header value is a name, value field pair
+func (e *entry) encode() []byte {
+	size := 1 + 2 + 8 + 8 + binary.MaxVarintLen64 + len(e.body)
+	fields := 0
+	for name, values := range e.header {
+		for _, value := range values {
+			size += 2*binary.MaxVarintLen64 + len(name) + len(value)
+			fields++
+		}
+	}
+	buf := make([]byte, 0, size)
+	buf = append(buf, kindResponse)
+	buf = appendUint16(buf, uint16(e.status))
+	buf = appendUint64(buf, uint64(e.responseTime.UnixNano()))
+	buf = appendUint64(buf, uint64(e.initialAge))
+	buf = appendUvarint(buf, uint64(2*fields))
+	for name, values := range e.header {
+		for _, value := range values {
+			buf = appendString(buf, name)
+			buf = appendString(buf, value)
+		}
+	}
+	return append(buf, e.body...)
+}
+
+// vary is the vary entry of the responses varying on names
+type vary struct {
+	names      []string
+	generation uint64
+}
+
+// encode renders the vary entry as
+// kind | generation | uvarint(len(names)) | names
+func (v *vary) encode() []byte {
+	buf := []byte{kindVary}
+	buf = appendUint64(buf, v.generation)
+	buf = appendUvarint(buf, uint64(len(v.names)))
+	for _, name := range v.names {
+		buf = appendString(buf, name)
+	}
+	return buf
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	var b [2]byte
+	binary.LittleEndian.PutUint16(b[:], v)
+	return append(buf, b[:]...)
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	return append(buf, b[:]...)
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutUvarint(b[:], v)]...)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = appendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// decodeEntry returns either a response entry or a vary entry
+func decodeEntry(data []byte) (*entry, *vary, error) {
+	if len(data) == 0 {
+		return nil, nil, errInvalidEntry
+	}
+	d := &decoder{buf: data[1:]}
+	switch data[0] {
+	case kindResponse:
+		e := &entry{header: make(http.Header)}
+		e.status = int(d.uint16())
+		e.responseTime = time.Unix(0, int64(d.uint64()))
+		e.initialAge = time.Duration(d.uint64())
+		fields := d.uvarint()
+		if fields%2 != 0 || fields > uint64(len(d.buf)) {
+			return nil, nil, errInvalidEntry
+		}
+		for i := uint64(0); i < fields; i += 2 {
+			name, value := d.string(), d.string()
+			e.header[name] = append(e.header[name], value)
+		}
+		e.body = d.buf
+		return e, nil, d.err
+	case kindVary:
+		v := &vary{generation: d.uint64()}
+		count := d.uvarint()
+		if count > uint64(len(d.buf)) {
+			return nil, nil, errInvalidEntry
+		}
+		v.names = make([]string, 0, count)
+		for i := uint64(0); i < count; i++ {
+			v.names = append(v.names, d.string())
+		}
+		if d.err != nil {
+			return nil, nil, d.err
+		}
+		return nil, v, nil
+	}
+	return nil, nil, errInvalidEntry
+}
+
+// decoder reads the fields of an entry, the first failure sticks in err
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) uint16() uint16 {
+	if d.err != nil || len(d.buf) < 2 {
+		d.err = errInvalidEntry
+		return 0
+	}
+	v := binary.LittleEndian.Uint16(d.buf)
+	d.buf = d.buf[2:]
+	return v
+}
+
+func (d *decoder) uint64() uint64 {
+	if d.err != nil || len(d.buf) < 8 {
+		d.err = errInvalidEntry
+		return 0
+	}
+	v := binary.LittleEndian.Uint64(d.buf)
+	d.buf = d.buf[8:]
+	return v
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errInvalidEntry
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	length := d.uvarint()
+	if d.err != nil || uint64(len(d.buf)) < length {
+		d.err = errInvalidEntry
+		return ""
+	}
+	v := string(d.buf[:length])
+	d.buf = d.buf[length:]
+	return v
+}
+
+// varyNames returns the canonical, sorted names of the request headers listed by Vary
+func varyNames(header http.Header) []string {
+	var names []string
+	for _, line := range header.Values("Vary") {
+		for _, name := range splitList(line) {
+			names = append(names, http.CanonicalHeaderKey(name))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,206 @@
+// Package httpcache caches HTTP responses in an ICache, both on the server side, as a
+// middleware in front of a handler, and on the client side, as a RoundTripper. Caching
+// follows Cache-Control (max-age, s-maxage, no-cache, no-store, private, must-revalidate,
+// stale-if-error), Expires, Vary, and revalidates stale responses with their ETag or
+// Last-Modified date. Only GET responses are stored; a successful unsafe request, like a
+// POST or a DELETE, drops the responses stored for its URL.
+//
+// Responses are stored as the byte values of the cache, under keys starting with
+// "httpcache:". Served responses carry an X-Cache header telling HIT, MISS, REVALIDATED
+// or STALE.
+package httpcache
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"time"
+
+	localcache "github.com/asong2020/go-localcache"
+)
+
+// notModifiedHeaders are the headers a 304 response repeats from the stored response
+var notModifiedHeaders = []string{"Cache-Control", "Content-Location", "Date", "ETag", "Expires",
+	"Last-Modified", "Vary", "Age", cacheStatusHeader}
+
+// NewMiddleware returns a middleware caching the responses of the wrapped handler in cache.
+// It acts as a shared cache. Responses are buffered up to the maximum body size, handlers
+// that write more or flush are streamed to the client and not stored.
+func NewMiddleware(cache localcache.ICache, opts ...Opt) func(http.Handler) http.Handler {
+	options := newOptions(opts)
+	options.shared = true
+	p := &policy{cache: cache, options: options}
+	return func(next http.Handler) http.Handler {
+		return &handler{policy: p, next: next}
+	}
+}
+
+type handler struct {
+	*policy
+	next http.Handler
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.next.ServeHTTP(sw, r)
+		if unsafeMethod(r.Method) && sw.status < http.StatusBadRequest {
+			h.invalidate(r)
+		}
+		return
+	}
+	if parseCacheControl(r.Header).has("no-store") {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	now := time.Now()
+	e := h.lookup(r)
+	if e != nil && h.fresh(e, r, now) {
+		h.serve(w, r, e, statusHit, now)
+		return
+	}
+	upstream := r
+	if e != nil && hasValidators(e.header) {
+		upstream = conditional(r, e)
+	}
+	cw := &captureWriter{w: w, header: make(http.Header), maxBodySize: h.options.maxBodySize}
+	h.next.ServeHTTP(cw, upstream)
+	if cw.passthrough {
+		return
+	}
+	if !cw.wroteHeader {
+		cw.status = http.StatusOK
+	}
+
+	now = time.Now()
+	switch {
+	case e != nil && upstream != r && cw.status == http.StatusNotModified:
+		e = revalidated(e, cw.header, now)
+		h.store(r, e, now)
+		h.serve(w, r, e, statusRevalidated, now)
+	case e != nil && serverError(cw.status) && h.staleIfError(e, r, now):
+		h.serve(w, r, e, statusStale, now)
+	case r.Method == http.MethodGet && h.storable(r, cw.status, cw.header):
+		e = newEntry(cw.status, cw.header, cw.body.Bytes(), now)
+		h.store(r, e, now)
+		h.serve(w, r, e, statusMiss, now)
+	default:
+		if e != nil && r.Method == http.MethodGet {
+			// the stored response was replaced by one that may not be stored
+			h.invalidate(r)
+		}
+		for name, values := range cw.header {
+			w.Header()[name] = values
+		}
+		w.Header().Set(cacheStatusHeader, statusMiss)
+		w.WriteHeader(cw.status)
+		w.Write(cw.body.Bytes())
+	}
+}
+
+// serve writes e, or a 304 when it satisfies the conditions of r
+func (h *handler) serve(w http.ResponseWriter, r *http.Request, e *entry, status string, now time.Time) {
+	header := e.servedHeader(now, status)
+	if e.status == http.StatusOK && notModified(r, header) {
+		for _, name := range notModifiedHeaders {
+			if values := header.Values(name); len(values) > 0 {
+				w.Header()[http.CanonicalHeaderKey(name)] = values
+			}
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	for name, values := range header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(e.body)))
+	w.WriteHeader(e.status)
+	if r.Method != http.MethodHead {
+		w.Write(e.body)
+	}
+}
+
+// statusWriter records the status of a response
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// captureWriter buffers a response so it can be stored, or replaced by a stored one. A
+// response outgrowing maxBodySize, or flushed by the handler, is passed through instead.
+type captureWriter struct {
+	w           http.ResponseWriter
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+	maxBodySize int
+	passthrough bool
+}
+
+func (c *captureWriter) Header() http.Header {
+	if c.passthrough {
+		return c.w.Header()
+	}
+	return c.header
+}
+
+func (c *captureWriter) WriteHeader(status int) {
+	if c.wroteHeader {
+		return
+	}
+	c.status, c.wroteHeader = status, true
+}
+
+func (c *captureWriter) Write(p []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	if !c.passthrough && c.body.Len()+len(p) > c.maxBodySize {
+		c.startPassthrough()
+	}
+	if c.passthrough {
+		return c.w.Write(p)
+	}
+	return c.body.Write(p)
+}
+
+func (c *captureWriter) Flush() {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	c.startPassthrough()
+	if flusher, ok := c.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// startPassthrough sends what was buffered and lets the rest of the response through
+func (c *captureWriter) startPassthrough() {
+	if c.passthrough {
+		return
+	}
+	c.passthrough = true
+	for name, values := range c.header {
+		c.w.Header()[name] = values
+	}
+	c.w.Header().Set(cacheStatusHeader, statusMiss)
+	c.w.WriteHeader(c.status)
+	c.w.Write(c.body.Bytes())
+	c.body = bytes.Buffer{}
+}
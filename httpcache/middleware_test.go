@@ -0,0 +1,292 @@
+package httpcache
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	localcache "github.com/asong2020/go-localcache"
+)
+
+type middlewareTestSuite struct {
+	suite.Suite
+	cache localcache.ICache
+	// calls counts the requests reaching the handler
+	calls int64
+}
+
+func TestMiddlewareTestSuite(t *testing.T) {
+	suite.Run(t, new(middlewareTestSuite))
+}
+
+func (m *middlewareTestSuite) SetupTest() {
+	cache, err := localcache.NewCache(localcache.SetShardCount(4))
+	assert.Equal(m.T(), nil, err)
+	m.cache = cache
+	atomic.StoreInt64(&m.calls, 0)
+}
+
+func (m *middlewareTestSuite) TearDownTest() {
+	m.cache.Close()
+}
+
+func (m *middlewareTestSuite) handler(fn http.HandlerFunc, opts ...Opt) http.Handler {
+	return NewMiddleware(m.cache, opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&m.calls, 1)
+		fn(w, r)
+	}))
+}
+
+func (m *middlewareTestSuite) do(h http.Handler, method string, target string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func (m *middlewareTestSuite) TestMaxAge() {
+	h := m.handler(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, "hello")
+	})
+	rec := m.do(h, http.MethodGet, "/asong", nil)
+	assert.Equal(m.T(), http.StatusOK, rec.Code)
+	assert.Equal(m.T(), statusMiss, rec.Header().Get(cacheStatusHeader))
+	assert.Equal(m.T(), "hello", rec.Body.String())
+
+	rec = m.do(h, http.MethodGet, "/asong", nil)
+	assert.Equal(m.T(), statusHit, rec.Header().Get(cacheStatusHeader))
+	assert.Equal(m.T(), "hello", rec.Body.String())
+	assert.Equal(m.T(), "0", rec.Header().Get("Age"))
+	rec = m.do(h, http.MethodHead, "/asong", nil)
+	assert.Equal(m.T(), statusHit, rec.Header().Get(cacheStatusHeader))
+	assert.Equal(m.T(), "", rec.Body.String())
+	assert.Equal(m.T(), int64(1), atomic.LoadInt64(&m.calls))
+
+	rec = m.do(h, http.MethodGet, "/asong", map[string]string{"Cache-Control": "no-cache"})
+	assert.Equal(m.T(), statusMiss, rec.Header().Get(cacheStatusHeader))
+	rec = m.do(h, http.MethodGet, "/other", nil)
+	assert.Equal(m.T(), statusMiss, rec.Header().Get(cacheStatusHeader))
+	assert.Equal(m.T(), int64(3), atomic.LoadInt64(&m.calls))
+}
+
+func (m *middlewareTestSuite) TestNotStored() {
+	for _, cc := range []string{"no-store", "private, max-age=60", "max-age=60, no-store"} {
+		atomic.StoreInt64(&m.calls, 0)
+		h := m.handler(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", cc)
+			fmt.Fprint(w, "hello")
+		})
+		m.do(h, http.MethodGet, "/asong", nil)
+		rec := m.do(h, http.MethodGet, "/asong", nil)
+		assert.Equal(m.T(), statusMiss, rec.Header().Get(cacheStatusHeader), cc)
+		assert.Equal(m.T(), int64(2), atomic.LoadInt64(&m.calls), cc)
+	}
+
+	atomic.StoreInt64(&m.calls, 0)
+	h := m.handler(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, "hello")
+	})
+	m.do(h, http.MethodGet, "/auth", map[string]string{"Authorization": "Bearer token"})
+	m.do(h, http.MethodGet, "/auth", map[string]string{"Authorization": "Bearer token"})
+	assert.Equal(m.T(), int64(2), atomic.LoadInt64(&m.calls))
+}
+
+func (m *middlewareTestSuite) TestSharedMaxAge() {
+	h := m.handler(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=0, s-maxage=60")
+		fmt.Fprint(w, "hello")
+	})
+	m.do(h, http.MethodGet, "/asong", nil)
+	rec := m.do(h, http.MethodGet, "/asong", nil)
+	assert.Equal(m.T(), statusHit, rec.Header().Get(cacheStatusHeader))
+}
+
+func (m *middlewareTestSuite) TestVary() {
+	h := m.handler(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		fmt.Fprint(w, "hello "+r.Header.Get("Accept-Language"))
+	})
+	en := map[string]string{"Accept-Language": "en"}
+	fr := map[string]string{"Accept-Language": "fr"}
+	assert.Equal(m.T(), "hello en", m.do(h, http.MethodGet, "/asong", en).Body.String())
+	assert.Equal(m.T(), "hello fr", m.do(h, http.MethodGet, "/asong", fr).Body.String())
+	rec := m.do(h, http.MethodGet, "/asong", en)
+	assert.Equal(m.T(), statusHit, rec.Header().Get(cacheStatusHeader))
+	assert.Equal(m.T(), "hello en", rec.Body.String())
+	rec = m.do(h, http.MethodGet, "/asong", fr)
+	assert.Equal(m.T(), statusHit, rec.Header().Get(cacheStatusHeader))
+	assert.Equal(m.T(), "hello fr", rec.Body.String())
+	assert.Equal(m.T(), int64(2), atomic.LoadInt64(&m.calls))
+}
+
+func (m *middlewareTestSuite) TestRevalidation() {
+	h := m.handler(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprint(w, "hello")
+	})
+	rec := m.do(h, http.MethodGet, "/asong", nil)
+	assert.Equal(m.T(), statusMiss, rec.Header().Get(cacheStatusHeader))
+	rec = m.do(h, http.MethodGet, "/asong", nil)
+	assert.Equal(m.T(), http.StatusOK, rec.Code)
+	assert.Equal(m.T(), statusRevalidated, rec.Header().Get(cacheStatusHeader))
+	assert.Equal(m.T(), "hello", rec.Body.String())
+
+	rec = m.do(h, http.MethodGet, "/asong", map[string]string{"If-None-Match": `"v1"`})
+	assert.Equal(m.T(), http.StatusNotModified, rec.Code)
+	assert.Equal(m.T(), `"v1"`, rec.Header().Get("ETag"))
+	assert.Equal(m.T(), "", rec.Body.String())
+	rec = m.do(h, http.MethodGet, "/asong", map[string]string{"If-None-Match": `"v0"`})
+	assert.Equal(m.T(), http.StatusOK, rec.Code)
+	assert.Equal(m.T(), "hello", rec.Body.String())
+}
+
+func (m *middlewareTestSuite) TestLastModified() {
+	lastModified := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	h := m.handler(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Last-Modified", lastModified)
+		if r.Header.Get("If-Modified-Since") == lastModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprint(w, "hello")
+	})
+	m.do(h, http.MethodGet, "/asong", nil)
+	rec := m.do(h, http.MethodGet, "/asong", nil)
+	assert.Equal(m.T(), statusRevalidated, rec.Header().Get(cacheStatusHeader))
+	assert.Equal(m.T(), "hello", rec.Body.String())
+	rec = m.do(h, http.MethodGet, "/asong", map[string]string{"If-Modified-Since": lastModified})
+	assert.Equal(m.T(), http.StatusNotModified, rec.Code)
+}
+
+func (m *middlewareTestSuite) TestStaleIfError() {
+	var fail int32
+	h := m.handler(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&fail) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=0, stale-if-error=60")
+		fmt.Fprint(w, "hello")
+	})
+	m.do(h, http.MethodGet, "/asong", nil)
+	atomic.StoreInt32(&fail, 1)
+	rec := m.do(h, http.MethodGet, "/asong", nil)
+	assert.Equal(m.T(), http.StatusOK, rec.Code)
+	assert.Equal(m.T(), statusStale, rec.Header().Get(cacheStatusHeader))
+	assert.Equal(m.T(), "hello", rec.Body.String())
+
+	atomic.StoreInt32(&fail, 0)
+	h = m.handler(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&fail) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=0, must-revalidate, stale-if-error=60")
+		fmt.Fprint(w, "hello")
+	})
+	m.do(h, http.MethodGet, "/strict", nil)
+	atomic.StoreInt32(&fail, 1)
+	rec = m.do(h, http.MethodGet, "/strict", nil)
+	assert.Equal(m.T(), http.StatusServiceUnavailable, rec.Code)
+}
+
+func (m *middlewareTestSuite) TestUnsafeMethodInvalidates() {
+	h := m.handler(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, "hello")
+	})
+	m.do(h, http.MethodGet, "/asong", nil)
+	assert.Equal(m.T(), statusHit, m.do(h, http.MethodGet, "/asong", nil).Header().Get(cacheStatusHeader))
+	assert.Equal(m.T(), http.StatusNoContent, m.do(h, http.MethodPost, "/asong", nil).Code)
+	assert.Equal(m.T(), statusMiss, m.do(h, http.MethodGet, "/asong", nil).Header().Get(cacheStatusHeader))
+}
+
+func (m *middlewareTestSuite) TestUnsafeMethodInvalidatesVariants() {
+	version := "v1"
+	h := m.handler(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			version = "v2"
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		fmt.Fprint(w, version+" "+r.Header.Get("Accept-Language"))
+	})
+	en := map[string]string{"Accept-Language": "en"}
+	fr := map[string]string{"Accept-Language": "fr"}
+	assert.Equal(m.T(), "v1 en", m.do(h, http.MethodGet, "/asong", en).Body.String())
+	assert.Equal(m.T(), "v1 fr", m.do(h, http.MethodGet, "/asong", fr).Body.String())
+	assert.Equal(m.T(), http.StatusNoContent, m.do(h, http.MethodPost, "/asong", nil).Code)
+
+	// storing a new variant must not revive the ones from before the POST
+	rec := m.do(h, http.MethodGet, "/asong", en)
+	assert.Equal(m.T(), statusMiss, rec.Header().Get(cacheStatusHeader))
+	assert.Equal(m.T(), "v2 en", rec.Body.String())
+	rec = m.do(h, http.MethodGet, "/asong", fr)
+	assert.Equal(m.T(), statusMiss, rec.Header().Get(cacheStatusHeader))
+	assert.Equal(m.T(), "v2 fr", rec.Body.String())
+	rec = m.do(h, http.MethodGet, "/asong", en)
+	assert.Equal(m.T(), statusHit, rec.Header().Get(cacheStatusHeader))
+	assert.Equal(m.T(), "v2 en", rec.Body.String())
+}
+
+func (m *middlewareTestSuite) TestLargeBody() {
+	body := strings.Repeat("x", 100)
+	h := m.handler(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(body[:50]))
+		w.Write([]byte(body[50:]))
+	}, SetMaxBodySize(64))
+	rec := m.do(h, http.MethodGet, "/asong", nil)
+	assert.Equal(m.T(), body, rec.Body.String())
+	assert.Equal(m.T(), "max-age=60", rec.Header().Get("Cache-Control"))
+	rec = m.do(h, http.MethodGet, "/asong", nil)
+	assert.Equal(m.T(), statusMiss, rec.Header().Get(cacheStatusHeader))
+	assert.Equal(m.T(), body, rec.Body.String())
+	assert.Equal(m.T(), int64(2), atomic.LoadInt64(&m.calls))
+}
+
+func (m *middlewareTestSuite) TestEntryEncoding() {
+	e := &entry{
+		status:       http.StatusNotFound,
+		header:       http.Header{"Cache-Control": {"max-age=60"}, "Set-Cookie": {"a=1", "b=2"}},
+		body:         []byte("missing"),
+		responseTime: time.Unix(0, time.Now().UnixNano()),
+		initialAge:   3 * time.Second,
+	}
+	decoded, v, err := decodeEntry(e.encode())
+	assert.Equal(m.T(), nil, err)
+	assert.Equal(m.T(), (*vary)(nil), v)
+	assert.Equal(m.T(), e, decoded)
+
+	stored := &vary{names: []string{"Accept", "Accept-Language"}, generation: 42}
+	_, v, err = decodeEntry(stored.encode())
+	assert.Equal(m.T(), nil, err)
+	assert.Equal(m.T(), stored, v)
+
+	_, _, err = decodeEntry(e.encode()[:10])
+	assert.Equal(m.T(), errInvalidEntry, err)
+}
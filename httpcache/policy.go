@@ -0,0 +1,478 @@
+package httpcache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	localcache "github.com/asong2020/go-localcache"
+)
+
+const (
+	keyPrefix = "httpcache:"
+	// heuristicFraction is the share of the time since Last-Modified a response without
+	// explicit freshness is considered fresh, up to heuristicMaxLifetime
+	heuristicFraction    = 10
+	heuristicMaxLifetime = 24 * time.Hour
+
+	defaultMaxBodySize = 1024 * 1024
+	defaultKeepStale   = 10 * time.Minute
+)
+
+// heuristicStatus are the status codes cacheable without explicit freshness
+var heuristicStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// hopByHop are the headers that describe a connection rather than a response
+var hopByHop = []string{"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+// cacheStatusHeader tells whether a response was served from the cache
+const cacheStatusHeader = "X-Cache"
+
+const (
+	statusHit         = "HIT"
+	statusMiss        = "MISS"
+	statusRevalidated = "REVALIDATED"
+	statusStale       = "STALE"
+)
+
+type options struct {
+	maxBodySize  int
+	keepStale    time.Duration
+	shared       bool
+	roundTripper http.RoundTripper
+}
+
+// Opt configures a middleware or a Transport
+type Opt func(options *options)
+
+// SetMaxBodySize sets the largest body stored, 1MB by default. Larger responses are
+// passed through uncached.
+func SetMaxBodySize(size int) Opt {
+	return func(opt *options) {
+		opt.maxBodySize = size
+	}
+}
+
+// SetKeepStale sets how long a response with an ETag or a Last-Modified date is kept
+// once stale, to be revalidated instead of fetched again. 10 minutes by default.
+func SetKeepStale(keep time.Duration) Opt {
+	return func(opt *options) {
+		opt.keepStale = keep
+	}
+}
+
+// SetShared makes a Transport behave as a shared cache: it honors s-maxage and stores
+// neither private responses nor the responses to authorized requests. A Transport is a
+// private cache by default, the middleware is always a shared one.
+func SetShared(shared bool) Opt {
+	return func(opt *options) {
+		opt.shared = shared
+	}
+}
+
+// SetRoundTripper sets the RoundTripper a Transport sends its requests with,
+// http.DefaultTransport by default
+func SetRoundTripper(roundTripper http.RoundTripper) Opt {
+	return func(opt *options) {
+		opt.roundTripper = roundTripper
+	}
+}
+
+func newOptions(opts []Opt) *options {
+	options := &options{
+		maxBodySize:  defaultMaxBodySize,
+		keepStale:    defaultKeepStale,
+		roundTripper: http.DefaultTransport,
+	}
+	for _, each := range opts {
+		each(options)
+	}
+	return options
+}
+
+// cacheControl holds the directives of the Cache-Control headers, by lower case name
+type cacheControl map[string]string
+
+func parseCacheControl(header http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, line := range header.Values("Cache-Control") {
+		for _, directive := range splitList(line) {
+			name, value := directive, ""
+			if i := strings.IndexByte(directive, '='); i >= 0 {
+				name, value = directive[:i], strings.Trim(strings.TrimSpace(directive[i+1:]), `"`)
+			}
+			cc[strings.ToLower(strings.TrimSpace(name))] = value
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// seconds returns the delta seconds of directive. An invalid value counts as zero, so
+// a garbled max-age makes a response stale rather than fresh forever.
+func (cc cacheControl) seconds(directive string) (time.Duration, bool) {
+	value, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, true
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+func splitList(line string) []string {
+	var res []string
+	for _, part := range strings.Split(line, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			res = append(res, part)
+		}
+	}
+	return res
+}
+
+// policy holds the caching rules shared by the middleware and the Transport
+type policy struct {
+	cache   localcache.ICache
+	options *options
+}
+
+func primaryKey(r *http.Request) string {
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	scheme := r.URL.Scheme
+	if scheme == "" {
+		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+	return keyPrefix + scheme + "://" + host + r.URL.RequestURI()
+}
+
+// generation numbers the vary entries. It starts from the clock so the variants of a
+// cache that outlived the process are not reused.
+var generation = uint64(time.Now().UnixNano())
+
+// variantKey is the key of the response to r among the ones of v
+func variantKey(key string, v *vary, header http.Header) string {
+	var b strings.Builder
+	b.WriteString(key)
+	b.WriteByte(0)
+	b.WriteString(strconv.FormatUint(v.generation, 36))
+	for _, name := range v.names {
+		b.WriteByte(0)
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strings.Join(header.Values(name), ","))
+	}
+	return b.String()
+}
+
+// lookup returns the stored response for r, nil if there is none
+func (p *policy) lookup(r *http.Request) *entry {
+	key := primaryKey(r)
+	data, err := p.cache.Get(key)
+	if err != nil {
+		return nil
+	}
+	e, v, err := decodeEntry(data)
+	if err != nil || e != nil {
+		return e
+	}
+	if data, err = p.cache.Get(variantKey(key, v, r.Header)); err != nil {
+		return nil
+	}
+	if e, _, err = decodeEntry(data); err != nil {
+		return nil
+	}
+	return e
+}
+
+// store saves e as the response to r, for as long as it may be served or revalidated
+func (p *policy) store(r *http.Request, e *entry, now time.Time) {
+	ttl := p.ttl(e, now)
+	if ttl <= 0 {
+		return
+	}
+	// the cache counts whole seconds, round up so the entry outlives its freshness
+	ttl = ttl.Truncate(time.Second) + time.Second
+	key := primaryKey(r)
+	if names := varyNames(e.header); len(names) > 0 {
+		v := p.vary(key, names)
+		if p.cache.SetWithTime(key, v.encode(), ttl) != nil {
+			return
+		}
+		key = variantKey(key, v, r.Header)
+	}
+	_ = p.cache.SetWithTime(key, e.encode(), ttl)
+}
+
+// vary returns the stored vary entry of key when it varies on names, a new generation
+// otherwise
+func (p *policy) vary(key string, names []string) *vary {
+	if data, err := p.cache.Get(key); err == nil {
+		if _, v, err := decodeEntry(data); err == nil && v != nil && equalNames(v.names, names) {
+			return v
+		}
+	}
+	return &vary{names: names, generation: atomic.AddUint64(&generation, 1)}
+}
+
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// invalidate drops the responses to the URL of r, after an unsafe method changed it.
+// Dropping the vary entry orphans the variants, the next one stored starts a new generation.
+func (p *policy) invalidate(r *http.Request) {
+	_ = p.cache.Delete(primaryKey(r))
+}
+
+// storable reports whether the response to r may be stored
+func (p *policy) storable(r *http.Request, status int, header http.Header) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	reqCC, respCC := parseCacheControl(r.Header), parseCacheControl(header)
+	if reqCC.has("no-store") || respCC.has("no-store") {
+		return false
+	}
+	if p.options.shared {
+		if respCC.has("private") || header.Get("Set-Cookie") != "" {
+			return false
+		}
+		if r.Header.Get("Authorization") != "" &&
+			!respCC.has("public") && !respCC.has("s-maxage") && !respCC.has("must-revalidate") {
+			return false
+		}
+	}
+	for _, name := range varyNames(header) {
+		if name == "*" {
+			return false
+		}
+	}
+	explicit := respCC.has("max-age") || (p.options.shared && respCC.has("s-maxage")) ||
+		header.Get("Expires") != ""
+	if !explicit && !heuristicStatus[status] {
+		return false
+	}
+	return explicit || hasValidators(header) || respCC.has("public")
+}
+
+func hasValidators(header http.Header) bool {
+	return header.Get("ETag") != "" || header.Get("Last-Modified") != ""
+}
+
+// newEntry prepares a response for storage
+func newEntry(status int, header http.Header, body []byte, now time.Time) *entry {
+	header = header.Clone()
+	for _, name := range hopByHop {
+		header.Del(name)
+	}
+	header.Del(cacheStatusHeader)
+	return &entry{status: status, header: header, body: body, responseTime: now, initialAge: ageOf(header)}
+}
+
+func ageOf(header http.Header) time.Duration {
+	age, err := strconv.ParseInt(header.Get("Age"), 10, 64)
+	if err != nil || age < 0 {
+		return 0
+	}
+	return time.Duration(age) * time.Second
+}
+
+// lifetime is how long e is fresh after it was generated
+func (p *policy) lifetime(e *entry) time.Duration {
+	cc := parseCacheControl(e.header)
+	if p.options.shared {
+		if maxAge, ok := cc.seconds("s-maxage"); ok {
+			return maxAge
+		}
+	}
+	if maxAge, ok := cc.seconds("max-age"); ok {
+		return maxAge
+	}
+	date, err := http.ParseTime(e.header.Get("Date"))
+	if err != nil {
+		date = e.responseTime
+	}
+	if expires := e.header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		return t.Sub(date)
+	}
+	if !heuristicStatus[e.status] {
+		return 0
+	}
+	if lastModified, err := http.ParseTime(e.header.Get("Last-Modified")); err == nil && date.After(lastModified) {
+		lifetime := date.Sub(lastModified) / heuristicFraction
+		if lifetime > heuristicMaxLifetime {
+			lifetime = heuristicMaxLifetime
+		}
+		return lifetime
+	}
+	return 0
+}
+
+func (e *entry) age(now time.Time) time.Duration {
+	return e.initialAge + now.Sub(e.responseTime)
+}
+
+// fresh reports whether e may be served for r without asking the origin
+func (p *policy) fresh(e *entry, r *http.Request, now time.Time) bool {
+	reqCC, respCC := parseCacheControl(r.Header), parseCacheControl(e.header)
+	if reqCC.has("no-cache") || respCC.has("no-cache") {
+		return false
+	}
+	if len(reqCC) == 0 && r.Header.Get("Pragma") == "no-cache" {
+		return false
+	}
+	age := e.age(now)
+	if maxAge, ok := reqCC.seconds("max-age"); ok && age > maxAge {
+		return false
+	}
+	return age < p.lifetime(e)
+}
+
+// staleIfError reports whether the stale e may be served because the origin failed
+func (p *policy) staleIfError(e *entry, r *http.Request, now time.Time) bool {
+	reqCC, respCC := parseCacheControl(r.Header), parseCacheControl(e.header)
+	if respCC.has("must-revalidate") || respCC.has("no-cache") ||
+		(p.options.shared && (respCC.has("proxy-revalidate") || respCC.has("s-maxage"))) {
+		return false
+	}
+	window, ok := respCC.seconds("stale-if-error")
+	if reqWindow, reqOk := reqCC.seconds("stale-if-error"); reqOk {
+		window, ok = reqWindow, true
+	}
+	return ok && e.age(now) < p.lifetime(e)+window
+}
+
+// ttl is how long e is worth keeping: while it is fresh, may be served on errors or
+// may be revalidated
+func (p *policy) ttl(e *entry, now time.Time) time.Duration {
+	ttl := p.lifetime(e) - e.age(now)
+	if ttl < 0 {
+		ttl = 0
+	}
+	if window, ok := parseCacheControl(e.header).seconds("stale-if-error"); ok {
+		ttl += window
+	}
+	if hasValidators(e.header) {
+		ttl += p.options.keepStale
+	}
+	return ttl
+}
+
+// conditional returns a copy of r asking the origin whether e is still current
+func conditional(r *http.Request, e *entry) *http.Request {
+	out := r.Clone(r.Context())
+	out.Header.Del("If-None-Match")
+	out.Header.Del("If-Modified-Since")
+	if etag := e.header.Get("ETag"); etag != "" {
+		out.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := e.header.Get("Last-Modified"); lastModified != "" {
+		out.Header.Set("If-Modified-Since", lastModified)
+	}
+	return out
+}
+
+// revalidated returns e updated with the headers of the 304 response confirming it
+func revalidated(e *entry, header http.Header, now time.Time) *entry {
+	merged := e.header.Clone()
+	for name, values := range header {
+		switch name {
+		case "Content-Length", "Content-Type", "Content-Encoding", "Content-Range", cacheStatusHeader:
+			continue
+		}
+		merged[name] = values
+	}
+	for _, name := range hopByHop {
+		merged.Del(name)
+	}
+	return &entry{status: e.status, header: merged, body: e.body, responseTime: now, initialAge: ageOf(header)}
+}
+
+func serverError(status int) bool {
+	switch status {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// unsafeMethod reports whether method may change the resource, so a successful response
+// invalidates the stored ones
+func unsafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	return true
+}
+
+// notModified reports whether the conditional request r is satisfied by the response
+// with header, so a 304 can be sent instead
+func notModified(r *http.Request, header http.Header) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := strings.TrimPrefix(header.Get("ETag"), "W/")
+		if etag == "" {
+			return false
+		}
+		for _, tag := range splitList(inm) {
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(header.Get("Last-Modified"))
+	return err == nil && !lastModified.After(ims)
+}
+
+// servedHeader returns a copy of the header of e as served at now
+func (e *entry) servedHeader(now time.Time, status string) http.Header {
+	header := e.header.Clone()
+	header.Set("Age", strconv.FormatInt(int64(e.age(now)/time.Second), 10))
+	header.Set(cacheStatusHeader, status)
+	return header
+}
@@ -0,0 +1,127 @@
+package httpcache
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	localcache "github.com/asong2020/go-localcache"
+)
+
+// Transport is a RoundTripper answering requests from cache when it can, and caching
+// the responses of the RoundTripper it wraps otherwise
+type Transport struct {
+	policy
+}
+
+// NewTransport creates a Transport storing its responses in cache. It acts as a private
+// cache unless SetShared is given.
+func NewTransport(cache localcache.ICache, opts ...Opt) *Transport {
+	return &Transport{policy{cache: cache, options: newOptions(opts)}}
+}
+
+// Client returns an http.Client using the Transport
+func (t *Transport) Client() *http.Client {
+	return &http.Client{Transport: t}
+}
+
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	next := t.options.roundTripper
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		resp, err := next.RoundTrip(r)
+		if err == nil && unsafeMethod(r.Method) && resp.StatusCode < http.StatusBadRequest {
+			t.invalidate(r)
+		}
+		return resp, err
+	}
+	if parseCacheControl(r.Header).has("no-store") {
+		return next.RoundTrip(r)
+	}
+
+	now := time.Now()
+	e := t.lookup(r)
+	if e != nil && t.fresh(e, r, now) {
+		return e.response(r, statusHit, now), nil
+	}
+	upstream := r
+	// a request that is already conditional is the caller's business, its 304 is returned
+	if e != nil && hasValidators(e.header) &&
+		r.Header.Get("If-None-Match") == "" && r.Header.Get("If-Modified-Since") == "" {
+		upstream = conditional(r, e)
+	}
+	resp, err := next.RoundTrip(upstream)
+	now = time.Now()
+	if err != nil {
+		if e != nil && t.staleIfError(e, r, now) {
+			return e.response(r, statusStale, now), nil
+		}
+		return nil, err
+	}
+	switch {
+	case e != nil && upstream != r && resp.StatusCode == http.StatusNotModified:
+		discard(resp.Body)
+		e = revalidated(e, resp.Header, now)
+		t.store(r, e, now)
+		return e.response(r, statusRevalidated, now), nil
+	case e != nil && serverError(resp.StatusCode) && t.staleIfError(e, r, now):
+		discard(resp.Body)
+		return e.response(r, statusStale, now), nil
+	case r.Method == http.MethodGet && t.storable(r, resp.StatusCode, resp.Header):
+		return t.storeResponse(r, resp, now)
+	}
+	if e != nil && r.Method == http.MethodGet && resp.StatusCode != http.StatusNotModified {
+		t.invalidate(r)
+	}
+	resp.Header.Set(cacheStatusHeader, statusMiss)
+	return resp, nil
+}
+
+// storeResponse reads the body of resp and stores it, unless it is larger than the
+// maximum body size; either way resp is returned with its whole body
+func (t *Transport) storeResponse(r *http.Request, resp *http.Response, now time.Time) (*http.Response, error) {
+	resp.Header.Set(cacheStatusHeader, statusMiss)
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(t.options.maxBodySize)+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if len(body) > t.options.maxBodySize {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	t.store(r, newEntry(resp.StatusCode, resp.Header, body, now), now)
+	return resp, nil
+}
+
+// response returns e as the response to r
+func (e *entry) response(r *http.Request, status string, now time.Time) *http.Response {
+	body := e.body
+	if r.Method == http.MethodHead {
+		body = nil
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.status, http.StatusText(e.status)),
+		StatusCode:    e.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.servedHeader(now, status),
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(e.body)),
+		Request:       r,
+	}
+}
+
+// discard drains and closes body so the connection can be reused
+func discard(body io.ReadCloser) {
+	io.Copy(ioutil.Discard, io.LimitReader(body, 64*1024))
+	body.Close()
+}
@@ -0,0 +1,191 @@
+package httpcache
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	localcache "github.com/asong2020/go-localcache"
+)
+
+type roundTripperFunc func(r *http.Request) (*http.Response, error)
+
+func (fn roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return fn(r)
+}
+
+type transportTestSuite struct {
+	suite.Suite
+	cache localcache.ICache
+	// calls counts the requests reaching the server
+	calls int64
+}
+
+func TestTransportTestSuite(t *testing.T) {
+	suite.Run(t, new(transportTestSuite))
+}
+
+func (t *transportTestSuite) SetupTest() {
+	cache, err := localcache.NewCache(localcache.SetShardCount(4))
+	assert.Equal(t.T(), nil, err)
+	t.cache = cache
+	atomic.StoreInt64(&t.calls, 0)
+}
+
+func (t *transportTestSuite) TearDownTest() {
+	t.cache.Close()
+}
+
+func (t *transportTestSuite) server(fn http.HandlerFunc) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&t.calls, 1)
+		fn(w, r)
+	}))
+}
+
+func (t *transportTestSuite) get(client *http.Client, url string, header map[string]string) (*http.Response, string) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	assert.Equal(t.T(), nil, err)
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	resp, err := client.Do(req)
+	if !assert.Equal(t.T(), nil, err) {
+		return nil, ""
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.Equal(t.T(), nil, err)
+	return resp, string(body)
+}
+
+func (t *transportTestSuite) TestMaxAge() {
+	server := t.server(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, "hello")
+	})
+	defer server.Close()
+	client := NewTransport(t.cache).Client()
+
+	resp, body := t.get(client, server.URL+"/asong", nil)
+	assert.Equal(t.T(), statusMiss, resp.Header.Get(cacheStatusHeader))
+	assert.Equal(t.T(), "hello", body)
+	resp, body = t.get(client, server.URL+"/asong", nil)
+	assert.Equal(t.T(), http.StatusOK, resp.StatusCode)
+	assert.Equal(t.T(), statusHit, resp.Header.Get(cacheStatusHeader))
+	assert.Equal(t.T(), "hello", body)
+	assert.Equal(t.T(), int64(1), atomic.LoadInt64(&t.calls))
+
+	resp, err := client.Post(server.URL+"/asong", "text/plain", strings.NewReader("update"))
+	assert.Equal(t.T(), nil, err)
+	resp.Body.Close()
+	resp, _ = t.get(client, server.URL+"/asong", nil)
+	assert.Equal(t.T(), statusMiss, resp.Header.Get(cacheStatusHeader))
+}
+
+func (t *transportTestSuite) TestExpires() {
+	server := t.server(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now().UTC()
+		w.Header().Set("Date", now.Format(http.TimeFormat))
+		w.Header().Set("Expires", now.Add(time.Minute).Format(http.TimeFormat))
+		fmt.Fprint(w, "hello")
+	})
+	defer server.Close()
+	client := NewTransport(t.cache).Client()
+	t.get(client, server.URL, nil)
+	resp, _ := t.get(client, server.URL, nil)
+	assert.Equal(t.T(), statusHit, resp.Header.Get(cacheStatusHeader))
+}
+
+func (t *transportTestSuite) TestPrivate() {
+	server := t.server(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "private, max-age=60")
+		fmt.Fprint(w, "hello")
+	})
+	defer server.Close()
+
+	client := NewTransport(t.cache).Client()
+	t.get(client, server.URL, nil)
+	resp, _ := t.get(client, server.URL, nil)
+	assert.Equal(t.T(), statusHit, resp.Header.Get(cacheStatusHeader))
+
+	assert.Equal(t.T(), nil, t.cache.Clear())
+	client = NewTransport(t.cache, SetShared(true)).Client()
+	t.get(client, server.URL, nil)
+	resp, _ = t.get(client, server.URL, nil)
+	assert.Equal(t.T(), statusMiss, resp.Header.Get(cacheStatusHeader))
+}
+
+func (t *transportTestSuite) TestRevalidation() {
+	server := t.server(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("ETag", `W/"v1"`)
+		if r.Header.Get("If-None-Match") == `W/"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprint(w, "hello")
+	})
+	defer server.Close()
+	client := NewTransport(t.cache).Client()
+
+	t.get(client, server.URL, nil)
+	resp, body := t.get(client, server.URL, nil)
+	assert.Equal(t.T(), http.StatusOK, resp.StatusCode)
+	assert.Equal(t.T(), statusRevalidated, resp.Header.Get(cacheStatusHeader))
+	assert.Equal(t.T(), "hello", body)
+
+	// a request made conditional by the caller gets the 304 of the server
+	resp, body = t.get(client, server.URL, map[string]string{"If-None-Match": `W/"v1"`})
+	assert.Equal(t.T(), http.StatusNotModified, resp.StatusCode)
+	assert.Equal(t.T(), "", body)
+	assert.Equal(t.T(), int64(3), atomic.LoadInt64(&t.calls))
+}
+
+func (t *transportTestSuite) TestStaleIfError() {
+	var fail int32
+	next := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if atomic.LoadInt32(&fail) == 1 {
+			return nil, errors.New("connection refused")
+		}
+		return http.DefaultTransport.RoundTrip(r)
+	})
+	server := t.server(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=0, stale-if-error=60")
+		fmt.Fprint(w, "hello")
+	})
+	defer server.Close()
+	client := NewTransport(t.cache, SetRoundTripper(next)).Client()
+
+	t.get(client, server.URL, nil)
+	atomic.StoreInt32(&fail, 1)
+	resp, body := t.get(client, server.URL, nil)
+	assert.Equal(t.T(), statusStale, resp.Header.Get(cacheStatusHeader))
+	assert.Equal(t.T(), "hello", body)
+
+	_, err := client.Get(server.URL + "/missing")
+	assert.NotEqual(t.T(), nil, err)
+}
+
+func (t *transportTestSuite) TestLargeBody() {
+	payload := strings.Repeat("x", 100)
+	server := t.server(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, payload)
+	})
+	defer server.Close()
+	client := NewTransport(t.cache, SetMaxBodySize(64)).Client()
+	_, body := t.get(client, server.URL, nil)
+	assert.Equal(t.T(), payload, body)
+	resp, body := t.get(client, server.URL, nil)
+	assert.Equal(t.T(), statusMiss, resp.Header.Get(cacheStatusHeader))
+	assert.Equal(t.T(), payload, body)
+}
@@ -8,10 +8,36 @@ type ICache interface {
 	Set(key string, value []byte) error
 	// Get value if find it. if value already expire will delete.
 	Get(key string) ([]byte, error)
+	// View calls fn with the value of key under the segment read lock, without copying
+	// values stored raw. fn must neither keep nor modify value and must not call the cache.
+	View(key string, fn func(value []byte) error) error
+	// AppendTo appends the value of key to dst and returns the extended slice
+	AppendTo(dst []byte, key string) ([]byte, error)
 	// SetWithTime set value with expire time
 	SetWithTime(key string, value []byte, expired time.Duration) error
+	// SetWithCost set value with expire time and a cost, in place of the one given by the
+	// weigher. Eviction keeps the costly entries longer and SetMaxCost bounds the total cost.
+	// The cost survives a restart through the append only file, but not through the mmap files.
+	SetWithCost(key string, value []byte, expired time.Duration, cost int64) error
+	// Expire sets a new expire time on an existing key
+	Expire(key string, expired time.Duration) error
+	// TTL returns the remaining time to live of key, ErrEntryNotFound if it is missing or expired.
+	TTL(key string) (time.Duration, error)
 	// Delete manual removes the key
 	Delete(key string) error
+	// Evict removes the key from memory and from the disk tier only, neither the store nor
+	// the append only file see it. It drops a copy another replica changed.
+	Evict(key string) error
+	// Scan returns the keys of up to count live entries held in memory, starting at cursor,
+	// and the cursor to continue from, 0 once the whole cache was visited. Start with cursor 0.
+	// Keys present for the whole iteration are returned at least once.
+	Scan(cursor uint64, count int) ([]string, uint64)
+	// Snapshot calls fn with every live entry, from memory and from the disk tier. It holds
+	// the lock of one segment at a time, so fn must not call the cache. Entries written
+	// during the snapshot may or may not be part of it.
+	Snapshot(fn func(key string, value []byte, expireAt time.Time) error) error
+	// Clear removes every entry, from memory and from the disk tier. Statistics are kept.
+	Clear() error
 	// Len computes number of entries in cache
 	Len() int
 	// Capacity returns amount of bytes store in the cache.
@@ -20,8 +46,18 @@ type ICache interface {
 	// This allows the cleaning goroutines to exit and ensures references are not
 	// kept to the cache preventing GC of the entire cache.
 	Close() error
+	// RewriteAOF compacts the append only file into the live contents of the cache,
+	// ErrAOFDisabled if the cache was created without SetAOF.
+	RewriteAOF() error
 	// Stats returns cache's statistics
 	Stats() Stats
-	// GetKeyHit returns key hit
+	// ResetStats zeroes every statistics counter, the hot keys and the latency histograms
+	ResetStats()
+	// ShardStats returns the statistics of every segment, indexed by segment id
+	ShardStats() []ShardStat
+	// GetKeyHit returns an estimate of the key hit, it may overcount but never undercounts.
 	GetKeyHit(key string) int64
+	// HotKeys returns up to n of the most frequently hit keys, highest count first.
+	// Each segment tracks its own top keys, so counts are estimates.
+	HotKeys(n int) []KeyCount
 }
\ No newline at end of file
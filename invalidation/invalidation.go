@@ -0,0 +1,280 @@
+// Package invalidation keeps the caches of several instances coherent. Every local Set,
+// SetWithTime, Delete and Clear is published over a Transport, and the peers receiving it
+// drop their copy of the key, so their next Get misses and reloads the fresh value.
+package invalidation
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	localcache "github.com/asong2020/go-localcache"
+)
+
+// replayWindow is the number of recent sequence numbers remembered per origin to drop
+// the messages a transport delivers twice
+const replayWindow = 64
+
+// peerExpiry is how long the window of an origin is kept after its last message. Origins
+// change when instances restart, so the windows of the old ones are dropped eventually.
+const peerExpiry = time.Hour
+
+type options struct {
+	origin  string
+	onError func(err error)
+}
+
+// Opt configures a Cache
+type Opt func(options *options)
+
+// SetOrigin sets the id of the instance, a random one by default. Ids must be unique in
+// the fleet and should change when an instance restarts.
+func SetOrigin(origin string) Opt {
+	return func(opt *options) {
+		opt.origin = origin
+	}
+}
+
+// SetErrorHandler receives the errors of publishing, which do not fail the local write
+func SetErrorHandler(fn func(err error)) Opt {
+	return func(opt *options) {
+		opt.onError = fn
+	}
+}
+
+// Stats counts the messages of a Cache
+type Stats struct {
+	// Published is the number of messages published
+	Published int64
+	// PublishErrors is the number of messages the transport failed to publish
+	PublishErrors int64
+	// Received is the number of messages received from the peers
+	Received int64
+	// Applied is the number of received messages that invalidated the local cache
+	Applied int64
+	// Echoes is the number of own messages delivered back by the transport
+	Echoes int64
+	// Duplicates is the number of messages received more than once
+	Duplicates int64
+	// Gaps is the number of sequence numbers skipped by the received messages, a hint
+	// of lost messages
+	Gaps int64
+	// Invalid is the number of payloads that could not be decoded
+	Invalid int64
+}
+
+// Cache wraps a cache and publishes its local writes as invalidations. The methods not
+// listed here are served by the wrapped cache unchanged.
+type Cache struct {
+	localcache.ICache
+	transport Transport
+	origin    string
+	onError   func(err error)
+	seq       uint64
+
+	mu    sync.Mutex
+	peers map[string]*window
+	stats Stats
+}
+
+// window tracks the recent sequence numbers received from an origin
+type window struct {
+	// first is the first sequence number received, gaps are only counted after it
+	first   uint64
+	highest uint64
+	// seen has bit i set when highest-i was received
+	seen     uint64
+	lastSeen time.Time
+}
+
+// New wraps cache and subscribes to transport. Closing the returned cache closes the
+// transport as well.
+func New(cache localcache.ICache, transport Transport, opts ...Opt) *Cache {
+	options := &options{}
+	for _, each := range opts {
+		each(options)
+	}
+	if options.origin == "" {
+		options.origin = randomOrigin()
+	}
+	c := &Cache{
+		ICache:    cache,
+		transport: transport,
+		origin:    options.origin,
+		onError:   options.onError,
+		peers:     make(map[string]*window),
+	}
+	transport.Subscribe(c.receive)
+	return c
+}
+
+func randomOrigin() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return time.Now().Format(time.RFC3339Nano)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// Origin returns the id of the instance
+func (c *Cache) Origin() string {
+	return c.origin
+}
+
+func (c *Cache) Set(key string, value []byte) error {
+	if err := c.ICache.Set(key, value); err != nil {
+		return err
+	}
+	c.publish(OpDelete, key)
+	return nil
+}
+
+func (c *Cache) SetWithTime(key string, value []byte, expired time.Duration) error {
+	if err := c.ICache.SetWithTime(key, value, expired); err != nil {
+		return err
+	}
+	c.publish(OpDelete, key)
+	return nil
+}
+
+func (c *Cache) SetWithCost(key string, value []byte, expired time.Duration, cost int64) error {
+	if err := c.ICache.SetWithCost(key, value, expired, cost); err != nil {
+		return err
+	}
+	c.publish(OpDelete, key)
+	return nil
+}
+
+// Delete publishes the invalidation even when the key is not stored locally, as the peers
+// may hold it
+func (c *Cache) Delete(key string) error {
+	err := c.ICache.Delete(key)
+	c.publish(OpDelete, key)
+	return err
+}
+
+func (c *Cache) Clear() error {
+	if err := c.ICache.Clear(); err != nil {
+		return err
+	}
+	c.publish(OpClear, "")
+	return nil
+}
+
+// Close closes the transport and the wrapped cache
+func (c *Cache) Close() error {
+	err := c.transport.Close()
+	if cacheErr := c.ICache.Close(); err == nil {
+		err = cacheErr
+	}
+	return err
+}
+
+// InvalidationStats returns the message counters. Stats still returns the statistics of
+// the wrapped cache.
+func (c *Cache) InvalidationStats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+func (c *Cache) publish(op Op, key string) {
+	payload, err := encodeMessage(Message{
+		Origin: c.origin,
+		Seq:    atomic.AddUint64(&c.seq, 1),
+		Op:     op,
+		Key:    key,
+	})
+	if err == nil {
+		err = c.transport.Publish(payload)
+	}
+	c.mu.Lock()
+	if err != nil {
+		c.stats.PublishErrors++
+	} else {
+		c.stats.Published++
+	}
+	c.mu.Unlock()
+	if err != nil && c.onError != nil {
+		c.onError(err)
+	}
+}
+
+func (c *Cache) receive(payload []byte) {
+	m, err := decodeMessage(payload)
+	c.mu.Lock()
+	if err != nil {
+		c.stats.Invalid++
+		c.mu.Unlock()
+		return
+	}
+	c.stats.Received++
+	if m.Origin == c.origin {
+		c.stats.Echoes++
+		c.mu.Unlock()
+		return
+	}
+	if !c.accept(m) {
+		c.stats.Duplicates++
+		c.mu.Unlock()
+		return
+	}
+	c.stats.Applied++
+	c.mu.Unlock()
+
+	// the wrapped cache is called directly so the invalidation is not published again. The
+	// key is only evicted, the publisher already wrote the store.
+	switch m.Op {
+	case OpDelete:
+		_ = c.ICache.Evict(m.Key)
+	case OpClear:
+		_ = c.ICache.Clear()
+	}
+}
+
+// accept records the sequence number of m and reports whether it was not seen before.
+// Messages older than the replay window are accepted, dropping a key twice is harmless.
+func (c *Cache) accept(m Message) bool {
+	now := time.Now()
+	w, ok := c.peers[m.Origin]
+	if !ok {
+		for origin, each := range c.peers {
+			if now.Sub(each.lastSeen) > peerExpiry {
+				delete(c.peers, origin)
+			}
+		}
+		c.peers[m.Origin] = &window{first: m.Seq, highest: m.Seq, seen: 1, lastSeen: now}
+		return true
+	}
+	w.lastSeen = now
+	switch {
+	case m.Seq > w.highest:
+		shift := m.Seq - w.highest
+		if shift > 1 {
+			c.stats.Gaps += int64(shift - 1)
+		}
+		if shift >= replayWindow {
+			w.seen = 0
+		} else {
+			w.seen <<= shift
+		}
+		w.seen |= 1
+		w.highest = m.Seq
+		return true
+	case w.highest-m.Seq >= replayWindow:
+		return true
+	default:
+		bit := uint64(1) << (w.highest - m.Seq)
+		if w.seen&bit != 0 {
+			return false
+		}
+		w.seen |= bit
+		// a late message fills a gap counted before, unless it precedes the first one
+		if m.Seq > w.first {
+			c.stats.Gaps--
+		}
+		return true
+	}
+}
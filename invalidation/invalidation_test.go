@@ -0,0 +1,233 @@
+package invalidation
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	localcache "github.com/asong2020/go-localcache"
+)
+
+type invalidationTestSuite struct {
+	suite.Suite
+}
+
+func TestInvalidationTestSuite(t *testing.T) {
+	suite.Run(t, new(invalidationTestSuite))
+}
+
+func (i *invalidationTestSuite) SetupSuite() {}
+
+func (i *invalidationTestSuite) newCache(transport Transport, opts ...Opt) *Cache {
+	cache, err := localcache.NewCache(localcache.SetShardCount(4))
+	assert.Equal(i.T(), nil, err)
+	return New(cache, transport, opts...)
+}
+
+func (i *invalidationTestSuite) TestHub() {
+	hub := NewHub()
+	a, b := i.newCache(hub.Transport()), i.newCache(hub.Transport())
+	defer a.Close()
+	defer b.Close()
+
+	assert.Equal(i.T(), nil, b.ICache.Set("asong", []byte("stale")))
+	assert.Equal(i.T(), nil, a.Set("asong", []byte("fresh")))
+	_, err := b.Get("asong")
+	assert.Equal(i.T(), localcache.ErrEntryNotFound, err)
+	res, err := a.Get("asong")
+	assert.Equal(i.T(), nil, err)
+	assert.Equal(i.T(), []byte("fresh"), res)
+
+	assert.Equal(i.T(), nil, b.Set("asong", []byte("fresh")))
+	_, err = a.Get("asong")
+	assert.Equal(i.T(), localcache.ErrEntryNotFound, err)
+
+	assert.Equal(i.T(), nil, a.ICache.Set("other", []byte("value")))
+	assert.Equal(i.T(), localcache.ErrEntryNotFound, b.Delete("other"))
+	_, err = a.Get("other")
+	assert.Equal(i.T(), localcache.ErrEntryNotFound, err)
+
+	assert.Equal(i.T(), nil, a.ICache.Set("asong", []byte("value")))
+	assert.Equal(i.T(), nil, b.Clear())
+	assert.Equal(i.T(), 0, a.Len())
+
+	stats := a.InvalidationStats()
+	assert.Equal(i.T(), int64(1), stats.Published)
+	assert.Equal(i.T(), int64(1), stats.Echoes)
+	assert.Equal(i.T(), int64(3), stats.Applied)
+	assert.Equal(i.T(), int64(4), stats.Received)
+
+	assert.Equal(i.T(), nil, a.ICache.Set("costly", []byte("stale")))
+	assert.Equal(i.T(), nil, b.SetWithCost("costly", []byte("fresh"), time.Minute, 10))
+	_, err = a.Get("costly")
+	assert.Equal(i.T(), localcache.ErrEntryNotFound, err)
+}
+
+// mapStore is a Store shared by the replicas of a test
+type mapStore struct {
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+func (s *mapStore) Load(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.data[key]
+	if !ok {
+		return nil, localcache.ErrEntryNotFound
+	}
+	return value, nil
+}
+
+func (s *mapStore) Store(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = append([]byte(nil), value...)
+	return nil
+}
+
+func (s *mapStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, key)
+	return nil
+}
+
+func (i *invalidationTestSuite) TestWriteThroughStore() {
+	store := &mapStore{data: make(map[string][]byte)}
+	hub := NewHub()
+	replicas := make([]*Cache, 2)
+	for index := range replicas {
+		cache, err := localcache.NewCache(localcache.SetShardCount(4), localcache.SetStore(store, localcache.WriteThrough))
+		assert.Equal(i.T(), nil, err)
+		replicas[index] = New(cache, hub.Transport())
+		defer replicas[index].Close()
+	}
+	a, b := replicas[0], replicas[1]
+
+	// the invalidation received by b evicts its copy but leaves the row a wrote
+	assert.Equal(i.T(), nil, b.Set("asong", []byte("stale")))
+	assert.Equal(i.T(), nil, a.Set("asong", []byte("fresh")))
+	value, err := store.Load("asong")
+	assert.Equal(i.T(), nil, err)
+	assert.Equal(i.T(), []byte("fresh"), value)
+	res, err := b.Get("asong")
+	assert.Equal(i.T(), nil, err)
+	assert.Equal(i.T(), []byte("fresh"), res)
+
+	assert.Equal(i.T(), nil, a.Delete("asong"))
+	_, err = store.Load("asong")
+	assert.Equal(i.T(), localcache.ErrEntryNotFound, err)
+	_, err = b.Get("asong")
+	assert.Equal(i.T(), localcache.ErrEntryNotFound, err)
+}
+
+func (i *invalidationTestSuite) TestDuplicatesAndGaps() {
+	c := i.newCache(NewHub().Transport(), SetOrigin("self"))
+	defer c.Close()
+
+	deliver := func(seq uint64) {
+		payload, err := encodeMessage(Message{Origin: "peer", Seq: seq, Op: OpDelete, Key: "asong"})
+		assert.Equal(i.T(), nil, err)
+		c.receive(payload)
+	}
+	for _, seq := range []uint64{1, 2, 2, 5, 3, 3, 200, 5} {
+		deliver(seq)
+	}
+	c.receive([]byte("garbage"))
+	stats := c.InvalidationStats()
+	assert.Equal(i.T(), int64(8), stats.Received)
+	assert.Equal(i.T(), int64(2), stats.Duplicates)
+	// the last 5 is older than the replay window and applied again
+	assert.Equal(i.T(), int64(6), stats.Applied)
+	// 4 and 6 to 199 were never received
+	assert.Equal(i.T(), int64(195), stats.Gaps)
+	assert.Equal(i.T(), int64(1), stats.Invalid)
+}
+
+func (i *invalidationTestSuite) TestLateMessageBeforeFirst() {
+	c := i.newCache(NewHub().Transport(), SetOrigin("self"))
+	defer c.Close()
+
+	for _, seq := range []uint64{5, 3, 7, 6} {
+		payload, err := encodeMessage(Message{Origin: "peer", Seq: seq, Op: OpDelete, Key: "asong"})
+		assert.Equal(i.T(), nil, err)
+		c.receive(payload)
+	}
+	// 3 precedes the first message seen, only the gap of 6 was counted and it is filled
+	stats := c.InvalidationStats()
+	assert.Equal(i.T(), int64(4), stats.Applied)
+	assert.Equal(i.T(), int64(0), stats.Gaps)
+}
+
+// failingTransport fails every publish
+type failingTransport struct{}
+
+func (failingTransport) Publish(payload []byte) error      { return errors.New("unreachable") }
+func (failingTransport) Subscribe(fn func(payload []byte)) {}
+func (failingTransport) Close() error                      { return nil }
+
+func (i *invalidationTestSuite) TestPublishError() {
+	var errs []error
+	c := i.newCache(failingTransport{}, SetErrorHandler(func(err error) {
+		errs = append(errs, err)
+	}))
+	defer c.Close()
+
+	assert.Equal(i.T(), nil, c.Set("asong", []byte("value")))
+	res, err := c.Get("asong")
+	assert.Equal(i.T(), nil, err)
+	assert.Equal(i.T(), []byte("value"), res)
+	assert.Equal(i.T(), 1, len(errs))
+	assert.Equal(i.T(), int64(1), c.InvalidationStats().PublishErrors)
+}
+
+func (i *invalidationTestSuite) TestMessageCodec() {
+	m := Message{Origin: "instance-1", Seq: 42, Op: OpDelete, Key: "公众号：Golang梦工厂"}
+	payload, err := encodeMessage(m)
+	assert.Equal(i.T(), nil, err)
+	res, err := decodeMessage(payload)
+	assert.Equal(i.T(), nil, err)
+	assert.Equal(i.T(), m, res)
+
+	_, err = encodeMessage(Message{Op: OpDelete, Key: string(make([]byte, maxMessageSize))})
+	assert.Equal(i.T(), ErrMessageTooLarge, err)
+	_, err = decodeMessage(payload[:5])
+	assert.Equal(i.T(), errInvalidMessage, err)
+}
+
+func freeUDPAddr(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	defer conn.Close()
+	return conn.LocalAddr().String()
+}
+
+func (i *invalidationTestSuite) TestUDP() {
+	addrA, addrB := freeUDPAddr(i.T()), freeUDPAddr(i.T())
+	peers := []string{addrA, addrB}
+	transportA, err := NewUDPTransport(addrA, peers)
+	assert.Equal(i.T(), nil, err)
+	transportB, err := NewUDPTransport(addrB, peers)
+	assert.Equal(i.T(), nil, err)
+	a, b := i.newCache(transportA), i.newCache(transportB)
+	defer a.Close()
+	defer b.Close()
+
+	assert.Equal(i.T(), nil, b.ICache.Set("asong", []byte("stale")))
+	assert.Equal(i.T(), nil, a.Set("asong", []byte("fresh")))
+	assert.Eventually(i.T(), func() bool {
+		_, err := b.Get("asong")
+		return err == localcache.ErrEntryNotFound
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Eventually(i.T(), func() bool {
+		return a.InvalidationStats().Echoes == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	res, err := a.Get("asong")
+	assert.Equal(i.T(), nil, err)
+	assert.Equal(i.T(), []byte("fresh"), res)
+}
@@ -0,0 +1,73 @@
+package invalidation
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	messageVersion = 1
+	// maxMessageSize keeps a message within a single UDP datagram
+	maxMessageSize = 65507
+)
+
+// Op is the kind of an invalidation
+type Op byte
+
+const (
+	// OpDelete drops a single key
+	OpDelete Op = iota + 1
+	// OpClear drops every key
+	OpClear
+)
+
+var (
+	// ErrMessageTooLarge is returned when a key does not fit in a single message
+	ErrMessageTooLarge = errors.New("invalidation: message too large")
+	errInvalidMessage  = errors.New("invalidation: invalid message")
+)
+
+// Message is an invalidation published by the instance Origin. Seq increases by one with
+// every message of an origin.
+type Message struct {
+	Origin string
+	Seq    uint64
+	Op     Op
+	Key    string
+}
+
+// encodeMessage renders m as version | op | seq | uvarint(len(origin)) | origin | key
+func encodeMessage(m Message) ([]byte, error) {
+	res := make([]byte, 2+8+binary.MaxVarintLen64+len(m.Origin)+len(m.Key))
+	res[0] = messageVersion
+	res[1] = byte(m.Op)
+	binary.LittleEndian.PutUint64(res[2:], m.Seq)
+	n := 10 + binary.PutUvarint(res[10:], uint64(len(m.Origin)))
+	n += copy(res[n:], m.Origin)
+	n += copy(res[n:], m.Key)
+	if n > maxMessageSize {
+		return nil, ErrMessageTooLarge
+	}
+	return res[:n], nil
+}
+
+func decodeMessage(payload []byte) (Message, error) {
+	if len(payload) < 11 || payload[0] != messageVersion {
+		return Message{}, errInvalidMessage
+	}
+	m := Message{
+		Op:  Op(payload[1]),
+		Seq: binary.LittleEndian.Uint64(payload[2:]),
+	}
+	if m.Op != OpDelete && m.Op != OpClear {
+		return Message{}, errInvalidMessage
+	}
+	rest := payload[10:]
+	length, n := binary.Uvarint(rest)
+	if n <= 0 || uint64(len(rest)-n) < length {
+		return Message{}, errInvalidMessage
+	}
+	m.Origin = string(rest[n : n+int(length)])
+	m.Key = string(rest[n+int(length):])
+	return m, nil
+}
@@ -0,0 +1,69 @@
+package invalidation
+
+import "sync"
+
+// Transport carries the encoded messages between the instances
+type Transport interface {
+	// Publish sends payload to every peer. A transport may deliver it back to the
+	// sender, echoes are dropped by the receiving Cache.
+	Publish(payload []byte) error
+	// Subscribe sets the function receiving the payloads published by the peers. It is
+	// called once, before the first Publish. fn must not retain payload.
+	Subscribe(fn func(payload []byte))
+	// Close stops the transport
+	Close() error
+}
+
+// Hub connects in-process transports to each other, for tests and for several caches
+// living in the same process
+type Hub struct {
+	mu         sync.RWMutex
+	transports map[*hubTransport]struct{}
+}
+
+// NewHub creates an empty hub
+func NewHub() *Hub {
+	return &Hub{transports: make(map[*hubTransport]struct{})}
+}
+
+// Transport returns a new transport connected to the hub. Published payloads are delivered
+// synchronously to every transport of the hub, the sender included.
+func (h *Hub) Transport() Transport {
+	t := &hubTransport{hub: h}
+	h.mu.Lock()
+	h.transports[t] = struct{}{}
+	h.mu.Unlock()
+	return t
+}
+
+type hubTransport struct {
+	hub *Hub
+	mu  sync.RWMutex
+	fn  func(payload []byte)
+}
+
+func (t *hubTransport) Publish(payload []byte) error {
+	t.hub.mu.RLock()
+	defer t.hub.mu.RUnlock()
+	for peer := range t.hub.transports {
+		peer.mu.RLock()
+		if peer.fn != nil {
+			peer.fn(payload)
+		}
+		peer.mu.RUnlock()
+	}
+	return nil
+}
+
+func (t *hubTransport) Subscribe(fn func(payload []byte)) {
+	t.mu.Lock()
+	t.fn = fn
+	t.mu.Unlock()
+}
+
+func (t *hubTransport) Close() error {
+	t.hub.mu.Lock()
+	delete(t.hub.transports, t)
+	t.hub.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,96 @@
+package invalidation
+
+import (
+	"net"
+	"sync"
+)
+
+// udpTransport sends every payload as a datagram to a list of peers or a multicast group
+type udpTransport struct {
+	conn  *net.UDPConn
+	peers []*net.UDPAddr
+	// sender is the socket used to publish to a multicast group, nil for unicast
+	sender *net.UDPConn
+	once   sync.Once
+}
+
+// NewUDPTransport listens on the UDP address listen and publishes to every peer address.
+// The peer list may include the address of the instance itself.
+func NewUDPTransport(listen string, peers []string) (Transport, error) {
+	addr, err := net.ResolveUDPAddr("udp", listen)
+	if err != nil {
+		return nil, err
+	}
+	resolved := make([]*net.UDPAddr, 0, len(peers))
+	for _, peer := range peers {
+		peerAddr, err := net.ResolveUDPAddr("udp", peer)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, peerAddr)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &udpTransport{conn: conn, peers: resolved}, nil
+}
+
+// NewMulticastTransport joins the multicast group address, such as 239.1.2.3:7946, on
+// iface, nil for the system default, and publishes to the group. Every member of the
+// group, the sender included, receives the payloads.
+func NewMulticastTransport(group string, iface *net.Interface) (Transport, error) {
+	addr, err := net.ResolveUDPAddr("udp", group)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenMulticastUDP("udp", iface, addr)
+	if err != nil {
+		return nil, err
+	}
+	sender, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &udpTransport{conn: conn, sender: sender}, nil
+}
+
+func (t *udpTransport) Publish(payload []byte) error {
+	if t.sender != nil {
+		_, err := t.sender.Write(payload)
+		return err
+	}
+	var res error
+	for _, peer := range t.peers {
+		if _, err := t.conn.WriteToUDP(payload, peer); err != nil && res == nil {
+			res = err
+		}
+	}
+	return res
+}
+
+func (t *udpTransport) Subscribe(fn func(payload []byte)) {
+	go func() {
+		buf := make([]byte, maxMessageSize)
+		for {
+			n, _, err := t.conn.ReadFromUDP(buf)
+			if err != nil {
+				// the socket was closed
+				return
+			}
+			fn(buf[:n])
+		}
+	}()
+}
+
+func (t *udpTransport) Close() error {
+	var err error
+	t.once.Do(func() {
+		err = t.conn.Close()
+		if t.sender != nil {
+			t.sender.Close()
+		}
+	})
+	return err
+}
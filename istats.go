@@ -1,15 +1,39 @@
 package localcache
 
+import "time"
+
 type IStats interface {
 	miss()
 	delHit()
 	delMiss()
 	collision()
-	hit(key string)
+	evict()
+	expireLazy()
+	expireCleanup()
+	set(size int, overwrite bool)
+	values(raw int, stored int)
+	l2Hit()
+	l2Miss()
+	lockWait(wait time.Duration)
+	hit(key string, hashKey uint64)
 	getMisses() int64
 	getDelHits() int64
 	getDelMisses() int64
 	getCollisions() int64
+	getEvictions() int64
+	getExpirations() int64
+	getExpiredLazy() int64
+	getExpiredCleanup() int64
+	getSets() int64
+	getOverwrites() int64
+	getBytesIn() int64
+	getRawValueBytes() int64
+	getStoredValueBytes() int64
+	getL2Hits() int64
+	getL2Misses() int64
+	getLockWait() time.Duration
 	getHits() int64
-	getKeyHits(key string) int64
+	getKeyHits(hashKey uint64) int64
+	getHotKeys() []KeyCount
+	reset()
 }
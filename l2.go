@@ -0,0 +1,351 @@
+package localcache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	l2FileName        = "l2.data"
+	l2CompactFileName = "l2.compact"
+	// l2CompactMinGarbage is the amount of dead records below which compaction is not worth it
+	l2CompactMinGarbage = 1024 * 1024
+	// l2CompactGarbageRatio is the share of dead records that triggers a compaction
+	l2CompactGarbageRatio = 0.5
+)
+
+// l2Pending is a demoted entry not written yet
+type l2Pending struct {
+	value    []byte
+	expireAt uint64
+	cost     int64
+}
+
+// errL2Full is returned when demoting an entry would exceed the L2 byte budget
+var errL2Full = errors.New("l2 store is full")
+
+// l2Location is where a record lives in the data file
+type l2Location struct {
+	offset   int64
+	length   int
+	expireAt uint64
+}
+
+// diskStore is the second tier of the cache. Entries evicted from memory are appended
+// to a single data file, with their cost, and found again through an in-memory index. Removed and
+// overwritten records stay in the file as garbage until a compaction copies the live
+// records into a fresh file. The store starts empty every time the cache is created.
+//
+// Evictions happen under a segment write lock, so demoted entries are only queued as
+// pending there; flush writes them and compacts the file once the lock is released.
+// Pending entries are found, taken and deleted like the written ones.
+type diskStore struct {
+	mu       sync.Mutex
+	dir      string
+	file     *os.File
+	writer   *bufio.Writer
+	size     int64
+	live     int64
+	maxBytes int64
+	index    map[string]l2Location
+	// pending holds the demoted entries waiting for flush
+	pending map[string]l2Pending
+	// sealer encrypts the stored values, nil stores them in clear
+	sealer *sealer
+}
+
+func openDiskStore(dir string, maxBytes int64) (*diskStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, l2FileName), os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &diskStore{
+		dir:      dir,
+		file:     file,
+		writer:   bufio.NewWriter(file),
+		maxBytes: maxBytes,
+		index:    make(map[string]l2Location),
+		pending:  make(map[string]l2Pending),
+	}, nil
+}
+
+// queue records a demoted entry to be written by the next flush, replacing any previous
+// record of key. It does no I/O, so it can be called under a segment lock.
+func (d *diskStore) queue(key string, value []byte, expireAt uint64, cost int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pending[key] = l2Pending{value: value, expireAt: expireAt, cost: cost}
+}
+
+// flush writes the pending entries, dropping those that do not fit the budget, and
+// compacts the file when it is worth it
+func (d *diskStore) flush() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, pending := range d.pending {
+		delete(d.pending, key)
+		_ = d.putLocked(key, pending.value, pending.expireAt, pending.cost)
+	}
+	_ = d.maybeCompact()
+}
+
+// put appends an entry, replacing any previous record of key
+func (d *diskStore) put(key string, value []byte, expireAt uint64, cost int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.pending, key)
+	if err := d.putLocked(key, value, expireAt, cost); err != nil {
+		return err
+	}
+	return d.maybeCompact()
+}
+
+func (d *diskStore) putLocked(key string, value []byte, expireAt uint64, cost int64) error {
+	if d.sealer != nil {
+		sealed, err := d.sealer.seal(key, value)
+		if err != nil {
+			return err
+		}
+		value = sealed
+	}
+	record := encodeAOFCostRecord(aofOpCostSet, key, value, expireAt, cost)
+	previous, replace := d.index[key]
+	live := d.live + int64(len(record))
+	if replace {
+		live -= int64(previous.length)
+	}
+	if d.maxBytes > 0 && live > d.maxBytes {
+		return errL2Full
+	}
+	if _, err := d.writer.Write(record); err != nil {
+		return err
+	}
+	d.index[key] = l2Location{offset: d.size, length: len(record), expireAt: expireAt}
+	d.size += int64(len(record))
+	d.live = live
+	return nil
+}
+
+// take returns the value, the expire timestamp and the cost of key and removes it from
+// the store, as promoted entries live in memory again
+func (d *diskStore) take(key string, now int64) ([]byte, uint64, int64, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if pending, ok := d.pending[key]; ok {
+		delete(d.pending, key)
+		if location, ok := d.index[key]; ok {
+			d.removeLocked(key, location)
+		}
+		if now-int64(pending.expireAt) >= 0 {
+			return nil, 0, 0, false
+		}
+		return pending.value, pending.expireAt, pending.cost, true
+	}
+	location, ok := d.index[key]
+	if !ok {
+		return nil, 0, 0, false
+	}
+	if now-int64(location.expireAt) >= 0 {
+		d.removeLocked(key, location)
+		return nil, 0, 0, false
+	}
+	record, err := d.readLocked(location)
+	d.removeLocked(key, location)
+	if err != nil || record.key != key {
+		return nil, 0, 0, false
+	}
+	value, err := d.open(key, record.value)
+	if err != nil {
+		return nil, 0, 0, false
+	}
+	return value, record.expireAt, record.cost, true
+}
+
+// expireAt returns the expire timestamp of key without removing it
+func (d *diskStore) expireAt(key string, now int64) (uint64, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if pending, ok := d.pending[key]; ok {
+		return pending.expireAt, now-int64(pending.expireAt) < 0
+	}
+	location, ok := d.index[key]
+	if !ok || now-int64(location.expireAt) >= 0 {
+		return 0, false
+	}
+	return location.expireAt, true
+}
+
+// delete drops key and reports whether it was stored
+func (d *diskStore) delete(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, queued := d.pending[key]
+	delete(d.pending, key)
+	location, ok := d.index[key]
+	if !ok {
+		return queued
+	}
+	d.removeLocked(key, location)
+	return true
+}
+
+// removeLocked drops the record of key from the index, the file is compacted by flush
+func (d *diskStore) removeLocked(key string, location l2Location) {
+	delete(d.index, key)
+	d.live -= int64(location.length)
+}
+
+func (d *diskStore) readLocked(location l2Location) (aofRecord, error) {
+	if err := d.writer.Flush(); err != nil {
+		return aofRecord{}, err
+	}
+	buf := make([]byte, location.length)
+	if _, err := d.file.ReadAt(buf, location.offset); err != nil {
+		return aofRecord{}, err
+	}
+	body := buf[aofRecordHeaderSize:]
+	if crc32.Checksum(body, aofCRCTable) != binary.LittleEndian.Uint32(buf) {
+		return aofRecord{}, errAOFRecordCorrupted
+	}
+	return decodeAOFBody(body)
+}
+
+// maybeCompact rewrites the data file once dead records dominate it
+func (d *diskStore) maybeCompact() error {
+	garbage := d.size - d.live
+	if garbage < l2CompactMinGarbage || float64(garbage) < float64(d.size)*l2CompactGarbageRatio {
+		return nil
+	}
+	return d.compactLocked(time.Now().Unix())
+}
+
+// compactLocked copies the live, unexpired records into a new file and swaps it in
+func (d *diskStore) compactLocked(now int64) error {
+	path := filepath.Join(d.dir, l2CompactFileName)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	index := make(map[string]l2Location, len(d.index))
+	size := int64(0)
+	for key, location := range d.index {
+		if now-int64(location.expireAt) >= 0 {
+			continue
+		}
+		record, err := d.readLocked(location)
+		if err != nil {
+			continue
+		}
+		encoded := encodeAOFCostRecord(aofOpCostSet, key, record.value, record.expireAt, record.cost)
+		if _, err := writer.Write(encoded); err != nil {
+			file.Close()
+			os.Remove(path)
+			return err
+		}
+		index[key] = l2Location{offset: size, length: len(encoded), expireAt: record.expireAt}
+		size += int64(len(encoded))
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := os.Rename(path, filepath.Join(d.dir, l2FileName)); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	d.file.Close()
+	d.file = file
+	d.writer = bufio.NewWriter(file)
+	d.index = index
+	d.size = size
+	d.live = size
+	return nil
+}
+
+// dump calls fn with every live entry of the store
+func (d *diskStore) dump(now int64, fn func(key string, value []byte, expireAt uint64, cost int64) error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, pending := range d.pending {
+		if now-int64(pending.expireAt) >= 0 {
+			continue
+		}
+		if err := fn(key, pending.value, pending.expireAt, pending.cost); err != nil {
+			return err
+		}
+	}
+	for key, location := range d.index {
+		if _, ok := d.pending[key]; ok || now-int64(location.expireAt) >= 0 {
+			continue
+		}
+		record, err := d.readLocked(location)
+		if err != nil {
+			continue
+		}
+		value, err := d.open(key, record.value)
+		if err != nil {
+			continue
+		}
+		if err := fn(key, value, record.expireAt, record.cost); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// open returns the value stored for key, opening it when the store is sealed
+func (d *diskStore) open(key string, value []byte) ([]byte, error) {
+	if d.sealer == nil {
+		return value, nil
+	}
+	return d.sealer.open(key, value)
+}
+
+// clear drops every record and truncates the data file
+func (d *diskStore) clear() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.writer.Reset(d.file)
+	if err := d.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := d.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	d.index = make(map[string]l2Location)
+	d.pending = make(map[string]l2Pending)
+	d.size = 0
+	d.live = 0
+	return nil
+}
+
+func (d *diskStore) len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	res := len(d.index)
+	for key := range d.pending {
+		if _, ok := d.index[key]; !ok {
+			res++
+		}
+	}
+	return res
+}
+
+func (d *diskStore) close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.file.Close()
+}
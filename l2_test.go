@@ -0,0 +1,202 @@
+package localcache
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type l2TestSuite struct {
+	suite.Suite
+}
+
+func TestL2TestSuite(t *testing.T) {
+	suite.Run(t, new(l2TestSuite))
+}
+
+func (l *l2TestSuite) SetupSuite() {}
+
+func (l *l2TestSuite) newCache(opts ...Opt) ICache {
+	opts = append([]Opt{SetShardCount(1), SetMaxBytes(2 * segmentSize), SetStatsEnabled(true),
+		SetL2(l.T().TempDir(), 0)}, opts...)
+	c, err := NewCache(opts...)
+	assert.Equal(l.T(), nil, err)
+	return c
+}
+
+func (l *l2TestSuite) TestDemoteAndPromote() {
+	c := l.newCache()
+	defer c.Close()
+
+	for index := 0; index < 10; index++ {
+		err := c.SetWithTime(fmt.Sprintf("asong%02d", index), []byte(fmt.Sprintf("value%02d", index)), time.Hour)
+		assert.Equal(l.T(), nil, err)
+	}
+	assert.Equal(l.T(), 2, c.Len())
+	assert.Equal(l.T(), 8, c.(*cache).l2.len())
+
+	for index := 0; index < 10; index++ {
+		res, err := c.Get(fmt.Sprintf("asong%02d", index))
+		assert.Equal(l.T(), nil, err)
+		assert.Equal(l.T(), []byte(fmt.Sprintf("value%02d", index)), res)
+	}
+	_, err := c.Get("missing")
+	assert.Equal(l.T(), ErrEntryNotFound, err)
+
+	stats := c.Stats()
+	assert.Equal(l.T(), int64(10), stats.L2Hits)
+	assert.Equal(l.T(), int64(1), stats.L2Misses)
+	assert.Equal(l.T(), 2, c.Len())
+}
+
+func (l *l2TestSuite) TestOverwriteDropsDiskCopy() {
+	c := l.newCache()
+	defer c.Close()
+
+	for index := 0; index < 3; index++ {
+		assert.Equal(l.T(), nil, c.Set(fmt.Sprintf("asong%02d", index), []byte("old")))
+	}
+	// asong00 now lives on disk, a new value must win over it
+	assert.Equal(l.T(), nil, c.Set("asong00", []byte("new")))
+	res, err := c.Get("asong00")
+	assert.Equal(l.T(), nil, err)
+	assert.Equal(l.T(), []byte("new"), res)
+}
+
+func (l *l2TestSuite) TestConcurrentGetAndDelete() {
+	c := l.newCache()
+	defer c.Close()
+
+	for round := 0; round < 200; round++ {
+		assert.Equal(l.T(), nil, c.Set("asong", []byte("value")))
+		assert.Equal(l.T(), nil, c.Set("filler0", []byte("value")))
+		assert.Equal(l.T(), nil, c.Set("filler1", []byte("value")))
+
+		var wg sync.WaitGroup
+		var deleteErr error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, _ = c.Get("asong")
+		}()
+		go func() {
+			defer wg.Done()
+			deleteErr = c.Delete("asong")
+		}()
+		wg.Wait()
+		// the key was stored in one of the tiers, a promotion must neither hide it from
+		// Delete nor bring it back
+		assert.Equal(l.T(), nil, deleteErr)
+		_, err := c.Get("asong")
+		assert.Equal(l.T(), ErrEntryNotFound, err)
+	}
+}
+
+func (l *l2TestSuite) TestPendingDemotions() {
+	store, err := openDiskStore(l.T().TempDir(), 0)
+	assert.Equal(l.T(), nil, err)
+	defer store.close()
+	expireAt := uint64(time.Now().Add(time.Hour).Unix())
+
+	store.queue("asong", []byte("value"), expireAt, defaultCost)
+	store.queue("song", []byte("value"), expireAt, defaultCost)
+	assert.Equal(l.T(), 2, store.len())
+	_, ok := store.expireAt("asong", time.Now().Unix())
+	assert.True(l.T(), ok)
+	assert.True(l.T(), store.delete("song"))
+	res, _, _, ok := store.take("asong", time.Now().Unix())
+	assert.True(l.T(), ok)
+	assert.Equal(l.T(), []byte("value"), res)
+	store.flush()
+	assert.Equal(l.T(), 0, store.len())
+	assert.Equal(l.T(), int64(0), store.size)
+
+	store.queue("asong", []byte("value"), expireAt, defaultCost)
+	store.flush()
+	assert.Equal(l.T(), 1, len(store.index))
+	res, _, _, ok = store.take("asong", time.Now().Unix())
+	assert.True(l.T(), ok)
+	assert.Equal(l.T(), []byte("value"), res)
+}
+
+func (l *l2TestSuite) TestDeleteAndTTL() {
+	c := l.newCache()
+	defer c.Close()
+
+	for index := 0; index < 4; index++ {
+		assert.Equal(l.T(), nil, c.SetWithTime(fmt.Sprintf("asong%02d", index), []byte("value"), time.Hour))
+	}
+	ttl, err := c.TTL("asong00")
+	assert.Equal(l.T(), nil, err)
+	assert.True(l.T(), ttl > 59*time.Minute)
+
+	assert.Equal(l.T(), nil, c.Expire("asong01", 2*time.Hour))
+	ttl, err = c.TTL("asong01")
+	assert.Equal(l.T(), nil, err)
+	assert.True(l.T(), ttl > time.Hour)
+
+	assert.Equal(l.T(), nil, c.Delete("asong00"))
+	_, err = c.Get("asong00")
+	assert.Equal(l.T(), ErrEntryNotFound, err)
+	assert.Equal(l.T(), ErrEntryNotFound, c.Delete("asong00"))
+}
+
+func (l *l2TestSuite) TestBudget() {
+	c := l.newCache(SetL2(l.T().TempDir(), 100))
+	defer c.Close()
+
+	for index := 0; index < 10; index++ {
+		assert.Equal(l.T(), nil, c.Set(fmt.Sprintf("asong%02d", index), []byte("公众号：Golang梦工厂")))
+	}
+	assert.True(l.T(), c.(*cache).l2.len() < 8)
+}
+
+func (l *l2TestSuite) TestCompaction() {
+	dir := l.T().TempDir()
+	store, err := openDiskStore(dir, 0)
+	assert.Equal(l.T(), nil, err)
+	defer store.close()
+
+	expireAt := uint64(time.Now().Add(time.Hour).Unix())
+	value := make([]byte, 64*1024)
+	for round := 0; round < 40; round++ {
+		for index := 0; index < 4; index++ {
+			assert.Equal(l.T(), nil, store.put(fmt.Sprintf("asong%02d", index), value, expireAt, defaultCost))
+		}
+	}
+	info, err := os.Stat(filepath.Join(dir, l2FileName))
+	assert.Equal(l.T(), nil, err)
+	assert.True(l.T(), info.Size() < 2*l2CompactMinGarbage+4*int64(len(value)))
+	assert.Equal(l.T(), 4, store.len())
+
+	res, _, _, ok := store.take("asong03", time.Now().Unix())
+	assert.True(l.T(), ok)
+	assert.Equal(l.T(), value, res)
+	assert.Equal(l.T(), 3, store.len())
+}
+
+func (l *l2TestSuite) TestClear() {
+	c := l.newCache()
+	defer c.Close()
+
+	for index := 0; index < 10; index++ {
+		assert.Equal(l.T(), nil, c.Set(fmt.Sprintf("asong%02d", index), []byte("value")))
+	}
+	assert.Equal(l.T(), 8, c.(*cache).l2.len())
+	assert.Equal(l.T(), nil, c.Clear())
+	assert.Equal(l.T(), 0, c.Len())
+	assert.Equal(l.T(), 0, c.(*cache).l2.len())
+	_, err := c.Get("asong00")
+	assert.Equal(l.T(), ErrEntryNotFound, err)
+
+	assert.Equal(l.T(), nil, c.Set("asong", []byte("value")))
+	res, err := c.Get("asong")
+	assert.Equal(l.T(), nil, err)
+	assert.Equal(l.T(), []byte("value"), res)
+	assert.Equal(l.T(), 1, c.Len())
+}
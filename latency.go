@@ -0,0 +1,229 @@
+package localcache
+
+import (
+	"encoding/json"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// latencySubBucketBits is the number of linear sub-buckets per power of two, as bits.
+	// 3 bits keep the relative error of a recorded value below 12.5%.
+	latencySubBucketBits = 3
+	latencySubBuckets    = 1 << latencySubBucketBits
+	// latencyBuckets covers every uint64 nanosecond value
+	latencyBuckets = (64 - latencySubBucketBits + 1) * latencySubBuckets
+	// latencyStripes spreads concurrent writers over independent counter sets. value must be a power of two.
+	latencyStripes = 8
+)
+
+// LatencyStats stores the latency histograms of the cache operations
+type LatencyStats struct {
+	// Get is the latency of Get, including the lock wait
+	Get LatencyHistogram `json:"get"`
+	// Set is the latency of Set and SetWithTime, including the lock wait
+	Set LatencyHistogram `json:"set"`
+	// Delete is the latency of Delete, including the lock wait
+	Delete LatencyHistogram `json:"delete"`
+	// Expire is the latency of Expire, including the lock wait
+	Expire LatencyHistogram `json:"expire"`
+	// LockWait is the time spent waiting for segment locks by all operations
+	LockWait LatencyHistogram `json:"lock_wait"`
+}
+
+// LatencyHistogram is a snapshot of a log-linear latency histogram
+type LatencyHistogram struct {
+	// Count is a number of recorded operations
+	Count int64
+	// Sum is the total recorded time
+	Sum    time.Duration
+	counts []int64
+}
+
+// Mean returns the average recorded latency
+func (h LatencyHistogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Quantile returns the latency below which the q fraction of the operations fall,
+// e.g. Quantile(0.99) for the p99. The result is the upper bound of the bucket holding
+// the quantile, so it may overstate the real value by up to 12.5%.
+func (h LatencyHistogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	if q < 0 {
+		q = 0
+	}
+	if q > 1 {
+		q = 1
+	}
+	rank := int64(q*float64(h.Count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	seen := int64(0)
+	for index, count := range h.counts {
+		seen += count
+		if seen >= rank {
+			return time.Duration(latencyBucketUpper(index))
+		}
+	}
+	return time.Duration(latencyBucketUpper(len(h.counts) - 1))
+}
+
+func (h LatencyHistogram) delta(prev LatencyHistogram) LatencyHistogram {
+	if h.counts == nil {
+		return LatencyHistogram{}
+	}
+	res := LatencyHistogram{
+		Count:  h.Count - prev.Count,
+		Sum:    h.Sum - prev.Sum,
+		counts: make([]int64, len(h.counts)),
+	}
+	copy(res.counts, h.counts)
+	for index := range prev.counts {
+		res.counts[index] -= prev.counts[index]
+	}
+	return res
+}
+
+func (l LatencyStats) delta(prev LatencyStats) LatencyStats {
+	return LatencyStats{
+		Get:      l.Get.delta(prev.Get),
+		Set:      l.Set.delta(prev.Set),
+		Delete:   l.Delete.delta(prev.Delete),
+		Expire:   l.Expire.delta(prev.Expire),
+		LockWait: l.LockWait.delta(prev.LockWait),
+	}
+}
+
+// MarshalJSON renders the summary of the histogram rather than its buckets
+func (h LatencyHistogram) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Count int64         `json:"count"`
+		Mean  time.Duration `json:"mean"`
+		P50   time.Duration `json:"p50"`
+		P90   time.Duration `json:"p90"`
+		P99   time.Duration `json:"p99"`
+		P999  time.Duration `json:"p999"`
+	}{
+		Count: h.Count,
+		Mean:  h.Mean(),
+		P50:   h.Quantile(0.5),
+		P90:   h.Quantile(0.9),
+		P99:   h.Quantile(0.99),
+		P999:  h.Quantile(0.999),
+	})
+}
+
+// latencyBucket returns the bucket of a value in nanoseconds. values below latencySubBuckets
+// get a bucket each, above that every power of two is split into latencySubBuckets buckets.
+func latencyBucket(value uint64) int {
+	if value < latencySubBuckets {
+		return int(value)
+	}
+	exp := bits.Len64(value) - latencySubBucketBits - 1
+	sub := (value >> uint(exp)) & (latencySubBuckets - 1)
+	return (exp+1)*latencySubBuckets + int(sub)
+}
+
+// latencyBucketLower returns the smallest value of a bucket
+func latencyBucketLower(index int) uint64 {
+	if index < latencySubBuckets {
+		return uint64(index)
+	}
+	exp := index/latencySubBuckets - 1
+	sub := index % latencySubBuckets
+	return uint64(latencySubBuckets+sub) << uint(exp)
+}
+
+// latencyBucketUpper returns the largest value of a bucket
+func latencyBucketUpper(index int) uint64 {
+	if index >= latencyBuckets-1 {
+		return 1<<64 - 1
+	}
+	return latencyBucketLower(index+1) - 1
+}
+
+type latencyStripe struct {
+	counts [latencyBuckets]int64
+	sum    int64
+	// pad keeps the sum of neighbouring stripes on different cache lines
+	_ [56]byte
+}
+
+// latencyHistogram records durations into striped atomic counters
+type latencyHistogram struct {
+	stripes [latencyStripes]latencyStripe
+}
+
+// record adds a duration to the stripe picked by hint, callers pass the segment id
+func (h *latencyHistogram) record(hint uint64, d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	stripe := &h.stripes[hint&(latencyStripes-1)]
+	atomic.AddInt64(&stripe.counts[latencyBucket(uint64(d))], 1)
+	atomic.AddInt64(&stripe.sum, int64(d))
+}
+
+// since records the time elapsed since start
+func (h *latencyHistogram) since(hint uint64, start time.Time) {
+	h.record(hint, time.Since(start))
+}
+
+func (h *latencyHistogram) snapshot() LatencyHistogram {
+	res := LatencyHistogram{counts: make([]int64, latencyBuckets)}
+	for index := range h.stripes {
+		stripe := &h.stripes[index]
+		for bucket := range stripe.counts {
+			count := atomic.LoadInt64(&stripe.counts[bucket])
+			res.counts[bucket] += count
+			res.Count += count
+		}
+		res.Sum += time.Duration(atomic.LoadInt64(&stripe.sum))
+	}
+	return res
+}
+
+func (h *latencyHistogram) reset() {
+	for index := range h.stripes {
+		stripe := &h.stripes[index]
+		for bucket := range stripe.counts {
+			atomic.StoreInt64(&stripe.counts[bucket], 0)
+		}
+		atomic.StoreInt64(&stripe.sum, 0)
+	}
+}
+
+// latencyRecorder holds the histograms of every recorded operation
+type latencyRecorder struct {
+	get      latencyHistogram
+	set      latencyHistogram
+	delete   latencyHistogram
+	expire   latencyHistogram
+	lockWait latencyHistogram
+}
+
+func (r *latencyRecorder) snapshot() LatencyStats {
+	return LatencyStats{
+		Get:      r.get.snapshot(),
+		Set:      r.set.snapshot(),
+		Delete:   r.delete.snapshot(),
+		Expire:   r.expire.snapshot(),
+		LockWait: r.lockWait.snapshot(),
+	}
+}
+
+func (r *latencyRecorder) reset() {
+	r.get.reset()
+	r.set.reset()
+	r.delete.reset()
+	r.expire.reset()
+	r.lockWait.reset()
+}
@@ -0,0 +1,76 @@
+package localcache
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type latencyTestSuite struct {
+	suite.Suite
+}
+
+func TestLatencyTestSuite(t *testing.T) {
+	suite.Run(t, new(latencyTestSuite))
+}
+
+func (l *latencyTestSuite) SetupSuite() {}
+
+func (l *latencyTestSuite) TestBuckets() {
+	for _, value := range []uint64{0, 1, 7, 8, 15, 16, 1000, 123456789, 1<<63 + 5, 1<<64 - 1} {
+		index := latencyBucket(value)
+		assert.True(l.T(), index >= 0 && index < latencyBuckets)
+		assert.LessOrEqual(l.T(), latencyBucketLower(index), value)
+		assert.GreaterOrEqual(l.T(), latencyBucketUpper(index), value)
+	}
+	for index := 0; index < latencyBuckets-1; index++ {
+		assert.Equal(l.T(), latencyBucketUpper(index)+1, latencyBucketLower(index+1))
+	}
+}
+
+func (l *latencyTestSuite) TestQuantile() {
+	h := &latencyHistogram{}
+	for index := 1; index <= 1000; index++ {
+		h.record(uint64(index), time.Duration(index)*time.Microsecond)
+	}
+	snapshot := h.snapshot()
+	assert.Equal(l.T(), int64(1000), snapshot.Count)
+	assert.Equal(l.T(), 500500*time.Microsecond, snapshot.Sum)
+	assert.InEpsilon(l.T(), float64(500*time.Microsecond), float64(snapshot.Quantile(0.5)), 0.125)
+	assert.InEpsilon(l.T(), float64(990*time.Microsecond), float64(snapshot.Quantile(0.99)), 0.125)
+	assert.Equal(l.T(), time.Duration(0), LatencyHistogram{}.Quantile(0.5))
+}
+
+func (l *latencyTestSuite) TestCacheLatency() {
+	c, err := NewCache(SetLatencyEnabled(true))
+	assert.Equal(l.T(), nil, err)
+
+	value := []byte("公众号：Golang梦工厂")
+	for index := 0; index < 100; index++ {
+		key := fmt.Sprintf("asong%03d", index)
+		assert.Equal(l.T(), nil, c.Set(key, value))
+		_, err = c.Get(key)
+		assert.Equal(l.T(), nil, err)
+	}
+	assert.Equal(l.T(), nil, c.Delete("asong000"))
+	assert.Equal(l.T(), nil, c.Expire("asong001", time.Minute))
+
+	latency := c.Stats().Latency
+	assert.Equal(l.T(), int64(100), latency.Get.Count)
+	assert.Equal(l.T(), int64(100), latency.Set.Count)
+	assert.Equal(l.T(), int64(1), latency.Delete.Count)
+	assert.Equal(l.T(), int64(1), latency.Expire.Count)
+	assert.Equal(l.T(), int64(202), latency.LockWait.Count)
+	assert.True(l.T(), latency.Get.Quantile(0.99) > 0)
+
+	body, err := json.Marshal(latency.Get)
+	assert.Equal(l.T(), nil, err)
+	assert.Contains(l.T(), string(body), `"count":100`)
+
+	c, err = NewCache()
+	assert.Equal(l.T(), nil, err)
+	assert.Equal(l.T(), int64(0), c.Stats().Latency.Get.Count)
+}
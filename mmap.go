@@ -0,0 +1,66 @@
+package localcache
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/asong2020/go-localcache/buffer"
+)
+
+// newMmapSegments creates the segments on mmap buffers of segmentBytes each, anonymous
+// when dir is empty and file backed otherwise
+func newMmapSegments(count uint64, segmentBytes uint64, dir string, statsEnabled bool) ([]*segment, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	capacity := segmentCapacity(segmentBytes)
+	segments := make([]*segment, count)
+	for index := range segments {
+		var entries *buffer.MmapBuffer
+		var err error
+		if dir == "" {
+			entries, err = buffer.NewMmapBuffer(capacity, segmentSize)
+		} else {
+			entries, err = buffer.OpenMmapBuffer(mmapSegmentPath(dir, index), capacity, segmentSize)
+		}
+		if err != nil {
+			closeSegments(segments[:index])
+			return nil, err
+		}
+		segments[index] = newSegmentWithBuffer(entries, statsEnabled)
+		segments[index].chunked = true
+	}
+	return segments, nil
+}
+
+func mmapSegmentPath(dir string, index int) string {
+	return filepath.Join(dir, fmt.Sprintf("segment-%04d.mmap", index))
+}
+
+// rebuildSegments indexes the entries found in reopened file backed segments. An entry is
+// kept only if the current hash function still maps its key to the same hash and segment.
+func (c *cache) rebuildSegments() {
+	for index, segment := range c.segments {
+		shard := uint64(index)
+		segment.rebuild(func(key string, hashKey uint64) bool {
+			return c.hashFunc.Sum64(key) == hashKey && hashKey&c.bucketMask == shard
+		})
+	}
+}
+
+// closeSegments releases the buffers that hold resources outside the Go heap
+func closeSegments(segments []*segment) error {
+	var err error
+	for _, segment := range segments {
+		if closer, ok := segment.entries.(io.Closer); ok {
+			if closeErr := closer.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,142 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package localcache
+
+import (
+	"fmt"
+	"github.com/asong2020/go-localcache/buffer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mmapTestSuite struct {
+	suite.Suite
+}
+
+func TestMmapTestSuite(t *testing.T) {
+	suite.Run(t, new(mmapTestSuite))
+}
+
+func (m *mmapTestSuite) SetupSuite() {}
+
+func (m *mmapTestSuite) TestAnonymous() {
+	c, err := NewCache(SetShardCount(4), SetMaxBytes(8*segmentSize), SetMmap(""))
+	assert.Equal(m.T(), nil, err)
+	defer c.Close()
+
+	for index := 0; index < 100; index++ {
+		err := c.Set(fmt.Sprintf("asong%d", index), []byte(fmt.Sprintf("value%d", index)))
+		assert.Equal(m.T(), nil, err)
+	}
+	assert.Equal(m.T(), 8, c.Capacity())
+	assert.True(m.T(), c.Len() <= 8)
+
+	err = c.Set("large", make([]byte, segmentSize))
+	assert.NotEqual(m.T(), nil, err)
+	assert.True(m.T(), c.Len() > 0)
+}
+
+func (m *mmapTestSuite) TestWarmRestart() {
+	dir := m.T().TempDir()
+	opts := []Opt{SetShardCount(4), SetMaxBytes(64 * segmentSize), SetMmap(dir)}
+	c, err := NewCache(opts...)
+	assert.Equal(m.T(), nil, err)
+	for index := 0; index < 20; index++ {
+		err := c.SetWithTime(fmt.Sprintf("asong%d", index), []byte(fmt.Sprintf("value%d", index)), time.Hour)
+		assert.Equal(m.T(), nil, err)
+	}
+	assert.Equal(m.T(), nil, c.Delete("asong3"))
+	assert.Equal(m.T(), nil, c.Close())
+
+	c, err = NewCache(opts...)
+	assert.Equal(m.T(), nil, err)
+	defer c.Close()
+	assert.Equal(m.T(), 19, c.Len())
+	for index := 0; index < 20; index++ {
+		res, err := c.Get(fmt.Sprintf("asong%d", index))
+		if index == 3 {
+			assert.Equal(m.T(), ErrEntryNotFound, err)
+			continue
+		}
+		assert.Equal(m.T(), nil, err)
+		assert.Equal(m.T(), []byte(fmt.Sprintf("value%d", index)), res)
+	}
+	ttl, err := c.TTL("asong1")
+	assert.Equal(m.T(), nil, err)
+	assert.True(m.T(), ttl > 59*time.Minute)
+	assert.Equal(m.T(), nil, c.Delete("asong1"))
+	assert.Equal(m.T(), 18, c.Len())
+}
+
+func (m *mmapTestSuite) TestRestartWithOtherShardCount() {
+	dir := m.T().TempDir()
+	c, err := NewCache(SetShardCount(4), SetMaxBytes(64*segmentSize), SetMmap(dir))
+	assert.Equal(m.T(), nil, err)
+	for index := 0; index < 20; index++ {
+		assert.Equal(m.T(), nil, c.Set(fmt.Sprintf("asong%d", index), []byte("value")))
+	}
+	assert.Equal(m.T(), nil, c.Close())
+
+	// the four segment files are reused with the same geometry, entries that now
+	// belong to one of the new segments are dropped
+	c, err = NewCache(SetShardCount(8), SetMaxBytes(128*segmentSize), SetMmap(dir))
+	assert.Equal(m.T(), nil, err)
+	defer c.Close()
+	for index := 0; index < 20; index++ {
+		key := fmt.Sprintf("asong%d", index)
+		hashKey := c.(*cache).hashFunc.Sum64(key)
+		_, err := c.Get(key)
+		if hashKey&7 < 4 {
+			assert.Equal(m.T(), nil, err)
+		} else {
+			assert.Equal(m.T(), ErrEntryNotFound, err)
+		}
+	}
+}
+
+func (m *mmapTestSuite) TestChunkedValues() {
+	dir := m.T().TempDir()
+	opts := []Opt{SetShardCount(1), SetMaxBytes(64 * segmentSize), SetMmap(dir)}
+	c, err := NewCache(opts...)
+	assert.Equal(m.T(), nil, err)
+	assert.Equal(m.T(), ErrKeyTooLarge, c.Set(strings.Repeat("k", maxChunkedKeySize+1), []byte("value")))
+	assert.Equal(m.T(), nil, c.Set(strings.Repeat("k", maxChunkedKeySize), []byte("value")))
+
+	value := make([]byte, 3*segmentSize)
+	for i := range value {
+		value[i] = byte(i)
+	}
+	assert.Equal(m.T(), nil, c.SetWithTime("large", value, time.Hour))
+	res, err := c.Get("large")
+	assert.Equal(m.T(), nil, err)
+	assert.Equal(m.T(), value, res)
+	// a chunk left behind by a crash in the middle of a set
+	_, err = c.(*cache).segments[0].entries.Push([]byte{kindChunk, 1, 2, 3})
+	assert.Equal(m.T(), nil, err)
+	assert.Equal(m.T(), nil, c.Close())
+
+	c, err = NewCache(opts...)
+	assert.Equal(m.T(), nil, err)
+	defer c.Close()
+	assert.Equal(m.T(), 2, c.Len())
+	assert.Equal(m.T(), 2+4, c.(*cache).segments[0].entries.GetPlaceholderCount())
+	res, err = c.Get("large")
+	assert.Equal(m.T(), nil, err)
+	assert.Equal(m.T(), value, res)
+}
+
+func (m *mmapTestSuite) TestUseAfterClose() {
+	c, err := NewCache(SetShardCount(4), SetMaxBytes(64*segmentSize), SetMmap(m.T().TempDir()))
+	assert.Equal(m.T(), nil, err)
+	assert.Equal(m.T(), nil, c.Set("asong", []byte("value")))
+	assert.Equal(m.T(), nil, c.Close())
+
+	_, err = c.Get("asong")
+	assert.Equal(m.T(), buffer.ErrBufferClosed, err)
+	assert.Equal(m.T(), buffer.ErrBufferClosed, c.Set("asong", []byte("value")))
+	assert.Equal(m.T(), buffer.ErrBufferClosed, c.Delete("asong"))
+}
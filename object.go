@@ -0,0 +1,169 @@
+package localcache
+
+import (
+	"errors"
+	"time"
+
+	"github.com/asong2020/go-localcache/buffer"
+)
+
+// ErrObjectOption is returned by NewObjectCache for an option that needs the values as bytes
+var ErrObjectOption = errors.New("option not supported by object caches")
+
+// ObjectCache holds values as they are, without copying or encoding them. It shares the
+// sharding, the expiry and the eviction of the byte cache: maxBytes bounds the size of the
+// stored objects, as reported by their Sizer or the size function, plus their keys.
+type ObjectCache interface {
+	// Set stores value with the default expire time
+	Set(key string, value interface{}) error
+	// SetWithTime stores value with expire time
+	SetWithTime(key string, value interface{}, expired time.Duration) error
+	// SetWithCost stores value with expire time and a cost counted against SetMaxCost
+	SetWithCost(key string, value interface{}, expired time.Duration, cost int64) error
+	// Get returns the value stored for key, the very value passed to Set
+	Get(key string) (interface{}, error)
+	// Expire sets a new expire time on an existing key
+	Expire(key string, expired time.Duration) error
+	// TTL returns the remaining time to live of key, ErrEntryNotFound if it is missing or expired.
+	TTL(key string) (time.Duration, error)
+	// Delete removes the key
+	Delete(key string) error
+	// Scan returns the keys of up to count live entries, see ICache.Scan
+	Scan(cursor uint64, count int) ([]string, uint64)
+	// Clear removes every entry. Statistics are kept.
+	Clear() error
+	// Len computes number of entries in cache
+	Len() int
+	// Close stops the cleaning goroutines
+	Close() error
+	// Stats returns cache's statistics
+	Stats() Stats
+	// ResetStats zeroes every statistics counter
+	ResetStats()
+	// ShardStats returns the statistics of every segment, indexed by segment id
+	ShardStats() []ShardStat
+}
+
+// Sizer is implemented by values that know how many bytes they hold
+type Sizer interface {
+	Size() int
+}
+
+type objectCache struct {
+	*cache
+	// sizeFunc computes the size of the values, nil uses sizeOfObject
+	sizeFunc func(value interface{}) int
+}
+
+// NewObjectCache creates an ObjectCache. Options that store, encode or weigh the values as
+// bytes, SetAOF, SetL2, SetMmap, SetStore, SetCompression, SetEncryption and SetWeigher,
+// are rejected with ErrObjectOption.
+func NewObjectCache(opts ...Opt) (ObjectCache, error) {
+	options, err := newOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if options.aofPath != "" || options.l2Dir != "" || options.mmapEnabled || options.store != nil ||
+		options.compressor != nil || options.keyProvider != nil || options.weigher != nil {
+		return nil, ErrObjectOption
+	}
+	maxSegmentBytes := options.segmentBytes()
+	// slots are allocated on demand, the budget is enforced in bytes
+	capacity := int(maxSegmentBytes / (fixedHeadersSizeInBytes + 2))
+	if capacity == 0 {
+		capacity = 1
+	}
+	segments := make([]*segment, options.bucketCount)
+	for index := range segments {
+		segment := newSegmentWithBuffer(buffer.NewDynamicBuffer(capacity), options.statsEnabled)
+		segment.objects = make(map[uint32]interface{})
+		segment.maxBytes = int(maxSegmentBytes)
+		segments[index] = segment
+	}
+	c := &objectCache{
+		cache:    newCacheWithSegments(options, segments),
+		sizeFunc: options.objectSizeFunc,
+	}
+	c.start(options)
+	return c, nil
+}
+
+func (c *objectCache) Set(key string, value interface{}) error {
+	return c.set(key, value, defaultExpireTime, defaultCost)
+}
+
+func (c *objectCache) SetWithTime(key string, value interface{}, expired time.Duration) error {
+	return c.set(key, value, expired, defaultCost)
+}
+
+func (c *objectCache) SetWithCost(key string, value interface{}, expired time.Duration, cost int64) error {
+	if cost < 0 {
+		return ErrCostInvalid
+	}
+	return c.set(key, value, expired, cost)
+}
+
+func (c *objectCache) set(key string, value interface{}, expired time.Duration, cost int64) error {
+	if expired <= 0 {
+		return ErrExpireTimeInvalid
+	}
+	if uint64(len(key)) > c.maxKeySize {
+		return ErrKeyTooLarge
+	}
+	size := c.sizeOf(value)
+	hashKey := c.hashFunc.Sum64(key)
+	bucketIndex := hashKey & c.bucketMask
+	if c.latency != nil {
+		defer c.latency.set.since(bucketIndex, time.Now())
+	}
+	c.lock(bucketIndex)
+	defer c.locks[bucketIndex].Unlock()
+	segment := c.segments[bucketIndex]
+	return segment.setObject(key, hashKey, value, size, uint64(segment.clock.Epoch(expired)), cost)
+}
+
+func (c *objectCache) Get(key string) (interface{}, error) {
+	hashKey := c.hashFunc.Sum64(key)
+	bucketIndex := hashKey & c.bucketMask
+	if c.latency != nil {
+		defer c.latency.get.since(bucketIndex, time.Now())
+	}
+	c.rlock(bucketIndex)
+	value, err := c.segments[bucketIndex].getObject(key, hashKey)
+	c.locks[bucketIndex].RUnlock()
+	if err == errEntryExpired {
+		c.lock(bucketIndex)
+		c.segments[bucketIndex].removeExpired(key, hashKey)
+		c.locks[bucketIndex].Unlock()
+		return nil, ErrEntryNotFound
+	}
+	return value, err
+}
+
+// sizeOf returns the size value counts for in the byte budget
+func (c *objectCache) sizeOf(value interface{}) int {
+	size := 0
+	if c.sizeFunc != nil {
+		size = c.sizeFunc(value)
+	} else {
+		size = sizeOfObject(value)
+	}
+	if size < 0 {
+		return 0
+	}
+	return size
+}
+
+// sizeOfObject returns the size of a Sizer, a byte slice or a string. Other values count
+// for their key and entry headers only.
+func sizeOfObject(value interface{}) int {
+	switch v := value.(type) {
+	case Sizer:
+		return v.Size()
+	case []byte:
+		return len(v)
+	case string:
+		return len(v)
+	}
+	return 0
+}
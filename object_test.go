@@ -0,0 +1,139 @@
+package localcache
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type objectTestSuite struct {
+	suite.Suite
+}
+
+func TestObjectTestSuite(t *testing.T) {
+	suite.Run(t, new(objectTestSuite))
+}
+
+func (o *objectTestSuite) SetupSuite() {}
+
+type user struct {
+	name string
+	age  int
+}
+
+// sized is a value reporting its own size
+type sized int
+
+func (s sized) Size() int {
+	return int(s)
+}
+
+func (o *objectTestSuite) TestSetGet() {
+	c, err := NewObjectCache()
+	assert.Equal(o.T(), nil, err)
+	defer c.Close()
+
+	asong := &user{name: "asong", age: 18}
+	assert.Equal(o.T(), nil, c.Set("asong", asong))
+	value, err := c.Get("asong")
+	assert.Equal(o.T(), nil, err)
+	// the very object is returned, not a copy
+	assert.True(o.T(), value.(*user) == asong)
+
+	assert.Equal(o.T(), nil, c.Set("asong", "Golang梦工厂"))
+	value, err = c.Get("asong")
+	assert.Equal(o.T(), nil, err)
+	assert.Equal(o.T(), "Golang梦工厂", value)
+	assert.Equal(o.T(), 1, c.Len())
+
+	assert.Equal(o.T(), nil, c.Set("nil", nil))
+	value, err = c.Get("nil")
+	assert.Equal(o.T(), nil, err)
+	assert.Nil(o.T(), value)
+
+	assert.Equal(o.T(), nil, c.Delete("asong"))
+	_, err = c.Get("asong")
+	assert.Equal(o.T(), ErrEntryNotFound, err)
+	assert.Equal(o.T(), ErrEntryNotFound, c.Delete("asong"))
+
+	assert.Equal(o.T(), nil, c.Clear())
+	assert.Equal(o.T(), 0, c.Len())
+	_, err = c.Get("nil")
+	assert.Equal(o.T(), ErrEntryNotFound, err)
+}
+
+func (o *objectTestSuite) TestExpire() {
+	c, err := NewObjectCache()
+	assert.Equal(o.T(), nil, err)
+	defer c.Close()
+
+	assert.Equal(o.T(), ErrExpireTimeInvalid, c.SetWithTime("asong", 1, 0))
+	assert.Equal(o.T(), nil, c.SetWithTime("asong", 1, time.Second))
+	assert.Equal(o.T(), nil, c.SetWithTime("song", 2, time.Second))
+	assert.Equal(o.T(), nil, c.Expire("song", time.Hour))
+	ttl, err := c.TTL("song")
+	assert.Equal(o.T(), nil, err)
+	assert.True(o.T(), ttl > time.Minute)
+	value, err := c.Get("song")
+	assert.Equal(o.T(), nil, err)
+	assert.Equal(o.T(), 2, value)
+
+	time.Sleep(2 * time.Second)
+	_, err = c.Get("asong")
+	assert.Equal(o.T(), ErrEntryNotFound, err)
+	assert.Equal(o.T(), 1, c.Len())
+	value, err = c.Get("song")
+	assert.Equal(o.T(), nil, err)
+	assert.Equal(o.T(), 2, value)
+}
+
+func (o *objectTestSuite) TestEvictsBySize() {
+	c, err := NewObjectCache(SetShardCount(1), SetMaxBytes(1024), SetStatsEnabled(true))
+	assert.Equal(o.T(), nil, err)
+	defer c.Close()
+
+	for index := 0; index < 4; index++ {
+		assert.Equal(o.T(), nil, c.Set(fmt.Sprintf("asong%d", index), sized(300)))
+	}
+	// every entry is 300 bytes plus its headers, only three fit in 1024 bytes
+	assert.Equal(o.T(), 3, c.Len())
+	assert.Equal(o.T(), int64(1), c.Stats().Evictions)
+	_, err = c.Get("asong0")
+	assert.Equal(o.T(), ErrEntryNotFound, err)
+	assert.True(o.T(), c.Stats().LiveBytes <= 1024)
+
+	assert.Equal(o.T(), ErrEntryTooLarge, c.Set("large", sized(2048)))
+	assert.Equal(o.T(), nil, c.Set("bytes", make([]byte, 900)))
+	assert.Equal(o.T(), 1, c.Len())
+	assert.Equal(o.T(), 1, c.ShardStats()[0].Entries)
+}
+
+func (o *objectTestSuite) TestSizeFunc() {
+	c, err := NewObjectCache(SetShardCount(1), SetMaxBytes(1024), SetObjectSizeFunc(func(value interface{}) int {
+		return value.(*user).age
+	}))
+	assert.Equal(o.T(), nil, err)
+	defer c.Close()
+
+	assert.Equal(o.T(), nil, c.Set("asong", &user{name: "asong", age: 600}))
+	assert.Equal(o.T(), nil, c.Set("song", &user{name: "song", age: 600}))
+	assert.Equal(o.T(), 1, c.Len())
+	_, err = c.Get("asong")
+	assert.Equal(o.T(), ErrEntryNotFound, err)
+}
+
+func (o *objectTestSuite) TestRejectsByteOptions() {
+	compressor, err := NewFlateCompressor(1)
+	assert.Equal(o.T(), nil, err)
+	ring, err := NewKeyRing(1, make([]byte, 32))
+	assert.Equal(o.T(), nil, err)
+	for _, opt := range []Opt{SetAOF("aof"), SetL2("l2", 0), SetMmap(""), SetStore(newMemStore(), WriteThrough),
+		SetCompression(compressor, 0), SetEncryption(ring)} {
+		_, err := NewObjectCache(opt)
+		assert.Equal(o.T(), ErrObjectOption, err)
+	}
+	_, err = NewObjectCache(SetShardCount(3))
+	assert.Equal(o.T(), ErrShardCount, err)
+}
@@ -10,6 +10,29 @@ type options struct {
 	cleanTime time.Duration
 	statsEnabled bool
 	cleanupEnabled bool
+	cleanupBatchSize int
+	cleanupTimeBudget time.Duration
+	skewThreshold float64
+	skewInterval time.Duration
+	skewWarning SkewWarningFunc
+	latencyEnabled bool
+	aofPath string
+	aofFsync FsyncPolicy
+	l2Dir string
+	l2MaxBytes int64
+	mmapEnabled bool
+	mmapDir string
+	store Store
+	writeMode WriteMode
+	writeBehindBatchSize int
+	writeBehindInterval time.Duration
+	writeBehindMaxDirty int
+	compressor Compressor
+	compressionThreshold int
+	keyProvider KeyProvider
+	objectSizeFunc func(value interface{}) int
+	maxCost int64
+	weigher Weigher
 }
 
 type Opt func(options *options)
@@ -32,6 +55,16 @@ func SetMaxBytes(maxBytes uint64) Opt {
 	}
 }
 
+// SetMaxCost bounds the total cost of the entries, alongside maxBytes. It is split evenly
+// across the shards like maxBytes; 0, the default, leaves the cost unbounded. Once entries
+// have costs, eviction prefers the entries with the lowest cost per byte among the least
+// recently stored ones.
+func SetMaxCost(maxCost int64) Opt {
+	return func(opt *options) {
+		opt.maxCost = maxCost
+	}
+}
+
 func SetCleanTime(time time.Duration) Opt {
 	return func(opt *options) {
 		opt.cleanTime = time
@@ -48,4 +81,135 @@ func SetCleanupEnabled(enabled bool) Opt {
 	return func(opt *options) {
 		opt.cleanupEnabled = enabled
 	}
-}
\ No newline at end of file
+}
+
+// SetCleanupBatchSize sets how many buffer slots a segment inspects per cleanup pass.
+func SetCleanupBatchSize(size int) Opt {
+	return func(opt *options) {
+		opt.cleanupBatchSize = size
+	}
+}
+
+// SetCleanupTimeBudget sets the maximum time a single cleanup cycle may spend
+// before it yields and resumes on the next tick.
+func SetCleanupTimeBudget(budget time.Duration) Opt {
+	return func(opt *options) {
+		opt.cleanupTimeBudget = budget
+	}
+}
+
+// SetShardSkewWarning checks the shard load every interval and calls fn when the
+// skew returned by ShardSkew exceeds threshold.
+func SetShardSkewWarning(threshold float64, interval time.Duration, fn SkewWarningFunc) Opt {
+	return func(opt *options) {
+		opt.skewThreshold = threshold
+		opt.skewInterval = interval
+		opt.skewWarning = fn
+	}
+}
+
+// SetLatencyEnabled records Get, Set, Delete and Expire latencies, including the time
+// spent waiting for segment locks, into histograms reported by Stats.
+func SetLatencyEnabled(enabled bool) Opt {
+	return func(opt *options) {
+		opt.latencyEnabled = enabled
+	}
+}
+
+// SetAOF logs every Set, SetWithTime, SetWithCost, Delete and Expire to the append only
+// file at path, and replays it when the cache is created.
+func SetAOF(path string) Opt {
+	return func(opt *options) {
+		opt.aofPath = path
+	}
+}
+
+// SetAOFFsync sets how often the append only file is synced, FsyncEverySecond by default.
+func SetAOFFsync(policy FsyncPolicy) Opt {
+	return func(opt *options) {
+		opt.aofFsync = policy
+	}
+}
+
+// SetL2 demotes entries evicted from memory to a file backed store in dir, holding at
+// most maxBytes of records (0 for no limit). A Get that misses memory looks the key up
+// there and promotes it back.
+func SetL2(dir string, maxBytes int64) Opt {
+	return func(opt *options) {
+		opt.l2Dir = dir
+		opt.l2MaxBytes = maxBytes
+	}
+}
+
+// SetMmap stores the entries in mmap regions outside the Go heap. With an empty dir the
+// regions are anonymous; otherwise every segment maps a file in dir, and a cache created
+// again on the same dir with the same shard count and maxBytes starts with the entries
+// left by the previous one. Keys are limited to 16KB, values larger than a 32KB slot are
+// split across several slots.
+func SetMmap(dir string) Opt {
+	return func(opt *options) {
+		opt.mmapEnabled = true
+		opt.mmapDir = dir
+	}
+}
+
+// SetStore puts store behind the cache. A Get that misses loads the key from the store and
+// caches it; Set, SetWithTime, SetWithCost and Delete reach the store as mode decides.
+// Expire and Clear only affect the cache. Delete reports ErrEntryNotFound when the key was
+// not cached, the store delete is done anyway.
+func SetStore(store Store, mode WriteMode) Opt {
+	return func(opt *options) {
+		opt.store = store
+		opt.writeMode = mode
+	}
+}
+
+// SetWriteBehind tunes the WriteBehind mode. Dirty keys are flushed in batches of
+// batchSize every flushInterval, or as soon as a batch is full. When maxDirty keys are
+// waiting, writers block until a flush makes room. Defaults are 100, one second and 10000.
+func SetWriteBehind(batchSize int, flushInterval time.Duration, maxDirty int) Opt {
+	return func(opt *options) {
+		opt.writeBehindBatchSize = batchSize
+		opt.writeBehindInterval = flushInterval
+		opt.writeBehindMaxDirty = maxDirty
+	}
+}
+
+// SetCompression compresses the values of at least threshold bytes with compressor.
+// Smaller values, and values that do not shrink, are stored raw. Get returns the values
+// decompressed, Stats reports the raw and the stored value bytes.
+func SetCompression(compressor Compressor, threshold int) Opt {
+	return func(opt *options) {
+		opt.compressor = compressor
+		opt.compressionThreshold = threshold
+	}
+}
+
+// SetEncryption seals the values with AES-GCM under the keys of provider, in memory, in
+// mmap files, in the append only file and in the disk tier. Every value records the id of
+// the key it was sealed with, so rotated keys stay readable as long as provider keeps them.
+// Get returns ErrEntryTampered for a value that fails authentication.
+func SetEncryption(provider KeyProvider) Opt {
+	return func(opt *options) {
+		opt.keyProvider = provider
+	}
+}
+
+// SetObjectSizeFunc makes an ObjectCache count every value as fn(value) bytes against
+// maxBytes, in place of the Size of a Sizer or the length of a byte slice or a string.
+func SetObjectSizeFunc(fn func(value interface{}) int) Opt {
+	return func(opt *options) {
+		opt.objectSizeFunc = fn
+	}
+}
+
+// Weigher computes the cost of an entry from its key and value
+type Weigher func(key string, value []byte) int64
+
+// SetWeigher gives the entries set without an explicit cost the cost returned by weigher,
+// in place of 1. Negative costs count as 0.
+func SetWeigher(weigher Weigher) Opt {
+	return func(opt *options) {
+		opt.weigher = weigher
+	}
+}
@@ -0,0 +1,245 @@
+// Package peer spreads a cache over a fleet of instances, in the way of groupcache. Every
+// key is owned by one instance, chosen with a consistent hash ring. The owner loads the
+// value with its Getter and caches it, the other instances fetch it from the owner and may
+// keep a short lived copy of the popular ones.
+package peer
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	localcache "github.com/asong2020/go-localcache"
+)
+
+// ErrNotFound is returned by a Getter for a missing key. It is passed from the owner
+// to the instance asking for the key.
+var ErrNotFound = errors.New("peer: key not found")
+
+// Getter loads the value of a key on a cache miss of its owner
+type Getter interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// GetterFunc is a function implementing Getter
+type GetterFunc func(ctx context.Context, key string) ([]byte, error)
+
+func (f GetterFunc) Get(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
+// Peer is a remote instance
+type Peer interface {
+	// Fetch returns the value of key in the group named group from the peer
+	Fetch(ctx context.Context, group string, key string) ([]byte, error)
+}
+
+// PeerPicker chooses the owner of a key
+type PeerPicker interface {
+	// PickPeer returns the peer owning key, false when this instance owns it
+	PickPeer(key string) (Peer, bool)
+}
+
+const (
+	defaultTTL    = 10 * time.Minute
+	defaultHotTTL = time.Minute
+	// defaultHotRatio keeps a copy of one in ten values fetched from a peer
+	defaultHotRatio = 10
+)
+
+type groupOptions struct {
+	peers    PeerPicker
+	ttl      time.Duration
+	hotCache localcache.ICache
+	hotTTL   time.Duration
+	hotRatio int
+}
+
+// GroupOpt configures a Group
+type GroupOpt func(options *groupOptions)
+
+// SetPeers routes every key to its owner with picker. Without it every key is owned locally.
+func SetPeers(picker PeerPicker) GroupOpt {
+	return func(opt *groupOptions) {
+		opt.peers = picker
+	}
+}
+
+// SetTTL sets the time to live of the values loaded by the owner, 10 minutes by default
+func SetTTL(ttl time.Duration) GroupOpt {
+	return func(opt *groupOptions) {
+		opt.ttl = ttl
+	}
+}
+
+// SetHotCache keeps a copy of the values fetched from the peers in cache for ttl. As a
+// value is fetched again each time its copy expires, popular values are the likeliest to
+// be found there. One fetched value out of ratio is kept, ratio 1 keeps them all.
+func SetHotCache(cache localcache.ICache, ttl time.Duration, ratio int) GroupOpt {
+	return func(opt *groupOptions) {
+		opt.hotCache = cache
+		opt.hotTTL = ttl
+		opt.hotRatio = ratio
+	}
+}
+
+// GroupStats counts the operations of a Group
+type GroupStats struct {
+	// Gets is the number of Get calls
+	Gets int64
+	// CacheHits is the number of keys found in the cache of the owned keys
+	CacheHits int64
+	// HotHits is the number of keys found in the hot cache
+	HotHits int64
+	// Loads is the number of loads after a miss, deduplicated loads count once
+	Loads int64
+	// PeerLoads is the number of values fetched from a peer
+	PeerLoads int64
+	// PeerErrors is the number of failed fetches, the value is then loaded locally
+	PeerErrors int64
+	// LocalLoads is the number of values loaded by the Getter
+	LocalLoads int64
+	// LocalLoadErrors is the number of Getter failures
+	LocalLoadErrors int64
+	// ServerRequests is the number of keys asked by the peers
+	ServerRequests int64
+}
+
+// Group is a named set of keys loaded by the same Getter
+type Group struct {
+	name     string
+	getter   Getter
+	cache    localcache.ICache
+	peers    PeerPicker
+	ttl      time.Duration
+	hotCache localcache.ICache
+	hotTTL   time.Duration
+	hotRatio int
+	loads    flightGroup
+
+	mu    sync.Mutex
+	stats GroupStats
+	rand  *rand.Rand
+}
+
+// NewGroup creates the group name, storing the owned keys in cache
+func NewGroup(name string, cache localcache.ICache, getter Getter, opts ...GroupOpt) *Group {
+	options := &groupOptions{
+		ttl:      defaultTTL,
+		hotTTL:   defaultHotTTL,
+		hotRatio: defaultHotRatio,
+	}
+	for _, each := range opts {
+		each(options)
+	}
+	if options.hotRatio <= 0 {
+		options.hotRatio = 1
+	}
+	return &Group{
+		name:     name,
+		getter:   getter,
+		cache:    cache,
+		peers:    options.peers,
+		ttl:      options.ttl,
+		hotCache: options.hotCache,
+		hotTTL:   options.hotTTL,
+		hotRatio: options.hotRatio,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Name returns the name of the group
+func (g *Group) Name() string {
+	return g.name
+}
+
+// Stats returns the counters of the group
+func (g *Group) Stats() GroupStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.stats
+}
+
+func (g *Group) count(fn func(stats *GroupStats)) {
+	g.mu.Lock()
+	fn(&g.stats)
+	g.mu.Unlock()
+}
+
+// Get returns the value of key, from the local caches, from its owner or from the Getter.
+// Concurrent misses of a key share a single load, which keeps the values of ctx but not
+// its deadline or cancellation; ctx only bounds how long this caller waits for it.
+func (g *Group) Get(ctx context.Context, key string) ([]byte, error) {
+	g.count(func(stats *GroupStats) { stats.Gets++ })
+	if value, err := g.cache.Get(key); err == nil {
+		g.count(func(stats *GroupStats) { stats.CacheHits++ })
+		return value, nil
+	}
+	if g.hotCache != nil {
+		if value, err := g.hotCache.Get(key); err == nil {
+			g.count(func(stats *GroupStats) { stats.HotHits++ })
+			return value, nil
+		}
+	}
+	value, err, _ := g.loads.do(ctx, key, func(ctx context.Context) ([]byte, error) {
+		g.count(func(stats *GroupStats) { stats.Loads++ })
+		if g.peers != nil {
+			if peer, ok := g.peers.PickPeer(key); ok {
+				value, err := peer.Fetch(ctx, g.name, key)
+				if err == nil {
+					g.count(func(stats *GroupStats) { stats.PeerLoads++ })
+					g.keepHot(key, value)
+					return value, nil
+				}
+				if err == ErrNotFound {
+					return nil, err
+				}
+				// the owner is unreachable, serve the key locally rather than fail
+				g.count(func(stats *GroupStats) { stats.PeerErrors++ })
+			}
+		}
+		return g.loadLocally(ctx, key)
+	})
+	return value, err
+}
+
+// getOwned serves a key asked by a peer, which considers this instance its owner. It is
+// never forwarded again, even if the ring of this instance disagrees, to avoid loops.
+func (g *Group) getOwned(ctx context.Context, key string) ([]byte, error) {
+	g.count(func(stats *GroupStats) { stats.ServerRequests++ })
+	if value, err := g.cache.Get(key); err == nil {
+		g.count(func(stats *GroupStats) { stats.CacheHits++ })
+		return value, nil
+	}
+	value, err, _ := g.loads.do(ctx, key, func(ctx context.Context) ([]byte, error) {
+		g.count(func(stats *GroupStats) { stats.Loads++ })
+		return g.loadLocally(ctx, key)
+	})
+	return value, err
+}
+
+func (g *Group) loadLocally(ctx context.Context, key string) ([]byte, error) {
+	value, err := g.getter.Get(ctx, key)
+	if err != nil {
+		g.count(func(stats *GroupStats) { stats.LocalLoadErrors++ })
+		return nil, err
+	}
+	g.count(func(stats *GroupStats) { stats.LocalLoads++ })
+	// a value too large for the cache is still returned
+	_ = g.cache.SetWithTime(key, value, g.ttl)
+	return value, nil
+}
+
+func (g *Group) keepHot(key string, value []byte) {
+	if g.hotCache == nil {
+		return
+	}
+	g.mu.Lock()
+	keep := g.rand.Intn(g.hotRatio) == 0
+	g.mu.Unlock()
+	if keep {
+		_ = g.hotCache.SetWithTime(key, value, g.hotTTL)
+	}
+}
@@ -0,0 +1,253 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	localcache "github.com/asong2020/go-localcache"
+)
+
+type groupTestSuite struct {
+	suite.Suite
+}
+
+func TestGroupTestSuite(t *testing.T) {
+	suite.Run(t, new(groupTestSuite))
+}
+
+func (g *groupTestSuite) SetupSuite() {}
+
+func (g *groupTestSuite) newCache() localcache.ICache {
+	c, err := localcache.NewCache(localcache.SetShardCount(4))
+	assert.Equal(g.T(), nil, err)
+	return c
+}
+
+// instance is a member of a test fleet
+type instance struct {
+	server *httptest.Server
+	pool   *HTTPPool
+	group  *Group
+	loads  int64
+}
+
+// fleet starts n instances sharing the ring, the getter of each one counts its loads.
+// Keys named "missing" are not found.
+func (g *groupTestSuite) fleet(n int, opts ...GroupOpt) []*instance {
+	instances := make([]*instance, n)
+	urls := make([]string, n)
+	for index := range instances {
+		inst := &instance{}
+		mux := http.NewServeMux()
+		inst.server = httptest.NewServer(mux)
+		inst.pool = NewHTTPPool(inst.server.URL)
+		mux.Handle(defaultBasePath, inst.pool)
+		getter := GetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+			atomic.AddInt64(&inst.loads, 1)
+			if key == "missing" {
+				return nil, ErrNotFound
+			}
+			return []byte("value of " + key), nil
+		})
+		inst.group = NewGroup("users", g.newCache(), getter, append([]GroupOpt{SetPeers(inst.pool)}, opts...)...)
+		inst.pool.Register(inst.group)
+		instances[index] = inst
+		urls[index] = inst.server.URL
+	}
+	for _, inst := range instances {
+		inst.pool.Set(urls...)
+	}
+	g.T().Cleanup(func() {
+		for _, inst := range instances {
+			inst.server.Close()
+		}
+	})
+	return instances
+}
+
+func (g *groupTestSuite) TestSingleOwner() {
+	instances := g.fleet(3)
+	ctx := context.Background()
+	keys := []string{"asong", "golang", "cache", "peer", "ring", "group"}
+	for _, inst := range instances {
+		for _, key := range keys {
+			value, err := inst.group.Get(ctx, key)
+			assert.Equal(g.T(), nil, err)
+			assert.Equal(g.T(), []byte("value of "+key), value)
+		}
+	}
+	// every key was loaded once, by its owner
+	loads := int64(0)
+	for _, inst := range instances {
+		loads += atomic.LoadInt64(&inst.loads)
+	}
+	assert.Equal(g.T(), int64(len(keys)), loads)
+
+	_, err := instances[0].group.Get(ctx, "missing")
+	assert.Equal(g.T(), ErrNotFound, err)
+	stats := instances[0].group.Stats()
+	assert.Equal(g.T(), int64(len(keys)+1), stats.Gets)
+	assert.True(g.T(), stats.PeerLoads > 0)
+	assert.Equal(g.T(), int64(0), stats.PeerErrors)
+}
+
+func (g *groupTestSuite) TestSingleflight() {
+	instances := g.fleet(1)
+	release := make(chan struct{})
+	group := NewGroup("slow", g.newCache(), GetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		atomic.AddInt64(&instances[0].loads, 1)
+		<-release
+		return []byte("value"), nil
+	}))
+
+	var wg sync.WaitGroup
+	for index := 0; index < 10; index++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := group.Get(context.Background(), "asong")
+			assert.Equal(g.T(), nil, err)
+			assert.Equal(g.T(), []byte("value"), value)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(g.T(), int64(1), atomic.LoadInt64(&instances[0].loads))
+}
+
+func (g *groupTestSuite) TestSingleflightCancel() {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	group := NewGroup("slow", g.newCache(), GetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		close(started)
+		select {
+		case <-release:
+			return []byte("value"), nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}))
+
+	// the first caller gives up, the one sharing its load still gets the value
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := group.Get(ctx, "asong")
+		errs <- err
+	}()
+	<-started
+	values := make(chan []byte, 1)
+	go func() {
+		value, err := group.Get(context.Background(), "asong")
+		assert.Equal(g.T(), nil, err)
+		values <- value
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	assert.Equal(g.T(), context.Canceled, <-errs)
+	close(release)
+	assert.Equal(g.T(), []byte("value"), <-values)
+}
+
+func (g *groupTestSuite) TestSingleflightPanic() {
+	release := make(chan struct{})
+	loads := int64(0)
+	group := NewGroup("panic", g.newCache(), GetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		if atomic.AddInt64(&loads, 1) == 1 {
+			<-release
+			panic("boom")
+		}
+		return []byte("value"), nil
+	}))
+
+	var wg sync.WaitGroup
+	for index := 0; index < 3; index++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := group.Get(context.Background(), "asong")
+			assert.NotEqual(g.T(), nil, err)
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	// the key is free again for the next load
+	value, err := group.Get(context.Background(), "asong")
+	assert.Equal(g.T(), nil, err)
+	assert.Equal(g.T(), []byte("value"), value)
+}
+
+func (g *groupTestSuite) TestHotCache() {
+	hot := g.newCache()
+	instances := g.fleet(2, SetHotCache(hot, time.Minute, 1))
+	ctx := context.Background()
+
+	// find a key owned by the second instance
+	key := ""
+	for _, candidate := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		if _, remote := instances[0].pool.PickPeer(candidate); remote {
+			key = candidate
+			break
+		}
+	}
+	assert.NotEqual(g.T(), "", key)
+
+	_, err := instances[0].group.Get(ctx, key)
+	assert.Equal(g.T(), nil, err)
+	_, err = instances[0].group.Get(ctx, key)
+	assert.Equal(g.T(), nil, err)
+	stats := instances[0].group.Stats()
+	assert.Equal(g.T(), int64(1), stats.PeerLoads)
+	assert.Equal(g.T(), int64(1), stats.HotHits)
+	assert.Equal(g.T(), int64(1), instances[1].group.Stats().ServerRequests)
+}
+
+func (g *groupTestSuite) TestPeerDown() {
+	instances := g.fleet(2)
+	instances[1].server.Close()
+	ctx := context.Background()
+
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		value, err := instances[0].group.Get(ctx, key)
+		assert.Equal(g.T(), nil, err)
+		assert.Equal(g.T(), []byte("value of "+key), value)
+	}
+	assert.True(g.T(), instances[0].group.Stats().PeerErrors > 0)
+	assert.Equal(g.T(), int64(8), atomic.LoadInt64(&instances[0].loads))
+}
+
+func (g *groupTestSuite) TestServeErrors() {
+	instances := g.fleet(1)
+	url := instances[0].server.URL + defaultBasePath
+
+	for path, status := range map[string]int{
+		"users/missing": http.StatusNotFound,
+		"other/asong":   http.StatusNotFound,
+		"users":         http.StatusBadRequest,
+		"users/asong":   http.StatusOK,
+	} {
+		resp, err := http.Get(url + path)
+		assert.Equal(g.T(), nil, err)
+		resp.Body.Close()
+		assert.Equal(g.T(), status, resp.StatusCode, path)
+	}
+
+	failing := NewGroup("failing", g.newCache(), GetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		return nil, errors.New("database down")
+	}))
+	instances[0].pool.Register(failing)
+	resp, err := http.Get(url + "failing/asong")
+	assert.Equal(g.T(), nil, err)
+	resp.Body.Close()
+	assert.Equal(g.T(), http.StatusInternalServerError, resp.StatusCode)
+}
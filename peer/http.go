@@ -0,0 +1,208 @@
+package peer
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBasePath = "/_localcache/"
+	defaultTimeout  = 5 * time.Second
+)
+
+type poolOptions struct {
+	basePath string
+	replicas int
+	hash     HashFn
+	client   *http.Client
+}
+
+// PoolOpt configures an HTTPPool
+type PoolOpt func(options *poolOptions)
+
+// SetBasePath sets the path the pool serves the peers on, "/_localcache/" by default.
+// Every instance of the fleet must use the same one.
+func SetBasePath(path string) PoolOpt {
+	return func(opt *poolOptions) {
+		opt.basePath = path
+	}
+}
+
+// SetReplicas sets the number of virtual nodes of every peer on the ring, 50 by default
+func SetReplicas(replicas int) PoolOpt {
+	return func(opt *poolOptions) {
+		opt.replicas = replicas
+	}
+}
+
+// SetRingHash sets the hash of the ring, crc32 by default
+func SetRingHash(hash HashFn) PoolOpt {
+	return func(opt *poolOptions) {
+		opt.hash = hash
+	}
+}
+
+// SetHTTPClient sets the client fetching from the peers, one with a 5 seconds timeout by default
+func SetHTTPClient(client *http.Client) PoolOpt {
+	return func(opt *poolOptions) {
+		opt.client = client
+	}
+}
+
+// HTTPPool is a PeerPicker whose peers are reached over HTTP, and the http.Handler serving
+// the keys this instance owns to them. Peers are identified by their base URL, such as
+// http://10.0.0.1:8000.
+type HTTPPool struct {
+	self     string
+	basePath string
+	replicas int
+	hash     HashFn
+	client   *http.Client
+
+	mu     sync.RWMutex
+	ring   *Ring
+	peers  map[string]*httpPeer
+	groups map[string]*Group
+}
+
+// NewHTTPPool creates a pool for the instance reachable at the base URL self
+func NewHTTPPool(self string, opts ...PoolOpt) *HTTPPool {
+	options := &poolOptions{
+		basePath: defaultBasePath,
+		client:   &http.Client{Timeout: defaultTimeout},
+	}
+	for _, each := range opts {
+		each(options)
+	}
+	if !strings.HasSuffix(options.basePath, "/") {
+		options.basePath += "/"
+	}
+	return &HTTPPool{
+		self:     strings.TrimSuffix(self, "/"),
+		basePath: options.basePath,
+		replicas: options.replicas,
+		hash:     options.hash,
+		client:   options.client,
+		ring:     NewRing(options.replicas, options.hash),
+		groups:   make(map[string]*Group),
+	}
+}
+
+// Set replaces the peers of the pool. The list should include self, and must be the same
+// on every instance for the keys to have a single owner.
+func (p *HTTPPool) Set(peers ...string) {
+	ring := NewRing(p.replicas, p.hash)
+	clients := make(map[string]*httpPeer, len(peers))
+	for _, peer := range peers {
+		peer = strings.TrimSuffix(peer, "/")
+		ring.Add(peer)
+		clients[peer] = &httpPeer{baseURL: peer + p.basePath, client: p.client}
+	}
+	p.mu.Lock()
+	p.ring = ring
+	p.peers = clients
+	p.mu.Unlock()
+}
+
+// Register serves the keys of g to the peers
+func (p *HTTPPool) Register(g *Group) {
+	p.mu.Lock()
+	p.groups[g.Name()] = g
+	p.mu.Unlock()
+}
+
+func (p *HTTPPool) PickPeer(key string) (Peer, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	owner := p.ring.Get(key)
+	if owner == "" || owner == p.self {
+		return nil, false
+	}
+	return p.peers[owner], true
+}
+
+// ServeHTTP serves GET {basePath}{group}/{key}
+func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.EscapedPath()
+	if !strings.HasPrefix(path, p.basePath) {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(path, p.basePath), "/", 2)
+	if len(parts) != 2 {
+		http.Error(w, "expected "+p.basePath+"{group}/{key}", http.StatusBadRequest)
+		return
+	}
+	groupName, err := url.PathUnescape(parts[0])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key, err := url.PathUnescape(parts[1])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p.mu.RLock()
+	group, ok := p.groups[groupName]
+	p.mu.RUnlock()
+	if !ok {
+		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
+		return
+	}
+
+	value, err := group.getOwned(r.Context(), key)
+	if err == ErrNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = w.Write(value)
+}
+
+// httpPeer fetches keys from a peer over HTTP
+type httpPeer struct {
+	baseURL string
+	client  *http.Client
+}
+
+func (h *httpPeer) Fetch(ctx context.Context, group string, key string) ([]byte, error) {
+	u := h.baseURL + url.PathEscape(group) + "/" + url.PathEscape(key)
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return body, nil
+	case http.StatusNotFound:
+		if strings.HasPrefix(string(body), ErrNotFound.Error()) {
+			return nil, ErrNotFound
+		}
+	}
+	return nil, fmt.Errorf("peer: %s returned %s", u, resp.Status)
+}
@@ -0,0 +1,97 @@
+package peer
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// defaultReplicas is the number of virtual nodes of every peer on the ring
+const defaultReplicas = 50
+
+// HashFn hashes the virtual node names and the keys onto the ring
+type HashFn func(data []byte) uint32
+
+// Ring is a consistent hash ring. Every peer is placed on it as replicas virtual nodes,
+// and a key belongs to the first virtual node following its hash. Adding or removing a
+// peer only moves the keys of that peer. A Ring is not safe for concurrent use.
+type Ring struct {
+	hash     HashFn
+	replicas int
+	// hashes holds the sorted hashes of the virtual nodes
+	hashes []uint32
+	nodes  map[uint32]string
+	peers  map[string]struct{}
+}
+
+// NewRing creates an empty ring with replicas virtual nodes per peer. A nil hash uses
+// crc32, replicas <= 0 uses 50.
+func NewRing(replicas int, hash HashFn) *Ring {
+	if replicas <= 0 {
+		replicas = defaultReplicas
+	}
+	if hash == nil {
+		hash = crc32.ChecksumIEEE
+	}
+	return &Ring{
+		hash:     hash,
+		replicas: replicas,
+		nodes:    make(map[uint32]string),
+		peers:    make(map[string]struct{}),
+	}
+}
+
+// Add places peers on the ring
+func (r *Ring) Add(peers ...string) {
+	for _, peer := range peers {
+		r.peers[peer] = struct{}{}
+		for i := 0; i < r.replicas; i++ {
+			hash := r.hash([]byte(strconv.Itoa(i) + peer))
+			// on a collision the smallest peer name wins, so the ring does not depend
+			// on the order peers are added in
+			if owner, ok := r.nodes[hash]; ok {
+				if owner <= peer {
+					continue
+				}
+			} else {
+				r.hashes = append(r.hashes, hash)
+			}
+			r.nodes[hash] = peer
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+}
+
+// Remove takes peer off the ring
+func (r *Ring) Remove(peer string) {
+	if _, ok := r.peers[peer]; !ok {
+		return
+	}
+	delete(r.peers, peer)
+	// rebuilt from scratch, a virtual node of peer may hide a colliding one
+	peers := make([]string, 0, len(r.peers))
+	for each := range r.peers {
+		peers = append(peers, each)
+	}
+	r.hashes = r.hashes[:0]
+	r.nodes = make(map[uint32]string, len(r.nodes))
+	r.Add(peers...)
+}
+
+// Get returns the peer owning key, "" for an empty ring
+func (r *Ring) Get(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	hash := r.hash([]byte(key))
+	index := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= hash })
+	if index == len(r.hashes) {
+		index = 0
+	}
+	return r.nodes[r.hashes[index]]
+}
+
+// Len returns the number of virtual nodes on the ring
+func (r *Ring) Len() int {
+	return len(r.hashes)
+}
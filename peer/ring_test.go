@@ -0,0 +1,68 @@
+package peer
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"strconv"
+	"testing"
+)
+
+type ringTestSuite struct {
+	suite.Suite
+}
+
+func TestRingTestSuite(t *testing.T) {
+	suite.Run(t, new(ringTestSuite))
+}
+
+func (r *ringTestSuite) SetupSuite() {}
+
+func (r *ringTestSuite) TestGet() {
+	// the hash of "{replica}{peer}" is the number it spells, so the ring is 2, 4, 6, 12, 14, 16, 22, 24, 26
+	ring := NewRing(3, func(data []byte) uint32 {
+		n, _ := strconv.Atoi(string(data))
+		return uint32(n)
+	})
+	assert.Equal(r.T(), "", ring.Get("1"))
+	ring.Add("6", "4", "2")
+	assert.Equal(r.T(), 9, ring.Len())
+
+	cases := map[string]string{"2": "2", "11": "2", "23": "4", "27": "2", "5": "6"}
+	for key, owner := range cases {
+		assert.Equal(r.T(), owner, ring.Get(key), key)
+	}
+
+	ring.Add("8")
+	assert.Equal(r.T(), "8", ring.Get("27"))
+	ring.Remove("8")
+	assert.Equal(r.T(), "2", ring.Get("27"))
+	ring.Remove("2")
+	assert.Equal(r.T(), "4", ring.Get("11"))
+	assert.Equal(r.T(), 6, ring.Len())
+}
+
+func (r *ringTestSuite) TestBalanceAndStability() {
+	ring := NewRing(100, nil)
+	ring.Add("a", "b", "c")
+	owners := make(map[string]string)
+	counts := make(map[string]int)
+	for index := 0; index < 3000; index++ {
+		key := fmt.Sprintf("key%d", index)
+		owners[key] = ring.Get(key)
+		counts[owners[key]]++
+	}
+	for _, count := range counts {
+		assert.InDelta(r.T(), 1000, count, 300)
+	}
+
+	ring.Add("d")
+	moved := 0
+	for key, owner := range owners {
+		if now := ring.Get(key); now != owner {
+			assert.Equal(r.T(), "d", now)
+			moved++
+		}
+	}
+	assert.InDelta(r.T(), 750, moved, 250)
+}
@@ -0,0 +1,74 @@
+package peer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// call is a load in progress or completed
+type call struct {
+	// done is closed once value and err are set
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+// flightGroup runs a single load per key at a time, the concurrent callers share its result
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// do runs fn for key, or waits for the run already in progress. shared reports whether
+// the result came from another caller's run. fn gets a context detached from ctx, so
+// one caller giving up does not fail the others: ctx only bounds the wait of its caller.
+// A panic of fn is returned as an error to every caller.
+func (g *flightGroup) do(ctx context.Context, key string, fn func(ctx context.Context) ([]byte, error)) (value []byte, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	c, shared := g.calls[key]
+	if !shared {
+		c = &call{done: make(chan struct{})}
+		g.calls[key] = c
+		go g.run(c, key, detachedContext{ctx}, fn)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.value, c.err, shared
+	case <-ctx.Done():
+		return nil, ctx.Err(), shared
+	}
+}
+
+func (g *flightGroup) run(c *call, key string, ctx context.Context, fn func(ctx context.Context) ([]byte, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.value, c.err = nil, fmt.Errorf("peer: load of %q panicked: %v", key, r)
+		}
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	c.value, c.err = fn(ctx)
+}
+
+// detachedContext carries the values of its parent but neither its deadline nor its
+// cancellation
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
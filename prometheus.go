@@ -0,0 +1,192 @@
+package localcache
+
+import (
+	"bufio"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultMetricsNamespace = "localcache"
+	prometheusContentType   = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+type prometheusOptions struct {
+	namespace   string
+	constLabels map[string]string
+}
+
+// PrometheusOpt configures the handler returned by NewPrometheusHandler
+type PrometheusOpt func(options *prometheusOptions)
+
+// SetMetricsNamespace sets the prefix of every exported metric name, "localcache" by default.
+// The namespace must be a valid prometheus metric name.
+func SetMetricsNamespace(namespace string) PrometheusOpt {
+	return func(opt *prometheusOptions) {
+		opt.namespace = namespace
+	}
+}
+
+// SetMetricsConstLabels sets labels attached to every exported sample, so that several
+// caches scraped from the same process can be told apart.
+func SetMetricsConstLabels(labels map[string]string) PrometheusOpt {
+	return func(opt *prometheusOptions) {
+		opt.constLabels = labels
+	}
+}
+
+type prometheusHandler struct {
+	cache     ICache
+	namespace string
+	// labels is the rendered list of const labels, without braces
+	labels string
+}
+
+// NewPrometheusHandler returns a http.Handler rendering the cache statistics in the
+// prometheus text exposition format. Counters are only maintained when the cache
+// is created with SetStatsEnabled(true).
+func NewPrometheusHandler(cache ICache, opts ...PrometheusOpt) http.Handler {
+	options := &prometheusOptions{
+		namespace: defaultMetricsNamespace,
+	}
+	for _, each := range opts {
+		each(options)
+	}
+	names := make([]string, 0, len(options.constLabels))
+	for name := range options.constLabels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+`="`+escapeLabelValue(options.constLabels[name])+`"`)
+	}
+	return &prometheusHandler{
+		cache:     cache,
+		namespace: options.namespace,
+		labels:    strings.Join(pairs, ","),
+	}
+}
+
+func (h *prometheusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", prometheusContentType)
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	stats := h.cache.Stats()
+	h.writeCounter(bw, "hits_total", "Number of successfully found keys.", float64(stats.Hits))
+	h.writeCounter(bw, "misses_total", "Number of not found keys.", float64(stats.Misses))
+	h.writeCounter(bw, "delete_hits_total", "Number of successfully deleted keys.", float64(stats.DelHits))
+	h.writeCounter(bw, "delete_misses_total", "Number of not deleted keys.", float64(stats.DelMisses))
+	h.writeCounter(bw, "collisions_total", "Number of key collisions.", float64(stats.Collisions))
+	h.writeCounter(bw, "evictions_total", "Number of entries evicted to make room for new ones.", float64(stats.Evictions))
+	h.writeCounter(bw, "expirations_total", "Number of entries removed because they expired.", float64(stats.Expirations))
+	h.writeCounter(bw, "expired_lazy_total", "Number of expired entries removed when they were read.", float64(stats.ExpiredLazy))
+	h.writeCounter(bw, "expired_cleanup_total", "Number of expired entries removed by the background cleanup.", float64(stats.ExpiredCleanup))
+	h.writeCounter(bw, "sets_total", "Number of successfully stored entries.", float64(stats.Sets))
+	h.writeCounter(bw, "overwrites_total", "Number of sets that replaced an existing entry.", float64(stats.Overwrites))
+	h.writeCounter(bw, "bytes_in_total", "Number of bytes written by sets.", float64(stats.BytesIn))
+	h.writeCounter(bw, "raw_value_bytes_total", "Number of value bytes written by sets, before compression.", float64(stats.RawValueBytes))
+	h.writeCounter(bw, "stored_value_bytes_total", "Number of value bytes written by sets, as stored.", float64(stats.StoredValueBytes))
+	h.writeCounter(bw, "l2_hits_total", "Number of memory misses found in the disk tier.", float64(stats.L2Hits))
+	h.writeCounter(bw, "l2_misses_total", "Number of memory misses not found in the disk tier either.", float64(stats.L2Misses))
+	h.writeGauge(bw, "entries", "Number of entries in the cache.", float64(h.cache.Len()))
+	h.writeGauge(bw, "bytes", "Number of bytes held by cache entries.", float64(stats.LiveBytes))
+	h.writeGauge(bw, "cost", "Total cost of the cache entries.", float64(stats.LiveCost))
+
+	if stats.Latency.Get.counts != nil {
+		h.writeLatency(bw, stats.Latency)
+	}
+
+	shards := h.cache.ShardStats()
+	h.writeCounter(bw, "lock_wait_seconds_total", "Time spent waiting for segment locks.", stats.LockWait.Seconds())
+	h.writeGauge(bw, "shard_skew", "Ratio of the most loaded shard to the mean shard load.", ShardSkew(shards))
+
+	h.writeShardMetric(bw, "shard_entries", "Number of entries in a shard.", "gauge", shards, func(shard ShardStat) float64 {
+		return float64(shard.Entries)
+	})
+	h.writeShardMetric(bw, "shard_bytes", "Number of bytes held by the entries of a shard.", "gauge", shards, func(shard ShardStat) float64 {
+		return float64(shard.Bytes)
+	})
+	h.writeShardMetric(bw, "shard_hits_total", "Number of successfully found keys in a shard.", "counter", shards, func(shard ShardStat) float64 {
+		return float64(shard.Hits)
+	})
+	h.writeShardMetric(bw, "shard_misses_total", "Number of not found keys in a shard.", "counter", shards, func(shard ShardStat) float64 {
+		return float64(shard.Misses)
+	})
+	h.writeShardMetric(bw, "shard_evictions_total", "Number of entries evicted from a shard.", "counter", shards, func(shard ShardStat) float64 {
+		return float64(shard.Evictions)
+	})
+	h.writeShardMetric(bw, "shard_lock_wait_seconds_total", "Time spent waiting for the lock of a shard.", "counter", shards, func(shard ShardStat) float64 {
+		return shard.LockWait.Seconds()
+	})
+}
+
+func (h *prometheusHandler) writeCounter(w *bufio.Writer, name, help string, value float64) {
+	h.writeHeader(w, name, help, "counter")
+	h.writeSample(w, name, h.labels, value)
+}
+
+func (h *prometheusHandler) writeGauge(w *bufio.Writer, name, help string, value float64) {
+	h.writeHeader(w, name, help, "gauge")
+	h.writeSample(w, name, h.labels, value)
+}
+
+func (h *prometheusHandler) writeShardMetric(w *bufio.Writer, name, help, kind string, shards []ShardStat, value func(shard ShardStat) float64) {
+	h.writeHeader(w, name, help, kind)
+	for index, shard := range shards {
+		labels := `shard="` + strconv.Itoa(index) + `"`
+		if h.labels != "" {
+			labels = h.labels + "," + labels
+		}
+		h.writeSample(w, name, labels, value(shard))
+	}
+}
+
+func (h *prometheusHandler) writeLatency(w *bufio.Writer, latency LatencyStats) {
+	name := "operation_duration_seconds"
+	h.writeHeader(w, name, "Latency of cache operations, including the lock wait.", "summary")
+	ops := []struct {
+		op        string
+		histogram LatencyHistogram
+	}{
+		{"get", latency.Get},
+		{"set", latency.Set},
+		{"delete", latency.Delete},
+		{"expire", latency.Expire},
+		{"lock_wait", latency.LockWait},
+	}
+	for _, each := range ops {
+		labels := `op="` + each.op + `"`
+		if h.labels != "" {
+			labels = h.labels + "," + labels
+		}
+		for _, q := range []string{"0.5", "0.9", "0.99", "0.999"} {
+			quantile, _ := strconv.ParseFloat(q, 64)
+			h.writeSample(w, name, labels+`,quantile="`+q+`"`, each.histogram.Quantile(quantile).Seconds())
+		}
+		h.writeSample(w, name+"_sum", labels, each.histogram.Sum.Seconds())
+		h.writeSample(w, name+"_count", labels, float64(each.histogram.Count))
+	}
+}
+
+func (h *prometheusHandler) writeHeader(w *bufio.Writer, name, help, kind string) {
+	w.WriteString("# HELP " + h.namespace + "_" + name + " " + help + "\n")
+	w.WriteString("# TYPE " + h.namespace + "_" + name + " " + kind + "\n")
+}
+
+func (h *prometheusHandler) writeSample(w *bufio.Writer, name, labels string, value float64) {
+	w.WriteString(h.namespace + "_" + name)
+	if labels != "" {
+		w.WriteString("{" + labels + "}")
+	}
+	w.WriteString(" " + strconv.FormatFloat(value, 'g', -1, 64) + "\n")
+}
+
+// escapeLabelValue escapes backslash, double-quote and line feed as required by the
+// text exposition format.
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,82 @@
+package localcache
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type prometheusTestSuite struct {
+	suite.Suite
+}
+
+func TestPrometheusTestSuite(t *testing.T) {
+	suite.Run(t, new(prometheusTestSuite))
+}
+
+func (p *prometheusTestSuite) SetupSuite() {}
+
+func (p *prometheusTestSuite) scrape(c ICache, opts ...PrometheusOpt) string {
+	server := httptest.NewServer(NewPrometheusHandler(c, opts...))
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL)
+	assert.Equal(p.T(), nil, err)
+	defer resp.Body.Close()
+	assert.Equal(p.T(), prometheusContentType, resp.Header.Get("Content-Type"))
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.Equal(p.T(), nil, err)
+	return string(body)
+}
+
+func (p *prometheusTestSuite) TestExposition() {
+	c, err := NewCache(SetStatsEnabled(true), SetShardCount(2))
+	assert.Equal(p.T(), nil, err)
+
+	value := []byte("公众号：Golang梦工厂")
+	for index := 0; index < 10; index++ {
+		err = c.Set(fmt.Sprintf("asong%03d", index), value)
+		assert.Equal(p.T(), nil, err)
+	}
+	_, _ = c.Get("asong000")
+	_, _ = c.Get("asong100")
+
+	body := p.scrape(c)
+	assert.Contains(p.T(), body, "# TYPE localcache_hits_total counter\nlocalcache_hits_total 1\n")
+	assert.Contains(p.T(), body, "localcache_misses_total 1\n")
+	assert.Contains(p.T(), body, "# TYPE localcache_entries gauge\nlocalcache_entries 10\n")
+	assert.Contains(p.T(), body, `localcache_shard_entries{shard="0"}`)
+	assert.Contains(p.T(), body, `localcache_shard_bytes{shard="1"}`)
+	assert.NotContains(p.T(), body, "localcache_bytes 0\n")
+}
+
+func (p *prometheusTestSuite) TestNamespaceAndConstLabels() {
+	c, err := NewCache(SetShardCount(1))
+	assert.Equal(p.T(), nil, err)
+
+	body := p.scrape(c, SetMetricsNamespace("session"), SetMetricsConstLabels(map[string]string{
+		"name": "users",
+		"env":  "a\"b",
+	}))
+	assert.Contains(p.T(), body, `session_entries{env="a\"b",name="users"} 0`)
+	assert.Contains(p.T(), body, `session_shard_entries{env="a\"b",name="users",shard="0"} 0`)
+	assert.False(p.T(), strings.Contains(body, "localcache_"))
+}
+
+func (p *prometheusTestSuite) TestLatencySummary() {
+	c, err := NewCache(SetLatencyEnabled(true), SetShardCount(1))
+	assert.Equal(p.T(), nil, err)
+	_, _ = c.Get("asong")
+
+	body := p.scrape(c)
+	assert.Contains(p.T(), body, "# TYPE localcache_operation_duration_seconds summary\n")
+	assert.Contains(p.T(), body, `localcache_operation_duration_seconds{op="get",quantile="0.99"}`)
+	assert.Contains(p.T(), body, `localcache_operation_duration_seconds_count{op="get"} 1`)
+
+	c, err = NewCache(SetShardCount(1))
+	assert.Equal(p.T(), nil, err)
+	assert.NotContains(p.T(), p.scrape(c), "operation_duration_seconds")
+}
@@ -0,0 +1,80 @@
+package replication
+
+import "sync"
+
+// backlog keeps the most recent records, up to maxSize bytes, so a follower that
+// reconnects can resume from its offset instead of taking a full snapshot
+type backlog struct {
+	mu      sync.Mutex
+	records []record
+	size    int
+	maxSize int
+	// start is the offset of records[0], next the offset of the next record
+	start uint64
+	next  uint64
+	// notify is closed and replaced when a record is appended
+	notify chan struct{}
+}
+
+func newBacklog(maxSize int) *backlog {
+	return &backlog{
+		maxSize: maxSize,
+		start:   1,
+		next:    1,
+		notify:  make(chan struct{}),
+	}
+}
+
+func (b *backlog) append(r record) {
+	b.mu.Lock()
+	r.offset = b.next
+	b.next++
+	b.records = append(b.records, r)
+	b.size += r.size()
+	trimmed := 0
+	for b.size > b.maxSize && trimmed < len(b.records)-1 {
+		b.size -= b.records[trimmed].size()
+		b.records[trimmed] = record{}
+		trimmed++
+	}
+	if trimmed > 0 {
+		b.records = b.records[trimmed:]
+		b.start += uint64(trimmed)
+	}
+	close(b.notify)
+	b.notify = make(chan struct{})
+	b.mu.Unlock()
+}
+
+// offset returns the offset of the next record
+func (b *backlog) offset() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.next
+}
+
+// contains reports whether the records from offset on are all available
+func (b *backlog) contains(offset uint64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return offset >= b.start && offset <= b.next
+}
+
+// read returns up to max records from offset on. ok is false when some of them were
+// already trimmed. When there are no records yet, the returned channel is closed once
+// one is appended.
+func (b *backlog) read(offset uint64, max int) (records []record, ok bool, notify <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if offset < b.start || offset > b.next {
+		return nil, false, nil
+	}
+	from := int(offset - b.start)
+	to := len(b.records)
+	if to-from > max {
+		to = from + max
+	}
+	records = make([]record, to-from)
+	copy(records, b.records[from:to])
+	return records, true, b.notify
+}
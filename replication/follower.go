@@ -0,0 +1,328 @@
+package replication
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	localcache "github.com/asong2020/go-localcache"
+)
+
+// Status is the replication state of a Follower
+type Status struct {
+	// Connected reports whether the follower is streaming from the primary
+	Connected bool
+	// ReplID is the replication id of the primary the follower synced from
+	ReplID string
+	// Offset is the offset of the next record the follower needs
+	Offset uint64
+	// PrimaryOffset is the offset of the primary as of its last ping
+	PrimaryOffset uint64
+	// Lag is the number of records the follower is behind the primary, as of its last ping
+	Lag uint64
+	// LastContact is when the follower last heard from the primary
+	LastContact time.Time
+	// FullSyncs is the number of snapshots the follower loaded
+	FullSyncs int64
+	// PartialSyncs is the number of times the follower resumed from its offset
+	PartialSyncs int64
+}
+
+// Follower keeps a cache in sync with a primary. It connects in the background and
+// reconnects whenever the connection is lost, resuming from its offset when the primary
+// still has the records it missed. While a snapshot is loading, readers of the cache see
+// a partial content.
+type Follower struct {
+	cache   localcache.ICache
+	addr    string
+	options *options
+
+	mu     sync.Mutex
+	status Status
+	conn   net.Conn
+	closed bool
+	close  chan struct{}
+	done   chan struct{}
+}
+
+// NewFollower starts replicating the primary at the TCP address addr into cache
+func NewFollower(cache localcache.ICache, addr string, opts ...Opt) *Follower {
+	f := &Follower{
+		cache:   cache,
+		addr:    addr,
+		options: newOptions(opts),
+		close:   make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go f.run()
+	return f
+}
+
+// Status returns the replication state of the follower
+func (f *Follower) Status() Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.status
+}
+
+// Lag returns the number of records the follower is behind the primary and the time
+// since it last heard from it
+func (f *Follower) Lag() (uint64, time.Duration) {
+	status := f.Status()
+	if status.LastContact.IsZero() {
+		return status.Lag, 0
+	}
+	return status.Lag, time.Since(status.LastContact)
+}
+
+// Close stops replicating. The cache is not closed.
+func (f *Follower) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	f.closed = true
+	close(f.close)
+	if f.conn != nil {
+		f.conn.Close()
+	}
+	f.mu.Unlock()
+	<-f.done
+	return nil
+}
+
+func (f *Follower) run() {
+	defer close(f.done)
+	delay := f.options.retryInterval
+	for {
+		synced, err := f.sync()
+		if err != nil {
+			f.reportError(err)
+		}
+		if synced {
+			delay = f.options.retryInterval
+		}
+		select {
+		case <-f.close:
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > defaultMaxRetryPeriod {
+			delay = defaultMaxRetryPeriod
+		}
+	}
+}
+
+func (f *Follower) reportError(err error) {
+	if f.options.onError != nil && !f.isClosed() {
+		f.options.onError(err)
+	}
+}
+
+func (f *Follower) isClosed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed
+}
+
+// sync connects to the primary and applies its stream until the connection fails.
+// synced reports whether the handshake succeeded.
+func (f *Follower) sync() (synced bool, err error) {
+	conn, err := net.DialTimeout("tcp", f.addr, ioTimeout)
+	if err != nil {
+		return false, err
+	}
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		conn.Close()
+		return false, nil
+	}
+	f.conn = conn
+	replID, offset := f.status.ReplID, f.status.Offset
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.conn = nil
+		f.status.Connected = false
+		f.mu.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(deadlineWriter{conn, ioTimeout})
+	body := appendBytes(nil, []byte(handshakeMagic))
+	body = appendBytes(body, []byte(replID))
+	if err := writeFrame(w, frameHandshake, appendUint64(body, offset)); err != nil {
+		return false, err
+	}
+	if err := w.Flush(); err != nil {
+		return false, err
+	}
+	if offset, err = f.handshake(conn, r, replID, offset); err != nil {
+		return false, err
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go f.sendAcks(conn, w, stop)
+	for {
+		conn.SetReadDeadline(time.Now().Add(3 * f.options.pingInterval))
+		frameType, body, err := readFrame(r)
+		if err != nil {
+			return true, err
+		}
+		now := time.Now()
+		switch frameType {
+		case frameRecord:
+			record, err := decodeRecord(body)
+			if err != nil {
+				return true, err
+			}
+			if record.offset != offset || record.op < opSet || record.op > opClear {
+				return true, errInvalidFrame
+			}
+			f.apply(record)
+			offset++
+			f.mu.Lock()
+			f.status.Offset = offset
+			if f.status.Lag > 0 {
+				f.status.Lag--
+			}
+			f.status.LastContact = now
+			f.mu.Unlock()
+		case framePing:
+			d := &decoder{buf: body}
+			primaryOffset := d.uint64()
+			if d.err != nil {
+				return true, d.err
+			}
+			f.mu.Lock()
+			f.status.PrimaryOffset = primaryOffset
+			f.status.Lag = 0
+			if primaryOffset > offset {
+				f.status.Lag = primaryOffset - offset
+			}
+			f.status.LastContact = now
+			f.mu.Unlock()
+		default:
+			return true, errInvalidFrame
+		}
+	}
+}
+
+// handshake reads the answer of the primary, loading its snapshot when it sends one, and
+// returns the offset the stream starts from
+func (f *Follower) handshake(conn net.Conn, r *bufio.Reader, replID string, offset uint64) (uint64, error) {
+	conn.SetReadDeadline(time.Now().Add(ioTimeout))
+	frameType, body, err := readFrame(r)
+	if err != nil {
+		return 0, err
+	}
+	d := &decoder{buf: body}
+	switch frameType {
+	case frameContinue:
+		if primaryID := string(d.bytes()); d.err != nil || primaryID != replID {
+			return 0, errInvalidFrame
+		}
+		f.mu.Lock()
+		f.status.Connected = true
+		f.status.PartialSyncs++
+		f.status.LastContact = time.Now()
+		f.mu.Unlock()
+		return offset, nil
+	case frameFullSync:
+		replID, offset = string(d.bytes()), d.uint64()
+		if d.err != nil {
+			return 0, d.err
+		}
+	default:
+		return 0, errInvalidFrame
+	}
+
+	// the records of the previous primary may be gone from the new one, so a snapshot
+	// always replaces the whole content
+	f.mu.Lock()
+	f.status.ReplID = ""
+	f.mu.Unlock()
+	if err := f.cache.Clear(); err != nil {
+		return 0, err
+	}
+	for {
+		conn.SetReadDeadline(time.Now().Add(ioTimeout))
+		frameType, body, err := readFrame(r)
+		if err != nil {
+			return 0, err
+		}
+		if frameType == frameSnapshotEnd {
+			break
+		}
+		if frameType != frameEntry {
+			return 0, errInvalidFrame
+		}
+		entry, err := decodeEntry(body)
+		if err != nil {
+			return 0, err
+		}
+		f.apply(entry)
+	}
+	f.mu.Lock()
+	f.status.Connected = true
+	f.status.ReplID = replID
+	f.status.Offset = offset
+	f.status.FullSyncs++
+	f.status.LastContact = time.Now()
+	f.mu.Unlock()
+	return offset, nil
+}
+
+// sendAcks tells the primary the offset of the follower every ping interval
+func (f *Follower) sendAcks(conn net.Conn, w *bufio.Writer, stop chan struct{}) {
+	ticker := time.NewTicker(f.options.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		f.mu.Lock()
+		offset := f.status.Offset
+		f.mu.Unlock()
+		if writeFrame(w, frameAck, appendUint64(nil, offset)) != nil || w.Flush() != nil {
+			conn.Close()
+			return
+		}
+	}
+}
+
+// apply replays a record on the cache. Records whose time to live ran out in transit
+// delete their key. Deletes only evict the key, the primary already wrote the store. A record the cache rejects, say a value too large for a smaller
+// follower, is reported and skipped rather than stopping the stream.
+func (f *Follower) apply(r ttlRecord) {
+	var err error
+	switch r.op {
+	case opSet:
+		if r.ttl <= 0 {
+			err = f.cache.Evict(r.key)
+		} else {
+			err = f.cache.SetWithTime(r.key, r.value, r.ttl)
+		}
+	case opDelete:
+		err = f.cache.Evict(r.key)
+	case opExpire:
+		if r.ttl <= 0 {
+			err = f.cache.Evict(r.key)
+		} else {
+			err = f.cache.Expire(r.key, r.ttl)
+		}
+	case opClear:
+		err = f.cache.Clear()
+	}
+	if err != nil && !errors.Is(err, localcache.ErrEntryNotFound) {
+		f.reportError(err)
+	}
+}
@@ -0,0 +1,493 @@
+// Package replication streams the writes of a primary cache to follower caches over TCP.
+// A follower that connects for the first time, or that fell too far behind, bootstraps
+// from a snapshot of every segment of the primary; a follower that reconnects after a
+// short outage resumes from its offset with the records kept in the backlog of the
+// primary. Followers are read replicas: writes made to them directly are not replicated
+// and are overwritten by the stream.
+package replication
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	localcache "github.com/asong2020/go-localcache"
+)
+
+// ErrServerClosed is returned by Serve and ListenAndServe after Close
+var ErrServerClosed = errors.New("replication: primary closed")
+
+const (
+	// lockStripes is the number of locks ordering the writes of a key with their records
+	lockStripes = 256
+	// sendBatch is the number of records read from the backlog at once
+	sendBatch = 1024
+	// ioTimeout bounds the handshake and every write to a peer
+	ioTimeout = 10 * time.Second
+
+	defaultBacklogSize    = 16 * 1024 * 1024
+	defaultPingInterval   = time.Second
+	defaultRetryInterval  = 100 * time.Millisecond
+	defaultMaxRetryPeriod = 5 * time.Second
+)
+
+type options struct {
+	backlogSize   int
+	pingInterval  time.Duration
+	retryInterval time.Duration
+	onError       func(err error)
+}
+
+// Opt configures a Primary or a Follower
+type Opt func(options *options)
+
+// SetBacklogSize sets the bytes of records the primary keeps for partial resyncs, 16MB by
+// default. A follower that falls behind by more takes a full snapshot again.
+func SetBacklogSize(size int) Opt {
+	return func(opt *options) {
+		opt.backlogSize = size
+	}
+}
+
+// SetPingInterval sets how often the primary sends its offset to idle followers and the
+// followers acknowledge theirs, one second by default. A peer silent for three intervals
+// is considered gone.
+func SetPingInterval(interval time.Duration) Opt {
+	return func(opt *options) {
+		opt.pingInterval = interval
+	}
+}
+
+// SetRetryInterval sets the first delay before a follower reconnects, doubled after every
+// failed attempt up to five seconds. 100ms by default.
+func SetRetryInterval(interval time.Duration) Opt {
+	return func(opt *options) {
+		opt.retryInterval = interval
+	}
+}
+
+// SetErrorHandler receives the connection and stream errors, which are otherwise dropped
+func SetErrorHandler(fn func(err error)) Opt {
+	return func(opt *options) {
+		opt.onError = fn
+	}
+}
+
+func newOptions(opts []Opt) *options {
+	options := &options{
+		backlogSize:   defaultBacklogSize,
+		pingInterval:  defaultPingInterval,
+		retryInterval: defaultRetryInterval,
+	}
+	for _, each := range opts {
+		each(options)
+	}
+	return options
+}
+
+// FollowerInfo is the replication state of a follower connected to a primary
+type FollowerInfo struct {
+	// Addr is the remote address of the follower
+	Addr string
+	// Offset is the next offset the follower acknowledged to need
+	Offset uint64
+	// Lag is the number of records the follower has yet to acknowledge
+	Lag uint64
+	// LastAck is when the follower acknowledged last
+	LastAck time.Time
+}
+
+// Primary wraps a cache and logs its Set, SetWithTime, SetWithCost, Delete, Expire and
+// Clear calls for the followers. The methods not listed here are served by the wrapped
+// cache unchanged. Writes to the wrapped cache that bypass the Primary are not replicated.
+type Primary struct {
+	localcache.ICache
+	options  *options
+	replID   string
+	backlog  *backlog
+	hashFunc localcache.HashFunc
+	locks    [lockStripes]sync.Mutex
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]*followerConn
+	closed    bool
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// followerConn is the state of a connected follower
+type followerConn struct {
+	addr string
+	// ack and lastAck are accessed atomically
+	ack     uint64
+	lastAck int64
+}
+
+// NewPrimary wraps cache. Every primary gets a new replication id, so the followers of
+// a restarted primary take a full snapshot.
+func NewPrimary(cache localcache.ICache, opts ...Opt) *Primary {
+	options := newOptions(opts)
+	return &Primary{
+		ICache:    cache,
+		options:   options,
+		replID:    newReplID(),
+		backlog:   newBacklog(options.backlogSize),
+		hashFunc:  localcache.NewDefaultHashFunc(),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]*followerConn),
+		done:      make(chan struct{}),
+	}
+}
+
+func newReplID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return time.Now().Format(time.RFC3339Nano)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// ReplID returns the replication id of the primary
+func (p *Primary) ReplID() string {
+	return p.replID
+}
+
+// Offset returns the offset the next record gets
+func (p *Primary) Offset() uint64 {
+	return p.backlog.offset()
+}
+
+// lockKey locks the stripe of key and returns its unlock function
+func (p *Primary) lockKey(key string) func() {
+	mu := &p.locks[p.hashFunc.Sum64(key)%lockStripes]
+	mu.Lock()
+	return mu.Unlock
+}
+
+func (p *Primary) Set(key string, value []byte) error {
+	defer p.lockKey(key)()
+	if err := p.ICache.Set(key, value); err != nil {
+		return err
+	}
+	p.logSet(key, value)
+	return nil
+}
+
+func (p *Primary) SetWithTime(key string, value []byte, expired time.Duration) error {
+	defer p.lockKey(key)()
+	if err := p.ICache.SetWithTime(key, value, expired); err != nil {
+		return err
+	}
+	p.logSet(key, value)
+	return nil
+}
+
+// SetWithCost is replicated as a plain set, the followers weigh the entry themselves
+func (p *Primary) SetWithCost(key string, value []byte, expired time.Duration, cost int64) error {
+	defer p.lockKey(key)()
+	if err := p.ICache.SetWithCost(key, value, expired, cost); err != nil {
+		return err
+	}
+	p.logSet(key, value)
+	return nil
+}
+
+// logSet records the expire time the cache gave key, so the followers expire it together
+// with the primary
+func (p *Primary) logSet(key string, value []byte) {
+	ttl, err := p.ICache.TTL(key)
+	if err != nil {
+		p.backlog.append(record{op: opDelete, key: key})
+		return
+	}
+	// the caller may reuse value once the set returns, the backlog keeps its own copy
+	p.backlog.append(record{op: opSet, key: key, value: append([]byte(nil), value...), expireAt: time.Now().Add(ttl)})
+}
+
+func (p *Primary) Delete(key string) error {
+	defer p.lockKey(key)()
+	err := p.ICache.Delete(key)
+	if err != nil && !errors.Is(err, localcache.ErrEntryNotFound) {
+		return err
+	}
+	p.backlog.append(record{op: opDelete, key: key})
+	return err
+}
+
+func (p *Primary) Expire(key string, expired time.Duration) error {
+	defer p.lockKey(key)()
+	if err := p.ICache.Expire(key, expired); err != nil {
+		return err
+	}
+	ttl, err := p.ICache.TTL(key)
+	if err != nil {
+		p.backlog.append(record{op: opDelete, key: key})
+		return nil
+	}
+	p.backlog.append(record{op: opExpire, key: key, expireAt: time.Now().Add(ttl)})
+	return nil
+}
+
+func (p *Primary) Clear() error {
+	for i := range p.locks {
+		p.locks[i].Lock()
+	}
+	defer func() {
+		for i := range p.locks {
+			p.locks[i].Unlock()
+		}
+	}()
+	if err := p.ICache.Clear(); err != nil {
+		return err
+	}
+	p.backlog.append(record{op: opClear})
+	return nil
+}
+
+// Followers returns the state of the connected followers
+func (p *Primary) Followers() []FollowerInfo {
+	offset := p.backlog.offset()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	res := make([]FollowerInfo, 0, len(p.conns))
+	for _, f := range p.conns {
+		info := FollowerInfo{
+			Addr:    f.addr,
+			Offset:  atomic.LoadUint64(&f.ack),
+			LastAck: time.Unix(0, atomic.LoadInt64(&f.lastAck)),
+		}
+		if offset > info.Offset {
+			info.Lag = offset - info.Offset
+		}
+		res = append(res, info)
+	}
+	return res
+}
+
+// ListenAndServe listens on the TCP address addr and serves the followers
+func (p *Primary) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return p.Serve(l)
+}
+
+// Serve accepts followers on l until it fails or the primary is closed, it always returns
+// a non nil error. The listener is closed on return.
+func (p *Primary) Serve(l net.Listener) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	p.listeners[l] = struct{}{}
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.listeners, l)
+		p.mu.Unlock()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if p.isClosed() {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		f := &followerConn{addr: conn.RemoteAddr().String(), lastAck: time.Now().UnixNano()}
+		p.conns[conn] = f
+		p.wg.Add(1)
+		p.mu.Unlock()
+		go p.serveFollower(conn, f)
+	}
+}
+
+func (p *Primary) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+// Close disconnects the followers and stops the listeners. The wrapped cache is not closed.
+func (p *Primary) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	for l := range p.listeners {
+		l.Close()
+	}
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+	return nil
+}
+
+func (p *Primary) reportError(err error) {
+	if p.options.onError != nil && !p.isClosed() {
+		p.options.onError(err)
+	}
+}
+
+func (p *Primary) serveFollower(conn net.Conn, f *followerConn) {
+	defer p.wg.Done()
+	defer func() {
+		p.mu.Lock()
+		delete(p.conns, conn)
+		p.mu.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(deadlineWriter{conn, ioTimeout})
+	conn.SetReadDeadline(time.Now().Add(ioTimeout))
+	from, err := p.handshake(r, w)
+	if err != nil {
+		p.reportError(err)
+		return
+	}
+	atomic.StoreUint64(&f.ack, from)
+
+	go p.readAcks(conn, r, f)
+	if err := p.stream(conn, w, from); err != nil {
+		p.reportError(err)
+	}
+}
+
+// handshake answers the follower and returns the offset the stream starts from. A
+// follower of this primary whose offset is still in the backlog continues; any other
+// gets a snapshot first.
+func (p *Primary) handshake(r *bufio.Reader, w *bufio.Writer) (uint64, error) {
+	frameType, body, err := readFrame(r)
+	if err != nil {
+		return 0, err
+	}
+	d := &decoder{buf: body}
+	magic, replID, offset := string(d.bytes()), string(d.bytes()), d.uint64()
+	if frameType != frameHandshake || d.err != nil || magic != handshakeMagic {
+		return 0, errInvalidFrame
+	}
+	if replID == p.replID && p.backlog.contains(offset) {
+		if err := writeFrame(w, frameContinue, appendBytes(nil, []byte(p.replID))); err != nil {
+			return 0, err
+		}
+		return offset, w.Flush()
+	}
+
+	// records appended from here on are streamed after the snapshot. Those already part
+	// of it are applied twice, which is harmless as every record carries a whole state.
+	from := p.backlog.offset()
+	if err := writeFrame(w, frameFullSync, appendUint64(appendBytes(nil, []byte(p.replID)), from)); err != nil {
+		return 0, err
+	}
+	// Snapshot holds a segment read lock while calling back, so the entries are encoded in
+	// memory and only sent once it returns: a slow follower must not hold up the writers
+	now := time.Now()
+	var snapshot bytes.Buffer
+	sw := bufio.NewWriter(&snapshot)
+	err = p.ICache.Snapshot(func(key string, value []byte, expireAt time.Time) error {
+		ttl := ttlMillis(expireAt, now)
+		if ttl <= 0 {
+			return nil
+		}
+		return writeFrame(sw, frameEntry, encodeEntry(key, value, ttl))
+	})
+	if err == nil {
+		err = sw.Flush()
+	}
+	if err != nil {
+		return 0, err
+	}
+	if _, err := snapshot.WriteTo(w); err != nil {
+		return 0, err
+	}
+	if err := writeFrame(w, frameSnapshotEnd, nil); err != nil {
+		return 0, err
+	}
+	return from, w.Flush()
+}
+
+// readAcks records the offsets acknowledged by the follower. Silence for three ping
+// intervals closes the connection, which ends the stream.
+func (p *Primary) readAcks(conn net.Conn, r *bufio.Reader, f *followerConn) {
+	for {
+		conn.SetReadDeadline(time.Now().Add(3 * p.options.pingInterval))
+		frameType, body, err := readFrame(r)
+		if err != nil {
+			conn.Close()
+			return
+		}
+		if frameType != frameAck {
+			continue
+		}
+		d := &decoder{buf: body}
+		if offset := d.uint64(); d.err == nil {
+			atomic.StoreUint64(&f.ack, offset)
+			atomic.StoreInt64(&f.lastAck, time.Now().UnixNano())
+		}
+	}
+}
+
+// stream sends the records of the backlog from offset on as they are appended, and the
+// offset of the primary every ping interval
+func (p *Primary) stream(conn net.Conn, w *bufio.Writer, offset uint64) error {
+	ticker := time.NewTicker(p.options.pingInterval)
+	defer ticker.Stop()
+	for {
+		records, ok, notify := p.backlog.read(offset, sendBatch)
+		if !ok {
+			// the follower is too slow, it takes a snapshot when it reconnects
+			return errors.New("replication: follower " + conn.RemoteAddr().String() + " fell out of the backlog")
+		}
+		now := time.Now()
+		for _, r := range records {
+			if err := writeFrame(w, frameRecord, encodeRecord(r, now)); err != nil {
+				return err
+			}
+			offset = r.offset + 1
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if len(records) == sendBatch {
+			continue
+		}
+		select {
+		case <-notify:
+		case <-ticker.C:
+			if err := writeFrame(w, framePing, appendUint64(nil, p.backlog.offset())); err != nil {
+				return err
+			}
+		case <-p.done:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package replication
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// frame types. A frame is type | uvarint(len(body)) | body.
+const (
+	// frameHandshake opens a connection: magic | replication id | offset wanted
+	frameHandshake byte = iota + 1
+	// frameFullSync announces a snapshot: replication id | offset the stream resumes from
+	frameFullSync
+	// frameContinue accepts a partial resync: replication id
+	frameContinue
+	// frameEntry is an entry of the snapshot: ttl | key | value
+	frameEntry
+	// frameSnapshotEnd ends the snapshot
+	frameSnapshotEnd
+	// frameRecord is a logged mutation: offset | op | ttl | key | value
+	frameRecord
+	// framePing carries the offset of the primary: offset
+	framePing
+	// frameAck carries the offset applied by the follower: offset
+	frameAck
+)
+
+// record operations
+const (
+	opSet byte = iota + 1
+	opDelete
+	opExpire
+	opClear
+)
+
+const (
+	handshakeMagic = "LCREPL1"
+	// maxFrameSize bounds the memory a malformed frame can make a peer allocate
+	maxFrameSize = 64 * 1024 * 1024
+)
+
+var errInvalidFrame = errors.New("replication: invalid frame")
+
+// record is a mutation of the primary cache. Set and expire records carry the absolute
+// expire time, which is turned into a time to live when the record is sent.
+type record struct {
+	offset   uint64
+	op       byte
+	key      string
+	value    []byte
+	expireAt time.Time
+}
+
+func (r record) size() int {
+	return 32 + len(r.key) + len(r.value)
+}
+
+func writeFrame(w *bufio.Writer, frameType byte, body []byte) error {
+	var header [1 + binary.MaxVarintLen64]byte
+	header[0] = frameType
+	n := 1 + binary.PutUvarint(header[1:], uint64(len(body)))
+	if _, err := w.Write(header[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(body)
+	return err
+}
+
+func readFrame(r *bufio.Reader) (byte, []byte, error) {
+	frameType, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if length > maxFrameSize {
+		return 0, nil, errInvalidFrame
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return frameType, body, nil
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	return append(buf, b[:]...)
+}
+
+func appendBytes(buf []byte, b []byte) []byte {
+	var n [binary.MaxVarintLen64]byte
+	buf = append(buf, n[:binary.PutUvarint(n[:], uint64(len(b)))]...)
+	return append(buf, b...)
+}
+
+// ttlMillis is the time to live left at now in milliseconds, at least 1 so that a
+// positive time is never mistaken for an expired entry
+func ttlMillis(expireAt time.Time, now time.Time) int64 {
+	ttl := expireAt.Sub(now).Milliseconds()
+	if ttl < 1 && expireAt.After(now) {
+		ttl = 1
+	}
+	return ttl
+}
+
+func encodeRecord(r record, now time.Time) []byte {
+	buf := make([]byte, 0, 8+1+8+2*binary.MaxVarintLen64+len(r.key)+len(r.value))
+	buf = appendUint64(buf, r.offset)
+	buf = append(buf, r.op)
+	buf = appendUint64(buf, uint64(ttlMillis(r.expireAt, now)))
+	buf = appendBytes(buf, []byte(r.key))
+	return appendBytes(buf, r.value)
+}
+
+func encodeEntry(key string, value []byte, ttl int64) []byte {
+	buf := make([]byte, 0, 8+2*binary.MaxVarintLen64+len(key)+len(value))
+	buf = appendUint64(buf, uint64(ttl))
+	buf = appendBytes(buf, []byte(key))
+	return appendBytes(buf, value)
+}
+
+// decoder reads the fields of a frame body, the first failure sticks in err
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) uint64() uint64 {
+	if d.err != nil || len(d.buf) < 8 {
+		d.err = errInvalidFrame
+		return 0
+	}
+	v := binary.LittleEndian.Uint64(d.buf)
+	d.buf = d.buf[8:]
+	return v
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.buf) < 1 {
+		d.err = errInvalidFrame
+		return 0
+	}
+	v := d.buf[0]
+	d.buf = d.buf[1:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	if d.err != nil {
+		return nil
+	}
+	length, n := binary.Uvarint(d.buf)
+	if n <= 0 || uint64(len(d.buf)-n) < length {
+		d.err = errInvalidFrame
+		return nil
+	}
+	v := d.buf[n : n+int(length)]
+	d.buf = d.buf[n+int(length):]
+	return v
+}
+
+// ttlRecord is a decoded record, with the time to live it had when it was sent
+type ttlRecord struct {
+	offset uint64
+	op     byte
+	ttl    time.Duration
+	key    string
+	value  []byte
+}
+
+func decodeRecord(body []byte) (ttlRecord, error) {
+	d := &decoder{buf: body}
+	r := ttlRecord{
+		offset: d.uint64(),
+		op:     d.byte(),
+		ttl:    time.Duration(int64(d.uint64())) * time.Millisecond,
+		key:    string(d.bytes()),
+		value:  d.bytes(),
+	}
+	return r, d.err
+}
+
+func decodeEntry(body []byte) (ttlRecord, error) {
+	d := &decoder{buf: body}
+	r := ttlRecord{
+		op:    opSet,
+		ttl:   time.Duration(int64(d.uint64())) * time.Millisecond,
+		key:   string(d.bytes()),
+		value: d.bytes(),
+	}
+	return r, d.err
+}
+
+// deadlineWriter pushes the write deadline of conn forward before every write, so a peer
+// that stops reading is dropped while a long snapshot to a live one is not cut short
+type deadlineWriter struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (w deadlineWriter) Write(p []byte) (int, error) {
+	w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+	return w.conn.Write(p)
+}
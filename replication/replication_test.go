@@ -0,0 +1,286 @@
+package replication
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	localcache "github.com/asong2020/go-localcache"
+)
+
+type replicationTestSuite struct {
+	suite.Suite
+}
+
+func TestReplicationTestSuite(t *testing.T) {
+	suite.Run(t, new(replicationTestSuite))
+}
+
+func (r *replicationTestSuite) SetupSuite() {}
+
+// trackingListener remembers the accepted connections so a test can cut them
+type trackingListener struct {
+	net.Listener
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func (l *trackingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.mu.Lock()
+		l.conns = append(l.conns, conn)
+		l.mu.Unlock()
+	}
+	return conn, err
+}
+
+func (l *trackingListener) cut() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, conn := range l.conns {
+		conn.Close()
+	}
+	l.conns = nil
+}
+
+func (r *replicationTestSuite) newCache() localcache.ICache {
+	cache, err := localcache.NewCache(localcache.SetShardCount(4))
+	assert.Equal(r.T(), nil, err)
+	return cache
+}
+
+// serve starts a primary on a loopback port
+func (r *replicationTestSuite) serve(opts ...Opt) (*Primary, *trackingListener) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(r.T(), nil, err)
+	listener := &trackingListener{Listener: l}
+	primary := NewPrimary(r.newCache(), opts...)
+	go primary.Serve(listener)
+	return primary, listener
+}
+
+func (r *replicationTestSuite) waitValue(cache localcache.ICache, key string, value string) {
+	assert.Eventually(r.T(), func() bool {
+		res, err := cache.Get(key)
+		return err == nil && string(res) == value
+	}, 3*time.Second, 10*time.Millisecond, key)
+}
+
+func (r *replicationTestSuite) waitMissing(cache localcache.ICache, key string) {
+	assert.Eventually(r.T(), func() bool {
+		_, err := cache.Get(key)
+		return err == localcache.ErrEntryNotFound
+	}, 3*time.Second, 10*time.Millisecond, key)
+}
+
+func (r *replicationTestSuite) TestFullSyncAndStream() {
+	primary, listener := r.serve(SetPingInterval(20 * time.Millisecond))
+	defer primary.Close()
+	assert.Equal(r.T(), nil, primary.Set("asong", []byte("1")))
+	assert.Equal(r.T(), nil, primary.SetWithTime("short", []byte("2"), 10*time.Second))
+	assert.Equal(r.T(), nil, primary.Set("removed", []byte("3")))
+	assert.Equal(r.T(), nil, primary.Delete("removed"))
+
+	cache := r.newCache()
+	assert.Equal(r.T(), nil, cache.Set("stale", []byte("value")))
+	follower := NewFollower(cache, listener.Addr().String(), SetPingInterval(20*time.Millisecond))
+	defer follower.Close()
+
+	r.waitValue(cache, "asong", "1")
+	r.waitValue(cache, "short", "2")
+	_, err := cache.Get("stale")
+	assert.Equal(r.T(), localcache.ErrEntryNotFound, err)
+	_, err = cache.Get("removed")
+	assert.Equal(r.T(), localcache.ErrEntryNotFound, err)
+	ttl, err := cache.TTL("short")
+	assert.Equal(r.T(), nil, err)
+	assert.True(r.T(), ttl > 8*time.Second && ttl <= 10*time.Second)
+
+	assert.Equal(r.T(), nil, primary.Set("asong", []byte("updated")))
+	r.waitValue(cache, "asong", "updated")
+	assert.Equal(r.T(), nil, primary.SetWithCost("costly", []byte("4"), 10*time.Second, 50))
+	r.waitValue(cache, "costly", "4")
+	assert.Equal(r.T(), nil, primary.Expire("asong", 100*time.Second))
+	assert.Eventually(r.T(), func() bool {
+		ttl, err := cache.TTL("asong")
+		return err == nil && ttl > 90*time.Second
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(r.T(), nil, primary.Delete("short"))
+	r.waitMissing(cache, "short")
+	assert.Equal(r.T(), nil, primary.Clear())
+	r.waitMissing(cache, "asong")
+
+	assert.Eventually(r.T(), func() bool {
+		status := follower.Status()
+		followers := primary.Followers()
+		return status.Offset == primary.Offset() && status.Lag == 0 &&
+			len(followers) == 1 && followers[0].Lag == 0
+	}, 3*time.Second, 10*time.Millisecond)
+	status := follower.Status()
+	assert.True(r.T(), status.Connected)
+	assert.Equal(r.T(), primary.ReplID(), status.ReplID)
+	assert.Equal(r.T(), int64(1), status.FullSyncs)
+	assert.Equal(r.T(), int64(0), status.PartialSyncs)
+	lag, since := follower.Lag()
+	assert.Equal(r.T(), uint64(0), lag)
+	assert.True(r.T(), since < time.Second)
+}
+
+func (r *replicationTestSuite) TestPartialResync() {
+	primary, listener := r.serve()
+	defer primary.Close()
+	cache := r.newCache()
+	follower := NewFollower(cache, listener.Addr().String(), SetRetryInterval(10*time.Millisecond))
+	defer follower.Close()
+
+	assert.Equal(r.T(), nil, primary.Set("asong", []byte("1")))
+	r.waitValue(cache, "asong", "1")
+
+	listener.cut()
+	assert.Equal(r.T(), nil, primary.Set("asong", []byte("2")))
+	assert.Equal(r.T(), nil, primary.Set("other", []byte("3")))
+	r.waitValue(cache, "asong", "2")
+	r.waitValue(cache, "other", "3")
+
+	status := follower.Status()
+	assert.Equal(r.T(), int64(1), status.FullSyncs)
+	assert.Equal(r.T(), int64(1), status.PartialSyncs)
+	assert.Equal(r.T(), primary.Offset(), status.Offset)
+}
+
+func (r *replicationTestSuite) TestBacklogOverflow() {
+	primary, listener := r.serve(SetBacklogSize(256))
+	defer primary.Close()
+	cache := r.newCache()
+	follower := NewFollower(cache, listener.Addr().String(), SetRetryInterval(50*time.Millisecond))
+	defer follower.Close()
+
+	assert.Equal(r.T(), nil, primary.Set("asong", []byte("1")))
+	r.waitValue(cache, "asong", "1")
+
+	listener.cut()
+	for i := 0; i < 100; i++ {
+		assert.Equal(r.T(), nil, primary.Set("asong", []byte{byte(i)}))
+	}
+	assert.Equal(r.T(), nil, primary.Set("last", []byte("value")))
+	r.waitValue(cache, "last", "value")
+	r.waitValue(cache, "asong", string([]byte{99}))
+	assert.Equal(r.T(), int64(2), follower.Status().FullSyncs)
+}
+
+func (r *replicationTestSuite) TestRestartedPrimary() {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(r.T(), nil, err)
+	addr := l.Addr().String()
+	primary := NewPrimary(r.newCache())
+	go primary.Serve(l)
+	assert.Equal(r.T(), nil, primary.Set("asong", []byte("1")))
+
+	cache := r.newCache()
+	follower := NewFollower(cache, addr, SetRetryInterval(10*time.Millisecond))
+	defer follower.Close()
+	r.waitValue(cache, "asong", "1")
+	primary.Close()
+
+	l, err = net.Listen("tcp", addr)
+	assert.Equal(r.T(), nil, err)
+	primary = NewPrimary(r.newCache())
+	defer primary.Close()
+	go primary.Serve(l)
+	assert.Equal(r.T(), nil, primary.Set("other", []byte("2")))
+	r.waitValue(cache, "other", "2")
+	r.waitMissing(cache, "asong")
+	assert.Equal(r.T(), primary.ReplID(), follower.Status().ReplID)
+	assert.Equal(r.T(), int64(2), follower.Status().FullSyncs)
+}
+
+func (r *replicationTestSuite) TestStalledFollowerDoesNotBlockWriters() {
+	primary, listener := r.serve()
+	defer primary.Close()
+	value := bytes.Repeat([]byte("a"), 256*1024)
+	for i := 0; i < 64; i++ {
+		assert.Equal(r.T(), nil, primary.Set(fmt.Sprintf("asong%02d", i), value))
+	}
+
+	// a follower that asks for a snapshot and never reads it
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Equal(r.T(), nil, err)
+	defer conn.Close()
+	w := bufio.NewWriter(conn)
+	body := appendBytes(appendBytes(nil, []byte(handshakeMagic)), nil)
+	assert.Equal(r.T(), nil, writeFrame(w, frameHandshake, appendUint64(body, 0)))
+	assert.Equal(r.T(), nil, w.Flush())
+	time.Sleep(200 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 64; i++ {
+			_ = primary.Set(fmt.Sprintf("asong%02d", i), []byte("value"))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		r.T().Fatal("writers blocked by a stalled follower")
+	}
+}
+
+func (r *replicationTestSuite) TestLogSetCopiesValue() {
+	primary := NewPrimary(r.newCache())
+	defer primary.Close()
+	value := []byte("value")
+	assert.Equal(r.T(), nil, primary.Set("asong", value))
+	copy(value, "xxxxx")
+
+	records, ok, _ := primary.backlog.read(1, 1)
+	assert.True(r.T(), ok)
+	assert.Equal(r.T(), 1, len(records))
+	assert.Equal(r.T(), []byte("value"), records[0].value)
+}
+
+func (r *replicationTestSuite) TestBacklog() {
+	b := newBacklog(3 * record{key: "k"}.size())
+	for i := 0; i < 5; i++ {
+		b.append(record{op: opSet, key: "k"})
+	}
+	assert.Equal(r.T(), uint64(6), b.offset())
+	assert.False(r.T(), b.contains(2))
+	assert.True(r.T(), b.contains(3))
+	assert.True(r.T(), b.contains(6))
+	assert.False(r.T(), b.contains(7))
+
+	records, ok, _ := b.read(4, 1)
+	assert.True(r.T(), ok)
+	assert.Equal(r.T(), 1, len(records))
+	assert.Equal(r.T(), uint64(4), records[0].offset)
+	records, ok, notify := b.read(6, 10)
+	assert.True(r.T(), ok)
+	assert.Equal(r.T(), 0, len(records))
+	b.append(record{op: opDelete, key: "k"})
+	<-notify
+	_, ok, _ = b.read(1, 10)
+	assert.False(r.T(), ok)
+}
+
+func (r *replicationTestSuite) TestRecordEncoding() {
+	now := time.Now()
+	body := encodeRecord(record{offset: 7, op: opSet, key: "asong", value: []byte("value"), expireAt: now.Add(time.Minute)}, now)
+	decoded, err := decodeRecord(body)
+	assert.Equal(r.T(), nil, err)
+	assert.Equal(r.T(), uint64(7), decoded.offset)
+	assert.Equal(r.T(), opSet, decoded.op)
+	assert.Equal(r.T(), time.Minute, decoded.ttl)
+	assert.Equal(r.T(), "asong", decoded.key)
+	assert.Equal(r.T(), []byte("value"), decoded.value)
+
+	_, err = decodeRecord(body[:len(body)-1])
+	assert.Equal(r.T(), errInvalidFrame, err)
+}
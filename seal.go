@@ -0,0 +1,170 @@
+package localcache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// KeyProvider hands out the AES keys values are sealed with. Keys are 16, 24 or 32 bytes
+// long and a key id must always name the same key.
+type KeyProvider interface {
+	// CurrentKey returns the key new values are sealed with
+	CurrentKey() (id uint32, key []byte, err error)
+	// Key returns the key of id, which values sealed before a rotation still need
+	Key(id uint32) ([]byte, error)
+}
+
+var (
+	// ErrEntryTampered is returned when a sealed value fails authentication, because it
+	// was modified or moved to another key
+	ErrEntryTampered = errors.New("sealed entry failed authentication")
+	// ErrUnknownKeyID is returned by KeyRing for a key id it does not hold
+	ErrUnknownKeyID = errors.New("unknown encryption key id")
+	// ErrKeySize is returned for a key that is not 16, 24 or 32 bytes long
+	ErrKeySize = errors.New("encryption key must be 16, 24 or 32 bytes long")
+)
+
+const (
+	keyIDSizeInBytes = 4
+	nonceSizeInBytes = 12
+	// sealOverhead is the key id, the nonce and the GCM tag added to a sealed value
+	sealOverhead = keyIDSizeInBytes + nonceSizeInBytes + 16
+)
+
+// KeyRing is a KeyProvider holding its keys in memory. Rotate switches new values to
+// another key while the previous keys keep opening the values they sealed.
+type KeyRing struct {
+	mu      sync.RWMutex
+	current uint32
+	keys    map[uint32][]byte
+}
+
+// NewKeyRing creates a key ring sealing with key under id
+func NewKeyRing(id uint32, key []byte) (*KeyRing, error) {
+	if !validKeySize(key) {
+		return nil, ErrKeySize
+	}
+	return &KeyRing{current: id, keys: map[uint32][]byte{id: append([]byte(nil), key...)}}, nil
+}
+
+// Rotate adds key under id and seals the new values with it
+func (k *KeyRing) Rotate(id uint32, key []byte) error {
+	if !validKeySize(key) {
+		return ErrKeySize
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[id] = append([]byte(nil), key...)
+	k.current = id
+	return nil
+}
+
+// Remove forgets the key of id, the values it sealed can no longer be read
+func (k *KeyRing) Remove(id uint32) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if id != k.current {
+		delete(k.keys, id)
+	}
+}
+
+func (k *KeyRing) CurrentKey() (uint32, []byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current, k.keys[k.current], nil
+}
+
+func (k *KeyRing) Key(id uint32) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, ErrUnknownKeyID
+	}
+	return key, nil
+}
+
+func validKeySize(key []byte) bool {
+	return len(key) == 16 || len(key) == 24 || len(key) == 32
+}
+
+// sealer encrypts values with AES-GCM. A sealed value is keyID | nonce | ciphertext,
+// authenticated together with the entry key so it cannot be moved to another key.
+type sealer struct {
+	provider KeyProvider
+	mu       sync.RWMutex
+	// aeads caches the ciphers by key id. The provider is still asked for the key of every
+	// value opened, so a key it removed stops opening values.
+	aeads map[uint32]cipher.AEAD
+}
+
+func newSealer(provider KeyProvider) *sealer {
+	return &sealer{provider: provider, aeads: make(map[uint32]cipher.AEAD)}
+}
+
+func (s *sealer) aead(id uint32, k []byte) (cipher.AEAD, error) {
+	s.mu.RLock()
+	aead, ok := s.aeads[id]
+	s.mu.RUnlock()
+	if ok {
+		return aead, nil
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, ErrKeySize
+	}
+	if aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.aeads[id] = aead
+	s.mu.Unlock()
+	return aead, nil
+}
+
+func (s *sealer) seal(key string, value []byte) ([]byte, error) {
+	id, k, err := s.provider.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := s.aead(id, k)
+	if err != nil {
+		return nil, err
+	}
+	sealed := make([]byte, keyIDSizeInBytes+nonceSizeInBytes, sealOverhead+len(value))
+	binary.LittleEndian.PutUint32(sealed, id)
+	nonce := sealed[keyIDSizeInBytes:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(sealed, nonce, value, []byte(key)), nil
+}
+
+func (s *sealer) open(key string, sealed []byte) ([]byte, error) {
+	if len(sealed) < sealOverhead {
+		return nil, ErrEntryTampered
+	}
+	id := binary.LittleEndian.Uint32(sealed)
+	k, err := s.provider.Key(id)
+	if err != nil {
+		s.mu.Lock()
+		delete(s.aeads, id)
+		s.mu.Unlock()
+		return nil, err
+	}
+	aead, err := s.aead(id, k)
+	if err != nil {
+		return nil, err
+	}
+	nonce := sealed[keyIDSizeInBytes : keyIDSizeInBytes+nonceSizeInBytes]
+	value, err := aead.Open(nil, nonce, sealed[keyIDSizeInBytes+nonceSizeInBytes:], []byte(key))
+	if err != nil {
+		return nil, ErrEntryTampered
+	}
+	return value, nil
+}
@@ -0,0 +1,173 @@
+package localcache
+
+import (
+	"bytes"
+	"compress/flate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+type sealTestSuite struct {
+	suite.Suite
+}
+
+func TestSealTestSuite(t *testing.T) {
+	suite.Run(t, new(sealTestSuite))
+}
+
+func (h *sealTestSuite) SetupSuite() {}
+
+func (h *sealTestSuite) keyRing() *KeyRing {
+	ring, err := NewKeyRing(1, bytes.Repeat([]byte{1}, 32))
+	assert.Equal(h.T(), nil, err)
+	return ring
+}
+
+// storedEntry returns the wrapped entry of key as held by its segment
+func (h *sealTestSuite) storedEntry(c ICache, key string) []byte {
+	cache := c.(*cache)
+	hashKey := cache.hashFunc.Sum64(key)
+	entry, err := cache.segments[hashKey&cache.bucketMask].peek(key, hashKey)
+	assert.Equal(h.T(), nil, err)
+	return entry
+}
+
+func (h *sealTestSuite) TestSealedInMemory() {
+	c, err := NewCache(SetShardCount(4), SetEncryption(h.keyRing()))
+	assert.Equal(h.T(), nil, err)
+	defer c.Close()
+
+	value := []byte("4111 1111 1111 1111")
+	assert.Equal(h.T(), nil, c.Set("card", value))
+	entry := h.storedEntry(c, "card")
+	assert.False(h.T(), bytes.Contains(entry, value))
+	assert.Equal(h.T(), flagSealed, readCodecFromEntry(entry))
+
+	res, err := c.Get("card")
+	assert.Equal(h.T(), nil, err)
+	assert.Equal(h.T(), value, res)
+}
+
+func (h *sealTestSuite) TestRotation() {
+	ring := h.keyRing()
+	c, err := NewCache(SetShardCount(4), SetEncryption(ring))
+	assert.Equal(h.T(), nil, err)
+	defer c.Close()
+
+	assert.Equal(h.T(), nil, c.Set("old", []byte("sealed with 1")))
+	assert.Equal(h.T(), nil, ring.Rotate(2, bytes.Repeat([]byte{2}, 16)))
+	assert.Equal(h.T(), nil, c.Set("new", []byte("sealed with 2")))
+
+	for key, value := range map[string]string{"old": "sealed with 1", "new": "sealed with 2"} {
+		res, err := c.Get(key)
+		assert.Equal(h.T(), nil, err)
+		assert.Equal(h.T(), []byte(value), res)
+	}
+
+	// the current key cannot be removed, the values of a removed one are unreadable
+	ring.Remove(1)
+	ring.Remove(2)
+	_, err = c.Get("old")
+	assert.Equal(h.T(), ErrUnknownKeyID, err)
+	_, err = ring.Key(1)
+	assert.Equal(h.T(), ErrUnknownKeyID, err)
+	res, err := c.Get("new")
+	assert.Equal(h.T(), nil, err)
+	assert.Equal(h.T(), []byte("sealed with 2"), res)
+
+	other, err := NewCache(SetShardCount(4), SetEncryption(ring))
+	assert.Equal(h.T(), nil, err)
+	defer other.Close()
+	cache := other.(*cache)
+	hashKey := cache.hashFunc.Sum64("old")
+	_, _, err = cache.segments[hashKey&cache.bucketMask].push(hashKey, h.storedEntry(c, "old"), nil, defaultCost)
+	assert.Equal(h.T(), nil, err)
+	_, err = other.Get("old")
+	assert.Equal(h.T(), ErrUnknownKeyID, err)
+}
+
+func (h *sealTestSuite) TestTampered() {
+	c, err := NewCache(SetShardCount(1), SetEncryption(h.keyRing()))
+	assert.Equal(h.T(), nil, err)
+	defer c.Close()
+	cache := c.(*cache)
+	segment := cache.segments[0]
+
+	assert.Equal(h.T(), nil, c.Set("asong", []byte("value")))
+	tampered := append([]byte(nil), h.storedEntry(c, "asong")...)
+	tampered[len(tampered)-1] ^= 1
+	_, _, err = segment.push(cache.hashFunc.Sum64("asong"), tampered, nil, defaultCost)
+	assert.Equal(h.T(), nil, err)
+	_, err = c.Get("asong")
+	assert.Equal(h.T(), ErrEntryTampered, err)
+
+	// a sealed value moved to another key fails authentication too
+	assert.Equal(h.T(), nil, c.Set("admin", []byte("secret")))
+	assert.Equal(h.T(), nil, c.Set("guest", []byte("public")))
+	admin := h.storedEntry(c, "admin")
+	guest := h.storedEntry(c, "guest")
+	_, guestEnd := keyBoundsFromEntry(guest)
+	_, adminEnd := keyBoundsFromEntry(admin)
+	moved := append(guest[:guestEnd:guestEnd], admin[adminEnd:]...)
+	_, _, err = segment.push(cache.hashFunc.Sum64("guest"), moved, nil, defaultCost)
+	assert.Equal(h.T(), nil, err)
+	_, err = c.Get("guest")
+	assert.Equal(h.T(), ErrEntryTampered, err)
+}
+
+func (h *sealTestSuite) TestWithCompression() {
+	compressor, _ := NewFlateCompressor(flate.BestSpeed)
+	c, err := NewCache(SetShardCount(4), SetStatsEnabled(true), SetCompression(compressor, 64), SetEncryption(h.keyRing()))
+	assert.Equal(h.T(), nil, err)
+	defer c.Close()
+
+	value := jsonBlob(4096)
+	assert.Equal(h.T(), nil, c.Set("asong", value))
+	assert.Equal(h.T(), CodecFlate|flagSealed, readCodecFromEntry(h.storedEntry(c, "asong")))
+	assert.Less(h.T(), c.Stats().StoredValueBytes, int64(len(value)/5))
+	res, err := c.Get("asong")
+	assert.Equal(h.T(), nil, err)
+	assert.Equal(h.T(), value, res)
+}
+
+func (h *sealTestSuite) TestSealedAOF() {
+	path := filepath.Join(h.T().TempDir(), "cache.aof")
+	ring := h.keyRing()
+	c, err := NewCache(SetAOF(path), SetEncryption(ring))
+	assert.Equal(h.T(), nil, err)
+	value := []byte("123-45-6789")
+	assert.Equal(h.T(), nil, c.Set("ssn", value))
+	assert.Equal(h.T(), nil, c.RewriteAOF())
+	assert.Equal(h.T(), nil, c.Set("ssn2", value))
+	assert.Equal(h.T(), nil, c.Close())
+
+	data, err := ioutil.ReadFile(path)
+	assert.Equal(h.T(), nil, err)
+	assert.False(h.T(), bytes.Contains(data, value))
+
+	c, err = NewCache(SetAOF(path), SetEncryption(ring))
+	assert.Equal(h.T(), nil, err)
+	res, err := c.Get("ssn2")
+	assert.Equal(h.T(), nil, err)
+	assert.Equal(h.T(), value, res)
+	assert.Equal(h.T(), nil, c.Close())
+
+	// without the keys the sealed records are dropped
+	c, err = NewCache(SetAOF(path))
+	assert.Equal(h.T(), nil, err)
+	_, err = c.Get("ssn")
+	assert.Equal(h.T(), ErrEntryNotFound, err)
+	assert.Equal(h.T(), nil, c.Close())
+}
+
+func (h *sealTestSuite) TestInvalidKeys() {
+	_, err := NewKeyRing(1, []byte("short"))
+	assert.Equal(h.T(), ErrKeySize, err)
+	ring := h.keyRing()
+	assert.Equal(h.T(), ErrKeySize, ring.Rotate(2, make([]byte, 20)))
+	_, err = NewCache(SetEncryption(&KeyRing{keys: map[uint32][]byte{}}))
+	assert.Equal(h.T(), ErrKeySize, err)
+}
@@ -12,6 +12,11 @@ var (
 	// ErrEntryNotFound is an error type struct which is returned when entry was not found for provided key
 	ErrEntryNotFound = errors.New("Entry not found")
 	ErrExpireTimeInvalid = errors.New("Entry expire time invalid")
+	// errEntryExpired is returned by get when the entry exists but has expired. removing it
+	// needs the write lock, so the caller is expected to call removeExpired.
+	errEntryExpired = errors.New("Entry expired")
+	// ErrEntryTooLarge is returned for an entry larger, or costlier, than the budget of its segment
+	ErrEntryTooLarge = errors.New("Entry larger than the segment budget")
 )
 
 const (
@@ -19,6 +24,11 @@ const (
 	maxSegmentSize uint64 = 1 << segmentSizeBits
 	segmentSize = 32 * 1024 // 32kb
 	defaultExpireTime = 10 * time.Minute
+	// defaultCost is the cost of an entry stored without one and without a weigher
+	defaultCost = 1
+	// evictSamples is the number of least recently stored entries eviction weighs
+	// against each other once the entries have costs
+	evictSamples = 5
 )
 
 type segment struct {
@@ -26,7 +36,36 @@ type segment struct {
 	entries buffer.IBuffer
 	clock   clock
 	evictList  *list.List
+	// evictElements maps the hash of every stored entry to its element in evictList
+	evictElements map[uint64]*list.Element
 	stats IStats
+	// viewer reads the buffer without copying when its Get copies, nil otherwise
+	viewer buffer.Viewer
+	// chunked splits the values larger than a slot across several slots, for buffers
+	// whose slots have a fixed size
+	chunked bool
+	// cleanupCursor is the buffer index the next cleanup pass resumes from
+	cleanupCursor int
+	// bytes is the total size of the wrapped entries stored in the segment
+	bytes int
+	// onEvict receives the unexpired entries evicted to make room, nil to drop them
+	onEvict func(key string, value []byte, expireAt uint64, cost int64)
+	// codec compresses and seals the stored values, nil stores them raw
+	codec *valueCodec
+	// objects holds the values of the object entries by buffer index, nil unless the
+	// segment stores objects
+	objects map[uint32]interface{}
+	// maxBytes bounds bytes, the oldest entries are evicted to stay below it. 0 leaves
+	// the entries bounded by the buffer slots only.
+	maxBytes int
+	// costs holds the cost of the entries by hash, entries missing from it cost defaultCost.
+	// It is nil until the segment gets a cost budget or a cost other than defaultCost;
+	// eviction then weighs the cost of the entries against their size.
+	costs map[uint64]int64
+	// cost is the total cost of the entries stored in the segment
+	cost int64
+	// maxCost bounds cost, 0 for no bound
+	maxCost int64
 }
 
 func newSegment(bytes uint64, statsEnabled bool) *segment {
@@ -36,14 +75,27 @@ func newSegment(bytes uint64, statsEnabled bool) *segment {
 	if bytes >= maxSegmentSize{
 		panic(fmt.Errorf("too big bytes=%d; should be smaller than %d", bytes, maxSegmentSize))
 	}
-	capacity := (bytes + segmentSize - 1) / segmentSize
-	entries := buffer.NewBuffer(int(capacity))
+	entries := buffer.NewBuffer(segmentCapacity(bytes))
 	entries.Reset()
+	return newSegmentWithBuffer(entries, statsEnabled)
+}
+
+// segmentCapacity returns the number of buffer slots of a segment holding bytes
+func segmentCapacity(bytes uint64) int {
+	return int((bytes + segmentSize - 1) / segmentSize)
+}
+
+// newSegmentWithBuffer creates a segment storing its entries in entries, which must be empty
+// or be rebuilt into the segment with rebuild.
+func newSegmentWithBuffer(entries buffer.IBuffer, statsEnabled bool) *segment {
+	viewer, _ := entries.(buffer.Viewer)
 	return &segment{
+		viewer: viewer,
 		entries: entries,
 		hashmap: make(map[uint64]uint32),
 		clock:   &systemClock{},
 		evictList: list.New(),
+		evictElements: make(map[uint64]*list.Element),
 		stats: newStats(statsEnabled),
 	}
 }
@@ -52,29 +104,305 @@ func (s *segment) set(key string, hashKey uint64, value []byte, expireTime time.
 	if expireTime <= 0{
 		return ErrExpireTimeInvalid
 	}
-	expireAt := uint64(s.clock.Epoch(expireTime))
+	return s.setAt(key, hashKey, value, uint64(s.clock.Epoch(expireTime)), defaultCost)
+}
 
-	if previousIndex, ok := s.hashmap[hashKey]; ok {
-		if err := s.entries.Remove(int(previousIndex)); err != nil{
-			return err
+// setAt stores value with an absolute expire timestamp in seconds
+func (s *segment) setAt(key string, hashKey uint64, value []byte, expireAt uint64, cost int64) error {
+	entry, chunks, err := wrapEntry(expireAt, key, hashKey, value, s.codec, s.chunked)
+	if err != nil {
+		return err
+	}
+	size, overwrite, err := s.push(hashKey, entry, chunks, cost)
+	if err != nil {
+		return err
+	}
+	s.stats.set(size, overwrite)
+	s.stats.values(len(value), readValueSizeFromEntry(entry))
+	return nil
+}
+
+// store wraps and pushes the entry, evicting the oldest entries until it fits.
+// It returns the size of the stored entry and whether it replaced a previous one.
+func (s *segment) store(key string, hashKey uint64, value []byte, expireAt uint64, cost int64) (int, bool, error) {
+	entry, chunks, err := wrapEntry(expireAt, key, hashKey, value, s.codec, s.chunked)
+	if err != nil {
+		return 0, false, err
+	}
+	return s.push(hashKey, entry, chunks, cost)
+}
+
+// push stores a wrapped entry and its chunks, replacing the previous entry of hashKey.
+// The chunks are pushed first so their indexes can be recorded in the entry.
+func (s *segment) push(hashKey uint64, entry []byte, chunks [][]byte, cost int64) (int, bool, error) {
+	// an entry needing more slots than the buffer has would evict everything and still fail
+	if len(chunks)+1 > s.entries.Capacity() {
+		return 0, false, ErrEntryTooLarge
+	}
+	previousIndex, overwrite := s.hashmap[hashKey]
+	if overwrite {
+		if err := s.removeEntry(hashKey, previousIndex); err != nil{
+			return 0, false, err
 		}
-		delete(s.hashmap, hashKey)
 	}
 
-	entry := wrapEntry(expireAt, key, hashKey, value)
+	if s.maxBytes > 0 || s.maxCost > 0 {
+		if err := s.reserve(storedSizeOfEntry(entry), cost); err != nil {
+			return 0, false, err
+		}
+	}
+	size := len(entry)
+	for i, chunk := range chunks {
+		index, err := s.pushSlot(chunk)
+		if err != nil {
+			s.removeChunks(entry, i)
+			return 0, false, err
+		}
+		setChunkIndex(entry, i, uint32(index))
+		size += len(chunk)
+	}
+	index, err := s.pushSlot(entry)
+	if err != nil {
+		s.removeChunks(entry, len(chunks))
+		return 0, false, err
+	}
+	s.hashmap[hashKey] = uint32(index)
+	s.evictElements[hashKey] = s.evictList.PushFront(hashKey)
+	s.bytes += storedSizeOfEntry(entry)
+	s.cost += cost
+	if cost != defaultCost {
+		if s.costs == nil {
+			s.costs = make(map[uint64]int64)
+		}
+		s.costs[hashKey] = cost
+	}
+	return size, overwrite, nil
+}
+
+// reserve evicts entries until size more bytes fit in maxBytes and cost more in maxCost
+func (s *segment) reserve(size int, cost int64) error {
+	if (s.maxBytes > 0 && size > s.maxBytes) || (s.maxCost > 0 && cost > s.maxCost) {
+		return ErrEntryTooLarge
+	}
+	for (s.maxBytes > 0 && s.bytes+size > s.maxBytes) || (s.maxCost > 0 && s.cost+cost > s.maxCost) {
+		if !s.evict() {
+			return ErrEntryTooLarge
+		}
+	}
+	return nil
+}
+
+// pushSlot pushes data into a buffer slot, evicting the oldest entries until it fits
+func (s *segment) pushSlot(data []byte) (int, error) {
 	for {
-		index, err := s.entries.Push(entry)
+		index, err := s.entries.Push(data)
 		if err == nil {
-			s.hashmap[hashKey] = uint32(index)
-			s.evictList.PushFront(index)
-			return nil
+			return index, nil
 		}
-		ele := s.evictList.Back()
-		if err := s.entries.Remove(ele.Value.(int)); err != nil{
-			return err
+		if err != buffer.ErrBufferFull {
+			return 0, err
+		}
+		if !s.evict() {
+			return 0, err
+		}
+	}
+}
+
+// evict evicts the entry picked by victim, handing it to onEvict when it has not expired.
+// It reports false when there is nothing left to evict.
+func (s *segment) evict() bool {
+	evictHash, ok := s.victim()
+	if !ok {
+		return false
+	}
+	evictIndex := s.hashmap[evictHash]
+	if s.onEvict != nil {
+		if evicted, err := s.entries.Get(int(evictIndex)); err == nil && evicted != nil {
+			if evictAt := readExpireAtFromEntry(evicted); s.clock.TimeStamp() - int64(evictAt) < 0 {
+				if value, err := s.readValue(evicted); err == nil {
+					s.onEvict(readKeyFromEntry(evicted), value, evictAt, s.costOf(evictHash))
+				}
+			}
+		}
+	}
+	if err := s.removeEntry(evictHash, evictIndex); err != nil{
+		return false
+	}
+	s.stats.evict()
+	return true
+}
+
+// victim returns the hash of the entry to evict: the least recently stored one, or once
+// the entries have costs, the one with the lowest cost per byte among the evictSamples
+// least recently stored, so cheap and large entries go before costly and small ones.
+func (s *segment) victim() (uint64, bool) {
+	ele := s.evictList.Back()
+	if ele == nil {
+		return 0, false
+	}
+	victim := ele.Value.(uint64)
+	if s.costs == nil {
+		return victim, true
+	}
+	lowest := s.density(victim)
+	for i := 1; i < evictSamples; i++ {
+		if ele = ele.Prev(); ele == nil {
+			break
+		}
+		hashKey := ele.Value.(uint64)
+		if density := s.density(hashKey); density < lowest {
+			victim, lowest = hashKey, density
 		}
+	}
+	return victim, true
+}
+
+// density returns the cost per stored byte of the entry of hashKey
+func (s *segment) density(hashKey uint64) float64 {
+	entry, err := s.slot(int(s.hashmap[hashKey]))
+	if err != nil || len(entry) == 0 {
+		return 0
+	}
+	return float64(s.costOf(hashKey)) / float64(storedSizeOfEntry(entry))
+}
+
+// costOf returns the cost of the entry of hashKey
+func (s *segment) costOf(hashKey uint64) int64 {
+	if cost, ok := s.costs[hashKey]; ok {
+		return cost
+	}
+	return defaultCost
+}
+
+// expire changes the expire timestamp of a stored entry. The entry is replaced in place,
+// its value and chunks are kept as stored.
+func (s *segment) expire(key string, hashKey uint64, expireAt uint64) error {
+	entry, err := s.peek(key, hashKey)
+	if err != nil {
+		return err
+	}
+	if err := s.entries.Remove(int(s.hashmap[hashKey])); err != nil {
+		return err
+	}
+	// the slot just released takes the entry back, no eviction is needed
+	index, err := s.entries.Push(withExpireAt(entry, expireAt))
+	if err != nil {
+		s.removeChunks(entry, -1)
+		s.bytes -= storedSizeOfEntry(entry)
+		s.cost -= s.costOf(hashKey)
+		delete(s.costs, hashKey)
+		delete(s.objects, s.hashmap[hashKey])
+		delete(s.hashmap, hashKey)
+		s.evictList.Remove(s.evictElements[hashKey])
+		delete(s.evictElements, hashKey)
+		return err
+	}
+	if object, ok := s.objects[s.hashmap[hashKey]]; ok {
+		delete(s.objects, s.hashmap[hashKey])
+		s.objects[uint32(index)] = object
+	}
+	s.hashmap[hashKey] = uint32(index)
+	s.evictList.MoveToFront(s.evictElements[hashKey])
+	return nil
+}
+
+// removeKey removes the entry of key without touching the statistics.
+// It reports whether an entry was removed.
+func (s *segment) removeKey(key string, hashKey uint64) bool {
+	index, ok := s.hashmap[hashKey]
+	if !ok {
+		return false
+	}
+	entry, err := s.entries.Get(int(index))
+	if err != nil || entry == nil || !entryHasKey(entry, key) {
+		return false
+	}
+	return s.removeEntry(hashKey, index) == nil
+}
+
+// removeEntry drops the entry stored at index from the buffer, the hashmap and the evict list.
+func (s *segment) removeEntry(hashKey uint64, index uint32) error {
+	entry, err := s.entries.Get(int(index))
+	if err != nil {
+		return err
+	}
+	if err := s.entries.Remove(int(index)); err != nil {
+		return err
+	}
+	if len(entry) > 0 {
+		s.removeChunks(entry, -1)
+		s.bytes -= storedSizeOfEntry(entry)
+	}
+	s.cost -= s.costOf(hashKey)
+	delete(s.costs, hashKey)
+	delete(s.objects, index)
+	delete(s.hashmap, hashKey)
+	if ele, ok := s.evictElements[hashKey]; ok {
 		s.evictList.Remove(ele)
+		delete(s.evictElements, hashKey)
 	}
+	return nil
+}
+
+// removeChunks releases the first count chunks of a chunked entry, all of them when count
+// is negative
+func (s *segment) removeChunks(entry []byte, count int) {
+	if readKindFromEntry(entry) != kindChunked {
+		return
+	}
+	_, indexes := readChunksFromEntry(entry)
+	if count >= 0 {
+		indexes = indexes[:count]
+	}
+	for _, index := range indexes {
+		_ = s.entries.Remove(int(index))
+	}
+}
+
+// readValue returns the value of an entry, gathering its chunks when it is chunked
+func (s *segment) readValue(entry []byte) ([]byte, error) {
+	if readKindFromEntry(entry) != kindChunked {
+		return readEntry(entry, s.codec)
+	}
+	length, _ := readChunksFromEntry(entry)
+	payload, err := s.appendChunks(make([]byte, 0, length), entry)
+	if err != nil {
+		return nil, err
+	}
+	codec := readCodecFromEntry(entry)
+	if codec == codecRaw {
+		return payload, nil
+	}
+	return s.codec.decode(readKeyFromEntry(entry), codec, payload)
+}
+
+// appendChunks appends the chunks of a chunked entry to dst
+func (s *segment) appendChunks(dst []byte, entry []byte) ([]byte, error) {
+	length, indexes := readChunksFromEntry(entry)
+	start := len(dst)
+	for _, index := range indexes {
+		chunk, err := s.slot(int(index))
+		if err != nil {
+			return nil, err
+		}
+		if len(chunk) == 0 || chunk[0] != kindChunk {
+			return nil, errChunkMissing
+		}
+		dst = append(dst, chunk[kindSizeInBytes:]...)
+	}
+	if len(dst)-start != length {
+		return nil, errChunkMissing
+	}
+	return dst, nil
+}
+
+// slot returns the data of a buffer slot, without copying it when the buffer allows.
+// It must not be used once the segment lock is released.
+func (s *segment) slot(index int) ([]byte, error) {
+	if s.viewer != nil {
+		return s.viewer.View(index)
+	}
+	return s.entries.Get(index)
 }
 
 func (s *segment) getWarpEntry(key string, hashKey uint64) ([]byte,error) {
@@ -83,7 +411,7 @@ func (s *segment) getWarpEntry(key string, hashKey uint64) ([]byte,error) {
 		s.stats.miss()
 		return nil, ErrEntryNotFound
 	}
-	entry, err := s.entries.Get(int(index))
+	entry, err := s.slot(int(index))
 	if err != nil{
 		s.stats.miss()
 		return nil, err
@@ -93,42 +421,196 @@ func (s *segment) getWarpEntry(key string, hashKey uint64) ([]byte,error) {
 		return nil, ErrEntryNotFound
 	}
 
-	if entryKey := readKeyFromEntry(entry); key != entryKey {
+	if !entryHasKey(entry, key) {
 		s.stats.collision()
 		return nil, ErrEntryNotFound
 	}
 	return entry, nil
 }
 
-func (s *segment) get(key string, hashKey uint64) ([]byte, error) {
+// lookup returns the live entry of key, errEntryExpired if it has expired. The entry may
+// be the buffer memory itself, it must not be used once the segment lock is released.
+// Misses are counted, hits are left to the caller once the value is read.
+func (s *segment) lookup(key string, hashKey uint64) ([]byte, error) {
 	currentTimestamp := s.clock.TimeStamp()
 	entry, err := s.getWarpEntry(key, hashKey)
 	if err != nil{
 		return nil, err
 	}
-	res := readEntry(entry)
-
 	expireAt := int64(readExpireAtFromEntry(entry))
 	if currentTimestamp - expireAt >= 0{
-		_ = s.entries.Remove(int(s.hashmap[hashKey]))
-		delete(s.hashmap, hashKey)
-		return nil, ErrEntryNotFound
+		s.stats.miss()
+		return nil, errEntryExpired
+	}
+	return entry, nil
+}
+
+func (s *segment) get(key string, hashKey uint64) ([]byte, error) {
+	entry, err := s.lookup(key, hashKey)
+	if err != nil{
+		return nil, err
 	}
-	s.stats.hit(key)
+	res, err := s.readValue(entry)
+	if err != nil {
+		s.stats.miss()
+		return nil, err
+	}
+	s.stats.hit(key, hashKey)
 
 	return res, nil
 }
 
+// setObject stores an object of size bytes with an absolute expire timestamp in seconds
+func (s *segment) setObject(key string, hashKey uint64, object interface{}, size int, expireAt uint64, cost int64) error {
+	entry := wrapObjectEntry(expireAt, key, hashKey, size)
+	stored, overwrite, err := s.push(hashKey, entry, nil, cost)
+	if err != nil {
+		return err
+	}
+	s.objects[s.hashmap[hashKey]] = object
+	s.stats.set(stored, overwrite)
+	return nil
+}
+
+// getObject returns the object of key, errEntryExpired if it has expired
+func (s *segment) getObject(key string, hashKey uint64) (interface{}, error) {
+	if _, err := s.lookup(key, hashKey); err != nil {
+		return nil, err
+	}
+	s.stats.hit(key, hashKey)
+	return s.objects[s.hashmap[hashKey]], nil
+}
+
+// view calls fn with the value of an entry found by lookup. Raw values are passed as
+// stored, without copying; compressed, sealed and chunked values are decoded first.
+func (s *segment) view(key string, hashKey uint64, entry []byte, fn func(value []byte) error) error {
+	if readKindFromEntry(entry) == kindValue && readCodecFromEntry(entry) == codecRaw {
+		s.stats.hit(key, hashKey)
+		_, end := keyBoundsFromEntry(entry)
+		return fn(entry[end:])
+	}
+	value, err := s.readValue(entry)
+	if err != nil {
+		s.stats.miss()
+		return err
+	}
+	s.stats.hit(key, hashKey)
+	return fn(value)
+}
+
+// appendValue appends the value of an entry found by lookup to dst. Raw values, chunked
+// or not, are copied straight from the buffer.
+func (s *segment) appendValue(dst []byte, key string, hashKey uint64, entry []byte) ([]byte, error) {
+	if readCodecFromEntry(entry) != codecRaw {
+		err := s.view(key, hashKey, entry, func(value []byte) error {
+			dst = append(dst, value...)
+			return nil
+		})
+		return dst, err
+	}
+	if readKindFromEntry(entry) == kindChunked {
+		res, err := s.appendChunks(dst, entry)
+		if err != nil {
+			s.stats.miss()
+			return dst, err
+		}
+		s.stats.hit(key, hashKey)
+		return res, nil
+	}
+	s.stats.hit(key, hashKey)
+	_, end := keyBoundsFromEntry(entry)
+	return append(dst, entry[end:]...), nil
+}
+
+// peek returns the wrapped entry of key without touching the statistics.
+func (s *segment) peek(key string, hashKey uint64) ([]byte, error) {
+	index, ok := s.hashmap[hashKey]
+	if !ok {
+		return nil, ErrEntryNotFound
+	}
+	entry, err := s.entries.Get(int(index))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil || !entryHasKey(entry, key) {
+		return nil, ErrEntryNotFound
+	}
+	if s.clock.TimeStamp() - int64(readExpireAtFromEntry(entry)) >= 0 {
+		return nil, ErrEntryNotFound
+	}
+	return entry, nil
+}
+
+// ttl returns the remaining time to live of key
+func (s *segment) ttl(key string, hashKey uint64) (time.Duration, error) {
+	entry, err := s.peek(key, hashKey)
+	if err != nil {
+		return 0, err
+	}
+	remaining := int64(readExpireAtFromEntry(entry)) - s.clock.TimeStamp()
+	return time.Duration(remaining) * time.Second, nil
+}
+
+// removeExpired removes the entry of key if it is still stored and expired.
+func (s *segment) removeExpired(key string, hashKey uint64) {
+	index, ok := s.hashmap[hashKey]
+	if !ok {
+		return
+	}
+	entry, err := s.entries.Get(int(index))
+	if err != nil || entry == nil || !entryHasKey(entry, key) {
+		return
+	}
+	if s.clock.TimeStamp() - int64(readExpireAtFromEntry(entry)) < 0 {
+		return
+	}
+	if err := s.removeEntry(hashKey, index); err == nil {
+		s.stats.expireLazy()
+	}
+}
+
+// clear removes every entry without touching the statistics
+func (s *segment) clear() {
+	s.entries.Reset()
+	s.hashmap = make(map[uint64]uint32)
+	s.evictList.Init()
+	s.evictElements = make(map[uint64]*list.Element)
+	s.cleanupCursor = 0
+	s.bytes = 0
+	s.cost = 0
+	if s.costs != nil {
+		s.costs = make(map[uint64]int64)
+	}
+	if s.objects != nil {
+		s.objects = make(map[uint32]interface{})
+	}
+}
+
 func (s *segment) len() int {
 	res := len(s.hashmap)
 	return res
 }
 
+// size returns the number of bytes held by the entries of the segment
+func (s *segment) size() int {
+	return s.bytes
+}
+
+// totalCost returns the total cost of the entries of the segment
+func (s *segment) totalCost() int64 {
+	return s.cost
+}
+
 func (s *segment) capacity() int {
 	res := s.entries.Capacity()
 	return res
 }
 
+// available returns the number of free buffer slots
+func (s *segment) available() int {
+	return s.entries.GetAvailableSpaceCount()
+}
+
 func (s *segment) delete(hashKey uint64) error {
 	index,ok := s.hashmap[hashKey]
 	if !ok {
@@ -136,30 +618,144 @@ func (s *segment) delete(hashKey uint64) error {
 		return ErrEntryNotFound
 	}
 
-	if err := s.entries.Remove(int(index)); err != nil{
+	if err := s.removeEntry(hashKey, index); err != nil{
 		return err
 	}
 
-	delete(s.hashmap, hashKey)
 	s.stats.delHit()
 	return nil
 }
 
-func (s *segment) cleanup(currentTimestamp int64) {
-	indexs := s.entries.GetPlaceholderIndex()
-	for index := range indexs {
+// cleanup inspects at most limit buffer slots starting from the cleanup cursor and
+// removes the expired entries among them. It returns the number of entries checked
+// and how many of those were expired.
+func (s *segment) cleanup(currentTimestamp int64, limit int) (int, int) {
+	capacity := s.entries.Capacity()
+	if limit > capacity {
+		limit = capacity
+	}
+	checked, expired := 0, 0
+	for i := 0; i < limit; i++ {
+		index := s.cleanupCursor
+		s.cleanupCursor = (s.cleanupCursor + 1) % capacity
 		entry, err := s.entries.Get(index)
-		if err != nil || entry == nil{
+		if err != nil || entry == nil || readKindFromEntry(entry) == kindChunk {
 			continue
 		}
+		checked++
 		expireAt := int64(readExpireAtFromEntry(entry))
-		if currentTimestamp - expireAt >= 0{
-			hash := readHashFromEntry(entry)
-			delete(s.hashmap, hash)
+		if currentTimestamp-expireAt >= 0 {
+			if err := s.removeEntry(readHashFromEntry(entry), uint32(index)); err == nil {
+				s.stats.expireCleanup()
+				expired++
+			}
+		}
+	}
+	return checked, expired
+}
+
+// scan appends to keys the keys of the live entries stored from buffer slot from on, until
+// keys holds count keys. It returns the keys and the slot to resume from.
+func (s *segment) scan(keys []string, from int, count int) ([]string, int) {
+	now := s.clock.TimeStamp()
+	capacity := s.entries.Capacity()
+	index := from
+	for ; index < capacity && len(keys) < count; index++ {
+		entry, err := s.entries.Get(index)
+		if err != nil || entry == nil || readKindFromEntry(entry) == kindChunk {
+			continue
+		}
+		if now - int64(readExpireAtFromEntry(entry)) >= 0 {
+			continue
+		}
+		keys = append(keys, readKeyFromEntry(entry))
+	}
+	return keys, index
+}
+
+// rebuild indexes the entries already stored in the buffer, as found in a reopened file
+// backed buffer. Expired entries, the entries keep rejects, the entries missing a chunk
+// and the chunks no entry refers to are removed. It returns the number of entries kept.
+func (s *segment) rebuild(keep func(key string, hashKey uint64) bool) int {
+	now := s.clock.TimeStamp()
+	chunks := make(map[int]bool)
+	var entries []int
+	for _, index := range s.entries.GetPlaceholderIndex() {
+		entry, err := s.entries.Get(index)
+		if err != nil || entry == nil {
+			continue
+		}
+		if len(entry) > 0 && readKindFromEntry(entry) == kindChunk {
+			chunks[index] = false
+			continue
+		}
+		entries = append(entries, index)
+	}
+	for _, index := range entries {
+		entry, _ := s.entries.Get(index)
+		if !validEntry(entry) {
 			_ = s.entries.Remove(index)
 			continue
 		}
+		hashKey := readHashFromEntry(entry)
+		_, duplicate := s.hashmap[hashKey]
+		if duplicate || now - int64(readExpireAtFromEntry(entry)) >= 0 || !keep(readKeyFromEntry(entry), hashKey) ||
+			!s.claimChunks(entry, chunks) {
+			_ = s.entries.Remove(index)
+			continue
+		}
+		s.hashmap[hashKey] = uint32(index)
+		s.evictElements[hashKey] = s.evictList.PushFront(hashKey)
+		s.bytes += storedSizeOfEntry(entry)
+		s.cost += defaultCost
+	}
+	for index, claimed := range chunks {
+		if !claimed {
+			_ = s.entries.Remove(index)
+		}
+	}
+	return len(s.hashmap)
+}
+
+// claimChunks marks the chunks of a rebuilt entry as used. It reports false, claiming
+// nothing, when a chunk is missing or already used by another entry.
+func (s *segment) claimChunks(entry []byte, chunks map[int]bool) bool {
+	if readKindFromEntry(entry) != kindChunked {
+		return true
+	}
+	_, indexes := readChunksFromEntry(entry)
+	for _, index := range indexes {
+		if claimed, ok := chunks[int(index)]; !ok || claimed {
+			return false
+		}
+	}
+	for _, index := range indexes {
+		chunks[int(index)] = true
+	}
+	return true
+}
+
+// dump calls fn with every live entry of the segment
+func (s *segment) dump(fn func(key string, value []byte, expireAt uint64, cost int64) error) error {
+	now := s.clock.TimeStamp()
+	for _, index := range s.entries.GetPlaceholderIndex() {
+		entry, err := s.entries.Get(index)
+		if err != nil || entry == nil || readKindFromEntry(entry) == kindChunk {
+			continue
+		}
+		expireAt := readExpireAtFromEntry(entry)
+		if now - int64(expireAt) >= 0 {
+			continue
+		}
+		value, err := s.readValue(entry)
+		if err != nil {
+			continue
+		}
+		if err := fn(readKeyFromEntry(entry), value, expireAt, s.costOf(readHashFromEntry(entry))); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 func (s *segment) getStats() Stats {
@@ -169,10 +765,40 @@ func (s *segment) getStats() Stats {
 		DelHits:    s.stats.getDelHits(),
 		DelMisses:  s.stats.getDelMisses(),
 		Collisions: s.stats.getCollisions(),
+		Evictions:  s.stats.getEvictions(),
+		Expirations: s.stats.getExpirations(),
+		ExpiredLazy: s.stats.getExpiredLazy(),
+		ExpiredCleanup: s.stats.getExpiredCleanup(),
+		Sets: s.stats.getSets(),
+		Overwrites: s.stats.getOverwrites(),
+		BytesIn: s.stats.getBytesIn(),
+		RawValueBytes: s.stats.getRawValueBytes(),
+		StoredValueBytes: s.stats.getStoredValueBytes(),
+		L2Hits: s.stats.getL2Hits(),
+		L2Misses: s.stats.getL2Misses(),
+		LockWait:   s.stats.getLockWait(),
 	}
 	return res
 }
 
-func (s *segment) getKeyHit(key string) int64 {
-	return s.stats.getKeyHits(key)
+func (s *segment) getShardStat() ShardStat {
+	return ShardStat{
+		Entries:     s.len(),
+		Bytes:       s.size(),
+		Capacity:    s.capacity(),
+		Free:        s.available(),
+		Hits:        s.stats.getHits(),
+		Misses:      s.stats.getMisses(),
+		Evictions:   s.stats.getEvictions(),
+		Expirations: s.stats.getExpirations(),
+		LockWait:    s.stats.getLockWait(),
+	}
+}
+
+func (s *segment) getKeyHit(hashKey uint64) int64 {
+	return s.stats.getKeyHits(hashKey)
+}
+
+func (s *segment) getHotKeys() []KeyCount {
+	return s.stats.getHotKeys()
 }
\ No newline at end of file
@@ -0,0 +1,132 @@
+package localcache
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type segmentTestSuite struct {
+	suite.Suite
+}
+
+func TestSegmentTestSuite(t *testing.T) {
+	suite.Run(t, new(segmentTestSuite))
+}
+
+func (s *segmentTestSuite) SetupSuite() {}
+
+func (s *segmentTestSuite) TestCleanupResumesFromCursor() {
+	seg := newSegment(10*segmentSize, false)
+	value := []byte("公众号：Golang梦工厂")
+	fnv := NewDefaultHashFunc()
+	for index := 0; index < 10; index++ {
+		key := fmt.Sprintf("asong%02d", index)
+		err := seg.set(key, fnv.Sum64(key), value, time.Second)
+		assert.Equal(s.T(), nil, err)
+	}
+
+	future := time.Now().Add(time.Minute).Unix()
+	checked, expired := seg.cleanup(future, 4)
+	assert.Equal(s.T(), 4, checked)
+	assert.Equal(s.T(), 4, expired)
+	assert.Equal(s.T(), 6, seg.len())
+
+	checked, expired = seg.cleanup(future, 4)
+	assert.Equal(s.T(), 4, checked)
+	assert.Equal(s.T(), 4, expired)
+	assert.Equal(s.T(), 2, seg.len())
+
+	// the cursor wraps around and skips the slots that are already free
+	checked, expired = seg.cleanup(future, 100)
+	assert.Equal(s.T(), 2, checked)
+	assert.Equal(s.T(), 2, expired)
+	assert.Equal(s.T(), 0, seg.len())
+}
+
+func (s *segmentTestSuite) TestCleanupKeepsLiveEntries() {
+	seg := newSegment(10*segmentSize, false)
+	value := []byte("公众号：Golang梦工厂")
+	fnv := NewDefaultHashFunc()
+	for index := 0; index < 10; index++ {
+		key := fmt.Sprintf("asong%02d", index)
+		err := seg.set(key, fnv.Sum64(key), value, time.Hour)
+		assert.Equal(s.T(), nil, err)
+	}
+
+	checked, expired := seg.cleanup(time.Now().Unix(), 10)
+	assert.Equal(s.T(), 10, checked)
+	assert.Equal(s.T(), 0, expired)
+	assert.Equal(s.T(), 10, seg.len())
+}
+
+func (s *segmentTestSuite) TestNextCleanInterval() {
+	cleanTime := 16 * time.Second
+	interval := nextCleanInterval(cleanTime, cleanTime, 0.5)
+	assert.Equal(s.T(), 8*time.Second, interval)
+	for i := 0; i < 10; i++ {
+		interval = nextCleanInterval(interval, cleanTime, 0.5)
+	}
+	assert.Equal(s.T(), time.Second, interval)
+
+	interval = nextCleanInterval(interval, cleanTime, 0)
+	assert.Equal(s.T(), 2*time.Second, interval)
+	for i := 0; i < 10; i++ {
+		interval = nextCleanInterval(interval, cleanTime, 0)
+	}
+	assert.Equal(s.T(), cleanTime, interval)
+}
+
+func (s *segmentTestSuite) TestEvictionKeepsIndexConsistent() {
+	seg := newSegment(3*segmentSize, true)
+	value := []byte("公众号：Golang梦工厂")
+	fnv := NewDefaultHashFunc()
+	for index := 0; index < 3; index++ {
+		key := fmt.Sprintf("asong%02d", index)
+		err := seg.set(key, fnv.Sum64(key), value, time.Hour)
+		assert.Equal(s.T(), nil, err)
+	}
+	// freeing a slot must not leave a stale element in the evict list
+	err := seg.delete(fnv.Sum64("asong00"))
+	assert.Equal(s.T(), nil, err)
+
+	for index := 3; index < 5; index++ {
+		key := fmt.Sprintf("asong%02d", index)
+		err := seg.set(key, fnv.Sum64(key), value, time.Hour)
+		assert.Equal(s.T(), nil, err)
+	}
+
+	assert.Equal(s.T(), 3, seg.len())
+	assert.Equal(s.T(), int64(1), seg.getStats().Evictions)
+	_, err = seg.get("asong01", fnv.Sum64("asong01"))
+	assert.Equal(s.T(), ErrEntryNotFound, err)
+	for _, key := range []string{"asong02", "asong03", "asong04"} {
+		res, err := seg.get(key, fnv.Sum64(key))
+		assert.Equal(s.T(), nil, err)
+		assert.Equal(s.T(), value, res)
+	}
+	assert.Equal(s.T(), seg.evictList.Len(), seg.len())
+}
+
+func (s *segmentTestSuite) TestValueLargerThanSegment() {
+	seg := newSegment(4*segmentSize, true)
+	seg.chunked = true
+	value := []byte("公众号：Golang梦工厂")
+	fnv := NewDefaultHashFunc()
+	for _, key := range []string{"asong", "song"} {
+		assert.Equal(s.T(), nil, seg.set(key, fnv.Sum64(key), value, time.Hour))
+	}
+
+	large := make([]byte, 4*chunkDataSize)
+	err := seg.set("large", fnv.Sum64("large"), large, time.Hour)
+	assert.Equal(s.T(), ErrEntryTooLarge, err)
+	// nothing was evicted for it
+	assert.Equal(s.T(), 2, seg.len())
+	assert.Equal(s.T(), int64(0), seg.getStats().Evictions)
+
+	err = seg.set("large", fnv.Sum64("large"), large[:3*chunkDataSize], time.Hour)
+	assert.Equal(s.T(), nil, err)
+	assert.Equal(s.T(), 1, seg.len())
+}
@@ -0,0 +1,25 @@
+package netserver
+
+import (
+	"sync"
+
+	localcache "github.com/asong2020/go-localcache"
+)
+
+// keyLockStripes is the number of locks of a KeyLocks
+const keyLockStripes = 256
+
+var hashFunc = localcache.NewDefaultHashFunc()
+
+// KeyLocks serializes the writes of a key with locks striped by key. The zero value is
+// ready to use.
+type KeyLocks struct {
+	locks [keyLockStripes]sync.Mutex
+}
+
+// Lock locks the stripe of key and returns its unlock function
+func (l *KeyLocks) Lock(key string) func() {
+	mu := &l.locks[hashFunc.Sum64(key)%keyLockStripes]
+	mu.Lock()
+	return mu.Unlock
+}
@@ -0,0 +1,169 @@
+// Package netserver holds what the protocol servers share: the lifecycle of their
+// listeners and connections, and the locks serializing the writes of a key.
+package netserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Server accepts connections and hands each of them to a connection handler running in
+// its own goroutine, until it is shut down
+type Server struct {
+	// errClosed is returned by Serve once the server is shut down
+	errClosed error
+	handler   func(conn net.Conn)
+	// accepted counts the accepted connections
+	accepted int64
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	shutdown  bool
+	wg        sync.WaitGroup
+}
+
+// New creates a server serving its connections with handler. Serve returns errClosed
+// after Shutdown or Close. The connection is closed once handler returns.
+func New(errClosed error, handler func(conn net.Conn)) *Server {
+	return &Server{
+		errClosed: errClosed,
+		handler:   handler,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and serves the connections
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until it fails or the server is shut down, it always
+// returns a non nil error. The listener is closed on return.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.shutdown {
+		s.mu.Unlock()
+		l.Close()
+		return s.errClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.Closed() {
+				return s.errClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.shutdown {
+			s.mu.Unlock()
+			conn.Close()
+			return s.errClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		atomic.AddInt64(&s.accepted, 1)
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	s.handler(conn)
+}
+
+// Closed reports whether the server is shut down. A connection handler checks it once it
+// has flushed its replies, to return instead of waiting for the next command.
+func (s *Server) Closed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shutdown
+}
+
+// Connections returns the number of open connections
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// Accepted returns the number of connections accepted since the server was created
+func (s *Server) Accepted() int64 {
+	return atomic.LoadInt64(&s.accepted)
+}
+
+// Shutdown stops accepting connections and lets every connection finish the commands it
+// has already received, then closes it. If ctx is done first, the remaining connections
+// are closed and the context error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shutdown = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	// wake up the connections waiting for a command, the ones serving a command notice
+	// the shutdown once they flushed their replies
+	for conn := range s.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.Close()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// Close closes the listeners and every connection immediately
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shutdown = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return nil
+}
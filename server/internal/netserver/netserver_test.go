@@ -0,0 +1,95 @@
+package netserver
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errTestClosed = errors.New("netserver test: server closed")
+
+type netserverTestSuite struct {
+	suite.Suite
+}
+
+func TestNetserverTestSuite(t *testing.T) {
+	suite.Run(t, new(netserverTestSuite))
+}
+
+// echo serves an echo server on a loopback listener and returns its address
+func (n *netserverTestSuite) echo() (*Server, string, chan error) {
+	var s *Server
+	s = New(errTestClosed, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(line))
+			if s.Closed() {
+				return
+			}
+		}
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(n.T(), nil, err)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Serve(l)
+	}()
+	return s, l.Addr().String(), errs
+}
+
+func (n *netserverTestSuite) TestShutdown() {
+	s, addr, errs := n.echo()
+	conn, err := net.Dial("tcp", addr)
+	assert.Equal(n.T(), nil, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	_, err = conn.Write([]byte("asong\n"))
+	assert.Equal(n.T(), nil, err)
+	line, err := r.ReadString('\n')
+	assert.Equal(n.T(), nil, err)
+	assert.Equal(n.T(), "asong\n", line)
+	assert.Equal(n.T(), 1, s.Connections())
+	assert.Equal(n.T(), int64(1), s.Accepted())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Equal(n.T(), nil, s.Shutdown(ctx))
+	assert.Equal(n.T(), errTestClosed, <-errs)
+	_, err = r.ReadString('\n')
+	assert.Equal(n.T(), io.EOF, err)
+	assert.Equal(n.T(), 0, s.Connections())
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(n.T(), nil, err)
+	assert.Equal(n.T(), errTestClosed, s.Serve(l))
+}
+
+func (n *netserverTestSuite) TestKeyLocks() {
+	var locks KeyLocks
+	var wg sync.WaitGroup
+	counter := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				unlock := locks.Lock("asong")
+				counter++
+				unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(n.T(), 1000, counter)
+}
@@ -0,0 +1,434 @@
+package memcache
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// relativeExptimeLimit is the largest exptime taken as seconds from now, larger ones
+	// are unix timestamps
+	relativeExptimeLimit = 60 * 60 * 24 * 30
+	version              = "1.6.0-localcache"
+)
+
+const (
+	replyError          = "ERROR\r\n"
+	replyBadFormat      = "CLIENT_ERROR bad command line format\r\n"
+	replyBadChunk       = "CLIENT_ERROR bad data chunk\r\n"
+	replyNotNumeric     = "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"
+	replyInvalidNumeric = "CLIENT_ERROR invalid numeric delta argument\r\n"
+	replyTooLarge       = "SERVER_ERROR object too large for cache\r\n"
+	replyStored         = "STORED\r\n"
+	replyNotStored      = "NOT_STORED\r\n"
+	replyExists         = "EXISTS\r\n"
+	replyNotFound       = "NOT_FOUND\r\n"
+	replyDeleted        = "DELETED\r\n"
+	replyTouched        = "TOUCHED\r\n"
+	replyOK             = "OK\r\n"
+	replyEnd            = "END\r\n"
+)
+
+// dispatch serves the command on line, reading its data block from r for the storage
+// commands. It returns true to close the connection.
+func (s *Server) dispatch(r *bufio.Reader, w *bufio.Writer, line []byte) bool {
+	fields := bytes.Fields(line)
+	if len(fields) == 0 {
+		w.WriteString(replyError)
+		return false
+	}
+	args := make([]string, len(fields)-1)
+	for i, field := range fields[1:] {
+		args[i] = string(field)
+	}
+	switch string(fields[0]) {
+	case "get":
+		s.get(w, args, false)
+	case "gets":
+		s.get(w, args, true)
+	case "set", "add", "replace", "append", "prepend", "cas":
+		return s.store(r, w, string(fields[0]), args)
+	case "delete":
+		s.delete(w, args)
+	case "incr":
+		s.incr(w, args, true)
+	case "decr":
+		s.incr(w, args, false)
+	case "touch":
+		s.touch(w, args)
+	case "stats":
+		s.writeStats(w, args)
+	case "flush_all":
+		s.flushAll(w, args)
+	case "version":
+		w.WriteString("VERSION " + version + "\r\n")
+	case "verbosity":
+		s.reply(w, replyOK, noreply(args, 1))
+	case "quit":
+		return true
+	default:
+		w.WriteString(replyError)
+	}
+	return false
+}
+
+// noreply reports whether the optional noreply argument follows n arguments
+func noreply(args []string, n int) bool {
+	return len(args) == n+1 && args[n] == "noreply"
+}
+
+func (s *Server) reply(w *bufio.Writer, reply string, noreply bool) {
+	if !noreply {
+		w.WriteString(reply)
+	}
+}
+
+func validKey(key string) bool {
+	if len(key) == 0 || len(key) > maxKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// ttl converts a memcached exptime into a time to live. 0 keeps the default time to live
+// of the cache, values up to 30 days are seconds from now and larger ones are unix
+// timestamps. expired is true when the item would already be expired.
+func ttl(exptime int64) (ttl time.Duration, expired bool) {
+	switch {
+	case exptime == 0:
+		return 0, false
+	case exptime < 0:
+		return 0, true
+	case exptime > relativeExptimeLimit:
+		exptime -= time.Now().Unix()
+		if exptime <= 0 {
+			return 0, true
+		}
+	}
+	return time.Duration(exptime) * time.Second, false
+}
+
+// lookup returns the item of key. It is not counted by cmd_get, but the cache counts it
+// as a hit or a miss.
+func (s *Server) lookup(key string) (item, bool) {
+	value, err := s.cache.Get(key)
+	if err != nil {
+		return item{}, false
+	}
+	return decodeItem(value)
+}
+
+// save stores it under key, ttl 0 uses the default time to live of the cache
+func (s *Server) save(key string, it item, ttl time.Duration) error {
+	if ttl > 0 {
+		return s.cache.SetWithTime(key, encodeItem(it), ttl)
+	}
+	return s.cache.Set(key, encodeItem(it))
+}
+
+// remainingTTL returns the time to live of key rounded up to whole seconds, the expire
+// granularity of the cache, so rewriting an item keeps its expire time
+func (s *Server) remainingTTL(key string) (time.Duration, bool) {
+	remaining, err := s.cache.TTL(key)
+	if err != nil {
+		return 0, false
+	}
+	remaining = (remaining + time.Second - 1) / time.Second * time.Second
+	if remaining <= 0 {
+		remaining = time.Second
+	}
+	return remaining, true
+}
+
+func (s *Server) get(w *bufio.Writer, keys []string, withCAS bool) {
+	if len(keys) == 0 {
+		w.WriteString(replyError)
+		return
+	}
+	for _, key := range keys {
+		atomic.AddInt64(&s.stats.cmdGet, 1)
+		it, ok := s.lookup(key)
+		if !ok {
+			atomic.AddInt64(&s.stats.getMisses, 1)
+			continue
+		}
+		atomic.AddInt64(&s.stats.getHits, 1)
+		if withCAS {
+			fmt.Fprintf(w, "VALUE %s %d %d %d\r\n", key, it.flags, len(it.data), it.cas)
+		} else {
+			fmt.Fprintf(w, "VALUE %s %d %d\r\n", key, it.flags, len(it.data))
+		}
+		w.Write(it.data)
+		w.WriteString("\r\n")
+	}
+	w.WriteString(replyEnd)
+}
+
+// store serves <command> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply]
+// followed by the data block
+func (s *Server) store(r *bufio.Reader, w *bufio.Writer, command string, args []string) bool {
+	n := 4
+	if command == "cas" {
+		n = 5
+	}
+	if len(args) != n && !noreply(args, n) {
+		w.WriteString(replyError)
+		return false
+	}
+	length, err := strconv.Atoi(args[3])
+	if err != nil || length < 0 {
+		w.WriteString(replyBadFormat)
+		return true
+	}
+	if length > maxItemSize {
+		// swallow the data block so the connection stays in sync
+		if _, err := io.CopyN(ioutil.Discard, r, int64(length)+2); err != nil {
+			return true
+		}
+		w.WriteString(replyTooLarge)
+		return false
+	}
+	data := make([]byte, length+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return true
+	}
+	if data[length] != '\r' || data[length+1] != '\n' {
+		w.WriteString(replyBadChunk)
+		return true
+	}
+	data = data[:length]
+
+	key := args[0]
+	flags, flagsErr := strconv.ParseUint(args[1], 10, 32)
+	exptime, exptimeErr := strconv.ParseInt(args[2], 10, 64)
+	var casUnique uint64
+	var casErr error
+	if command == "cas" {
+		casUnique, casErr = strconv.ParseUint(args[4], 10, 64)
+	}
+	if !validKey(key) || flagsErr != nil || exptimeErr != nil || casErr != nil {
+		w.WriteString(replyBadFormat)
+		return false
+	}
+	atomic.AddInt64(&s.stats.cmdSet, 1)
+	quiet := noreply(args, n)
+
+	defer s.locks.Lock(key)()
+	current, exists := s.lookup(key)
+	switch command {
+	case "add":
+		if exists {
+			s.reply(w, replyNotStored, quiet)
+			return false
+		}
+	case "replace", "append", "prepend":
+		if !exists {
+			s.reply(w, replyNotStored, quiet)
+			return false
+		}
+	case "cas":
+		if !exists {
+			s.reply(w, replyNotFound, quiet)
+			return false
+		}
+		if current.cas != casUnique {
+			s.reply(w, replyExists, quiet)
+			return false
+		}
+	}
+
+	it := item{flags: uint32(flags), cas: s.nextCAS(), data: data}
+	var expireIn time.Duration
+	var expired bool
+	if command == "append" || command == "prepend" {
+		// append and prepend ignore the flags and exptime arguments
+		it.flags = current.flags
+		if command == "append" {
+			it.data = append(current.data, data...)
+		} else {
+			it.data = append(data, current.data...)
+		}
+		expireIn, _ = s.remainingTTL(key)
+	} else {
+		expireIn, expired = ttl(exptime)
+	}
+	if expired {
+		_ = s.cache.Delete(key)
+		s.reply(w, replyStored, quiet)
+		return false
+	}
+	if err := s.save(key, it, expireIn); err != nil {
+		s.reply(w, "SERVER_ERROR "+err.Error()+"\r\n", quiet)
+		return false
+	}
+	s.reply(w, replyStored, quiet)
+	return false
+}
+
+// delete serves delete <key> [noreply]
+func (s *Server) delete(w *bufio.Writer, args []string) {
+	if len(args) != 1 && !noreply(args, 1) {
+		w.WriteString(replyError)
+		return
+	}
+	key := args[0]
+	defer s.locks.Lock(key)()
+	if s.cache.Delete(key) != nil {
+		s.reply(w, replyNotFound, noreply(args, 1))
+		return
+	}
+	s.reply(w, replyDeleted, noreply(args, 1))
+}
+
+// incr serves incr and decr <key> <value> [noreply]. incr wraps around at 2^64,
+// decr stops at 0. The flags and the expire time of the item are kept.
+func (s *Server) incr(w *bufio.Writer, args []string, increment bool) {
+	if len(args) != 2 && !noreply(args, 2) {
+		w.WriteString(replyError)
+		return
+	}
+	quiet := noreply(args, 2)
+	key := args[0]
+	delta, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		w.WriteString(replyInvalidNumeric)
+		return
+	}
+	defer s.locks.Lock(key)()
+	it, ok := s.lookup(key)
+	if !ok {
+		s.reply(w, replyNotFound, quiet)
+		return
+	}
+	current, err := strconv.ParseUint(string(bytes.TrimSpace(it.data)), 10, 64)
+	if err != nil {
+		w.WriteString(replyNotNumeric)
+		return
+	}
+	if increment {
+		current += delta
+	} else if delta > current {
+		current = 0
+	} else {
+		current -= delta
+	}
+	expireIn, _ := s.remainingTTL(key)
+	it.cas = s.nextCAS()
+	it.data = []byte(strconv.FormatUint(current, 10))
+	if err := s.save(key, it, expireIn); err != nil {
+		s.reply(w, "SERVER_ERROR "+err.Error()+"\r\n", quiet)
+		return
+	}
+	s.reply(w, string(it.data)+"\r\n", quiet)
+}
+
+// touch serves touch <key> <exptime> [noreply]
+func (s *Server) touch(w *bufio.Writer, args []string) {
+	if len(args) != 2 && !noreply(args, 2) {
+		w.WriteString(replyError)
+		return
+	}
+	quiet := noreply(args, 2)
+	key := args[0]
+	exptime, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		w.WriteString(replyInvalidNumeric)
+		return
+	}
+	atomic.AddInt64(&s.stats.cmdTouch, 1)
+	defer s.locks.Lock(key)()
+	it, ok := s.lookup(key)
+	if !ok {
+		s.reply(w, replyNotFound, quiet)
+		return
+	}
+	expireIn, expired := ttl(exptime)
+	if expired {
+		_ = s.cache.Delete(key)
+	} else if expireIn > 0 {
+		err = s.cache.Expire(key, expireIn)
+	} else {
+		err = s.save(key, it, 0)
+	}
+	if err != nil {
+		s.reply(w, "SERVER_ERROR "+err.Error()+"\r\n", quiet)
+		return
+	}
+	s.reply(w, replyTouched, quiet)
+}
+
+// flushAll serves flush_all [delay] [noreply]
+func (s *Server) flushAll(w *bufio.Writer, args []string) {
+	quiet := len(args) > 0 && args[len(args)-1] == "noreply"
+	if quiet {
+		args = args[:len(args)-1]
+	}
+	if len(args) > 1 {
+		w.WriteString(replyError)
+		return
+	}
+	delay := int64(0)
+	if len(args) == 1 {
+		var err error
+		if delay, err = strconv.ParseInt(args[0], 10, 64); err != nil || delay < 0 {
+			w.WriteString(replyBadFormat)
+			return
+		}
+	}
+	atomic.AddInt64(&s.stats.cmdFlush, 1)
+	// as in memcached, the last flush_all replaces a pending delayed one
+	s.stopFlush()
+	if delay > 0 {
+		s.mu.Lock()
+		s.flush = time.AfterFunc(time.Duration(delay)*time.Second, func() {
+			_ = s.cache.Clear()
+		})
+		s.mu.Unlock()
+	} else if err := s.cache.Clear(); err != nil {
+		s.reply(w, "SERVER_ERROR "+err.Error()+"\r\n", quiet)
+		return
+	}
+	s.reply(w, replyOK, quiet)
+}
+
+// writeStats serves the general purpose statistics, other groups are not supported
+func (s *Server) writeStats(w *bufio.Writer, args []string) {
+	if len(args) > 0 {
+		w.WriteString(replyEnd)
+		return
+	}
+	cacheStats := s.cache.Stats()
+	connections := s.conns.Connections()
+	now := time.Now()
+	stat := func(name string, value interface{}) {
+		fmt.Fprintf(w, "STAT %s %v\r\n", name, value)
+	}
+	stat("pid", os.Getpid())
+	stat("uptime", int64(now.Sub(s.start)/time.Second))
+	stat("time", now.Unix())
+	stat("version", version)
+	stat("curr_connections", connections)
+	stat("total_connections", s.conns.Accepted())
+	stat("cmd_get", atomic.LoadInt64(&s.stats.cmdGet))
+	stat("cmd_set", atomic.LoadInt64(&s.stats.cmdSet))
+	stat("cmd_flush", atomic.LoadInt64(&s.stats.cmdFlush))
+	stat("cmd_touch", atomic.LoadInt64(&s.stats.cmdTouch))
+	stat("get_hits", atomic.LoadInt64(&s.stats.getHits))
+	stat("get_misses", atomic.LoadInt64(&s.stats.getMisses))
+	stat("curr_items", s.cache.Len())
+	stat("bytes", cacheStats.LiveBytes)
+	stat("evictions", cacheStats.Evictions)
+	w.WriteString(replyEnd)
+}
@@ -0,0 +1,37 @@
+package memcache
+
+import "encoding/binary"
+
+// itemHeaderSize is the client flags and the cas token stored in front of the data
+const itemHeaderSize = 4 + 8
+
+// item is a stored value. The cache holds it as flags | cas | data, so values written
+// through the memcached protocol are not readable as plain values by other clients of
+// the cache and the other way around.
+type item struct {
+	flags uint32
+	cas   uint64
+	data  []byte
+}
+
+func encodeItem(it item) []byte {
+	res := make([]byte, itemHeaderSize+len(it.data))
+	binary.LittleEndian.PutUint32(res, it.flags)
+	binary.LittleEndian.PutUint64(res[4:], it.cas)
+	copy(res[itemHeaderSize:], it.data)
+	return res
+}
+
+// decodeItem returns false for a value too short to hold the item header. Other values
+// are not told apart: one stored by another client of the cache decodes with whatever
+// flags and cas its first bytes make.
+func decodeItem(value []byte) (item, bool) {
+	if len(value) < itemHeaderSize {
+		return item{}, false
+	}
+	return item{
+		flags: binary.LittleEndian.Uint32(value),
+		cas:   binary.LittleEndian.Uint64(value[4:]),
+		data:  value[itemHeaderSize:],
+	}, true
+}
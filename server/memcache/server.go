@@ -0,0 +1,137 @@
+// Package memcache serves a cache over the memcached ASCII protocol.
+package memcache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	localcache "github.com/asong2020/go-localcache"
+	"github.com/asong2020/go-localcache/server/internal/netserver"
+)
+
+// ErrServerClosed is returned by Serve and ListenAndServe after Shutdown or Close
+var ErrServerClosed = errors.New("memcache: server closed")
+
+const (
+	// maxLineLength is the longest command line, as in memcached
+	maxLineLength = 2048
+	// maxKeyLength is the longest key, as in memcached
+	maxKeyLength = 250
+	// maxItemSize is the largest data block accepted
+	maxItemSize = 1024 * 1024
+)
+
+// Server serves the memcached ASCII protocol against a cache. Every command writing a key
+// holds a lock striped by key, so cas, add, replace, incr and decr are atomic towards the
+// clients of the server but not towards code using the cache directly.
+type Server struct {
+	cache localcache.ICache
+	locks netserver.KeyLocks
+	start time.Time
+	// cas is the last cas token handed out
+	cas   uint64
+	stats counters
+	conns *netserver.Server
+
+	mu sync.Mutex
+	// flush is the pending delayed flush_all, stopped by a later flush_all or the shutdown
+	flush *time.Timer
+}
+
+// counters are the protocol level statistics reported by the stats command
+type counters struct {
+	cmdGet    int64
+	cmdSet    int64
+	cmdTouch  int64
+	cmdFlush  int64
+	getHits   int64
+	getMisses int64
+}
+
+// NewServer creates a server for cache
+func NewServer(cache localcache.ICache) *Server {
+	s := &Server{
+		cache: cache,
+		start: time.Now(),
+	}
+	s.conns = netserver.New(ErrServerClosed, s.serveConn)
+	return s
+}
+
+// ListenAndServe listens on the TCP address addr and serves the connections
+func (s *Server) ListenAndServe(addr string) error {
+	return s.conns.ListenAndServe(addr)
+}
+
+// Serve accepts connections on l until it fails or the server is shut down, it always
+// returns a non nil error. The listener is closed on return.
+func (s *Server) Serve(l net.Listener) error {
+	return s.conns.Serve(l)
+}
+
+// Shutdown stops accepting connections and lets every connection finish the commands it
+// has already received, then closes it. If ctx is done first, the remaining connections
+// are closed and the context error is returned. A pending delayed flush_all is dropped.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.conns.Shutdown(ctx)
+	s.stopFlush()
+	return err
+}
+
+// Close closes the listeners and every connection immediately. A pending delayed
+// flush_all is dropped.
+func (s *Server) Close() error {
+	err := s.conns.Close()
+	s.stopFlush()
+	return err
+}
+
+// stopFlush stops the pending delayed flush_all, if any
+func (s *Server) stopFlush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.flush != nil {
+		s.flush.Stop()
+		s.flush = nil
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	r := bufio.NewReaderSize(conn, maxLineLength)
+	w := bufio.NewWriter(conn)
+	for {
+		line, err := r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			w.WriteString("CLIENT_ERROR line too long\r\n")
+			w.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+		if quit := s.dispatch(r, w, line); quit {
+			w.Flush()
+			return
+		}
+		// replies of pipelined commands are sent together once the input is drained
+		if r.Buffered() > 0 {
+			continue
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+		if s.conns.Closed() {
+			return
+		}
+	}
+}
+
+// nextCAS returns a new unique cas token
+func (s *Server) nextCAS() uint64 {
+	return atomic.AddUint64(&s.cas, 1)
+}
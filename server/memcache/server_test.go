@@ -0,0 +1,301 @@
+package memcache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	localcache "github.com/asong2020/go-localcache"
+)
+
+type serverTestSuite struct {
+	suite.Suite
+	cache  localcache.ICache
+	server *Server
+	addr   string
+	errs   chan error
+}
+
+func TestServerTestSuite(t *testing.T) {
+	suite.Run(t, new(serverTestSuite))
+}
+
+func (s *serverTestSuite) SetupTest() {
+	cache, err := localcache.NewCache(localcache.SetShardCount(4), localcache.SetStatsEnabled(true))
+	assert.Equal(s.T(), nil, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(s.T(), nil, err)
+	server, errs := NewServer(cache), make(chan error, 1)
+	go func() {
+		errs <- server.Serve(l)
+	}()
+	s.cache, s.server, s.errs = cache, server, errs
+	s.addr = l.Addr().String()
+}
+
+func (s *serverTestSuite) TearDownTest() {
+	s.server.Close()
+	s.cache.Close()
+}
+
+// client is a minimal memcached text protocol client
+type client struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (s *serverTestSuite) dial() *client {
+	conn, err := net.Dial("tcp", s.addr)
+	assert.Equal(s.T(), nil, err)
+	return &client{conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (s *serverTestSuite) send(c *client, request string) {
+	_, err := c.conn.Write([]byte(request))
+	assert.Equal(s.T(), nil, err)
+}
+
+func (s *serverTestSuite) line(c *client) string {
+	line, err := c.r.ReadString('\n')
+	assert.Equal(s.T(), nil, err)
+	return strings.TrimSuffix(line, "\r\n")
+}
+
+// do sends a command line and returns the reply line
+func (s *serverTestSuite) do(c *client, request string) string {
+	s.send(c, request+"\r\n")
+	return s.line(c)
+}
+
+// storeCmd sends a storage command with its data block and returns the reply line
+func (s *serverTestSuite) storeCmd(c *client, command string, data string) string {
+	s.send(c, fmt.Sprintf("%s %d\r\n%s\r\n", command, len(data), data))
+	return s.line(c)
+}
+
+type value struct {
+	key   string
+	flags uint32
+	data  string
+	cas   uint64
+}
+
+// get sends a retrieval command and reads the values up to END
+func (s *serverTestSuite) get(c *client, request string) []value {
+	s.send(c, request+"\r\n")
+	var res []value
+	for {
+		line := s.line(c)
+		if line == "END" {
+			return res
+		}
+		fields := strings.Fields(line)
+		assert.Equal(s.T(), "VALUE", fields[0])
+		flags, _ := strconv.ParseUint(fields[2], 10, 32)
+		length, _ := strconv.Atoi(fields[3])
+		v := value{key: fields[1], flags: uint32(flags)}
+		if len(fields) == 5 {
+			v.cas, _ = strconv.ParseUint(fields[4], 10, 64)
+		}
+		data := make([]byte, length+2)
+		_, err := io.ReadFull(c.r, data)
+		assert.Equal(s.T(), nil, err)
+		v.data = string(data[:length])
+		res = append(res, v)
+	}
+}
+
+func (s *serverTestSuite) TestSetGetDelete() {
+	c := s.dial()
+	defer c.conn.Close()
+
+	assert.Equal(s.T(), 0, len(s.get(c, "get asong")))
+	assert.Equal(s.T(), "STORED", s.storeCmd(c, "set asong 42 0", "golang"))
+	assert.Equal(s.T(), "STORED", s.storeCmd(c, "set empty 0 0", ""))
+	assert.Equal(s.T(), []value{{key: "asong", flags: 42, data: "golang"}, {key: "empty"}},
+		s.get(c, "get asong missing empty"))
+	assert.Equal(s.T(), "DELETED", s.do(c, "delete asong"))
+	assert.Equal(s.T(), "NOT_FOUND", s.do(c, "delete asong"))
+	assert.Equal(s.T(), 0, len(s.get(c, "get asong")))
+	assert.Equal(s.T(), "ERROR", s.do(c, "bogus"))
+	assert.Equal(s.T(), "VERSION "+version, s.do(c, "version"))
+}
+
+func (s *serverTestSuite) TestAddReplace() {
+	c := s.dial()
+	defer c.conn.Close()
+
+	assert.Equal(s.T(), "NOT_STORED", s.storeCmd(c, "replace asong 0 0", "a"))
+	assert.Equal(s.T(), "STORED", s.storeCmd(c, "add asong 0 0", "b"))
+	assert.Equal(s.T(), "NOT_STORED", s.storeCmd(c, "add asong 0 0", "c"))
+	assert.Equal(s.T(), "STORED", s.storeCmd(c, "replace asong 1 0", "d"))
+	assert.Equal(s.T(), "STORED", s.storeCmd(c, "append asong 5 0", "e"))
+	assert.Equal(s.T(), "STORED", s.storeCmd(c, "prepend asong 5 0", "f"))
+	assert.Equal(s.T(), []value{{key: "asong", flags: 1, data: "fde"}}, s.get(c, "get asong"))
+}
+
+func (s *serverTestSuite) TestCAS() {
+	c := s.dial()
+	defer c.conn.Close()
+
+	assert.Equal(s.T(), "NOT_FOUND", s.storeCmd(c, "cas asong 0 0 1", "a"))
+	assert.Equal(s.T(), "STORED", s.storeCmd(c, "set asong 0 0", "a"))
+	values := s.get(c, "gets asong")
+	assert.Equal(s.T(), 1, len(values))
+	cas := values[0].cas
+	assert.NotEqual(s.T(), uint64(0), cas)
+
+	assert.Equal(s.T(), "STORED", s.storeCmd(c, fmt.Sprintf("cas asong 3 0 %d", cas), "b"))
+	assert.Equal(s.T(), "EXISTS", s.storeCmd(c, fmt.Sprintf("cas asong 0 0 %d", cas), "c"))
+	values = s.get(c, "gets asong")
+	assert.Equal(s.T(), "b", values[0].data)
+	assert.Equal(s.T(), uint32(3), values[0].flags)
+	assert.NotEqual(s.T(), cas, values[0].cas)
+}
+
+func (s *serverTestSuite) TestIncrDecr() {
+	c := s.dial()
+	defer c.conn.Close()
+
+	assert.Equal(s.T(), "NOT_FOUND", s.do(c, "incr counter 1"))
+	assert.Equal(s.T(), "STORED", s.storeCmd(c, "set counter 7 100", "10"))
+	assert.Equal(s.T(), "15", s.do(c, "incr counter 5"))
+	assert.Equal(s.T(), "12", s.do(c, "decr counter 3"))
+	assert.Equal(s.T(), "0", s.do(c, "decr counter 100"))
+	assert.Equal(s.T(), []value{{key: "counter", flags: 7, data: "0"}}, s.get(c, "get counter"))
+	ttl, err := s.cache.TTL("counter")
+	assert.Equal(s.T(), nil, err)
+	assert.InDelta(s.T(), float64(100*time.Second), float64(ttl), float64(2*time.Second))
+
+	assert.Equal(s.T(), "STORED", s.storeCmd(c, "set max 0 0", "18446744073709551615"))
+	assert.Equal(s.T(), "1", s.do(c, "incr max 2"))
+	assert.Equal(s.T(), "STORED", s.storeCmd(c, "set asong 0 0", "golang"))
+	assert.Equal(s.T(), "CLIENT_ERROR cannot increment or decrement non-numeric value", s.do(c, "incr asong 1"))
+	assert.Equal(s.T(), "CLIENT_ERROR invalid numeric delta argument", s.do(c, "incr counter -1"))
+}
+
+func (s *serverTestSuite) TestExptime() {
+	c := s.dial()
+	defer c.conn.Close()
+
+	assert.Equal(s.T(), "STORED", s.storeCmd(c, "set relative 0 100", "a"))
+	assert.Equal(s.T(), "STORED", s.storeCmd(c, fmt.Sprintf("set absolute 0 %d", time.Now().Unix()+200), "b"))
+	assert.Equal(s.T(), "STORED", s.storeCmd(c, "set gone 0 -1", "c"))
+	ttl, err := s.cache.TTL("relative")
+	assert.Equal(s.T(), nil, err)
+	assert.InDelta(s.T(), float64(100*time.Second), float64(ttl), float64(2*time.Second))
+	ttl, err = s.cache.TTL("absolute")
+	assert.Equal(s.T(), nil, err)
+	assert.InDelta(s.T(), float64(200*time.Second), float64(ttl), float64(2*time.Second))
+	assert.Equal(s.T(), 0, len(s.get(c, "get gone")))
+
+	assert.Equal(s.T(), "TOUCHED", s.do(c, "touch relative 300"))
+	ttl, err = s.cache.TTL("relative")
+	assert.Equal(s.T(), nil, err)
+	assert.InDelta(s.T(), float64(300*time.Second), float64(ttl), float64(2*time.Second))
+	assert.Equal(s.T(), "NOT_FOUND", s.do(c, "touch missing 300"))
+	assert.Equal(s.T(), "TOUCHED", s.do(c, "touch relative -1"))
+	assert.Equal(s.T(), 0, len(s.get(c, "get relative")))
+}
+
+func (s *serverTestSuite) TestNoreplyAndPipelining() {
+	c := s.dial()
+	defer c.conn.Close()
+
+	var b strings.Builder
+	for index := 0; index < 50; index++ {
+		fmt.Fprintf(&b, "set asong%d 0 0 %d noreply\r\n%d\r\n", index, len(strconv.Itoa(index)), index)
+	}
+	b.WriteString("incr asong1 1 noreply\r\n")
+	b.WriteString("delete asong2 noreply\r\n")
+	for index := 0; index < 3; index++ {
+		fmt.Fprintf(&b, "get asong%d\r\n", index)
+	}
+	s.send(c, b.String())
+	for _, expected := range []string{"0", "2"} {
+		fields := strings.Fields(s.line(c))
+		assert.Equal(s.T(), "VALUE", fields[0])
+		assert.Equal(s.T(), expected, s.line(c))
+		assert.Equal(s.T(), "END", s.line(c))
+	}
+	assert.Equal(s.T(), "END", s.line(c))
+	assert.Equal(s.T(), 49, s.cache.Len())
+}
+
+func (s *serverTestSuite) TestBadRequests() {
+	c := s.dial()
+	defer c.conn.Close()
+
+	assert.Equal(s.T(), "CLIENT_ERROR bad command line format", s.storeCmd(c, "set asong x 0", "a"))
+	assert.Equal(s.T(), "SERVER_ERROR object too large for cache", s.storeCmd(c, "set asong 0 0", strings.Repeat("a", maxItemSize+1)))
+	assert.Equal(s.T(), "STORED", s.storeCmd(c, "set asong 0 0", "a"))
+	s.send(c, "set asong 0 0 1\r\nabc\r\n")
+	assert.Equal(s.T(), "CLIENT_ERROR bad data chunk", s.line(c))
+	_, err := c.r.ReadString('\n')
+	assert.Equal(s.T(), io.EOF, err)
+}
+
+func (s *serverTestSuite) TestStatsAndFlush() {
+	c := s.dial()
+	defer c.conn.Close()
+
+	assert.Equal(s.T(), "STORED", s.storeCmd(c, "set asong 0 0", "golang"))
+	s.get(c, "get asong missing")
+	s.send(c, "stats\r\n")
+	stats := make(map[string]string)
+	for {
+		line := s.line(c)
+		if line == "END" {
+			break
+		}
+		fields := strings.Fields(line)
+		stats[fields[1]] = fields[2]
+	}
+	assert.Equal(s.T(), "1", stats["get_hits"])
+	assert.Equal(s.T(), "1", stats["get_misses"])
+	assert.Equal(s.T(), "2", stats["cmd_get"])
+	assert.Equal(s.T(), "1", stats["cmd_set"])
+	assert.Equal(s.T(), "1", stats["curr_items"])
+	assert.Equal(s.T(), "1", stats["curr_connections"])
+
+	assert.Equal(s.T(), "OK", s.do(c, "flush_all"))
+	assert.Equal(s.T(), 0, s.cache.Len())
+	assert.Equal(s.T(), "STORED", s.storeCmd(c, "set asong 0 0", "golang"))
+	assert.Equal(s.T(), "OK", s.do(c, "flush_all 1"))
+	assert.Equal(s.T(), 1, s.cache.Len())
+	assert.Eventually(s.T(), func() bool { return s.cache.Len() == 0 }, 3*time.Second, 50*time.Millisecond)
+}
+
+func (s *serverTestSuite) TestShutdown() {
+	c := s.dial()
+	defer c.conn.Close()
+	assert.Equal(s.T(), "STORED", s.storeCmd(c, "set asong 0 0", "golang"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Equal(s.T(), nil, s.server.Shutdown(ctx))
+	assert.Equal(s.T(), ErrServerClosed, <-s.errs)
+	_, err := c.r.ReadString('\n')
+	assert.Equal(s.T(), io.EOF, err)
+}
+
+func (s *serverTestSuite) TestShutdownDropsDelayedFlush() {
+	c := s.dial()
+	defer c.conn.Close()
+	assert.Equal(s.T(), "STORED", s.storeCmd(c, "set asong 0 0", "golang"))
+	assert.Equal(s.T(), "OK", s.do(c, "flush_all 1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Equal(s.T(), nil, s.server.Shutdown(ctx))
+	time.Sleep(1500 * time.Millisecond)
+	assert.Equal(s.T(), 1, s.cache.Len())
+}
@@ -0,0 +1,458 @@
+package resp
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// command serves a command, args includes the command name. It returns true to close the connection.
+type command struct {
+	// arity is the number of arguments including the name, -n for at least n
+	arity int
+	fn    func(s *Server, w writer, args [][]byte) bool
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"ping":    {-1, (*Server).ping},
+		"echo":    {2, (*Server).echo},
+		"quit":    {1, (*Server).quit},
+		"select":  {2, (*Server).selectDB},
+		"command": {-1, (*Server).command},
+		"get":     {2, (*Server).get},
+		"set":     {-3, (*Server).set},
+		"del":     {-2, (*Server).del},
+		"exists":  {-2, (*Server).exists},
+		"ttl":     {2, (*Server).ttl},
+		"pexpire": {3, (*Server).pexpire},
+		"incrby":  {3, (*Server).incrby},
+		"mget":    {-2, (*Server).mget},
+		"mset":    {-3, (*Server).mset},
+		"scan":    {-2, (*Server).scan},
+		"dbsize":  {1, (*Server).dbsize},
+		"info":    {-1, (*Server).info},
+	}
+}
+
+const (
+	errSyntax     = "ERR syntax error"
+	errNotInteger = "ERR value is not an integer or out of range"
+	errExpireTime = "ERR invalid expire time in 'set' command"
+)
+
+func (s *Server) dispatch(w writer, args [][]byte) bool {
+	name := strings.ToLower(string(args[0]))
+	cmd, ok := commands[name]
+	if !ok {
+		w.error(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return false
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		w.error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+		return false
+	}
+	return cmd.fn(s, w, args)
+}
+
+// ttlSeconds rounds ttl up to whole seconds, the expire granularity of the cache
+func ttlSeconds(ttl time.Duration) time.Duration {
+	return (ttl + time.Second - 1) / time.Second * time.Second
+}
+
+// stored reports whether key is stored, without counting a hit or a miss
+func (s *Server) stored(key string) bool {
+	_, err := s.cache.TTL(key)
+	return err == nil
+}
+
+func (s *Server) ping(w writer, args [][]byte) bool {
+	switch len(args) {
+	case 1:
+		w.simple("PONG")
+	case 2:
+		w.bulk(args[1])
+	default:
+		w.error("ERR wrong number of arguments for 'ping' command")
+	}
+	return false
+}
+
+func (s *Server) echo(w writer, args [][]byte) bool {
+	w.bulk(args[1])
+	return false
+}
+
+func (s *Server) quit(w writer, args [][]byte) bool {
+	w.simple("OK")
+	return true
+}
+
+// selectDB accepts database 0 only, some clients select it on connect
+func (s *Server) selectDB(w writer, args [][]byte) bool {
+	if string(args[1]) != "0" {
+		w.error("ERR DB index is out of range")
+		return false
+	}
+	w.simple("OK")
+	return false
+}
+
+// command replies with an empty command table, redis-cli asks for it on start
+func (s *Server) command(w writer, args [][]byte) bool {
+	w.array(0)
+	return false
+}
+
+func (s *Server) get(w writer, args [][]byte) bool {
+	value, err := s.cache.Get(string(args[1]))
+	if err != nil {
+		w.bulk(nil)
+		return false
+	}
+	w.bulk(value)
+	return false
+}
+
+// set serves SET key value [EX seconds|PX milliseconds] [NX|XX]
+func (s *Server) set(w writer, args [][]byte) bool {
+	key := string(args[1])
+	var ttl time.Duration
+	var nx, xx bool
+	for i := 3; i < len(args); i++ {
+		switch option := strings.ToLower(string(args[i])); {
+		case option == "nx" && !xx:
+			nx = true
+		case option == "xx" && !nx:
+			xx = true
+		case (option == "ex" || option == "px") && ttl == 0 && i+1 < len(args):
+			i++
+			n, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				w.error(errNotInteger)
+				return false
+			}
+			if n <= 0 || n > math.MaxInt64/int64(time.Second) {
+				w.error(errExpireTime)
+				return false
+			}
+			if option == "ex" {
+				ttl = time.Duration(n) * time.Second
+			} else {
+				ttl = ttlSeconds(time.Duration(n) * time.Millisecond)
+			}
+		default:
+			w.error(errSyntax)
+			return false
+		}
+	}
+
+	defer s.locks.Lock(key)()
+	if (nx || xx) && s.stored(key) != xx {
+		w.bulk(nil)
+		return false
+	}
+	var err error
+	if ttl > 0 {
+		err = s.cache.SetWithTime(key, args[2], ttl)
+	} else {
+		err = s.cache.Set(key, args[2])
+	}
+	if err != nil {
+		w.error("ERR " + err.Error())
+		return false
+	}
+	w.simple("OK")
+	return false
+}
+
+func (s *Server) del(w writer, args [][]byte) bool {
+	deleted := int64(0)
+	for _, arg := range args[1:] {
+		key := string(arg)
+		unlock := s.locks.Lock(key)
+		if s.cache.Delete(key) == nil {
+			deleted++
+		}
+		unlock()
+	}
+	w.integer(deleted)
+	return false
+}
+
+func (s *Server) exists(w writer, args [][]byte) bool {
+	count := int64(0)
+	for _, arg := range args[1:] {
+		if s.stored(string(arg)) {
+			count++
+		}
+	}
+	w.integer(count)
+	return false
+}
+
+// ttl replies with the remaining seconds, -2 for a missing key. Every entry of the cache
+// expires, so -1 is never returned.
+func (s *Server) ttl(w writer, args [][]byte) bool {
+	ttl, err := s.cache.TTL(string(args[1]))
+	if err != nil {
+		w.integer(-2)
+		return false
+	}
+	w.integer(int64(ttlSeconds(ttl) / time.Second))
+	return false
+}
+
+// pexpire rounds the new time to live up to whole seconds, a time in the past deletes the key
+func (s *Server) pexpire(w writer, args [][]byte) bool {
+	key := string(args[1])
+	ms, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil || ms > math.MaxInt64/int64(time.Millisecond) {
+		w.error(errNotInteger)
+		return false
+	}
+	defer s.locks.Lock(key)()
+	if ms <= 0 {
+		if s.cache.Delete(key) == nil {
+			w.integer(1)
+		} else {
+			w.integer(0)
+		}
+		return false
+	}
+	if s.cache.Expire(key, ttlSeconds(time.Duration(ms)*time.Millisecond)) != nil {
+		w.integer(0)
+		return false
+	}
+	w.integer(1)
+	return false
+}
+
+// incrby keeps the time to live of an existing key, a new key gets the default one
+func (s *Server) incrby(w writer, args [][]byte) bool {
+	key := string(args[1])
+	increment, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		w.error(errNotInteger)
+		return false
+	}
+	defer s.locks.Lock(key)()
+	current := int64(0)
+	ttl, ttlErr := s.cache.TTL(key)
+	if ttlErr == nil {
+		value, err := s.cache.Get(key)
+		if err == nil {
+			current, err = strconv.ParseInt(string(value), 10, 64)
+			if err != nil {
+				w.error(errNotInteger)
+				return false
+			}
+		} else {
+			ttlErr = err
+		}
+	}
+	if (increment > 0 && current > math.MaxInt64-increment) || (increment < 0 && current < math.MinInt64-increment) {
+		w.error("ERR increment or decrement would overflow")
+		return false
+	}
+	current += increment
+	value := []byte(strconv.FormatInt(current, 10))
+	if ttlErr == nil {
+		err = s.cache.SetWithTime(key, value, ttlSeconds(ttl))
+	} else {
+		err = s.cache.Set(key, value)
+	}
+	if err != nil {
+		w.error("ERR " + err.Error())
+		return false
+	}
+	w.integer(current)
+	return false
+}
+
+func (s *Server) mget(w writer, args [][]byte) bool {
+	w.array(len(args) - 1)
+	for _, arg := range args[1:] {
+		value, err := s.cache.Get(string(arg))
+		if err != nil {
+			value = nil
+		}
+		w.bulk(value)
+	}
+	return false
+}
+
+func (s *Server) mset(w writer, args [][]byte) bool {
+	if len(args)%2 != 1 {
+		w.error("ERR wrong number of arguments for 'mset' command")
+		return false
+	}
+	for i := 1; i < len(args); i += 2 {
+		key := string(args[i])
+		unlock := s.locks.Lock(key)
+		err := s.cache.Set(key, args[i+1])
+		unlock()
+		if err != nil {
+			w.error("ERR " + err.Error())
+			return false
+		}
+	}
+	w.simple("OK")
+	return false
+}
+
+// scan serves SCAN cursor [MATCH pattern] [COUNT count]. As in redis, COUNT is a hint of
+// the work done per call and MATCH filters the keys afterwards, so a reply may hold fewer
+// keys than COUNT or none at all.
+func (s *Server) scan(w writer, args [][]byte) bool {
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		w.error("ERR invalid cursor")
+		return false
+	}
+	count := 10
+	var pattern []byte
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			w.error(errSyntax)
+			return false
+		}
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			pattern = args[i+1]
+		case "count":
+			count, err = strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				w.error(errNotInteger)
+				return false
+			}
+			if count < 1 {
+				w.error(errSyntax)
+				return false
+			}
+		default:
+			w.error(errSyntax)
+			return false
+		}
+	}
+	keys, next := s.cache.Scan(cursor, count)
+	if pattern != nil {
+		matched := keys[:0]
+		for _, key := range keys {
+			if matchGlob(pattern, []byte(key)) {
+				matched = append(matched, key)
+			}
+		}
+		keys = matched
+	}
+	w.array(2)
+	w.bulk([]byte(strconv.FormatUint(next, 10)))
+	w.array(len(keys))
+	for _, key := range keys {
+		w.bulk([]byte(key))
+	}
+	return false
+}
+
+func (s *Server) dbsize(w writer, args [][]byte) bool {
+	w.integer(int64(s.cache.Len()))
+	return false
+}
+
+// info replies with the server and keyspace statistics in the redis INFO format
+func (s *Server) info(w writer, args [][]byte) bool {
+	stats := s.cache.Stats()
+	clients := s.conns.Connections()
+
+	var b strings.Builder
+	b.WriteString("# Server\r\n")
+	fmt.Fprintf(&b, "uptime_in_seconds:%d\r\n", int64(time.Since(s.start)/time.Second))
+	b.WriteString("\r\n# Clients\r\n")
+	fmt.Fprintf(&b, "connected_clients:%d\r\n", clients)
+	b.WriteString("\r\n# Memory\r\n")
+	fmt.Fprintf(&b, "used_memory:%d\r\n", stats.LiveBytes)
+	b.WriteString("\r\n# Stats\r\n")
+	fmt.Fprintf(&b, "total_commands_processed:%d\r\n", atomic.LoadInt64(&s.commands))
+	fmt.Fprintf(&b, "keyspace_hits:%d\r\n", stats.Hits)
+	fmt.Fprintf(&b, "keyspace_misses:%d\r\n", stats.Misses)
+	fmt.Fprintf(&b, "evicted_keys:%d\r\n", stats.Evictions)
+	fmt.Fprintf(&b, "expired_keys:%d\r\n", stats.Expirations)
+	b.WriteString("\r\n# Keyspace\r\n")
+	fmt.Fprintf(&b, "db0:keys=%d\r\n", s.cache.Len())
+	w.bulk([]byte(b.String()))
+	return false
+}
+
+// matchGlob reports whether name matches the redis glob pattern, which supports *, ?,
+// [abc], [^abc], [a-z] and backslash escapes
+func matchGlob(pattern, name []byte) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchGlob(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(name) == 0 {
+				return false
+			}
+		case '[':
+			if len(name) == 0 {
+				return false
+			}
+			end := 1
+			negate := end < len(pattern) && pattern[end] == '^'
+			if negate {
+				end++
+			}
+			matched := false
+			for ; end < len(pattern) && pattern[end] != ']'; end++ {
+				if pattern[end] == '\\' && end+1 < len(pattern) {
+					end++
+					matched = matched || pattern[end] == name[0]
+				} else if end+2 < len(pattern) && pattern[end+1] == '-' && pattern[end+2] != ']' {
+					lo, hi := pattern[end], pattern[end+2]
+					if lo > hi {
+						lo, hi = hi, lo
+					}
+					matched = matched || (name[0] >= lo && name[0] <= hi)
+					end += 2
+				} else {
+					matched = matched || pattern[end] == name[0]
+				}
+			}
+			if matched == negate {
+				return false
+			}
+			if end < len(pattern) {
+				pattern = pattern[end:]
+			} else {
+				pattern = pattern[len(pattern)-1:]
+			}
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if len(name) == 0 || name[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+	return len(name) == 0
+}
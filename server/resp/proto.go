@@ -0,0 +1,137 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+)
+
+const (
+	// maxBulkLength is the largest bulk string accepted, as in redis
+	maxBulkLength = 512 * 1024 * 1024
+	// maxArgs is the largest number of arguments of a command
+	maxArgs = 1024 * 1024
+	// maxInlineLength is the longest inline command line
+	maxInlineLength = 64 * 1024
+)
+
+// protocolError is a malformed request, the connection is closed after replying with it
+type protocolError struct {
+	msg string
+}
+
+func (e *protocolError) Error() string {
+	return "Protocol error: " + e.msg
+}
+
+// readCommand reads a command sent as an array of bulk strings, or as an inline command
+// for telnet style clients. An empty inline line, like an empty or null array as redis
+// accepts them, yields no arguments.
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return bytes.Fields(line), nil
+	}
+	count, err := strconv.Atoi(string(line[1:]))
+	if err != nil || count > maxArgs {
+		return nil, &protocolError{"invalid multibulk length"}
+	}
+	if count <= 0 {
+		return nil, nil
+	}
+	args := make([][]byte, 0, count)
+	for len(args) < count {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, &protocolError{"expected '$', got '" + string(line) + "'"}
+		}
+		length, err := strconv.Atoi(string(line[1:]))
+		if err != nil || length < 0 || length > maxBulkLength {
+			return nil, &protocolError{"invalid bulk length"}
+		}
+		arg := make([]byte, length+2)
+		if _, err := io.ReadFull(r, arg); err != nil {
+			return nil, err
+		}
+		if arg[length] != '\r' || arg[length+1] != '\n' {
+			return nil, &protocolError{"bulk string not terminated by CRLF"}
+		}
+		args = append(args, arg[:length])
+	}
+	return args, nil
+}
+
+// readLine reads a line terminated by CRLF, or by a bare LF for inline commands
+func readLine(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if err == nil {
+			break
+		}
+		if err != bufio.ErrBufferFull {
+			if err == io.EOF && len(line) > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if len(line) > maxInlineLength {
+			return nil, &protocolError{"too big inline request"}
+		}
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
+
+// writer renders replies, it is flushed once the pipelined commands read so far are served
+type writer struct {
+	*bufio.Writer
+}
+
+func (w writer) simple(s string) {
+	w.WriteByte('+')
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
+
+func (w writer) error(s string) {
+	w.WriteByte('-')
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
+
+func (w writer) integer(n int64) {
+	w.WriteByte(':')
+	w.WriteString(strconv.FormatInt(n, 10))
+	w.WriteString("\r\n")
+}
+
+// bulk writes b as a bulk string, a nil b as the nil bulk string
+func (w writer) bulk(b []byte) {
+	if b == nil {
+		w.WriteString("$-1\r\n")
+		return
+	}
+	w.WriteByte('$')
+	w.WriteString(strconv.Itoa(len(b)))
+	w.WriteString("\r\n")
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+func (w writer) array(n int) {
+	w.WriteByte('*')
+	w.WriteString(strconv.Itoa(n))
+	w.WriteString("\r\n")
+}
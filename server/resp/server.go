@@ -0,0 +1,97 @@
+// Package resp serves a cache over the redis serialization protocol (RESP2), so redis-cli
+// and the common redis clients can read and write it.
+package resp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	localcache "github.com/asong2020/go-localcache"
+	"github.com/asong2020/go-localcache/server/internal/netserver"
+)
+
+// ErrServerClosed is returned by Serve and ListenAndServe after Shutdown or Close
+var ErrServerClosed = errors.New("resp: server closed")
+
+// Server serves the commands of its connections against a cache. Commands that read and
+// then write a key (SET NX/XX, INCRBY, PEXPIRE) hold a lock striped by key, together with
+// the other writes of the server, so they are atomic towards the clients of the server but
+// not towards code using the cache directly.
+type Server struct {
+	cache localcache.ICache
+	locks netserver.KeyLocks
+	start time.Time
+	// commands counts the processed commands, for INFO
+	commands int64
+	conns    *netserver.Server
+}
+
+// NewServer creates a server for cache
+func NewServer(cache localcache.ICache) *Server {
+	s := &Server{
+		cache: cache,
+		start: time.Now(),
+	}
+	s.conns = netserver.New(ErrServerClosed, s.serveConn)
+	return s
+}
+
+// ListenAndServe listens on the TCP address addr and serves the connections
+func (s *Server) ListenAndServe(addr string) error {
+	return s.conns.ListenAndServe(addr)
+}
+
+// Serve accepts connections on l until it fails or the server is shut down, it always
+// returns a non nil error. The listener is closed on return.
+func (s *Server) Serve(l net.Listener) error {
+	return s.conns.Serve(l)
+}
+
+// Shutdown stops accepting connections and lets every connection finish the commands it
+// has already received, then closes it. If ctx is done first, the remaining connections
+// are closed and the context error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.conns.Shutdown(ctx)
+}
+
+// Close closes the listeners and every connection immediately
+func (s *Server) Close() error {
+	return s.conns.Close()
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	w := writer{bufio.NewWriter(conn)}
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			var protoErr *protocolError
+			if errors.As(err, &protoErr) {
+				w.error("ERR " + protoErr.Error())
+				w.Flush()
+			}
+			return
+		}
+		if len(args) > 0 {
+			atomic.AddInt64(&s.commands, 1)
+			if quit := s.dispatch(w, args); quit {
+				w.Flush()
+				return
+			}
+		}
+		// replies of pipelined commands are sent together once the input is drained
+		if r.Buffered() > 0 {
+			continue
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+		if s.conns.Closed() {
+			return
+		}
+	}
+}
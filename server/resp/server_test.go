@@ -0,0 +1,368 @@
+package resp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	localcache "github.com/asong2020/go-localcache"
+)
+
+type serverTestSuite struct {
+	suite.Suite
+	cache  localcache.ICache
+	server *Server
+	addr   string
+	errs   chan error
+}
+
+func TestServerTestSuite(t *testing.T) {
+	suite.Run(t, new(serverTestSuite))
+}
+
+func (s *serverTestSuite) SetupTest() {
+	cache, err := localcache.NewCache(localcache.SetShardCount(4), localcache.SetStatsEnabled(true))
+	assert.Equal(s.T(), nil, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(s.T(), nil, err)
+	server, errs := NewServer(cache), make(chan error, 1)
+	go func() {
+		errs <- server.Serve(l)
+	}()
+	s.cache, s.server, s.errs = cache, server, errs
+	s.addr = l.Addr().String()
+}
+
+func (s *serverTestSuite) TearDownTest() {
+	s.server.Close()
+	s.cache.Close()
+}
+
+// client is a minimal RESP client
+type client struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (s *serverTestSuite) dial() *client {
+	conn, err := net.Dial("tcp", s.addr)
+	assert.Equal(s.T(), nil, err)
+	return &client{conn: conn, r: bufio.NewReader(conn)}
+}
+
+func encodeCommand(args ...string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return b.String()
+}
+
+// replyError is an error reply
+type replyError string
+
+func (c *client) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return replyError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		length, _ := strconv.Atoi(line[1:])
+		if length < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, length+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:length]), nil
+	case '*':
+		length, _ := strconv.Atoi(line[1:])
+		res := make([]interface{}, length)
+		for i := range res {
+			if res[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	}
+	return nil, errors.New("unexpected reply " + line)
+}
+
+func (s *serverTestSuite) do(c *client, args ...string) interface{} {
+	_, err := c.conn.Write([]byte(encodeCommand(args...)))
+	assert.Equal(s.T(), nil, err)
+	res, err := c.read()
+	assert.Equal(s.T(), nil, err)
+	return res
+}
+
+func (s *serverTestSuite) TestGetSetDel() {
+	c := s.dial()
+	defer c.conn.Close()
+
+	assert.Equal(s.T(), "PONG", s.do(c, "PING"))
+	assert.Equal(s.T(), "hello", s.do(c, "ping", "hello"))
+	assert.Equal(s.T(), nil, s.do(c, "GET", "asong"))
+	assert.Equal(s.T(), "OK", s.do(c, "SET", "asong", "golang"))
+	assert.Equal(s.T(), "golang", s.do(c, "GET", "asong"))
+	assert.Equal(s.T(), int64(1), s.do(c, "EXISTS", "asong", "missing"))
+	assert.Equal(s.T(), int64(1), s.do(c, "DBSIZE"))
+	assert.Equal(s.T(), int64(1), s.do(c, "DEL", "asong", "missing"))
+	assert.Equal(s.T(), nil, s.do(c, "GET", "asong"))
+	assert.Equal(s.T(), replyError("ERR unknown command 'NOPE'"), s.do(c, "NOPE"))
+	assert.Equal(s.T(), replyError("ERR wrong number of arguments for 'get' command"), s.do(c, "GET"))
+}
+
+func (s *serverTestSuite) TestSetOptions() {
+	c := s.dial()
+	defer c.conn.Close()
+
+	assert.Equal(s.T(), nil, s.do(c, "SET", "asong", "1", "XX"))
+	assert.Equal(s.T(), "OK", s.do(c, "SET", "asong", "1", "NX", "EX", "100"))
+	assert.Equal(s.T(), nil, s.do(c, "SET", "asong", "2", "NX"))
+	assert.Equal(s.T(), "1", s.do(c, "GET", "asong"))
+	assert.Equal(s.T(), int64(100), s.do(c, "TTL", "asong"))
+	assert.Equal(s.T(), "OK", s.do(c, "SET", "asong", "3", "XX", "PX", "1500"))
+	assert.Equal(s.T(), int64(2), s.do(c, "TTL", "asong"))
+	assert.Equal(s.T(), "3", s.do(c, "GET", "asong"))
+
+	assert.Equal(s.T(), replyError(errSyntax), s.do(c, "SET", "asong", "4", "NX", "XX"))
+	assert.Equal(s.T(), replyError(errExpireTime), s.do(c, "SET", "asong", "4", "EX", "0"))
+	assert.Equal(s.T(), replyError(errNotInteger), s.do(c, "SET", "asong", "4", "EX", "soon"))
+}
+
+func (s *serverTestSuite) TestExpire() {
+	c := s.dial()
+	defer c.conn.Close()
+
+	assert.Equal(s.T(), int64(-2), s.do(c, "TTL", "asong"))
+	assert.Equal(s.T(), int64(0), s.do(c, "PEXPIRE", "asong", "5000"))
+	assert.Equal(s.T(), "OK", s.do(c, "SET", "asong", "golang"))
+	assert.Equal(s.T(), int64(1), s.do(c, "PEXPIRE", "asong", "5000"))
+	assert.Equal(s.T(), int64(5), s.do(c, "TTL", "asong"))
+	assert.Equal(s.T(), int64(1), s.do(c, "PEXPIRE", "asong", "-1"))
+	assert.Equal(s.T(), int64(-2), s.do(c, "TTL", "asong"))
+}
+
+func (s *serverTestSuite) TestIncrBy() {
+	c := s.dial()
+	defer c.conn.Close()
+
+	assert.Equal(s.T(), int64(5), s.do(c, "INCRBY", "counter", "5"))
+	assert.Equal(s.T(), int64(3), s.do(c, "INCRBY", "counter", "-2"))
+	assert.Equal(s.T(), "3", s.do(c, "GET", "counter"))
+
+	assert.Equal(s.T(), "OK", s.do(c, "SET", "counter", "7", "EX", "100"))
+	assert.Equal(s.T(), int64(8), s.do(c, "INCRBY", "counter", "1"))
+	assert.Equal(s.T(), int64(100), s.do(c, "TTL", "counter"))
+
+	assert.Equal(s.T(), "OK", s.do(c, "SET", "asong", "golang"))
+	assert.Equal(s.T(), replyError(errNotInteger), s.do(c, "INCRBY", "asong", "1"))
+	assert.Equal(s.T(), "OK", s.do(c, "SET", "max", "9223372036854775807"))
+	assert.Equal(s.T(), replyError("ERR increment or decrement would overflow"), s.do(c, "INCRBY", "max", "1"))
+}
+
+func (s *serverTestSuite) TestConcurrentIncrBy() {
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			c := s.dial()
+			defer c.conn.Close()
+			for j := 0; j < 50; j++ {
+				s.do(c, "INCRBY", "counter", "1")
+			}
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+	c := s.dial()
+	defer c.conn.Close()
+	assert.Equal(s.T(), "200", s.do(c, "GET", "counter"))
+}
+
+func (s *serverTestSuite) TestMGetMSet() {
+	c := s.dial()
+	defer c.conn.Close()
+
+	assert.Equal(s.T(), "OK", s.do(c, "MSET", "a", "1", "b", "2"))
+	assert.Equal(s.T(), []interface{}{"1", nil, "2"}, s.do(c, "MGET", "a", "missing", "b"))
+	assert.Equal(s.T(), replyError("ERR wrong number of arguments for 'mset' command"), s.do(c, "MSET", "a", "1", "b"))
+}
+
+func (s *serverTestSuite) TestScan() {
+	c := s.dial()
+	defer c.conn.Close()
+
+	for index := 0; index < 30; index++ {
+		assert.Equal(s.T(), "OK", s.do(c, "SET", fmt.Sprintf("user:%d", index), "v"))
+		assert.Equal(s.T(), "OK", s.do(c, "SET", fmt.Sprintf("order:%d", index), "v"))
+	}
+	seen := make(map[string]bool)
+	cursor := "0"
+	for {
+		res := s.do(c, "SCAN", cursor, "MATCH", "user:*", "COUNT", "7").([]interface{})
+		cursor = res[0].(string)
+		for _, key := range res[1].([]interface{}) {
+			assert.True(s.T(), strings.HasPrefix(key.(string), "user:"))
+			seen[key.(string)] = true
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	assert.Equal(s.T(), 30, len(seen))
+	assert.Equal(s.T(), replyError("ERR invalid cursor"), s.do(c, "SCAN", "x"))
+}
+
+func (s *serverTestSuite) TestInfo() {
+	c := s.dial()
+	defer c.conn.Close()
+
+	assert.Equal(s.T(), "OK", s.do(c, "SET", "asong", "golang"))
+	s.do(c, "GET", "asong")
+	s.do(c, "GET", "missing")
+	info := s.do(c, "INFO").(string)
+	assert.Contains(s.T(), info, "keyspace_hits:1\r\n")
+	assert.Contains(s.T(), info, "keyspace_misses:1\r\n")
+	assert.Contains(s.T(), info, "db0:keys=1\r\n")
+	assert.Contains(s.T(), info, "connected_clients:1\r\n")
+}
+
+func (s *serverTestSuite) TestPipelining() {
+	c := s.dial()
+	defer c.conn.Close()
+
+	var b strings.Builder
+	for index := 0; index < 100; index++ {
+		b.WriteString(encodeCommand("SET", fmt.Sprintf("asong%d", index), strconv.Itoa(index)))
+		b.WriteString(encodeCommand("GET", fmt.Sprintf("asong%d", index)))
+	}
+	_, err := c.conn.Write([]byte(b.String()))
+	assert.Equal(s.T(), nil, err)
+	for index := 0; index < 100; index++ {
+		res, err := c.read()
+		assert.Equal(s.T(), nil, err)
+		assert.Equal(s.T(), "OK", res)
+		res, err = c.read()
+		assert.Equal(s.T(), nil, err)
+		assert.Equal(s.T(), strconv.Itoa(index), res)
+	}
+}
+
+func (s *serverTestSuite) TestInlineAndProtocolError() {
+	c := s.dial()
+	defer c.conn.Close()
+
+	_, err := c.conn.Write([]byte("SET asong golang\r\nGET asong\n\r\n"))
+	assert.Equal(s.T(), nil, err)
+	res, err := c.read()
+	assert.Equal(s.T(), nil, err)
+	assert.Equal(s.T(), "OK", res)
+	res, err = c.read()
+	assert.Equal(s.T(), nil, err)
+	assert.Equal(s.T(), "golang", res)
+
+	_, err = c.conn.Write([]byte("*1\r\n+GET\r\n"))
+	assert.Equal(s.T(), nil, err)
+	res, err = c.read()
+	assert.Equal(s.T(), nil, err)
+	assert.Equal(s.T(), replyError("ERR Protocol error: expected '$', got '+GET'"), res)
+	_, err = c.read()
+	assert.Equal(s.T(), io.EOF, err)
+}
+
+func (s *serverTestSuite) TestEmptyMultibulk() {
+	c := s.dial()
+	defer c.conn.Close()
+
+	_, err := c.conn.Write([]byte("*-1\r\n*0\r\n*-100\r\n"))
+	assert.Equal(s.T(), nil, err)
+	assert.Equal(s.T(), "PONG", s.do(c, "PING"))
+
+	// the server still serves other clients
+	other := s.dial()
+	defer other.conn.Close()
+	assert.Equal(s.T(), "PONG", s.do(other, "PING"))
+}
+
+func (s *serverTestSuite) TestQuit() {
+	c := s.dial()
+	defer c.conn.Close()
+
+	assert.Equal(s.T(), "OK", s.do(c, "QUIT"))
+	_, err := c.read()
+	assert.Equal(s.T(), io.EOF, err)
+}
+
+func (s *serverTestSuite) TestShutdown() {
+	idle := s.dial()
+	defer idle.conn.Close()
+	busy := s.dial()
+	defer busy.conn.Close()
+	assert.Equal(s.T(), "PONG", s.do(idle, "PING"))
+
+	// a command sent before the shutdown is still served
+	_, err := busy.conn.Write([]byte(encodeCommand("SET", "asong", "golang")))
+	assert.Equal(s.T(), nil, err)
+	res, err := busy.read()
+	assert.Equal(s.T(), nil, err)
+	assert.Equal(s.T(), "OK", res)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Equal(s.T(), nil, s.server.Shutdown(ctx))
+	assert.Equal(s.T(), ErrServerClosed, <-s.errs)
+
+	_, err = idle.read()
+	assert.Equal(s.T(), io.EOF, err)
+	_, err = net.Dial("tcp", s.addr)
+	assert.NotEqual(s.T(), nil, err)
+}
+
+func (s *serverTestSuite) TestMatchGlob() {
+	cases := []struct {
+		pattern, name string
+		match         bool
+	}{
+		{"*", "anything", true},
+		{"user:*", "user:1", true},
+		{"user:*", "order:1", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"", "", true},
+		{"a", "", false},
+	}
+	for _, each := range cases {
+		assert.Equal(s.T(), each.match, matchGlob([]byte(each.pattern), []byte(each.name)), each.pattern+" "+each.name)
+	}
+}
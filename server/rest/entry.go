@@ -0,0 +1,48 @@
+package rest
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+// entry is a stored value. The cache holds it as version | len(contentType) | contentType | body.
+type entry struct {
+	version     uint64
+	contentType string
+	body        []byte
+}
+
+const entryHeaderSize = 8 + 1
+
+// maxContentTypeLength is the longest content type that can be stored
+const maxContentTypeLength = 255
+
+func encodeEntry(e entry) []byte {
+	res := make([]byte, entryHeaderSize+len(e.contentType)+len(e.body))
+	binary.LittleEndian.PutUint64(res, e.version)
+	res[8] = byte(len(e.contentType))
+	n := entryHeaderSize + copy(res[entryHeaderSize:], e.contentType)
+	copy(res[n:], e.body)
+	return res
+}
+
+// decodeEntry returns false for a value that was not stored through the handler
+func decodeEntry(value []byte) (entry, bool) {
+	if len(value) < entryHeaderSize {
+		return entry{}, false
+	}
+	length := int(value[8])
+	if len(value) < entryHeaderSize+length {
+		return entry{}, false
+	}
+	return entry{
+		version:     binary.LittleEndian.Uint64(value),
+		contentType: string(value[entryHeaderSize : entryHeaderSize+length]),
+		body:        value[entryHeaderSize+length:],
+	}, true
+}
+
+// etag is the strong entity tag of the entry
+func (e entry) etag() string {
+	return `"` + strconv.FormatUint(e.version, 16) + `"`
+}
@@ -0,0 +1,275 @@
+// Package rest exposes a cache over HTTP:
+//
+//	GET    /keys/{key}   the stored body, with its content type, ETag and X-TTL
+//	HEAD   /keys/{key}   the same headers without the body
+//	PUT    /keys/{key}   stores the request body, with the TTL from the X-TTL header or the ttl query parameter
+//	DELETE /keys/{key}   removes the key
+//	GET    /stats        the cache statistics as JSON
+//	POST   /flush        removes every key
+//
+// Keys are path segments, a key holding a slash must escape it as %2F. GET and HEAD honor
+// If-None-Match, PUT and DELETE honor If-Match and If-None-Match: *.
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	localcache "github.com/asong2020/go-localcache"
+	"github.com/asong2020/go-localcache/server/internal/netserver"
+)
+
+const (
+	keysPrefix         = "/keys/"
+	ttlHeader          = "X-TTL"
+	defaultContentType = "application/octet-stream"
+	// defaultMaxBodySize is the largest body accepted by PUT
+	defaultMaxBodySize = 1024 * 1024
+)
+
+type options struct {
+	maxBodySize int64
+}
+
+// Opt configures the handler returned by NewHandler
+type Opt func(options *options)
+
+// SetMaxBodySize sets the largest body accepted by PUT, 1MB by default
+func SetMaxBodySize(size int64) Opt {
+	return func(opt *options) {
+		opt.maxBodySize = size
+	}
+}
+
+type handler struct {
+	cache       localcache.ICache
+	maxBodySize int64
+	locks       netserver.KeyLocks
+	// version is the last entry version handed out. It starts from the creation time so
+	// versions, and the ETags derived from them, are not reused after a restart.
+	version uint64
+}
+
+// NewHandler returns a http.Handler serving cache. Entries are stored with their version
+// and content type in front of the body, so they are not readable as plain values by the
+// other clients of the cache. Writes of the same key through the handler are serialized,
+// which makes the conditional requests atomic towards the other requests of the handler.
+func NewHandler(cache localcache.ICache, opts ...Opt) http.Handler {
+	options := &options{
+		maxBodySize: defaultMaxBodySize,
+	}
+	for _, each := range opts {
+		each(options)
+	}
+	return &handler{
+		cache:       cache,
+		maxBodySize: options.maxBodySize,
+		version:     uint64(time.Now().UnixNano()),
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.EscapedPath()
+	switch {
+	case strings.HasPrefix(path, keysPrefix):
+		key, err := url.PathUnescape(strings.TrimPrefix(path, keysPrefix))
+		if err != nil || key == "" {
+			http.Error(w, "invalid key", http.StatusBadRequest)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			h.get(w, r, key)
+		case http.MethodPut:
+			h.put(w, r, key)
+		case http.MethodDelete:
+			h.delete(w, r, key)
+		default:
+			methodNotAllowed(w, "GET, HEAD, PUT, DELETE")
+		}
+	case path == "/stats":
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			methodNotAllowed(w, "GET, HEAD")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(h.cache.Stats())
+	case path == "/flush":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, "POST")
+			return
+		}
+		if err := h.cache.Clear(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
+
+// lookup returns the entry of key and its remaining time to live
+func (h *handler) lookup(key string) (entry, time.Duration, bool) {
+	value, err := h.cache.Get(key)
+	if err != nil {
+		return entry{}, 0, false
+	}
+	e, ok := decodeEntry(value)
+	if !ok {
+		return entry{}, 0, false
+	}
+	ttl, err := h.cache.TTL(key)
+	if err != nil {
+		return entry{}, 0, false
+	}
+	return e, ttl, true
+}
+
+func (h *handler) get(w http.ResponseWriter, r *http.Request, key string) {
+	e, ttl, ok := h.lookup(key)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	header := w.Header()
+	header.Set("ETag", e.etag())
+	header.Set(ttlHeader, strconv.FormatInt(int64((ttl+time.Second-1)/time.Second), 10))
+	if matchETag(r.Header.Get("If-None-Match"), e) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	header.Set("Content-Type", e.contentType)
+	header.Set("Content-Length", strconv.Itoa(len(e.body)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(e.body)
+	}
+}
+
+func (h *handler) put(w http.ResponseWriter, r *http.Request, key string) {
+	ttl, err := parseTTL(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+	if len(contentType) > maxContentTypeLength {
+		http.Error(w, "content type too long", http.StatusBadRequest)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, h.maxBodySize))
+	if err != nil {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	defer h.locks.Lock(key)()
+	current, _, exists := h.lookup(key)
+	if !preconditions(r, current, exists) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	e := entry{
+		version:     atomic.AddUint64(&h.version, 1),
+		contentType: contentType,
+		body:        body,
+	}
+	if ttl > 0 {
+		err = h.cache.SetWithTime(key, encodeEntry(e), ttl)
+	} else {
+		err = h.cache.Set(key, encodeEntry(e))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+	w.Header().Set("ETag", e.etag())
+	if exists {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *handler) delete(w http.ResponseWriter, r *http.Request, key string) {
+	defer h.locks.Lock(key)()
+	current, _, exists := h.lookup(key)
+	if !preconditions(r, current, exists) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	if !exists || h.cache.Delete(key) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// preconditions evaluates If-Match and If-None-Match for a write
+func preconditions(r *http.Request, current entry, exists bool) bool {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && (!exists || !matchETag(ifMatch, current)) {
+		return false
+	}
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && exists && matchETag(ifNoneMatch, current) {
+		return false
+	}
+	return true
+}
+
+// matchETag reports whether the list of entity tags of a conditional header holds the tag
+// of e. Weak tags compare by their opaque value.
+func matchETag(header string, e entry) bool {
+	if header == "" {
+		return false
+	}
+	etag := e.etag()
+	for _, each := range strings.Split(header, ",") {
+		each = strings.TrimSpace(each)
+		if each == "*" || strings.TrimPrefix(each, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+var errInvalidTTL = errors.New("invalid ttl, expected seconds or a duration like 1m30s")
+
+// parseTTL reads the time to live from the X-TTL header or the ttl query parameter, as
+// seconds or as a duration. 0 means the default time to live of the cache.
+func parseTTL(r *http.Request) (time.Duration, error) {
+	value := r.Header.Get(ttlHeader)
+	if value == "" {
+		value = r.URL.Query().Get("ttl")
+	}
+	if value == "" {
+		return 0, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds <= 0 {
+			return 0, errInvalidTTL
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		return 0, errInvalidTTL
+	}
+	// the cache expires entries with a one second granularity
+	return (ttl + time.Second - 1) / time.Second * time.Second, nil
+}
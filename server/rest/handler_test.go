@@ -0,0 +1,169 @@
+package rest
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	localcache "github.com/asong2020/go-localcache"
+)
+
+type handlerTestSuite struct {
+	suite.Suite
+	cache  localcache.ICache
+	server *httptest.Server
+}
+
+func TestHandlerTestSuite(t *testing.T) {
+	suite.Run(t, new(handlerTestSuite))
+}
+
+func (h *handlerTestSuite) SetupTest() {
+	cache, err := localcache.NewCache(localcache.SetShardCount(4), localcache.SetStatsEnabled(true))
+	assert.Equal(h.T(), nil, err)
+	h.cache = cache
+	h.server = httptest.NewServer(NewHandler(cache, SetMaxBodySize(1024)))
+}
+
+func (h *handlerTestSuite) TearDownTest() {
+	h.server.Close()
+	h.cache.Close()
+}
+
+func (h *handlerTestSuite) do(method, path, body string, headers map[string]string) (*http.Response, string) {
+	req, err := http.NewRequest(method, h.server.URL+path, strings.NewReader(body))
+	assert.Equal(h.T(), nil, err)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.Equal(h.T(), nil, err)
+	defer resp.Body.Close()
+	res, err := ioutil.ReadAll(resp.Body)
+	assert.Equal(h.T(), nil, err)
+	return resp, string(res)
+}
+
+func (h *handlerTestSuite) TestPutGetDelete() {
+	resp, _ := h.do(http.MethodGet, "/keys/asong", "", nil)
+	assert.Equal(h.T(), http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = h.do(http.MethodPut, "/keys/asong", `{"name":"asong"}`, map[string]string{"Content-Type": "application/json"})
+	assert.Equal(h.T(), http.StatusCreated, resp.StatusCode)
+	etag := resp.Header.Get("ETag")
+	assert.NotEqual(h.T(), "", etag)
+
+	resp, body := h.do(http.MethodGet, "/keys/asong", "", nil)
+	assert.Equal(h.T(), http.StatusOK, resp.StatusCode)
+	assert.Equal(h.T(), `{"name":"asong"}`, body)
+	assert.Equal(h.T(), "application/json", resp.Header.Get("Content-Type"))
+	assert.Equal(h.T(), etag, resp.Header.Get("ETag"))
+
+	resp, _ = h.do(http.MethodPut, "/keys/asong", "golang", nil)
+	assert.Equal(h.T(), http.StatusNoContent, resp.StatusCode)
+	assert.NotEqual(h.T(), etag, resp.Header.Get("ETag"))
+	resp, body = h.do(http.MethodGet, "/keys/asong", "", nil)
+	assert.Equal(h.T(), "golang", body)
+	assert.Equal(h.T(), defaultContentType, resp.Header.Get("Content-Type"))
+
+	resp, _ = h.do(http.MethodDelete, "/keys/asong", "", nil)
+	assert.Equal(h.T(), http.StatusNoContent, resp.StatusCode)
+	resp, _ = h.do(http.MethodDelete, "/keys/asong", "", nil)
+	assert.Equal(h.T(), http.StatusNotFound, resp.StatusCode)
+}
+
+func (h *handlerTestSuite) TestEscapedKey() {
+	resp, _ := h.do(http.MethodPut, "/keys/user%2F1", "asong", nil)
+	assert.Equal(h.T(), http.StatusCreated, resp.StatusCode)
+	res, err := h.cache.Get("user/1")
+	assert.Equal(h.T(), nil, err)
+	e, ok := decodeEntry(res)
+	assert.True(h.T(), ok)
+	assert.Equal(h.T(), []byte("asong"), e.body)
+}
+
+func (h *handlerTestSuite) TestTTLAndHead() {
+	resp, _ := h.do(http.MethodPut, "/keys/header", "a", map[string]string{ttlHeader: "100"})
+	assert.Equal(h.T(), http.StatusCreated, resp.StatusCode)
+	resp, _ = h.do(http.MethodPut, "/keys/query?ttl=2m", "bb", nil)
+	assert.Equal(h.T(), http.StatusCreated, resp.StatusCode)
+	resp, _ = h.do(http.MethodPut, "/keys/bad?ttl=-1", "c", nil)
+	assert.Equal(h.T(), http.StatusBadRequest, resp.StatusCode)
+
+	resp, body := h.do(http.MethodHead, "/keys/header", "", nil)
+	assert.Equal(h.T(), http.StatusOK, resp.StatusCode)
+	assert.Equal(h.T(), "", body)
+	assert.Equal(h.T(), "100", resp.Header.Get(ttlHeader))
+	assert.Equal(h.T(), "1", resp.Header.Get("Content-Length"))
+
+	resp, _ = h.do(http.MethodHead, "/keys/query", "", nil)
+	assert.Equal(h.T(), "120", resp.Header.Get(ttlHeader))
+	assert.Equal(h.T(), "2", resp.Header.Get("Content-Length"))
+
+	resp, _ = h.do(http.MethodHead, "/keys/missing", "", nil)
+	assert.Equal(h.T(), http.StatusNotFound, resp.StatusCode)
+
+	ttl, err := h.cache.TTL("query")
+	assert.Equal(h.T(), nil, err)
+	assert.InDelta(h.T(), float64(2*time.Minute), float64(ttl), float64(2*time.Second))
+}
+
+func (h *handlerTestSuite) TestConditionalRequests() {
+	resp, _ := h.do(http.MethodPut, "/keys/asong", "v1", map[string]string{"If-None-Match": "*"})
+	assert.Equal(h.T(), http.StatusCreated, resp.StatusCode)
+	etag := resp.Header.Get("ETag")
+	resp, _ = h.do(http.MethodPut, "/keys/asong", "v2", map[string]string{"If-None-Match": "*"})
+	assert.Equal(h.T(), http.StatusPreconditionFailed, resp.StatusCode)
+
+	resp, _ = h.do(http.MethodGet, "/keys/asong", "", map[string]string{"If-None-Match": `"other", ` + etag})
+	assert.Equal(h.T(), http.StatusNotModified, resp.StatusCode)
+	assert.Equal(h.T(), etag, resp.Header.Get("ETag"))
+	resp, body := h.do(http.MethodGet, "/keys/asong", "", map[string]string{"If-None-Match": `"other"`})
+	assert.Equal(h.T(), http.StatusOK, resp.StatusCode)
+	assert.Equal(h.T(), "v1", body)
+
+	resp, _ = h.do(http.MethodPut, "/keys/asong", "v2", map[string]string{"If-Match": `"other"`})
+	assert.Equal(h.T(), http.StatusPreconditionFailed, resp.StatusCode)
+	resp, _ = h.do(http.MethodPut, "/keys/asong", "v2", map[string]string{"If-Match": etag})
+	assert.Equal(h.T(), http.StatusNoContent, resp.StatusCode)
+	resp, _ = h.do(http.MethodDelete, "/keys/asong", "", map[string]string{"If-Match": etag})
+	assert.Equal(h.T(), http.StatusPreconditionFailed, resp.StatusCode)
+	resp, _ = h.do(http.MethodPut, "/keys/missing", "v1", map[string]string{"If-Match": "*"})
+	assert.Equal(h.T(), http.StatusPreconditionFailed, resp.StatusCode)
+}
+
+func (h *handlerTestSuite) TestStatsAndFlush() {
+	h.do(http.MethodPut, "/keys/asong", "golang", nil)
+	h.do(http.MethodGet, "/keys/asong", "", nil)
+
+	resp, body := h.do(http.MethodGet, "/stats", "", nil)
+	assert.Equal(h.T(), "application/json", resp.Header.Get("Content-Type"))
+	stats := localcache.Stats{}
+	assert.Equal(h.T(), nil, json.Unmarshal([]byte(body), &stats))
+	assert.True(h.T(), stats.Hits > 0)
+	assert.Equal(h.T(), int64(1), stats.Sets)
+
+	resp, _ = h.do(http.MethodGet, "/flush", "", nil)
+	assert.Equal(h.T(), http.StatusMethodNotAllowed, resp.StatusCode)
+	assert.Equal(h.T(), "POST", resp.Header.Get("Allow"))
+	resp, _ = h.do(http.MethodPost, "/flush", "", nil)
+	assert.Equal(h.T(), http.StatusNoContent, resp.StatusCode)
+	assert.Equal(h.T(), 0, h.cache.Len())
+}
+
+func (h *handlerTestSuite) TestErrors() {
+	resp, _ := h.do(http.MethodPut, "/keys/large", strings.Repeat("a", 1025), nil)
+	assert.Equal(h.T(), http.StatusRequestEntityTooLarge, resp.StatusCode)
+	resp, _ = h.do(http.MethodPost, "/keys/asong", "", nil)
+	assert.Equal(h.T(), http.StatusMethodNotAllowed, resp.StatusCode)
+	resp, _ = h.do(http.MethodGet, "/keys/", "", nil)
+	assert.Equal(h.T(), http.StatusBadRequest, resp.StatusCode)
+	resp, _ = h.do(http.MethodGet, "/other", "", nil)
+	assert.Equal(h.T(), http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,89 @@
+package localcache
+
+import (
+	"sort"
+	"time"
+)
+
+// ShardStat stores the statistics of a single segment
+type ShardStat struct {
+	// Entries is a number of entries stored in the segment
+	Entries int `json:"entries"`
+	// Bytes is a number of bytes held by the entries of the segment
+	Bytes int `json:"bytes"`
+	// Capacity is a number of buffer slots of the segment
+	Capacity int `json:"capacity"`
+	// Free is a number of buffer slots that are not in use
+	Free int `json:"free"`
+	// Hits is a number of successfully found keys
+	Hits int64 `json:"hits"`
+	// Misses is a number of not found keys
+	Misses int64 `json:"misses"`
+	// Evictions is a number of entries removed to make room for new ones
+	Evictions int64 `json:"evictions"`
+	// Expirations is a number of entries removed because they expired
+	Expirations int64 `json:"expirations"`
+	// LockWait is the total time spent waiting for the segment lock
+	LockWait time.Duration `json:"lock_wait"`
+}
+
+// SkewWarningFunc is called when the shard skew crosses the configured threshold
+type SkewWarningFunc func(skew float64, shards []ShardStat)
+
+// ShardSkew returns the ratio of the most loaded segment to the mean segment load,
+// counted in entries. 1 means perfectly balanced; an empty cache reports 1.
+func ShardSkew(shards []ShardStat) float64 {
+	if len(shards) == 0 {
+		return 1
+	}
+	total, max := 0, 0
+	for _, shard := range shards {
+		total += shard.Entries
+		if shard.Entries > max {
+			max = shard.Entries
+		}
+	}
+	if total == 0 {
+		return 1
+	}
+	mean := float64(total) / float64(len(shards))
+	return float64(max) / mean
+}
+
+// ShardGini returns the Gini coefficient of the segment loads, counted in entries.
+// 0 means every segment holds the same number of entries, values close to 1 mean
+// a few segments hold almost everything.
+func ShardGini(shards []ShardStat) float64 {
+	loads := make([]int, len(shards))
+	total := 0
+	for index, shard := range shards {
+		loads[index] = shard.Entries
+		total += shard.Entries
+	}
+	if total == 0 {
+		return 0
+	}
+	sort.Ints(loads)
+	n := float64(len(loads))
+	weighted := 0.0
+	for index, load := range loads {
+		weighted += float64(index+1) * float64(load)
+	}
+	return 2*weighted/(n*float64(total)) - (n+1)/n
+}
+
+func (c *cache) monitorSkew(interval time.Duration, threshold float64, fn SkewWarningFunc) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			shards := c.ShardStats()
+			if skew := ShardSkew(shards); skew > threshold {
+				fn(skew, shards)
+			}
+		case <-c.close:
+			return
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package localcache
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type shardTestSuite struct {
+	suite.Suite
+}
+
+func TestShardTestSuite(t *testing.T) {
+	suite.Run(t, new(shardTestSuite))
+}
+
+func (s *shardTestSuite) SetupSuite() {}
+
+// firstShardHash sends every key to the first of up to 256 segments
+type firstShardHash struct{}
+
+func (firstShardHash) Sum64(key string) uint64 {
+	return fnv64a{}.Sum64(key) << 8
+}
+
+func (s *shardTestSuite) TestShardStats() {
+	c, err := NewCache(SetStatsEnabled(true), SetShardCount(4))
+	assert.Equal(s.T(), nil, err)
+
+	value := []byte("公众号：Golang梦工厂")
+	for index := 0; index < 100; index++ {
+		err = c.Set(fmt.Sprintf("asong%03d", index), value)
+		assert.Equal(s.T(), nil, err)
+	}
+	for index := 0; index < 110; index++ {
+		_, _ = c.Get(fmt.Sprintf("asong%03d", index))
+	}
+
+	shards := c.ShardStats()
+	assert.Equal(s.T(), 4, len(shards))
+	entries, hits, misses := 0, int64(0), int64(0)
+	for _, shard := range shards {
+		entries += shard.Entries
+		hits += shard.Hits
+		misses += shard.Misses
+		assert.Equal(s.T(), shard.Capacity-shard.Entries, shard.Free)
+	}
+	assert.Equal(s.T(), 100, entries)
+	assert.Equal(s.T(), int64(100), hits)
+	assert.Equal(s.T(), int64(10), misses)
+}
+
+func (s *shardTestSuite) TestShardSkew() {
+	balanced := []ShardStat{{Entries: 10}, {Entries: 10}, {Entries: 10}, {Entries: 10}}
+	assert.Equal(s.T(), float64(1), ShardSkew(balanced))
+	assert.Equal(s.T(), float64(0), ShardGini(balanced))
+
+	skewed := []ShardStat{{Entries: 40}, {Entries: 0}, {Entries: 0}, {Entries: 0}}
+	assert.Equal(s.T(), float64(4), ShardSkew(skewed))
+	assert.InDelta(s.T(), 0.75, ShardGini(skewed), 1e-9)
+
+	assert.Equal(s.T(), float64(1), ShardSkew(nil))
+	assert.Equal(s.T(), float64(0), ShardGini([]ShardStat{{}, {}}))
+}
+
+func (s *shardTestSuite) TestSkewWarning() {
+	warnings := make(chan float64, 1)
+	c, err := NewCache(SetShardCount(4), SetHashFunc(firstShardHash{}),
+		SetShardSkewWarning(2, 10*time.Millisecond, func(skew float64, shards []ShardStat) {
+			select {
+			case warnings <- skew:
+			default:
+			}
+		}))
+	assert.Equal(s.T(), nil, err)
+	defer c.Close()
+
+	for index := 0; index < 10; index++ {
+		err = c.Set(fmt.Sprintf("asong%03d", index), []byte("公众号：Golang梦工厂"))
+		assert.Equal(s.T(), nil, err)
+	}
+	assert.Equal(s.T(), 10, c.ShardStats()[0].Entries)
+
+	select {
+	case skew := <-warnings:
+		assert.Equal(s.T(), float64(4), skew)
+	case <-time.After(time.Second):
+		s.T().Fatal("skew warning was not reported")
+	}
+
+	_, err = NewCache(SetShardSkewWarning(2, 0, func(float64, []ShardStat) {}))
+	assert.Equal(s.T(), ErrSkewInterval, err)
+}
@@ -0,0 +1,122 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+)
+
+// fakeDatabase answers the statements of the fake driver. Queries are looked up by their
+// exact text and may use a single ? placeholder, matched against the first column.
+type fakeDatabase struct {
+	mu      sync.Mutex
+	columns map[string][]string
+	rows    map[string][][]driver.Value
+	queries int
+	execs   int
+}
+
+func newFakeDatabase() *fakeDatabase {
+	return &fakeDatabase{columns: make(map[string][]string), rows: make(map[string][][]driver.Value)}
+}
+
+func (f *fakeDatabase) set(query string, columns []string, rows ...[]driver.Value) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.columns[query] = columns
+	f.rows[query] = rows
+}
+
+func (f *fakeDatabase) counts() (int, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.queries, f.execs
+}
+
+func (f *fakeDatabase) open() *sql.DB {
+	return sql.OpenDB(fakeConnector{f})
+}
+
+type fakeConnector struct {
+	db *fakeDatabase
+}
+
+func (c fakeConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &fakeConn{c.db}, nil
+}
+
+func (c fakeConnector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	return nil, errors.New("fake driver: use a connector")
+}
+
+type fakeConn struct {
+	db *fakeDatabase
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("fake driver: prepare unsupported")
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("fake driver: transactions unsupported")
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.queries++
+	columns, ok := c.db.columns[query]
+	if !ok {
+		return nil, errors.New("fake driver: unknown query " + query)
+	}
+	rows := &fakeRows{columns: columns}
+	for _, row := range c.db.rows[query] {
+		if len(args) > 0 && row[0] != args[0].Value {
+			continue
+		}
+		rows.rows = append(rows.rows, row)
+	}
+	return rows, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.execs++
+	return driver.RowsAffected(1), nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
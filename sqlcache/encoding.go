@@ -0,0 +1,236 @@
+package sqlcache
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+)
+
+// value types of the encoding
+const (
+	typeNull byte = iota
+	typeInt64
+	typeFloat64
+	typeFalse
+	typeTrue
+	typeBytes
+	typeString
+	typeTime
+)
+
+var (
+	errInvalidResult = errors.New("sqlcache: invalid cached result")
+	// errUnsupportedValue is returned for a value that is not a driver.Value, such a
+	// result is not cached
+	errUnsupportedValue = errors.New("sqlcache: unsupported value type")
+)
+
+// result is the outcome of a query, as read from the driver
+type result struct {
+	columns []string
+	rows    [][]interface{}
+}
+
+// encode renders the result as
+// uvarint(len(columns)) | columns | uvarint(len(rows)) | values
+// where every value is a type byte followed by its payload
+func (r *result) encode() ([]byte, error) {
+	buf := appendUvarint(nil, uint64(len(r.columns)))
+	for _, column := range r.columns {
+		buf = appendString(buf, column)
+	}
+	buf = appendUvarint(buf, uint64(len(r.rows)))
+	var err error
+	for _, row := range r.rows {
+		for _, value := range row {
+			if buf, err = appendValue(buf, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return buf, nil
+}
+
+// appendValue encodes a driver.Value. Times keep their instant and zone offset, the name
+// of their location is lost.
+func appendValue(buf []byte, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(buf, typeNull), nil
+	case int64:
+		return appendVarint(append(buf, typeInt64), v), nil
+	case float64:
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+		return append(append(buf, typeFloat64), b[:]...), nil
+	case bool:
+		if v {
+			return append(buf, typeTrue), nil
+		}
+		return append(buf, typeFalse), nil
+	case []byte:
+		return appendString(append(buf, typeBytes), string(v)), nil
+	case string:
+		return appendString(append(buf, typeString), v), nil
+	case time.Time:
+		_, offset := v.Zone()
+		buf = appendVarint(append(buf, typeTime), v.Unix())
+		buf = appendUvarint(buf, uint64(v.Nanosecond()))
+		return appendVarint(buf, int64(offset)), nil
+	}
+	return nil, errUnsupportedValue
+}
+
+func decodeResult(data []byte) (*result, error) {
+	d := &decoder{buf: data}
+	columns := d.uvarint()
+	if columns > uint64(len(data)) {
+		return nil, errInvalidResult
+	}
+	r := &result{columns: make([]string, 0, columns)}
+	for i := uint64(0); i < columns; i++ {
+		r.columns = append(r.columns, d.string())
+	}
+	rows := d.uvarint()
+	if d.err != nil || (columns > 0 && rows > uint64(len(d.buf))) {
+		return nil, errInvalidResult
+	}
+	r.rows = make([][]interface{}, 0, rows)
+	for i := uint64(0); i < rows; i++ {
+		row := make([]interface{}, columns)
+		for j := range row {
+			row[j] = d.value()
+		}
+		r.rows = append(r.rows, row)
+	}
+	if d.err != nil || len(d.buf) > 0 {
+		return nil, errInvalidResult
+	}
+	return r, nil
+}
+
+// appendArgs encodes the arguments of a query for its key. Arguments are converted to
+// driver values first, so 1 and int64(1) give the same key.
+func appendArgs(buf []byte, args []interface{}) ([]byte, error) {
+	buf = appendUvarint(buf, uint64(len(args)))
+	for _, arg := range args {
+		if named, ok := arg.(sql.NamedArg); ok {
+			buf = appendString(buf, named.Name)
+			arg = named.Value
+		} else {
+			buf = appendString(buf, "")
+		}
+		value, err := driver.DefaultParameterConverter.ConvertValue(arg)
+		if err != nil {
+			return nil, err
+		}
+		if buf, err = appendValue(buf, value); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutUvarint(b[:], v)]...)
+}
+
+func appendVarint(buf []byte, v int64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutVarint(b[:], v)]...)
+}
+
+func appendString(buf []byte, s string) []byte {
+	return append(appendUvarint(buf, uint64(len(s))), s...)
+}
+
+// decoder reads the fields of a cached result, the first failure sticks in err
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errInvalidResult
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errInvalidResult
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	length := d.uvarint()
+	if d.err != nil || uint64(len(d.buf)) < length {
+		d.err = errInvalidResult
+		return nil
+	}
+	v := d.buf[:length:length]
+	d.buf = d.buf[length:]
+	return v
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) value() interface{} {
+	if d.err != nil || len(d.buf) == 0 {
+		d.err = errInvalidResult
+		return nil
+	}
+	valueType := d.buf[0]
+	d.buf = d.buf[1:]
+	switch valueType {
+	case typeNull:
+		return nil
+	case typeInt64:
+		return d.varint()
+	case typeFloat64:
+		if len(d.buf) < 8 {
+			d.err = errInvalidResult
+			return nil
+		}
+		v := math.Float64frombits(binary.LittleEndian.Uint64(d.buf))
+		d.buf = d.buf[8:]
+		return v
+	case typeFalse:
+		return false
+	case typeTrue:
+		return true
+	case typeBytes:
+		return d.bytes()
+	case typeString:
+		return d.string()
+	case typeTime:
+		sec, nsec, offset := d.varint(), d.uvarint(), d.varint()
+		t := time.Unix(sec, int64(nsec))
+		if offset == 0 {
+			return t.UTC()
+		}
+		return t.In(time.FixedZone("", int(offset)))
+	}
+	d.err = errInvalidResult
+	return nil
+}
@@ -0,0 +1,268 @@
+package sqlcache
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// Rows is the result of a query. Cached results are served from memory; the results of
+// queries that are not cached are streamed from the *sql.Rows they wrap.
+type Rows struct {
+	rows *sql.Rows
+
+	result *result
+	// next is the index of the row the next call to Next moves to
+	next   int
+	closed bool
+}
+
+// Next prepares the next row for Scan, it returns false after the last one
+func (r *Rows) Next() bool {
+	if r.rows != nil {
+		return r.rows.Next()
+	}
+	if r.closed || r.next >= len(r.result.rows) {
+		r.closed = true
+		return false
+	}
+	r.next++
+	return true
+}
+
+// Columns returns the column names
+func (r *Rows) Columns() ([]string, error) {
+	if r.rows != nil {
+		return r.rows.Columns()
+	}
+	if r.closed {
+		return nil, errors.New("sqlcache: Rows are closed")
+	}
+	return append([]string(nil), r.result.columns...), nil
+}
+
+// Scan copies the columns of the current row into dest, converting them the way
+// database/sql does for the common destination types: pointers to strings, byte slices,
+// integers, floats, bools, time.Time, interface{} and sql.Scanner implementations.
+func (r *Rows) Scan(dest ...interface{}) error {
+	if r.rows != nil {
+		return r.rows.Scan(dest...)
+	}
+	if r.closed {
+		return errors.New("sqlcache: Rows are closed")
+	}
+	if r.next == 0 {
+		return errors.New("sqlcache: Scan called without calling Next")
+	}
+	row := r.result.rows[r.next-1]
+	if len(dest) != len(row) {
+		return fmt.Errorf("sqlcache: expected %d destination arguments in Scan, not %d", len(row), len(dest))
+	}
+	for i, value := range row {
+		if err := convertAssign(dest[i], value); err != nil {
+			return fmt.Errorf("sqlcache: Scan error on column index %d, name %q: %v", i, r.result.columns[i], err)
+		}
+	}
+	return nil
+}
+
+// Err returns the error met while iterating
+func (r *Rows) Err() error {
+	if r.rows != nil {
+		return r.rows.Err()
+	}
+	return nil
+}
+
+// Close releases the rows
+func (r *Rows) Close() error {
+	if r.rows != nil {
+		return r.rows.Close()
+	}
+	r.closed = true
+	return nil
+}
+
+// Row is the result of QueryRow
+type Row struct {
+	rows *Rows
+	err  error
+}
+
+// Scan copies the columns of the first row into dest, sql.ErrNoRows when there is none
+func (r *Row) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	defer r.rows.Close()
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	if err := r.rows.Scan(dest...); err != nil {
+		return err
+	}
+	return r.rows.Close()
+}
+
+// Err returns the error of the query, deferred until Scan otherwise
+func (r *Row) Err() error {
+	return r.err
+}
+
+// convertAssign stores the driver value src into dest
+func convertAssign(dest interface{}, src interface{}) error {
+	switch d := dest.(type) {
+	case sql.Scanner:
+		return d.Scan(src)
+	case *interface{}:
+		if b, ok := src.([]byte); ok {
+			src = append([]byte(nil), b...)
+		}
+		*d = src
+		return nil
+	case *string:
+		switch s := src.(type) {
+		case string:
+			*d = s
+			return nil
+		case []byte:
+			*d = string(s)
+			return nil
+		case time.Time:
+			*d = s.Format(time.RFC3339Nano)
+			return nil
+		case nil:
+		default:
+			*d = fmt.Sprint(s)
+			return nil
+		}
+	case *[]byte:
+		switch s := src.(type) {
+		case []byte:
+			*d = append([]byte(nil), s...)
+			return nil
+		case string:
+			*d = []byte(s)
+			return nil
+		case nil:
+			*d = nil
+			return nil
+		}
+	case *sql.RawBytes:
+		switch s := src.(type) {
+		case []byte:
+			*d = append((*d)[:0], s...)
+			return nil
+		case string:
+			*d = append((*d)[:0], s...)
+			return nil
+		case nil:
+			*d = nil
+			return nil
+		}
+	case *time.Time:
+		if t, ok := src.(time.Time); ok {
+			*d = t
+			return nil
+		}
+	case *bool:
+		switch s := src.(type) {
+		case bool:
+			*d = s
+			return nil
+		case int64:
+			if s == 0 || s == 1 {
+				*d = s == 1
+				return nil
+			}
+		case string, []byte:
+			v, err := strconv.ParseBool(asString(s))
+			if err != nil {
+				return err
+			}
+			*d = v
+			return nil
+		}
+	}
+	return assignReflect(dest, src)
+}
+
+// assignReflect handles the numeric destinations of any size and pointers to pointers,
+// which stay nil for a NULL
+func assignReflect(dest interface{}, src interface{}) error {
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return errors.New("destination not a pointer")
+	}
+	dv = dv.Elem()
+	if src == nil {
+		if dv.Kind() == reflect.Ptr {
+			dv.Set(reflect.Zero(dv.Type()))
+			return nil
+		}
+		return fmt.Errorf("converting NULL to %s is unsupported", dv.Kind())
+	}
+	if dv.Kind() == reflect.Ptr {
+		v := reflect.New(dv.Type().Elem())
+		if err := convertAssign(v.Interface(), src); err != nil {
+			return err
+		}
+		dv.Set(v)
+		return nil
+	}
+	sv := reflect.ValueOf(src)
+	if sv.Type().AssignableTo(dv.Type()) {
+		dv.Set(sv)
+		return nil
+	}
+	s := asString(src)
+	switch dv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err := strconv.ParseInt(s, 10, dv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("converting %T %q to %s: %v", src, s, dv.Kind(), err)
+		}
+		dv.SetInt(v)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err := strconv.ParseUint(s, 10, dv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("converting %T %q to %s: %v", src, s, dv.Kind(), err)
+		}
+		dv.SetUint(v)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		v, err := strconv.ParseFloat(s, dv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("converting %T %q to %s: %v", src, s, dv.Kind(), err)
+		}
+		dv.SetFloat(v)
+		return nil
+	case reflect.String:
+		dv.SetString(s)
+		return nil
+	}
+	return fmt.Errorf("unsupported Scan, storing %T into type %T", src, dest)
+}
+
+func asString(src interface{}) string {
+	switch v := src.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprint(src)
+}
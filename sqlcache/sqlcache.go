@@ -0,0 +1,276 @@
+// Package sqlcache caches the results of designated read queries of a database/sql
+// database in an ICache. A query is cached under a key built from its text and its
+// arguments, for the TTL it was registered with. Writes made through Exec that touch a
+// table a query reads from make its cached results unreachable; they expire on their own.
+//
+// Invalidation is local to the DB wrapper: writes made by other processes, or directly on
+// the database, are only seen once the cached results expire.
+package sqlcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	localcache "github.com/asong2020/go-localcache"
+)
+
+const (
+	keyPrefix = "sqlcache:"
+
+	defaultMaxResultSize = 1024 * 1024
+)
+
+// Querier is the part of *sql.DB the wrapper uses, also implemented by *sql.Conn and *sql.Tx
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+type options struct {
+	maxResultSize int
+}
+
+// Opt configures a DB
+type Opt func(options *options)
+
+// SetMaxResultSize sets the largest encoded result that is cached, 1MB by default. The
+// results of larger queries are returned without being cached.
+func SetMaxResultSize(size int) Opt {
+	return func(opt *options) {
+		opt.maxResultSize = size
+	}
+}
+
+// queryConfig is how a registered query is cached
+type queryConfig struct {
+	ttl    time.Duration
+	tables []string
+}
+
+// DB wraps a database and caches the results of the registered queries
+type DB struct {
+	db            Querier
+	cache         localcache.ICache
+	maxResultSize int
+
+	mu      sync.RWMutex
+	queries map[string]queryConfig
+	// versions holds the generation of every table. A write bumps the generations of the
+	// tables it touches, and the keys of the queries reading them change with it.
+	versions map[string]uint64
+	// generation is part of every key, a write whose tables are unknown bumps it
+	generation uint64
+}
+
+// New wraps db, caching the results of the registered queries in cache
+func New(db Querier, cache localcache.ICache, opts ...Opt) *DB {
+	options := &options{maxResultSize: defaultMaxResultSize}
+	for _, each := range opts {
+		each(options)
+	}
+	return &DB{
+		db:            db,
+		cache:         cache,
+		maxResultSize: options.maxResultSize,
+		queries:       make(map[string]queryConfig),
+		versions:      make(map[string]uint64),
+		// keys left in the cache by a previous instance must not be reused
+		generation: uint64(time.Now().UnixNano()),
+	}
+}
+
+// Register caches the results of query, matched on its exact text, for ttl. tables are
+// the tables it reads, a write to any of them invalidates its results.
+func (d *DB) Register(query string, ttl time.Duration, tables ...string) {
+	normalized := make([]string, 0, len(tables))
+	for _, table := range tables {
+		normalized = append(normalized, normalizeTable(table))
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.queries[query] = queryConfig{ttl: ttl, tables: normalized}
+}
+
+// Query runs a query without a context
+func (d *DB) Query(query string, args ...interface{}) (*Rows, error) {
+	return d.QueryContext(context.Background(), query, args...)
+}
+
+// QueryContext answers a registered query from the cache, running it and caching its
+// result on a miss. Other queries run on the database.
+func (d *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	d.mu.RLock()
+	config, ok := d.queries[query]
+	d.mu.RUnlock()
+	if !ok {
+		rows, err := d.db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		return &Rows{rows: rows}, nil
+	}
+
+	// the key is computed before the query runs, so a write landing meanwhile makes
+	// the result unreachable rather than cached under the new generation
+	key, keyErr := d.key(query, config.tables, args)
+	if keyErr == nil {
+		if data, err := d.cache.Get(key); err == nil {
+			if result, err := decodeResult(data); err == nil {
+				return &Rows{result: result}, nil
+			}
+		}
+	}
+	result, err := d.run(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	if keyErr == nil {
+		if data, err := result.encode(); err == nil && len(data) <= d.maxResultSize {
+			_ = d.cache.SetWithTime(key, data, config.ttl)
+		}
+	}
+	return &Rows{result: result}, nil
+}
+
+// run reads the whole result of query
+func (d *DB) run(ctx context.Context, query string, args []interface{}) (*result, error) {
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	r := &result{columns: columns}
+	for rows.Next() {
+		row := make([]interface{}, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range row {
+			dest[i] = &row[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		r.rows = append(r.rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// QueryRow runs a query expected to return at most one row without a context
+func (d *DB) QueryRow(query string, args ...interface{}) *Row {
+	return d.QueryRowContext(context.Background(), query, args...)
+}
+
+// QueryRowContext runs a query expected to return at most one row
+func (d *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	rows, err := d.QueryContext(ctx, query, args...)
+	return &Row{rows: rows, err: err}
+}
+
+// Exec runs a write without a context
+func (d *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return d.ExecContext(context.Background(), query, args...)
+}
+
+// ExecContext runs a write and invalidates the cached results reading the tables it
+// touches. The tables are read from the statement: INSERT, REPLACE, UPDATE, DELETE, MERGE,
+// TRUNCATE, ALTER TABLE and DROP TABLE are recognized; any other statement invalidates
+// every cached result. A failed statement invalidates too, as it may have been applied.
+func (d *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	res, err := d.db.ExecContext(ctx, query, args...)
+	if tables := writtenTables(query); len(tables) > 0 {
+		d.Invalidate(tables...)
+	} else {
+		d.InvalidateAll()
+	}
+	return res, err
+}
+
+// Invalidate drops the cached results of the queries reading tables
+func (d *DB) Invalidate(tables ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, table := range tables {
+		d.versions[normalizeTable(table)]++
+	}
+}
+
+// InvalidateAll drops every cached result
+func (d *DB) InvalidateAll() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.generation++
+}
+
+// key hashes the query, its arguments and the generations of its tables
+func (d *DB) key(query string, tables []string, args []interface{}) (string, error) {
+	buf := appendString(nil, query)
+	buf, err := appendArgs(buf, args)
+	if err != nil {
+		return "", err
+	}
+	d.mu.RLock()
+	buf = appendUvarint(buf, d.generation)
+	for _, table := range tables {
+		buf = appendUvarint(buf, d.versions[table])
+	}
+	d.mu.RUnlock()
+	sum := sha256.Sum256(buf)
+	return keyPrefix + hex.EncodeToString(sum[:]), nil
+}
+
+// writePattern finds the table a write statement touches
+var writePattern = regexp.MustCompile(`(?is)^\s*(?:insert(?:\s+ignore)?\s+into|replace\s+into|` +
+	`update(?:\s+ignore)?|delete\s+from|merge\s+into|truncate(?:\s+table)?|alter\s+table|` +
+	"drop\\s+table(?:\\s+if\\s+exists)?)\\s+([\\w.`\"\\[\\]]+)")
+
+// writtenTables returns the table written by a statement, nil when it is not recognized
+func writtenTables(query string) []string {
+	match := writePattern.FindStringSubmatch(stripComments(query))
+	if match == nil {
+		return nil
+	}
+	return []string{normalizeTable(match[1])}
+}
+
+// stripComments drops the leading comments of a statement
+func stripComments(query string) string {
+	for {
+		query = strings.TrimSpace(query)
+		switch {
+		case strings.HasPrefix(query, "--"):
+			if i := strings.IndexByte(query, '\n'); i >= 0 {
+				query = query[i+1:]
+				continue
+			}
+			return ""
+		case strings.HasPrefix(query, "/*"):
+			if i := strings.Index(query, "*/"); i >= 0 {
+				query = query[i+2:]
+				continue
+			}
+			return ""
+		}
+		return query
+	}
+}
+
+// normalizeTable lower cases a table name and drops its quotes and schema
+func normalizeTable(table string) string {
+	table = strings.ToLower(strings.Trim(table, "`\"[]"))
+	if i := strings.LastIndexByte(table, '.'); i >= 0 {
+		table = table[i+1:]
+	}
+	return strings.Trim(table, "`\"[]")
+}
@@ -0,0 +1,238 @@
+package sqlcache
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"strings"
+	"testing"
+	"time"
+
+	localcache "github.com/asong2020/go-localcache"
+)
+
+const (
+	userQuery  = "SELECT id, name, avatar, score, active, created, nickname FROM users WHERE id = ?"
+	orderQuery = "SELECT id, total FROM orders"
+)
+
+type sqlcacheTestSuite struct {
+	suite.Suite
+	fake  *fakeDatabase
+	sqlDB *sql.DB
+	cache localcache.ICache
+	db    *DB
+	now   time.Time
+}
+
+func TestSqlcacheTestSuite(t *testing.T) {
+	suite.Run(t, new(sqlcacheTestSuite))
+}
+
+func (s *sqlcacheTestSuite) SetupTest() {
+	s.now = time.Date(2021, 6, 1, 12, 30, 0, 500, time.FixedZone("", 8*3600))
+	s.fake = newFakeDatabase()
+	s.fake.set(userQuery, []string{"id", "name", "avatar", "score", "active", "created", "nickname"},
+		[]driver.Value{int64(1), "asong", []byte{0, 1, 2}, 9.5, true, s.now, nil},
+		[]driver.Value{int64(2), "song", []byte{}, float64(0), false, s.now, "s"})
+	s.fake.set(orderQuery, []string{"id", "total"}, []driver.Value{int64(10), int64(99)})
+	s.sqlDB = s.fake.open()
+	cache, err := localcache.NewCache(localcache.SetShardCount(4))
+	assert.Equal(s.T(), nil, err)
+	s.cache = cache
+	s.db = New(s.sqlDB, cache)
+	s.db.Register(userQuery, time.Minute, "users")
+	s.db.Register(orderQuery, time.Minute, "orders", "users")
+}
+
+func (s *sqlcacheTestSuite) TearDownTest() {
+	s.sqlDB.Close()
+	s.cache.Close()
+}
+
+type user struct {
+	id       int
+	name     string
+	avatar   []byte
+	score    float32
+	active   bool
+	created  time.Time
+	nickname sql.NullString
+}
+
+func (s *sqlcacheTestSuite) queryUser(id int) (user, error) {
+	var u user
+	err := s.db.QueryRow(userQuery, id).Scan(&u.id, &u.name, &u.avatar, &u.score, &u.active, &u.created, &u.nickname)
+	return u, err
+}
+
+func (s *sqlcacheTestSuite) TestCachedQuery() {
+	for i := 0; i < 3; i++ {
+		u, err := s.queryUser(1)
+		assert.Equal(s.T(), nil, err)
+		assert.Equal(s.T(), 1, u.id)
+		assert.Equal(s.T(), "asong", u.name)
+		assert.Equal(s.T(), []byte{0, 1, 2}, u.avatar)
+		assert.Equal(s.T(), float32(9.5), u.score)
+		assert.True(s.T(), u.active)
+		assert.True(s.T(), s.now.Equal(u.created))
+		_, offset := u.created.Zone()
+		assert.Equal(s.T(), 8*3600, offset)
+		assert.False(s.T(), u.nickname.Valid)
+	}
+	queries, _ := s.fake.counts()
+	assert.Equal(s.T(), 1, queries)
+
+	u, err := s.queryUser(2)
+	assert.Equal(s.T(), nil, err)
+	assert.Equal(s.T(), "s", u.nickname.String)
+	_, err = s.queryUser(3)
+	assert.Equal(s.T(), sql.ErrNoRows, err)
+	_, err = s.queryUser(3)
+	assert.Equal(s.T(), sql.ErrNoRows, err)
+	queries, _ = s.fake.counts()
+	assert.Equal(s.T(), 3, queries)
+
+	keys, _ := s.cache.Scan(0, 100)
+	assert.Equal(s.T(), 3, len(keys))
+	for _, key := range keys {
+		assert.True(s.T(), strings.HasPrefix(key, keyPrefix))
+		ttl, err := s.cache.TTL(key)
+		assert.Equal(s.T(), nil, err)
+		assert.True(s.T(), ttl > 50*time.Second && ttl <= time.Minute)
+	}
+}
+
+func (s *sqlcacheTestSuite) TestRows() {
+	rows, err := s.db.Query(orderQuery)
+	assert.Equal(s.T(), nil, err)
+	rows.Close()
+	rows, err = s.db.Query(orderQuery)
+	assert.Equal(s.T(), nil, err)
+	columns, err := rows.Columns()
+	assert.Equal(s.T(), nil, err)
+	assert.Equal(s.T(), []string{"id", "total"}, columns)
+	count := 0
+	for rows.Next() {
+		var id, total interface{}
+		assert.Equal(s.T(), nil, rows.Scan(&id, &total))
+		assert.Equal(s.T(), int64(10), id)
+		assert.Equal(s.T(), int64(99), total)
+		var short int
+		assert.NotEqual(s.T(), nil, rows.Scan(&short))
+		count++
+	}
+	assert.Equal(s.T(), 1, count)
+	assert.Equal(s.T(), nil, rows.Err())
+	assert.Equal(s.T(), nil, rows.Close())
+	queries, _ := s.fake.counts()
+	assert.Equal(s.T(), 1, queries)
+}
+
+func (s *sqlcacheTestSuite) TestUnregisteredQuery() {
+	const query = "SELECT id FROM users"
+	s.fake.set(query, []string{"id"}, []driver.Value{int64(1)})
+	for i := 0; i < 2; i++ {
+		var id int
+		assert.Equal(s.T(), nil, s.db.QueryRow(query).Scan(&id))
+		assert.Equal(s.T(), 1, id)
+	}
+	queries, _ := s.fake.counts()
+	assert.Equal(s.T(), 2, queries)
+	assert.Equal(s.T(), 0, s.cache.Len())
+}
+
+func (s *sqlcacheTestSuite) TestInvalidation() {
+	_, err := s.queryUser(1)
+	assert.Equal(s.T(), nil, err)
+	_, err = s.db.Query(orderQuery)
+	assert.Equal(s.T(), nil, err)
+
+	_, err = s.db.Exec("INSERT INTO orders (id, total) VALUES (?, ?)", 11, 5)
+	assert.Equal(s.T(), nil, err)
+	_, err = s.queryUser(1)
+	assert.Equal(s.T(), nil, err)
+	_, err = s.db.Query(orderQuery)
+	assert.Equal(s.T(), nil, err)
+	queries, execs := s.fake.counts()
+	assert.Equal(s.T(), 3, queries)
+	assert.Equal(s.T(), 1, execs)
+
+	_, err = s.db.Exec("/* audit */ UPDATE `app`.`Users` SET name = ? WHERE id = ?", "new", 1)
+	assert.Equal(s.T(), nil, err)
+	_, err = s.queryUser(1)
+	assert.Equal(s.T(), nil, err)
+	_, err = s.db.Query(orderQuery)
+	assert.Equal(s.T(), nil, err)
+	queries, _ = s.fake.counts()
+	assert.Equal(s.T(), 5, queries)
+
+	_, err = s.db.Exec("CALL cleanup()")
+	assert.Equal(s.T(), nil, err)
+	_, err = s.queryUser(1)
+	assert.Equal(s.T(), nil, err)
+	queries, _ = s.fake.counts()
+	assert.Equal(s.T(), 6, queries)
+}
+
+func (s *sqlcacheTestSuite) TestMaxResultSize() {
+	s.db = New(s.sqlDB, s.cache, SetMaxResultSize(8))
+	s.db.Register(userQuery, time.Minute, "users")
+	_, err := s.queryUser(1)
+	assert.Equal(s.T(), nil, err)
+	_, err = s.queryUser(1)
+	assert.Equal(s.T(), nil, err)
+	queries, _ := s.fake.counts()
+	assert.Equal(s.T(), 2, queries)
+}
+
+func (s *sqlcacheTestSuite) TestEncoding() {
+	r := &result{
+		columns: []string{"a", "b"},
+		rows: [][]interface{}{
+			{nil, int64(-42)},
+			{3.25, true},
+			{false, []byte("bytes")},
+			{"string", time.Unix(1622550000, 7).UTC()},
+		},
+	}
+	data, err := r.encode()
+	assert.Equal(s.T(), nil, err)
+	decoded, err := decodeResult(data)
+	assert.Equal(s.T(), nil, err)
+	assert.Equal(s.T(), r, decoded)
+
+	_, err = decodeResult(data[:len(data)-1])
+	assert.Equal(s.T(), errInvalidResult, err)
+	_, err = (&result{columns: []string{"a"}, rows: [][]interface{}{{uint8(1)}}}).encode()
+	assert.Equal(s.T(), errUnsupportedValue, err)
+
+	a, err := appendArgs(nil, []interface{}{1, "x"})
+	assert.Equal(s.T(), nil, err)
+	b, err := appendArgs(nil, []interface{}{int64(1), "x"})
+	assert.Equal(s.T(), nil, err)
+	assert.Equal(s.T(), a, b)
+	c, err := appendArgs(nil, []interface{}{sql.Named("id", 1), "x"})
+	assert.Equal(s.T(), nil, err)
+	assert.NotEqual(s.T(), a, c)
+}
+
+func (s *sqlcacheTestSuite) TestWrittenTables() {
+	cases := map[string]string{
+		"INSERT INTO users VALUES (1)":             "users",
+		"insert ignore into Users (id) values (1)": "users",
+		"REPLACE INTO users VALUES (1)":            "users",
+		"update `db`.`users` set a = 1":            "users",
+		"DELETE FROM \"users\" WHERE id = 1":       "users",
+		"TRUNCATE TABLE users":                     "users",
+		"truncate users":                           "users",
+		"-- comment\nDROP TABLE IF EXISTS users":   "users",
+		"ALTER TABLE [dbo].[users] ADD c INT":      "users",
+	}
+	for query, table := range cases {
+		assert.Equal(s.T(), []string{table}, writtenTables(query), query)
+	}
+	assert.Equal(s.T(), 0, len(writtenTables("CALL cleanup()")))
+	assert.Equal(s.T(), 0, len(writtenTables("SELECT * FROM users")))
+}
@@ -2,6 +2,7 @@ package localcache
 
 import (
 	"sync/atomic"
+	"time"
 )
 
 // Stats stores cache statistics
@@ -16,8 +17,41 @@ type Stats struct {
 	DelMisses int64 `json:"delete_misses"`
 	// Collisions is a number of happened key-collisions
 	Collisions int64 `json:"collisions"`
-	// hashmapStats record key hit
-	hashmapStats map[string]int64
+	// Evictions is a number of entries removed to make room for new ones
+	Evictions int64 `json:"evictions"`
+	// Expirations is a number of entries removed because they expired, ExpiredLazy + ExpiredCleanup
+	Expirations int64 `json:"expirations"`
+	// ExpiredLazy is a number of expired entries removed when they were read
+	ExpiredLazy int64 `json:"expired_lazy"`
+	// ExpiredCleanup is a number of expired entries removed by the background cleanup
+	ExpiredCleanup int64 `json:"expired_cleanup"`
+	// Sets is a number of successfully stored entries
+	Sets int64 `json:"sets"`
+	// Overwrites is a number of sets that replaced an existing entry
+	Overwrites int64 `json:"overwrites"`
+	// BytesIn is a number of bytes written by sets, including entry headers
+	BytesIn int64 `json:"bytes_in"`
+	// RawValueBytes is a number of value bytes written by sets, before compression
+	RawValueBytes int64 `json:"raw_value_bytes"`
+	// StoredValueBytes is a number of value bytes written by sets, as stored after compression
+	StoredValueBytes int64 `json:"stored_value_bytes"`
+	// LiveBytes is a number of bytes currently held by entries, including entry headers.
+	// It is a gauge, so it is neither reset by ResetStats nor subtracted by Delta.
+	LiveBytes int64 `json:"live_bytes"`
+	// LiveCost is the total cost of the entries currently held, a gauge like LiveBytes
+	LiveCost int64 `json:"live_cost"`
+	// L2Hits is a number of memory misses found in the disk tier and promoted back
+	L2Hits int64 `json:"l2_hits"`
+	// L2Misses is a number of memory misses that were not found in the disk tier either
+	L2Misses int64 `json:"l2_misses"`
+	// LockWait is the total time spent waiting for segment locks
+	LockWait time.Duration `json:"lock_wait"`
+	// Latency holds the operation latency histograms, empty unless SetLatencyEnabled(true)
+	Latency LatencyStats `json:"latency"`
+	// sketch estimates the number of hits of every key
+	sketch *countMinSketch
+	// hotKeys tracks the most frequently hit keys
+	hotKeys *topK
 	statsEnabled bool
 }
 
@@ -26,7 +60,8 @@ func newStats(enabled bool) *Stats {
 		statsEnabled: enabled,
 	}
 	if enabled {
-		s.hashmapStats = make(map[string]int64)
+		s.sketch = &countMinSketch{}
+		s.hotKeys = newTopK(defaultTopK)
 	}
 	return s
 }
@@ -59,12 +94,59 @@ func (s *Stats) collision() {
 	atomic.AddInt64(&s.Collisions, 1)
 }
 
-func (s *Stats) hit(key string)  {
+func (s *Stats) evict() {
+	if !s.statsEnabled {
+		return
+	}
+	atomic.AddInt64(&s.Evictions, 1)
+}
+
+func (s *Stats) expireLazy() {
+	if !s.statsEnabled {
+		return
+	}
+	atomic.AddInt64(&s.ExpiredLazy, 1)
+}
+
+func (s *Stats) expireCleanup() {
+	if !s.statsEnabled {
+		return
+	}
+	atomic.AddInt64(&s.ExpiredCleanup, 1)
+}
+
+func (s *Stats) set(size int, overwrite bool) {
+	if !s.statsEnabled {
+		return
+	}
+	atomic.AddInt64(&s.Sets, 1)
+	atomic.AddInt64(&s.BytesIn, int64(size))
+	if overwrite {
+		atomic.AddInt64(&s.Overwrites, 1)
+	}
+}
+
+func (s *Stats) values(raw int, stored int) {
+	if !s.statsEnabled {
+		return
+	}
+	atomic.AddInt64(&s.RawValueBytes, int64(raw))
+	atomic.AddInt64(&s.StoredValueBytes, int64(stored))
+}
+
+func (s *Stats) lockWait(wait time.Duration) {
+	if !s.statsEnabled {
+		return
+	}
+	atomic.AddInt64((*int64)(&s.LockWait), int64(wait))
+}
+
+func (s *Stats) hit(key string, hashKey uint64)  {
 	if !s.statsEnabled {
 		return
 	}
 	atomic.AddInt64(&s.Hits, 1)
-	s.hashmapStats[key]++
+	s.hotKeys.offer(key, s.sketch.add(hashKey))
 }
 
 func (s *Stats) getMisses() int64 {
@@ -83,11 +165,139 @@ func (s *Stats) getCollisions() int64 {
 	return atomic.LoadInt64(&s.Collisions)
 }
 
+func (s *Stats) getEvictions() int64 {
+	return atomic.LoadInt64(&s.Evictions)
+}
+
+func (s *Stats) getExpirations() int64 {
+	return s.getExpiredLazy() + s.getExpiredCleanup()
+}
+
+func (s *Stats) getExpiredLazy() int64 {
+	return atomic.LoadInt64(&s.ExpiredLazy)
+}
+
+func (s *Stats) getExpiredCleanup() int64 {
+	return atomic.LoadInt64(&s.ExpiredCleanup)
+}
+
+func (s *Stats) getSets() int64 {
+	return atomic.LoadInt64(&s.Sets)
+}
+
+func (s *Stats) getOverwrites() int64 {
+	return atomic.LoadInt64(&s.Overwrites)
+}
+
+func (s *Stats) l2Hit() {
+	if !s.statsEnabled {
+		return
+	}
+	atomic.AddInt64(&s.L2Hits, 1)
+}
+
+func (s *Stats) l2Miss() {
+	if !s.statsEnabled {
+		return
+	}
+	atomic.AddInt64(&s.L2Misses, 1)
+}
+
+func (s *Stats) getL2Hits() int64 {
+	return atomic.LoadInt64(&s.L2Hits)
+}
+
+func (s *Stats) getL2Misses() int64 {
+	return atomic.LoadInt64(&s.L2Misses)
+}
+
+func (s *Stats) getBytesIn() int64 {
+	return atomic.LoadInt64(&s.BytesIn)
+}
+
+func (s *Stats) getRawValueBytes() int64 {
+	return atomic.LoadInt64(&s.RawValueBytes)
+}
+
+func (s *Stats) getStoredValueBytes() int64 {
+	return atomic.LoadInt64(&s.StoredValueBytes)
+}
+
+func (s *Stats) getLockWait() time.Duration {
+	return time.Duration(atomic.LoadInt64((*int64)(&s.LockWait)))
+}
+
 func (s *Stats) getHits() int64 {
 	return atomic.LoadInt64(&s.Hits)
 }
 
-func (s *Stats)getKeyHits(key string) int64{
-	c := s.hashmapStats[key]
-	return c
-}
\ No newline at end of file
+func (s *Stats) getKeyHits(hashKey uint64) int64 {
+	if !s.statsEnabled {
+		return 0
+	}
+	return s.sketch.estimate(hashKey)
+}
+
+func (s *Stats) getHotKeys() []KeyCount {
+	if !s.statsEnabled {
+		return nil
+	}
+	return s.hotKeys.list()
+}
+// reset zeroes every counter and forgets the hot keys
+func (s *Stats) reset() {
+	for _, counter := range []*int64{&s.Hits, &s.Misses, &s.DelHits, &s.DelMisses, &s.Collisions,
+		&s.Evictions, &s.ExpiredLazy, &s.ExpiredCleanup, &s.Sets, &s.Overwrites, &s.BytesIn,
+		&s.RawValueBytes, &s.StoredValueBytes, &s.L2Hits, &s.L2Misses, (*int64)(&s.LockWait)} {
+		atomic.StoreInt64(counter, 0)
+	}
+	if s.statsEnabled {
+		s.sketch.reset()
+		s.hotKeys.reset()
+	}
+}
+
+// HitRatio returns the share of lookups that found their key, 0 when nothing was looked up
+func (s Stats) HitRatio() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+// CompressionRatio returns how many times smaller the written values were stored,
+// 0 when nothing was written
+func (s Stats) CompressionRatio() float64 {
+	if s.StoredValueBytes == 0 {
+		return 0
+	}
+	return float64(s.RawValueBytes) / float64(s.StoredValueBytes)
+}
+
+// Delta returns the counters accumulated since prev was taken, which turns two
+// snapshots of Stats into windowed rates. LiveBytes and LiveCost keep the value of s.
+func (s Stats) Delta(prev Stats) Stats {
+	return Stats{
+		Hits:             s.Hits - prev.Hits,
+		Misses:           s.Misses - prev.Misses,
+		DelHits:          s.DelHits - prev.DelHits,
+		DelMisses:        s.DelMisses - prev.DelMisses,
+		Collisions:       s.Collisions - prev.Collisions,
+		Evictions:        s.Evictions - prev.Evictions,
+		Expirations:      s.Expirations - prev.Expirations,
+		ExpiredLazy:      s.ExpiredLazy - prev.ExpiredLazy,
+		ExpiredCleanup:   s.ExpiredCleanup - prev.ExpiredCleanup,
+		Sets:             s.Sets - prev.Sets,
+		Overwrites:       s.Overwrites - prev.Overwrites,
+		BytesIn:          s.BytesIn - prev.BytesIn,
+		RawValueBytes:    s.RawValueBytes - prev.RawValueBytes,
+		StoredValueBytes: s.StoredValueBytes - prev.StoredValueBytes,
+		LiveBytes:        s.LiveBytes,
+		LiveCost:         s.LiveCost,
+		L2Hits:           s.L2Hits - prev.L2Hits,
+		L2Misses:         s.L2Misses - prev.L2Misses,
+		LockWait:         s.LockWait - prev.LockWait,
+		Latency:          s.Latency.delta(prev.Latency),
+	}
+}
@@ -0,0 +1,305 @@
+package localcache
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrWriteMode is returned by NewCache for an unknown WriteMode
+	ErrWriteMode = errors.New("unknown store write mode")
+	// ErrWriteBehind is returned by NewCache when the write-behind settings are not positive
+	ErrWriteBehind = errors.New("write-behind batch size, flush interval and dirty limit must be greater than 0")
+	// ErrWriteBehindClosed is returned by the writes of a write-behind cache once it is closed,
+	// nothing would flush them to the store
+	ErrWriteBehindClosed = errors.New("write-behind queue is closed")
+)
+
+// Store is the system of record behind a cache set up with SetStore
+type Store interface {
+	// Load returns the value of key, ErrEntryNotFound if it does not exist
+	Load(key string) ([]byte, error)
+	// Store persists the value of key
+	Store(key string, value []byte) error
+	// Delete removes key, removing a missing key is not an error
+	Delete(key string) error
+}
+
+// BatchStore is a Store that persists several writes at once. Write-behind flushes its
+// batches through StoreBatch when the store implements it.
+type BatchStore interface {
+	Store
+	// StoreBatch persists writes, it either persists all of them or fails
+	StoreBatch(writes []StoreWrite) error
+}
+
+// StoreWrite is a write waiting to be persisted
+type StoreWrite struct {
+	Key   string
+	Value []byte
+	// Deleted is set when the key was deleted, Value is nil then
+	Deleted bool
+}
+
+// WriteMode decides when the writes to the cache reach the Store
+type WriteMode int
+
+const (
+	// WriteThrough persists every Set and Delete before it is applied to the cache, a
+	// write the store rejects fails and leaves the cache unchanged
+	WriteThrough WriteMode = iota
+	// WriteBehind applies writes to the cache and persists them asynchronously, in batches.
+	// Repeated writes of a key waiting to be flushed are coalesced into the last one.
+	// Writes fail with ErrWriteBehindClosed once the cache is closed.
+	WriteBehind
+)
+
+const (
+	// storeLockStripes is the number of locks ordering the writes and loads of a key
+	storeLockStripes            = 256
+	defaultWriteBehindBatchSize = 100
+	defaultWriteBehindInterval  = time.Second
+	defaultWriteBehindMaxDirty  = 10000
+	// writeBehindMinBackoff and writeBehindMaxBackoff bound the delay before a failed
+	// batch is retried, it doubles after every failure
+	writeBehindMinBackoff = 100 * time.Millisecond
+	writeBehindMaxBackoff = 10 * time.Second
+	// writeBehindCloseAttempts is how many times Close tries to flush the dirty keys
+	writeBehindCloseAttempts = 3
+)
+
+// lockStore locks the store stripe of a key, held around the store call and the cache
+// update so they happen in the same order for every write and load of the key
+func (c *cache) lockStore(hashKey uint64) func() {
+	mu := &c.storeLocks[hashKey%storeLockStripes]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// load reads key from the store after a miss and caches it. Keys waiting in the
+// write-behind queue are answered from the queue, the store does not have them yet.
+func (c *cache) load(key string, hashKey uint64, bucketIndex uint64) ([]byte, error) {
+	defer c.lockStore(hashKey)()
+	c.rlock(bucketIndex)
+	segment := c.segments[bucketIndex]
+	entry, err := segment.peek(key, hashKey)
+	if err == nil {
+		// a write or a load of the key completed while waiting for the lock
+		value, err := segment.readValue(entry)
+		c.locks[bucketIndex].RUnlock()
+		return value, err
+	}
+	c.locks[bucketIndex].RUnlock()
+	if c.writeBehind != nil {
+		if write, ok := c.writeBehind.pending(key); ok {
+			if write.Deleted {
+				return nil, ErrEntryNotFound
+			}
+			// the queued value is written to the store later, the caller gets its own copy
+			return append([]byte(nil), write.Value...), nil
+		}
+	}
+	value, err := c.store.Load(key)
+	if err != nil {
+		return nil, err
+	}
+	// a value too large for the cache is still returned
+	_ = c.setLocal(key, hashKey, value, defaultExpireTime, c.weigh(key, value))
+	return value, nil
+}
+
+// writeBehind queues the writes to the store and flushes them in the background
+type writeBehind struct {
+	store     Store
+	batchSize int
+	interval  time.Duration
+	maxDirty  int
+
+	mu sync.Mutex
+	// flushed is signaled when dirty keys are flushed, for the writers waiting for room
+	flushed *sync.Cond
+	dirty   map[string]StoreWrite
+	// order holds the dirty keys, oldest first
+	order []string
+	// inflight holds the batch being flushed
+	inflight map[string]StoreWrite
+	closed   bool
+
+	kick  chan struct{}
+	close chan struct{}
+	done  chan struct{}
+}
+
+func newWriteBehind(store Store, batchSize int, interval time.Duration, maxDirty int) *writeBehind {
+	w := &writeBehind{
+		store:     store,
+		batchSize: batchSize,
+		interval:  interval,
+		maxDirty:  maxDirty,
+		dirty:     make(map[string]StoreWrite),
+		inflight:  make(map[string]StoreWrite),
+		kick:      make(chan struct{}, 1),
+		close:     make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	w.flushed = sync.NewCond(&w.mu)
+	go w.run()
+	return w
+}
+
+// put queues a write. When maxDirty keys are already waiting, it blocks until a flush
+// makes room, which slows writers down to the pace of the store. Once closed, it fails
+// with ErrWriteBehindClosed.
+func (w *writeBehind) put(write StoreWrite) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrWriteBehindClosed
+	}
+	if _, ok := w.dirty[write.Key]; ok {
+		w.dirty[write.Key] = write
+		return nil
+	}
+	for len(w.dirty) >= w.maxDirty && !w.closed {
+		w.flushed.Wait()
+	}
+	if w.closed {
+		return ErrWriteBehindClosed
+	}
+	if _, ok := w.dirty[write.Key]; !ok {
+		w.order = append(w.order, write.Key)
+	}
+	w.dirty[write.Key] = write
+	if len(w.dirty) >= w.batchSize {
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// pending returns the write of key that is not persisted yet
+func (w *writeBehind) pending(key string) (StoreWrite, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if write, ok := w.dirty[key]; ok {
+		return write, true
+	}
+	write, ok := w.inflight[key]
+	return write, ok
+}
+
+func (w *writeBehind) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.kick:
+		case <-w.close:
+			return
+		}
+		backoff := writeBehindMinBackoff
+		for w.flush() != nil {
+			select {
+			case <-time.After(backoff):
+			case <-w.close:
+				return
+			}
+			if backoff *= 2; backoff > writeBehindMaxBackoff {
+				backoff = writeBehindMaxBackoff
+			}
+		}
+	}
+}
+
+// flush persists the dirty keys batch by batch. A failed batch goes back to the queue,
+// unless its keys were written again meanwhile, and the error is returned.
+func (w *writeBehind) flush() error {
+	for {
+		w.mu.Lock()
+		n := len(w.order)
+		if n == 0 {
+			w.mu.Unlock()
+			return nil
+		}
+		if n > w.batchSize {
+			n = w.batchSize
+		}
+		batch := make([]StoreWrite, 0, n)
+		for _, key := range w.order[:n] {
+			write := w.dirty[key]
+			batch = append(batch, write)
+			w.inflight[key] = write
+			delete(w.dirty, key)
+		}
+		w.order = w.order[n:]
+		w.mu.Unlock()
+
+		done, err := w.write(batch)
+
+		w.mu.Lock()
+		for _, write := range batch {
+			delete(w.inflight, write.Key)
+		}
+		for _, write := range batch[done:] {
+			if _, ok := w.dirty[write.Key]; !ok {
+				w.dirty[write.Key] = write
+				w.order = append(w.order, write.Key)
+			}
+		}
+		w.flushed.Broadcast()
+		w.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// write persists batch and returns how many of its writes are persisted
+func (w *writeBehind) write(batch []StoreWrite) (int, error) {
+	if store, ok := w.store.(BatchStore); ok {
+		if err := store.StoreBatch(batch); err != nil {
+			return 0, err
+		}
+		return len(batch), nil
+	}
+	for index, write := range batch {
+		var err error
+		if write.Deleted {
+			err = w.store.Delete(write.Key)
+		} else {
+			err = w.store.Store(write.Key, write.Value)
+		}
+		if err != nil {
+			return index, err
+		}
+	}
+	return len(batch), nil
+}
+
+// closeWriteBehind stops the background flushes and flushes what is left, retrying a
+// few times. Later writes and the writers still waiting for room are rejected.
+func (w *writeBehind) closeWriteBehind() error {
+	w.mu.Lock()
+	w.closed = true
+	w.flushed.Broadcast()
+	w.mu.Unlock()
+	close(w.close)
+	<-w.done
+	var err error
+	backoff := writeBehindMinBackoff
+	for attempt := 0; attempt < writeBehindCloseAttempts; attempt++ {
+		if err = w.flush(); err == nil {
+			break
+		}
+		if attempt < writeBehindCloseAttempts-1 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return err
+}
@@ -0,0 +1,330 @@
+package localcache
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errStoreDown = errors.New("store down")
+
+// memStore is a Store in memory that can fail or block its writes
+type memStore struct {
+	mu     sync.Mutex
+	data   map[string][]byte
+	loads  int
+	writes int
+	// failures is the number of writes to fail before succeeding again
+	failures int
+	// gate blocks the writes while it is open, nil otherwise
+	gate chan struct{}
+}
+
+func newMemStore() *memStore {
+	return &memStore{data: make(map[string][]byte)}
+}
+
+func (m *memStore) Load(key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.loads++
+	value, ok := m.data[key]
+	if !ok {
+		return nil, ErrEntryNotFound
+	}
+	return value, nil
+}
+
+func (m *memStore) write(fn func()) error {
+	m.mu.Lock()
+	gate := m.gate
+	m.mu.Unlock()
+	if gate != nil {
+		<-gate
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failures > 0 {
+		m.failures--
+		return errStoreDown
+	}
+	m.writes++
+	fn()
+	return nil
+}
+
+func (m *memStore) Store(key string, value []byte) error {
+	return m.write(func() { m.data[key] = value })
+}
+
+func (m *memStore) Delete(key string) error {
+	return m.write(func() { delete(m.data, key) })
+}
+
+func (m *memStore) get(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, ok := m.data[key]
+	return value, ok
+}
+
+// memBatchStore records the size of the batches it persists
+type memBatchStore struct {
+	*memStore
+	batches []int
+}
+
+func (m *memBatchStore) StoreBatch(writes []StoreWrite) error {
+	m.mu.Lock()
+	m.batches = append(m.batches, len(writes))
+	m.mu.Unlock()
+	return m.write(func() {
+		for _, write := range writes {
+			if write.Deleted {
+				delete(m.data, write.Key)
+			} else {
+				m.data[write.Key] = write.Value
+			}
+		}
+	})
+}
+
+type storeTestSuite struct {
+	suite.Suite
+}
+
+func TestStoreTestSuite(t *testing.T) {
+	suite.Run(t, new(storeTestSuite))
+}
+
+func (s *storeTestSuite) SetupSuite() {}
+
+func (s *storeTestSuite) newCache(store Store, mode WriteMode, opts ...Opt) ICache {
+	opts = append([]Opt{SetShardCount(4), SetStore(store, mode)}, opts...)
+	c, err := NewCache(opts...)
+	assert.Equal(s.T(), nil, err)
+	return c
+}
+
+func (s *storeTestSuite) TestWriteThrough() {
+	store := newMemStore()
+	c := s.newCache(store, WriteThrough)
+	defer c.Close()
+
+	assert.Equal(s.T(), nil, c.Set("asong", []byte("1")))
+	value, ok := store.get("asong")
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), []byte("1"), value)
+
+	store.failures = 1
+	assert.Equal(s.T(), errStoreDown, c.Set("asong", []byte("2")))
+	res, err := c.Get("asong")
+	assert.Equal(s.T(), nil, err)
+	assert.Equal(s.T(), []byte("1"), res)
+
+	// evict leaves the store alone, the next Get loads the key again
+	assert.Equal(s.T(), nil, c.Evict("asong"))
+	assert.Equal(s.T(), 0, c.Len())
+	value, ok = store.get("asong")
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), []byte("1"), value)
+	res, err = c.Get("asong")
+	assert.Equal(s.T(), nil, err)
+	assert.Equal(s.T(), []byte("1"), res)
+
+	assert.Equal(s.T(), nil, c.Delete("asong"))
+	_, ok = store.get("asong")
+	assert.False(s.T(), ok)
+	_, err = c.Get("asong")
+	assert.Equal(s.T(), ErrEntryNotFound, err)
+}
+
+func (s *storeTestSuite) TestReadThrough() {
+	store := newMemStore()
+	store.data["asong"] = []byte("stored")
+	c := s.newCache(store, WriteThrough)
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		res, err := c.Get("asong")
+		assert.Equal(s.T(), nil, err)
+		assert.Equal(s.T(), []byte("stored"), res)
+	}
+	assert.Equal(s.T(), 1, store.loads)
+	assert.Equal(s.T(), 1, c.Len())
+	assert.Equal(s.T(), 0, store.writes)
+
+	_, err := c.Get("missing")
+	assert.Equal(s.T(), ErrEntryNotFound, err)
+	assert.Equal(s.T(), nil, c.Clear())
+	_, ok := store.get("asong")
+	assert.True(s.T(), ok)
+}
+
+func (s *storeTestSuite) TestWriteBehindCoalescing() {
+	store := newMemStore()
+	c := s.newCache(store, WriteBehind, SetWriteBehind(100, time.Hour, 1000))
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(s.T(), nil, c.Set("asong", []byte(fmt.Sprintf("value%d", i))))
+	}
+	assert.Equal(s.T(), nil, c.Set("other", []byte("value")))
+	assert.Equal(s.T(), nil, c.Delete("other"))
+	_, ok := store.get("asong")
+	assert.False(s.T(), ok)
+
+	assert.Equal(s.T(), nil, c.Close())
+	value, ok := store.get("asong")
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), []byte("value9"), value)
+	_, ok = store.get("other")
+	assert.False(s.T(), ok)
+	assert.Equal(s.T(), 2, store.writes)
+}
+
+func (s *storeTestSuite) TestWriteBehindPendingRead() {
+	store := newMemStore()
+	store.data["asong"] = []byte("old")
+	c := s.newCache(store, WriteBehind, SetWriteBehind(100, time.Hour, 1000))
+	defer c.Close()
+
+	assert.Equal(s.T(), ErrEntryNotFound, c.Delete("asong"))
+	_, err := c.Get("asong")
+	assert.Equal(s.T(), ErrEntryNotFound, err)
+	assert.Equal(s.T(), 0, store.loads)
+}
+
+func (s *storeTestSuite) TestWriteBehindPendingReadCopies() {
+	store := newMemStore()
+	c := s.newCache(store, WriteBehind, SetWriteBehind(100, time.Hour, 1000))
+	// a queued write whose key is no longer in memory
+	c.(*cache).writeBehind.put(StoreWrite{Key: "asong", Value: []byte("queued")})
+
+	res, err := c.Get("asong")
+	assert.Equal(s.T(), nil, err)
+	assert.Equal(s.T(), []byte("queued"), res)
+	copy(res, "change")
+	assert.Equal(s.T(), nil, c.Close())
+	value, ok := store.get("asong")
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), []byte("queued"), value)
+}
+
+func (s *storeTestSuite) TestReadThroughTooLarge() {
+	store := newMemStore()
+	store.data["asong"] = make([]byte, 2*segmentSize)
+	weigher := func(key string, value []byte) int64 {
+		return int64(len(value))
+	}
+	c := s.newCache(store, WriteThrough, SetShardCount(1), SetMaxCost(segmentSize), SetWeigher(weigher))
+	defer c.Close()
+
+	// the loaded value does not fit the cache but is still returned
+	res, err := c.Get("asong")
+	assert.Equal(s.T(), nil, err)
+	assert.Equal(s.T(), store.data["asong"], res)
+	assert.Equal(s.T(), 0, c.Len())
+}
+
+func (s *storeTestSuite) TestWriteBehindRetry() {
+	store := newMemStore()
+	store.failures = 3
+	c := s.newCache(store, WriteBehind, SetWriteBehind(1, 10*time.Millisecond, 10))
+	defer c.Close()
+
+	assert.Equal(s.T(), nil, c.Set("asong", []byte("value")))
+	assert.Eventually(s.T(), func() bool {
+		value, ok := store.get("asong")
+		return ok && string(value) == "value"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func (s *storeTestSuite) TestWriteBehindBackpressure() {
+	store := newMemStore()
+	gate := make(chan struct{})
+	store.gate = gate
+	c := s.newCache(store, WriteBehind, SetWriteBehind(1, time.Hour, 2))
+
+	// the first key is taken by the flusher, which blocks on the store
+	assert.Equal(s.T(), nil, c.Set("key0", []byte("0")))
+	assert.Eventually(s.T(), func() bool {
+		_, ok := c.(*cache).writeBehind.pending("key0")
+		c.(*cache).writeBehind.mu.Lock()
+		defer c.(*cache).writeBehind.mu.Unlock()
+		return ok && len(c.(*cache).writeBehind.inflight) == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(s.T(), nil, c.Set("key1", []byte("1")))
+	assert.Equal(s.T(), nil, c.Set("key2", []byte("2")))
+	// coalescing into a dirty key does not need room
+	assert.Equal(s.T(), nil, c.Set("key2", []byte("2")))
+
+	done := make(chan struct{})
+	go func() {
+		c.Set("key3", []byte("3"))
+		close(done)
+	}()
+	select {
+	case <-done:
+		s.T().Fatal("set did not wait for room in the dirty queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	store.mu.Lock()
+	store.gate = nil
+	store.mu.Unlock()
+	close(gate)
+	<-done
+
+	assert.Equal(s.T(), nil, c.Close())
+	for i := 0; i < 4; i++ {
+		_, ok := store.get(fmt.Sprintf("key%d", i))
+		assert.True(s.T(), ok)
+	}
+}
+
+func (s *storeTestSuite) TestWriteBehindAfterClose() {
+	store := newMemStore()
+	c := s.newCache(store, WriteBehind)
+	assert.Equal(s.T(), nil, c.Set("asong", []byte("value")))
+	assert.Equal(s.T(), nil, c.Close())
+
+	// nothing would flush them anymore
+	assert.Equal(s.T(), ErrWriteBehindClosed, c.Set("asong", []byte("lost")))
+	assert.Equal(s.T(), ErrWriteBehindClosed, c.Delete("asong"))
+	value, ok := store.get("asong")
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), []byte("value"), value)
+}
+
+func (s *storeTestSuite) TestWriteBehindBatchStore() {
+	store := &memBatchStore{memStore: newMemStore()}
+	c := s.newCache(store, WriteBehind, SetWriteBehind(4, time.Hour, 100))
+	for i := 0; i < 10; i++ {
+		assert.Equal(s.T(), nil, c.Set(fmt.Sprintf("key%d", i), []byte("value")))
+	}
+	assert.Equal(s.T(), nil, c.Close())
+	assert.Equal(s.T(), 10, len(store.data))
+	for _, size := range store.batches {
+		assert.True(s.T(), size <= 4)
+	}
+}
+
+func (s *storeTestSuite) TestCloseFlushFailure() {
+	store := newMemStore()
+	store.failures = writeBehindCloseAttempts
+	c := s.newCache(store, WriteBehind, SetWriteBehind(100, time.Hour, 100))
+	assert.Equal(s.T(), nil, c.Set("asong", []byte("value")))
+	assert.Equal(s.T(), errStoreDown, c.Close())
+	_, ok := store.get("asong")
+	assert.False(s.T(), ok)
+}
+
+func (s *storeTestSuite) TestInvalidOptions() {
+	_, err := NewCache(SetStore(newMemStore(), WriteMode(7)))
+	assert.Equal(s.T(), ErrWriteMode, err)
+	_, err = NewCache(SetStore(newMemStore(), WriteBehind), SetWriteBehind(0, time.Second, 10))
+	assert.Equal(s.T(), ErrWriteBehind, err)
+}
@@ -0,0 +1,191 @@
+package localcache
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type viewTestSuite struct {
+	suite.Suite
+}
+
+func TestViewTestSuite(t *testing.T) {
+	suite.Run(t, new(viewTestSuite))
+}
+
+func (v *viewTestSuite) SetupSuite() {}
+
+func (v *viewTestSuite) TestView() {
+	c, err := NewCache(SetShardCount(4), SetStatsEnabled(true))
+	assert.Equal(v.T(), nil, err)
+	defer c.Close()
+
+	assert.Equal(v.T(), nil, c.Set("asong", []byte("value")))
+	var seen []byte
+	err = c.View("asong", func(value []byte) error {
+		seen = append(seen, value...)
+		return nil
+	})
+	assert.Equal(v.T(), nil, err)
+	assert.Equal(v.T(), []byte("value"), seen)
+
+	errStop := errors.New("stop")
+	assert.Equal(v.T(), errStop, c.View("asong", func(value []byte) error { return errStop }))
+	called := false
+	err = c.View("missing", func(value []byte) error {
+		called = true
+		return nil
+	})
+	assert.Equal(v.T(), ErrEntryNotFound, err)
+	assert.False(v.T(), called)
+
+	stats := c.Stats()
+	assert.Equal(v.T(), int64(2), stats.Hits)
+	assert.Equal(v.T(), int64(1), stats.Misses)
+}
+
+func (v *viewTestSuite) TestViewDoesNotCopy() {
+	c, err := NewCache(SetShardCount(1))
+	assert.Equal(v.T(), nil, err)
+	defer c.Close()
+
+	assert.Equal(v.T(), nil, c.Set("asong", []byte("value")))
+	var first, second []byte
+	_ = c.View("asong", func(value []byte) error {
+		first = value
+		return nil
+	})
+	_ = c.View("asong", func(value []byte) error {
+		second = value
+		return nil
+	})
+	assert.True(v.T(), &first[0] == &second[0])
+
+	res, err := c.Get("asong")
+	assert.Equal(v.T(), nil, err)
+	assert.True(v.T(), &first[0] != &res[0])
+}
+
+func (v *viewTestSuite) TestAppendTo() {
+	compressor, _ := NewFlateCompressor(flate.BestSpeed)
+	c, err := NewCache(SetShardCount(4), SetCompression(compressor, 1024))
+	assert.Equal(v.T(), nil, err)
+	defer c.Close()
+
+	compressed := bytes.Repeat([]byte("asong"), 1000)
+	chunked := make([]byte, 3*segmentSize)
+	for i := range chunked {
+		chunked[i] = byte(i * 31 >> 4)
+	}
+	values := map[string][]byte{"raw": []byte("value"), "compressed": compressed}
+	for key, value := range values {
+		assert.Equal(v.T(), nil, c.Set(key, value))
+	}
+	raw, err := NewCache(SetShardCount(4))
+	assert.Equal(v.T(), nil, err)
+	defer raw.Close()
+	assert.Equal(v.T(), nil, raw.Set("chunked", chunked))
+
+	buf := make([]byte, 0, 64)
+	for key, value := range values {
+		buf, err = c.AppendTo(buf[:0], key)
+		assert.Equal(v.T(), nil, err)
+		assert.Equal(v.T(), value, buf)
+	}
+	buf, err = raw.AppendTo([]byte("prefix"), "chunked")
+	assert.Equal(v.T(), nil, err)
+	assert.Equal(v.T(), append([]byte("prefix"), chunked...), buf)
+
+	buf, err = c.AppendTo([]byte("prefix"), "missing")
+	assert.Equal(v.T(), ErrEntryNotFound, err)
+	assert.Equal(v.T(), []byte("prefix"), buf)
+}
+
+func (v *viewTestSuite) TestFallsBackToSlowPaths() {
+	store := newMemStore()
+	store.data["stored"] = []byte("from store")
+	c, err := NewCache(SetShardCount(4), SetStore(store, WriteThrough))
+	assert.Equal(v.T(), nil, err)
+	defer c.Close()
+
+	res, err := c.AppendTo(nil, "stored")
+	assert.Equal(v.T(), nil, err)
+	assert.Equal(v.T(), []byte("from store"), res)
+	err = c.View("stored", func(value []byte) error {
+		assert.Equal(v.T(), []byte("from store"), value)
+		return nil
+	})
+	assert.Equal(v.T(), nil, err)
+	assert.Equal(v.T(), 1, store.loads)
+
+	// expired entries are removed as by Get
+	other, err := NewCache(SetShardCount(4))
+	assert.Equal(v.T(), nil, err)
+	defer other.Close()
+	assert.Equal(v.T(), nil, other.SetWithTime("short", []byte("value"), time.Second))
+	time.Sleep(2 * time.Second)
+	err = other.View("short", func(value []byte) error { return nil })
+	assert.Equal(v.T(), ErrEntryNotFound, err)
+	assert.Equal(v.T(), 0, other.Len())
+}
+
+func benchmarkRead(b *testing.B, size int, read func(c ICache, key string, buf []byte) []byte) {
+	c, err := NewCache(SetShardCount(16), SetMaxBytes(256*1024*1024))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer c.Close()
+	value := make([]byte, size)
+	for index := 0; index < 1024; index++ {
+		if err := c.Set(fmt.Sprintf("asong%04d", index), value); err != nil {
+			b.Fatal(err)
+		}
+	}
+	keys := make([]string, 1024)
+	for index := range keys {
+		keys[index] = fmt.Sprintf("asong%04d", index)
+	}
+	buf := make([]byte, 0, size)
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf = read(c, keys[i&1023], buf[:0])
+	}
+}
+
+func readGet(c ICache, key string, buf []byte) []byte {
+	value, _ := c.Get(key)
+	return value
+}
+
+// viewed keeps the benchmarked views from being optimized away
+var viewed int
+
+func viewLength(value []byte) error {
+	viewed += len(value)
+	return nil
+}
+
+func readView(c ICache, key string, buf []byte) []byte {
+	_ = c.View(key, viewLength)
+	return buf
+}
+
+func readAppendTo(c ICache, key string, buf []byte) []byte {
+	buf, _ = c.AppendTo(buf, key)
+	return buf
+}
+
+func BenchmarkGet1KB(b *testing.B)       { benchmarkRead(b, 1024, readGet) }
+func BenchmarkView1KB(b *testing.B)      { benchmarkRead(b, 1024, readView) }
+func BenchmarkAppendTo1KB(b *testing.B)  { benchmarkRead(b, 1024, readAppendTo) }
+func BenchmarkGet16KB(b *testing.B)      { benchmarkRead(b, 16*1024, readGet) }
+func BenchmarkView16KB(b *testing.B)     { benchmarkRead(b, 16*1024, readView) }
+func BenchmarkAppendTo16KB(b *testing.B) { benchmarkRead(b, 16*1024, readAppendTo) }